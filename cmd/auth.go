@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/evansopilo/visuai/pkg/data"
+	"github.com/evansopilo/visuai/pkg/log"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Identity of the caller as asserted by the authenticating gateway in front of
+// the api through the X-User-ID and X-User-Roles headers.
+type Identity struct {
+	UserID string
+	Roles  []string
+}
+
+func (i Identity) HasRole(role string) bool {
+	for _, r := range i.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func (app App) Identify(c *fiber.Ctx) error {
+
+	identity := Identity{UserID: c.Get("X-User-ID")}
+
+	for _, role := range strings.Split(c.Get("X-User-Roles"), ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			identity.Roles = append(identity.Roles, role)
+		}
+	}
+
+	c.Locals("identity", identity)
+
+	return c.Next()
+}
+
+var errUnauthenticated = newProblem(fiber.StatusUnauthorized, "unauthenticated", "the request must be made by a signed in user")
+
+func (app App) RequireUser(c *fiber.Ctx) error {
+
+	if identityFrom(c).UserID == "" {
+		return errUnauthenticated
+	}
+
+	return c.Next()
+}
+
+func (app App) RequireRole(role string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+
+		identity := identityFrom(c)
+
+		if identity.UserID == "" {
+			return errUnauthenticated
+		}
+
+		if !identity.HasRole(role) {
+			log.FromContext(c.UserContext()).Warn("caller is missing required role", map[string]interface{}{"user_id": identity.UserID, "role": role})
+			return data.NewForbidden("missing_role", fmt.Sprintf("the %v role is required", role))
+		}
+
+		return c.Next()
+	}
+}
+
+// RequireAccount requires the caller to be the user of the user_id route parameter
+// or a moderator.
+func (app App) RequireAccount(c *fiber.Ctx) error {
+
+	identity := identityFrom(c)
+
+	if identity.UserID == "" {
+		return errUnauthenticated
+	}
+
+	if identity.UserID != c.Params("user_id") && !identity.HasRole("moderator") {
+		return data.NewForbidden("not_account_owner", "only the user or a moderator can manage the user's data")
+	}
+
+	return c.Next()
+}
+
+func identityFrom(c *fiber.Ctx) Identity {
+	identity, _ := c.Locals("identity").(Identity)
+	return identity
+}
+
+// viewer returns the caller as the data.Viewer posts are read on behalf of.
+func (app App) viewer(ctx context.Context, c *fiber.Ctx) (data.Viewer, error) {
+
+	viewer := data.Viewer{UserID: identityFrom(c).UserID}
+
+	if viewer.UserID == "" {
+		return viewer, nil
+	}
+
+	following, err := app.Models.Follow.GetFollowing(ctx, viewer.UserID)
+	if err != nil {
+		return viewer, err
+	}
+
+	viewer.Following = following
+
+	return viewer, nil
+}
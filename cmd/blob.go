@@ -1,59 +1,314 @@
 package main
 
 import (
-	"io"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"time"
 
 	"github.com/evansopilo/visuai/pkg/data"
+	"github.com/evansopilo/visuai/pkg/log"
+	"github.com/evansopilo/visuai/pkg/media"
+	"github.com/evansopilo/visuai/pkg/phash"
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func (app App) UploadFile(c *fiber.Ctx) error {
 
+	if c.FormValue("post_id") == "" {
+		return newProblem(fiber.StatusBadRequest, "post_id_required", "post_id is required")
+	}
+
+	return app.uploadMedia(c, c.FormValue("post_id"), false)
+}
+
+// uploadMedia appends the multipart file of the request to the post with postID,
+// when ownerOnly is set only the post's owner can add photos to it.
+func (app App) uploadMedia(c *fiber.Ctx, postID string, ownerOnly bool) error {
+
+	ctx, cancel := context.WithTimeout(c.UserContext(), time.Second*3)
+	defer cancel()
+
+	viewer, err := app.viewer(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	post, err := app.Models.Post.GetByID(data.ContextWithViewer(ctx, viewer), postID)
+	if err != nil {
+		if errors.Is(err, data.ErrNoDocument) {
+			return data.NewNotFound("post", postID)
+		}
+		return err
+	}
+
+	if ownerOnly && post.UserID != viewer.UserID {
+		return errNotOwner
+	}
+
 	file, err := c.FormFile("file")
 	if err != nil {
-		app.Logger.Error(err.Error(), map[string]interface{}{"requestid": c.Locals("requestid")})
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error",
-		})
+		return errFileRequired
 	}
 
-	buffer, err := file.Open()
+	fileByte, err := readFormFile(file)
 	if err != nil {
-		app.Logger.Error(err.Error(), map[string]interface{}{"requestid": c.Locals("requestid")})
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error",
-		})
+		return err
+	}
+
+	if len(post.Media) >= data.MaxMediaItems {
+		return newProblem(fiber.StatusBadRequest, "too_many_media", fmt.Sprintf("a post can hold at most %v media items", data.MaxMediaItems))
+	}
+
+	info, ok, err := app.probeMedia(ctx, fileByte)
+	if !ok {
+		return err
+	}
+
+	item, img, duplicates, err := app.inspectMedia(ctx, post, fileByte, info)
+	if err != nil {
+		return err
+	}
+
+	if len(duplicates) > 0 && c.FormValue("allow_duplicate") != "true" {
+		return duplicateConflict(duplicates)
+	}
+
+	item.AltText = c.FormValue("alt_text")
+
+	// the blobs are uploaded past the timeout of the reads above, storeMedia bounds
+	// its writes to the post on its own.
+	storeCtx, cancelStore := context.WithTimeout(c.UserContext(), blobWriteTimeout)
+	defer cancelStore()
+
+	if err := app.storeMedia(storeCtx, post, fileByte, img, item); err != nil {
+		return err
 	}
-	defer buffer.Close()
 
-	fileByte, err := io.ReadAll(buffer)
+	return uploadCreated(c, post.ID, item.ID, duplicates)
+}
+
+const (
+	// thumbnailSize is the longest side of the thumbnail and poster variants.
+	thumbnailSize = 320
+	// maxMediaDuration is the longest animation or video clip accepted.
+	maxMediaDuration = 60 * time.Second
+	// blobWriteTimeout bounds the upload of the blobs of a media item sent in a form,
+	// a file of up to maxBodySize along with its thumbnail or poster.
+	blobWriteTimeout = 30 * time.Second
+)
+
+// maxMediaSize is the largest file accepted for each supported content type.
+var maxMediaSize = map[string]int64{
+	"image/jpeg": 20 << 20,
+	"image/png":  20 << 20,
+	"image/gif":  15 << 20,
+	"video/mp4":  100 << 20,
+	"video/webm": 100 << 20,
+}
+
+// probeMedia reads the container metadata of an uploaded file, when the file is not
+// supported or exceeds the limits of its type ok is false and err describes why.
+func (app App) probeMedia(ctx context.Context, file []byte) (info media.Info, ok bool, err error) {
+
+	info, err = media.Probe(file)
 	if err != nil {
-		app.Logger.Error(err.Error(), map[string]interface{}{"requestid": c.Locals("requestid")})
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error",
-		})
+		log.FromContext(ctx).Warn(err.Error(), nil)
+		return info, false, newProblem(fiber.StatusUnsupportedMediaType, "unsupported_media_type", "only jpeg, png and gif images and mp4 and webm videos can be uploaded")
 	}
 
-	url, err := app.BlobModel.UploadBytesToBlob(fileByte, map[string]string{})
+	if limit := maxMediaSize[info.ContentType]; int64(len(file)) > limit {
+		return info, false, newProblem(fiber.StatusRequestEntityTooLarge, "file_too_large", fmt.Sprintf("%v files can be at most %v bytes", info.ContentType, limit))
+	}
+
+	if info.Kind == media.KindVideo && (info.Width <= 0 || info.Height <= 0) {
+		return info, false, newProblem(fiber.StatusUnsupportedMediaType, "unsupported_media_type", "videos must have a video track with a width and a height")
+	}
+
+	if info.Duration > maxMediaDuration {
+		return info, false, newProblem(fiber.StatusBadRequest, "clip_too_long", fmt.Sprintf("clips can be at most %v long", maxMediaDuration))
+	}
+
+	return info, true, nil
+}
+
+// inspectMedia turns an uploaded file into a new media item of post. Images and
+// animations are decoded to record their perceptual hash, along with the
+// near-duplicates the post's owner already uploaded.
+func (app App) inspectMedia(ctx context.Context, post *data.Post, file []byte, info media.Info) (data.Media, image.Image, []Duplicate, error) {
+
+	item := data.Media{
+		ID:          uuid.NewString(),
+		Kind:        info.Kind,
+		ContentType: info.ContentType,
+		Width:       info.Width,
+		Height:      info.Height,
+		Duration:    info.Duration.Seconds(),
+		Codec:       info.Codec,
+	}
+
+	if info.Kind == media.KindVideo {
+		return item, nil, nil, nil
+	}
+
+	img, err := media.DecodeImage(file)
 	if err != nil {
-		app.Logger.Error(err.Error(), map[string]interface{}{"requestid": c.Locals("requestid")})
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error",
-		})
+		log.FromContext(ctx).Warn("failed to decode uploaded file", map[string]interface{}{"error": err.Error()})
+		return item, nil, nil, nil
 	}
 
-	if err := app.Models.Post.UpdateByID(c.Context(), c.FormValue("post_id"), &data.Post{PhotoURL: url}); err != nil {
-		app.Logger.Error(err.Error(), map[string]interface{}{"requestid": c.Locals("requestid")})
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": true,
-			"msg":   err.Error(),
-		})
+	hash := phash.FromImage(img)
+
+	item.PHash = hash.String()
+
+	duplicates, err := app.findDuplicates(ctx, hash, post.UserID, post.ID)
+	if err != nil {
+		return item, nil, nil, err
 	}
 
-	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+	return item, img, duplicates, nil
+}
+
+// storeMedia stores the blobs of a media item, screens it and appends it to the
+// post's media. The item's blobs are deleted when it can not be appended. The blobs
+// are uploaded within ctx and the post is written within 3 seconds of their upload.
+func (app App) storeMedia(ctx context.Context, post *data.Post, file []byte, img image.Image, item data.Media) error {
+
+	item, err := app.storeBlobs(ctx, file, img, item)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*3)
+	defer cancel()
+
+	items := []data.Media{item}
+
+	// carry the photo of posts uploaded before multi-photo posts over as their cover.
+	if len(post.Media) == 0 && post.PhotoKey != "" {
+		items = append([]data.Media{{ID: uuid.NewString(), Key: post.PhotoKey, PHash: post.PHash}}, items...)
+	}
+
+	var bands []string
+	if hash, err := phash.Parse(item.PHash); err == nil {
+		bands = hash.Bands()
+	}
+
+	if err := app.Models.Post.AppendMedia(ctx, post.ID, bands, items...); err != nil {
+		app.deleteBlobs(ctx, item)
+		return err
+	}
+
+	if state := app.prescreen(ctx, post.ID, file); state != "" {
+		return app.Models.Post.SetModerationState(ctx, post.ID, state)
+	}
+
+	return nil
+}
+
+// storeBlobs uploads file unless it is already stored under item.Key along with its
+// thumbnail, or a poster placeholder for videos, and returns the item with their keys.
+// Blobs stored before a failed upload are deleted.
+func (app App) storeBlobs(ctx context.Context, file []byte, img image.Image, item data.Media) (data.Media, error) {
+
+	if item.Key == "" {
+		key, err := app.BlobModel.UploadBytesToBlob(ctx, file, item.ContentType, map[string]string{})
+		if err != nil {
+			return item, err
+		}
+		item.Key = key
+	}
+
+	name := "thumbnail"
+	if item.Kind == media.KindVideo {
+		name, img = "poster", media.Placeholder(media.Fit(item.Width, item.Height, thumbnailSize))
+	}
+
+	if img == nil {
+		return item, nil
+	}
+
+	thumbnail := media.Thumbnail(img, thumbnailSize)
+
+	b, err := media.EncodeJPEG(thumbnail)
+	if err != nil {
+		app.deleteBlobs(ctx, item)
+		return item, err
+	}
+
+	key, err := app.BlobModel.UploadBytesToBlob(ctx, b, "image/jpeg", map[string]string{})
+	if err != nil {
+		app.deleteBlobs(ctx, item)
+		return item, err
+	}
+
+	item.Variants = append(item.Variants, data.Variant{
+		Name:   name,
+		Key:    key,
+		Width:  thumbnail.Bounds().Dx(),
+		Height: thumbnail.Bounds().Dy(),
+	})
+
+	return item, nil
+}
+
+// deleteBlobs deletes the blobs of items and their variants to compensate for a
+// write that failed after they were stored. The blobs are deleted even when ctx is
+// already done, in the trace of ctx.
+func (app App) deleteBlobs(ctx context.Context, items ...data.Media) {
+
+	logger := log.FromContext(ctx)
+
+	ctx, cancel := context.WithTimeout(trace.ContextWithSpan(context.Background(), trace.SpanFromContext(ctx)), 10*time.Second)
+	defer cancel()
+
+	for _, item := range items {
+		keys := []string{item.Key}
+		for _, variant := range item.Variants {
+			keys = append(keys, variant.Key)
+		}
+
+		for _, key := range keys {
+			if err := app.BlobModel.DeleteBlob(ctx, key); err != nil {
+				logger.Warn("failed to delete blob", map[string]interface{}{"key": key, "error": err.Error()})
+			}
+		}
+	}
+}
+
+func duplicateConflict(duplicates []Duplicate) error {
+	return newProblem(fiber.StatusConflict, "near_duplicate", "a near-duplicate of this photo has already been uploaded, set allow_duplicate=true to upload anyway").
+		with("duplicates", duplicateIDs(duplicates))
+}
+
+func uploadCreated(c *fiber.Ctx, postID, mediaID string, duplicates []Duplicate) error {
+
+	response := fiber.Map{
 		"status": "success",
 		"data": map[string]string{
-			"id": c.FormValue("post_id"),
+			"id":       postID,
+			"media_id": mediaID,
 		},
-	})
+	}
+
+	if len(duplicates) > 0 {
+		response["warning"] = "a near-duplicate of this photo has already been uploaded"
+		response["duplicates"] = duplicateIDs(duplicates)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(response)
+}
+
+func duplicateIDs(duplicates []Duplicate) []string {
+
+	ids := make([]string, 0, len(duplicates))
+
+	for _, d := range duplicates {
+		ids = append(ids, d.Post.ID)
+	}
+
+	return ids
 }
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/evansopilo/visuai/pkg/apitest"
+	"github.com/evansopilo/visuai/pkg/data"
+	"github.com/evansopilo/visuai/pkg/moderation"
+)
+
+func TestUploadFile(t *testing.T) {
+
+	app := newTestApp(t)
+
+	app.createPosts(t, data.Post{ID: "post-1", UserID: "alice"}, data.Post{ID: "post-2", UserID: "alice"})
+
+	upload := func(postID string, file []byte) *apitest.Form {
+		form := apitest.NewForm().Field("post_id", postID)
+		if file != nil {
+			form.File("file", "photo.png", file)
+		}
+		return form
+	}
+
+	app.client.Post("/v1/api/upload", upload("", testPNG(t, 0))).ExpectProblem(http.StatusBadRequest, "post_id_required")
+
+	app.client.Post("/v1/api/upload", upload("missing", testPNG(t, 0))).ExpectProblem(http.StatusNotFound, "post_not_found")
+
+	app.client.Post("/v1/api/upload", upload("post-1", nil)).ExpectProblem(http.StatusBadRequest, "file_required")
+
+	app.client.Post("/v1/api/upload", upload("post-1", []byte("not a photo"))).ExpectProblem(http.StatusUnsupportedMediaType, "unsupported_media_type")
+
+	var created struct {
+		ID      string `json:"id"`
+		MediaID string `json:"media_id"`
+	}
+
+	app.client.Post("/v1/api/upload", upload("post-1", testPNG(t, 0)).Field("alt_text", "a gradient")).
+		ExpectStatus(http.StatusCreated).Data(&created)
+
+	post := app.post(t, "post-1")
+
+	if len(post.Media) != 1 || post.Media[0].ID != created.MediaID || post.Media[0].AltText != "a gradient" {
+		t.Fatalf("media = %+v, want the uploaded item", post.Media)
+	}
+
+	if item := post.Media[0]; item.ContentType != "image/png" || item.PHash == "" || len(item.Variants) != 1 {
+		t.Errorf("media item = %+v, want its content type, hash and thumbnail", item)
+	}
+
+	p := app.client.Post("/v1/api/upload", upload("post-2", testPNG(t, 0))).ExpectProblem(http.StatusConflict, "near_duplicate")
+
+	if duplicates, _ := p.Extra["duplicates"].([]interface{}); len(duplicates) != 1 || duplicates[0] != "post-1" {
+		t.Errorf("duplicates = %v, want [post-1]", p.Extra["duplicates"])
+	}
+
+	var body struct {
+		Warning    string   `json:"warning"`
+		Duplicates []string `json:"duplicates"`
+	}
+
+	app.client.Post("/v1/api/upload", upload("post-2", testPNG(t, 0)).Field("allow_duplicate", "true")).
+		ExpectStatus(http.StatusCreated).Decode(&body)
+
+	if body.Warning == "" || len(body.Duplicates) != 1 {
+		t.Errorf("response = %+v, want a duplicate warning", body)
+	}
+}
+
+func TestUploadFileTooManyMedia(t *testing.T) {
+
+	app := newTestApp(t)
+
+	media := make([]data.Media, data.MaxMediaItems)
+	for i := range media {
+		media[i] = data.Media{ID: string(rune('a' + i))}
+	}
+
+	app.createPosts(t, data.Post{ID: "post-1", UserID: "alice", Media: media})
+
+	form := apitest.NewForm().Field("post_id", "post-1").File("file", "photo.png", testPNG(t, 0))
+
+	app.client.Post("/v1/api/upload", form).ExpectProblem(http.StatusBadRequest, "too_many_media")
+}
+
+func TestUploadFileFlagged(t *testing.T) {
+
+	app := newTestApp(t, func(app *App) {
+		app.Classifier = classifierFunc(func(ctx context.Context, file []byte) (moderation.Verdict, error) {
+			return moderation.Verdict{Flagged: true, Labels: []string{"unsafe"}}, nil
+		})
+	})
+
+	app.createPosts(t, data.Post{ID: "post-1", UserID: "alice"})
+
+	form := apitest.NewForm().Field("post_id", "post-1").File("file", "photo.png", testPNG(t, 0))
+
+	app.client.Post("/v1/api/upload", form).ExpectStatus(http.StatusCreated)
+
+	if state := app.post(t, "post-1").ModerationState; state != data.ModerationPending {
+		t.Errorf("moderation state = %q, want %q", state, data.ModerationPending)
+	}
+
+	reports, err := app.Models.Report.GetOpen(context.Background(), 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(*reports) != 1 || (*reports)[0].Source != data.ReportSourceClassifier {
+		t.Errorf("reports = %+v, want a classifier report", *reports)
+	}
+}
+
+func TestAppendPostMedia(t *testing.T) {
+
+	app := newTestApp(t)
+
+	app.createPosts(t, data.Post{ID: "post-1", UserID: "alice"})
+
+	form := func() *apitest.Form {
+		return apitest.NewForm().File("file", "photo.png", testPNG(t, 1))
+	}
+
+	app.client.Post("/v1/api/posts/post-1/media", form()).ExpectProblem(http.StatusUnauthorized, "unauthenticated")
+
+	app.client.As("bob").Post("/v1/api/posts/post-1/media", form()).ExpectProblem(http.StatusForbidden, "not_owner")
+
+	app.client.As("alice").Post("/v1/api/posts/missing/media", form()).ExpectProblem(http.StatusNotFound, "post_not_found")
+
+	app.client.As("alice").Post("/v1/api/posts/post-1/media", form()).ExpectStatus(http.StatusCreated)
+
+	if media := app.post(t, "post-1").Media; len(media) != 1 {
+		t.Errorf("media = %+v, want the appended item", media)
+	}
+}
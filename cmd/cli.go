@@ -0,0 +1,501 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/evansopilo/visuai/pkg/lifecycle"
+	"gopkg.in/yaml.v3"
+)
+
+// command is a subcommand of the binary, run returns the exit code of the process.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+func commands() []command {
+	return []command{
+		{"serve", "run the api server and its background workers, the default", serve},
+		{"migrate", "apply the migrations of the database that were not applied yet", runMigrate},
+		{"create-indexes", "create the indexes of the database that do not exist yet", runCreateIndexes},
+		{"reindex", "recompute the perceptual hashes and duplicate bands of posts", runReindex},
+		{"purge-orphans", "delete the blobs no post or job refers to", runPurgeOrphans},
+		{"export-user", "write the posts and media of a user to a zip archive", runExportUser},
+		{"import", "import posts from a jsonl or csv file", runImport},
+		{"check-config", "validate the configuration and check its dependencies", runCheckConfig},
+	}
+}
+
+// run runs the command named by the first argument with the rest of args, the api
+// server is run when the first argument is a flag or there is none.
+func run(args []string) int {
+
+	name := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+
+	if name == "help" {
+		usage(os.Stdout)
+		return 0
+	}
+
+	for _, c := range commands() {
+		if c.name == name {
+			return c.run(args)
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+	usage(os.Stderr)
+
+	return 2
+}
+
+func usage(w io.Writer) {
+
+	fmt.Fprintf(w, "usage: cmd <command> [flags]\n\ncommands:\n")
+
+	for _, c := range commands() {
+		fmt.Fprintf(w, "  %-15s %v\n", c.name, c.summary)
+	}
+
+	fmt.Fprintf(w, "\nEvery command accepts the flags of the configuration, see cmd <command> -h.\n")
+}
+
+// newFlagSet returns the flag set of a command, operands describes the arguments
+// that follow its flags.
+func newFlagSet(name, operands string) *flag.FlagSet {
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: cmd %v [flags] %v\n\n", name, operands)
+		fs.PrintDefaults()
+	}
+
+	return fs
+}
+
+// runCommand sets up the App with the flags of fs and args, calls fn with a context
+// that is done once the process is asked to stop and writes the result fn returns
+// to standard output, as json when asJSON is set and as yaml otherwise. Logs are
+// written to standard error. operands is the number of arguments that must follow
+// the flags, they are checked before the App connects to anything. It returns the
+// exit code of the process.
+func runCommand(fs *flag.FlagSet, args []string, operands int, asJSON *bool, fn func(ctx context.Context, app *App) (interface{}, error)) int {
+
+	cfg, logger, err := loadConfig(fs, args, os.Stderr)
+	if err == flag.ErrHelp {
+		return 0
+	}
+	if err != nil {
+		logger.Error("failed to load application configuration", map[string]interface{}{"error": err.Error()})
+		return 2
+	}
+
+	if fs.NArg() != operands {
+		fs.Usage()
+		return 2
+	}
+
+	app, lc := mustApp(cfg, logger, os.Stderr)
+
+	code := 0
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+
+	result, err := fn(ctx, app)
+
+	stop()
+
+	if result != nil {
+		if err := output(os.Stdout, *asJSON, result); err != nil {
+			app.Logger.Error("failed to write the result", map[string]interface{}{"error": err.Error()})
+			code = 1
+		}
+	}
+
+	if err != nil {
+		app.Logger.Error(fs.Name()+" failed", map[string]interface{}{"error": err.Error()})
+		code = 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := lc.Shutdown(ctx); err != nil {
+		code = 1
+	}
+
+	return code
+}
+
+// output writes result to w as json when asJSON is set and as yaml otherwise, the
+// yaml is converted from the json so both use the same field names.
+func output(w io.Writer, asJSON bool, result interface{}) error {
+
+	b, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		_, err := fmt.Fprintf(w, "%s\n", b)
+		return err
+	}
+
+	var value interface{}
+
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+
+	if err := decoder.Decode(&value); err != nil {
+		return err
+	}
+
+	b, err = yaml.Marshal(yamlValue(value))
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(b)
+
+	return err
+}
+
+// yamlValue turns the json numbers of value into integers or floats.
+func yamlValue(value interface{}) interface{} {
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = yamlValue(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = yamlValue(item)
+		}
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+	}
+
+	return value
+}
+
+func runMigrate(args []string) int {
+
+	fs := newFlagSet("migrate", "")
+
+	dryRun := fs.Bool("dry-run", false, "list the migrations that would be applied without applying them")
+	tenant := fs.String("tenant", "", "name of the tenant to run the command for, every tenant when empty")
+	asJSON := fs.Bool("json", false, "write the result as json")
+
+	return runCommand(fs, args, 0, asJSON, func(ctx context.Context, app *App) (interface{}, error) {
+		return forTenants(app, *tenant, func(app *App) (map[string]interface{}, error) {
+			changes, err := app.Schema.Migrate(ctx, *dryRun)
+			return map[string]interface{}{"migrations": changes}, err
+		})
+	})
+}
+
+func runCreateIndexes(args []string) int {
+
+	fs := newFlagSet("create-indexes", "")
+
+	dryRun := fs.Bool("dry-run", false, "list the indexes that would be created without creating them")
+	tenant := fs.String("tenant", "", "name of the tenant to run the command for, every tenant when empty")
+	asJSON := fs.Bool("json", false, "write the result as json")
+
+	return runCommand(fs, args, 0, asJSON, func(ctx context.Context, app *App) (interface{}, error) {
+		return forTenants(app, *tenant, func(app *App) (map[string]interface{}, error) {
+			changes, err := app.Schema.CreateIndexes(ctx, *dryRun)
+			return map[string]interface{}{"indexes": changes}, err
+		})
+	})
+}
+
+func runReindex(args []string) int {
+
+	fs := newFlagSet("reindex", "")
+
+	all := fs.Bool("all", false, "recompute the hashes of every photo, not only of those that lack one")
+	dryRun := fs.Bool("dry-run", false, "count the posts that would change without updating them")
+	tenant := fs.String("tenant", "", "name of the tenant to run the command for, the default database when empty")
+	asJSON := fs.Bool("json", false, "write the result as json")
+
+	return runCommand(fs, args, 0, asJSON, func(ctx context.Context, app *App) (interface{}, error) {
+
+		app, err := app.tenant(*tenant)
+		if err != nil {
+			return nil, err
+		}
+
+		counts, err := app.reindexPosts(ctx, app.Logger, *all, *dryRun)
+		return map[string]interface{}{"counts": counts, "dry_run": *dryRun}, err
+	})
+}
+
+func runPurgeOrphans(args []string) int {
+
+	fs := newFlagSet("purge-orphans", "")
+
+	grace := fs.Duration("grace", orphanGrace, "age a blob must reach before it is purged")
+	dryRun := fs.Bool("dry-run", false, "list the orphaned blobs without deleting them")
+	asJSON := fs.Bool("json", false, "write the result as json")
+
+	return runCommand(fs, args, 0, asJSON, func(ctx context.Context, app *App) (interface{}, error) {
+		// tenants share the blob store, so the blobs of every tenant are kept.
+		counts, keys, err := app.purgeOrphans(ctx, app.Logger, *grace, *dryRun)
+		sort.Strings(keys)
+		return map[string]interface{}{"counts": counts, "orphans": keys, "dry_run": *dryRun}, err
+	})
+}
+
+// runExportUser writes the export of a user to an archive. It only reads the data
+// of the user, so unlike the commands that change data it has no -dry-run.
+func runExportUser(args []string) int {
+
+	fs := newFlagSet("export-user", "user_id")
+
+	out := fs.String("o", "", "path of the archive, <user_id>.zip by default")
+	tenant := fs.String("tenant", "", "name of the tenant to run the command for, the default database when empty")
+	asJSON := fs.Bool("json", false, "write the result as json")
+
+	return runCommand(fs, args, 1, asJSON, func(ctx context.Context, app *App) (interface{}, error) {
+
+		app, err := app.tenant(*tenant)
+		if err != nil {
+			return nil, err
+		}
+
+		userID := fs.Arg(0)
+
+		if *out == "" {
+			*out = userID + ".zip"
+		}
+
+		f, err := os.Create(*out)
+		if err != nil {
+			return nil, err
+		}
+
+		counts, err := app.writeExport(ctx, app.Logger, userID, f)
+
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+
+		if err != nil {
+			os.Remove(*out)
+			return nil, err
+		}
+
+		return map[string]interface{}{"counts": counts, "archive": *out}, nil
+	})
+}
+
+// runImport imports posts from a jsonl or csv file whose rows refer to their photos
+// by url or local path, it writes the rows that were not imported to a json lines
+// report and fails when any row failed.
+func runImport(args []string) int {
+
+	fs := newFlagSet("import", "file")
+
+	format := fs.String("format", "", "format of the file, jsonl or csv, taken from its extension by default")
+	concurrency := fs.Int("concurrency", importConcurrency, fmt.Sprintf("number of rows imported at a time, at most %v", maxImportConcurrency))
+	dryRun := fs.Bool("dry-run", false, "validate the rows and fetch their photos without storing anything")
+	reportPath := fs.String("report", "import-report.jsonl", "path of the report of the rows that were not imported")
+	tenant := fs.String("tenant", "", "name of the tenant to run the command for, the default database when empty")
+	asJSON := fs.Bool("json", false, "write the result as json")
+
+	return runCommand(fs, args, 1, asJSON, func(ctx context.Context, app *App) (interface{}, error) {
+
+		app, err := app.tenant(*tenant)
+		if err != nil {
+			return nil, err
+		}
+
+		name := fs.Arg(0)
+
+		if *format == "" {
+			*format = importFormat(name)
+		}
+
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		report, err := os.Create(*reportPath)
+		if err != nil {
+			return nil, err
+		}
+		defer report.Close()
+
+		enc := json.NewEncoder(report)
+
+		opts := importOptions{Format: *format, Concurrency: *concurrency, DryRun: *dryRun, AllowFiles: true}
+
+		counts, err := app.importPosts(ctx, f, opts, func(result importResult) {
+			if result.Status == importFailed || result.Status == importSkipped {
+				_ = enc.Encode(result)
+			}
+		})
+
+		result := map[string]interface{}{"counts": counts, "report": *reportPath, "dry_run": *dryRun}
+
+		if err == nil && counts[importFailed] > 0 {
+			err = fmt.Errorf("%v rows failed", counts[importFailed])
+		}
+
+		return result, err
+	})
+}
+
+// runCheckConfig writes the configuration with its secrets redacted and reports
+// whether it is valid, resolving the secrets it refers to, and whether every
+// dependency of the App can be reached. A dependency is not checked when the App
+// can not be set up, and the command fails when any check does.
+func runCheckConfig(args []string) int {
+
+	fs := newFlagSet("check-config", "")
+
+	asJSON := fs.Bool("json", false, "write the result as json")
+
+	loaded, logger, err := loadConfig(fs, args, os.Stderr)
+	if err == flag.ErrHelp {
+		return 0
+	}
+	if err != nil {
+		logger.Error("failed to load application configuration", map[string]interface{}{"error": err.Error()})
+		return 2
+	}
+
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+
+	code := 0
+
+	// the configuration is written as loaded, with the references to its secrets.
+	var cfg interface{}
+
+	b, err := yaml.Marshal(loaded.Redacted())
+	if err == nil {
+		err = yaml.Unmarshal(b, &cfg)
+	}
+	if err != nil {
+		logger.Error("failed to write the configuration", map[string]interface{}{"error": err.Error()})
+		code = 1
+	}
+
+	dependencies := []string{"mongodb", "blob"}
+	if loaded.Secrets.SecretProvider() != "none" {
+		dependencies = append(dependencies, "secrets")
+	}
+
+	lc := lifecycle.New(logger)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+
+	app, err := newApp(ctx, loaded, logger, os.Stderr, lc)
+
+	cancel()
+
+	checks := map[string]string{"config": "ok"}
+
+	if errors.Is(err, errSecrets) {
+		checks["config"] = "not checked, its secrets could not be looked up"
+		checks["secrets"] = err.Error()
+	} else if err != nil {
+		checks["config"] = err.Error()
+	}
+
+	if app == nil {
+		code = 1
+
+		for _, name := range dependencies {
+			if _, ok := checks[name]; !ok {
+				checks[name] = "not checked, the application could not be set up"
+			}
+		}
+	} else {
+		for _, check := range app.Checks {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+
+			checks[check.Name] = "ok"
+			if err := check.Check(ctx); err != nil {
+				checks[check.Name] = err.Error()
+				code = 1
+			}
+
+			cancel()
+		}
+	}
+
+	if err := output(os.Stdout, *asJSON, map[string]interface{}{"config": cfg, "checks": checks}); err != nil {
+		code = 1
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := lc.Shutdown(ctx); err != nil {
+		code = 1
+	}
+
+	return code
+}
+
+// forTenants calls fn with the App of the tenant with name, or with app and then
+// the Apps of all its tenants when name is empty, whose results are added to the
+// result of app under tenants.
+func forTenants(app *App, name string, fn func(app *App) (map[string]interface{}, error)) (interface{}, error) {
+
+	if name != "" {
+		tenant, err := app.tenant(name)
+		if err != nil {
+			return nil, err
+		}
+		return fn(tenant)
+	}
+
+	result, err := fn(app)
+	if err != nil || len(app.Tenants) == 0 {
+		return result, err
+	}
+
+	tenants := map[string]interface{}{}
+
+	result["tenants"] = tenants
+
+	for _, tenant := range app.Tenants {
+		tenants[tenant.Name], err = fn(tenant.App)
+		if err != nil {
+			return result, fmt.Errorf("tenant %v: %w", tenant.Name, err)
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestOutput(t *testing.T) {
+
+	result := map[string]interface{}{"counts": map[string]int64{"posts": 2}, "ratio": 0.5, "keys": []string{"a"}}
+
+	var buf bytes.Buffer
+
+	if err := output(&buf, true, result); err != nil {
+		t.Fatalf("output: %v", err)
+	}
+
+	want := "{\n  \"counts\": {\n    \"posts\": 2\n  },\n  \"keys\": [\n    \"a\"\n  ],\n  \"ratio\": 0.5\n}\n"
+	if got := buf.String(); got != want {
+		t.Errorf("json output = %q, want %q", got, want)
+	}
+
+	buf.Reset()
+
+	if err := output(&buf, false, result); err != nil {
+		t.Fatalf("output: %v", err)
+	}
+
+	want = "counts:\n    posts: 2\nkeys:\n    - a\nratio: 0.5\n"
+	if got := buf.String(); got != want {
+		t.Errorf("yaml output = %q, want %q", got, want)
+	}
+}
+
+func TestRunUnknownCommand(t *testing.T) {
+
+	if code := run([]string{"unknown"}); code != 2 {
+		t.Errorf("run(unknown) = %v, want 2", code)
+	}
+}
+
+func TestRunChecksArgumentsFirst(t *testing.T) {
+
+	// the default configuration refers to secrets no provider serves, so the commands
+	// would fail to set up the App were the arguments not checked first.
+	for _, args := range [][]string{{"export-user"}, {"migrate", "extra"}, {"check-config", "extra"}} {
+		if code := run(args); code != 2 {
+			t.Errorf("run(%v) = %v, want 2", args, code)
+		}
+	}
+}
+
+func TestRunCheckConfigInvalid(t *testing.T) {
+
+	if code := run([]string{"check-config", "-json"}); code != 1 {
+		t.Errorf("run(check-config) of the default configuration = %v, want 1", code)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/evansopilo/visuai/pkg/data"
+	"github.com/evansopilo/visuai/pkg/phash"
+	"github.com/gofiber/fiber/v2"
+)
+
+// maxDuplicateCandidates bounds the number of posts sharing a hash band that are
+// compared against a hash.
+const maxDuplicateCandidates = 500
+
+type Duplicate struct {
+	Post     data.Post `json:"post"`
+	Distance int       `json:"distance"`
+}
+
+// postHashes returns the perceptual hashes of every photo of post.
+func postHashes(post *data.Post) []phash.Hash {
+
+	values := []string{post.PHash}
+	for _, item := range post.Media {
+		values = append(values, item.PHash)
+	}
+
+	var hashes []phash.Hash
+
+	for _, value := range values {
+		if hash, err := phash.Parse(value); err == nil {
+			hashes = append(hashes, hash)
+		}
+	}
+
+	return hashes
+}
+
+// findDuplicates returns the posts with a photo that is a near-duplicate of hash, skipping
+// the post with id excludeID regardless of their visibility. When userID is not empty only
+// that user's posts are returned.
+func (app App) findDuplicates(ctx context.Context, hash phash.Hash, userID, excludeID string) ([]Duplicate, error) {
+
+	ctx = data.ContextWithViewer(ctx, data.Viewer{Moderator: true})
+
+	candidates, err := app.Models.Post.GetByPHashBands(ctx, hash.Bands(), 0, maxDuplicateCandidates)
+	if err != nil {
+		return nil, err
+	}
+
+	duplicates := []Duplicate{}
+
+	for _, post := range *candidates {
+		if post.ID == excludeID || (userID != "" && post.UserID != userID) {
+			continue
+		}
+
+		distance := -1
+		for _, other := range postHashes(&post) {
+			if d := hash.Distance(other); distance == -1 || d < distance {
+				distance = d
+			}
+		}
+
+		if distance != -1 && distance <= phash.MaxDistance {
+			if err := app.signPhotoURL(&post); err != nil {
+				return nil, err
+			}
+
+			duplicates = append(duplicates, Duplicate{Post: post, Distance: distance})
+		}
+	}
+
+	return duplicates, nil
+}
+
+func (app App) GetPostDuplicates(c *fiber.Ctx) error {
+
+	ctx, cancel := context.WithTimeout(c.UserContext(), time.Second*3)
+	defer cancel()
+
+	post, err := app.Models.Post.GetByID(data.ContextWithViewer(ctx, data.Viewer{Moderator: true}), c.Params("post_id"))
+	if err != nil {
+		if errors.Is(err, data.ErrNoDocument) {
+			return data.NewNotFound("post", c.Params("post_id"))
+		}
+		return err
+	}
+
+	duplicates := []Duplicate{}
+
+	seen := map[string]int{}
+
+	for _, hash := range postHashes(post) {
+		found, err := app.findDuplicates(ctx, hash, "", post.ID)
+		if err != nil {
+			return err
+		}
+
+		for _, d := range found {
+			i, ok := seen[d.Post.ID]
+			switch {
+			case !ok:
+				seen[d.Post.ID] = len(duplicates)
+				duplicates = append(duplicates, d)
+			case d.Distance < duplicates[i].Distance:
+				duplicates[i] = d
+			}
+		}
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status": "success",
+		"data":   duplicates,
+	})
+}
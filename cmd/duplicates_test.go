@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/evansopilo/visuai/pkg/apitest"
+	"github.com/evansopilo/visuai/pkg/data"
+)
+
+func TestGetPostDuplicates(t *testing.T) {
+
+	app := newTestApp(t)
+
+	app.createPosts(t,
+		data.Post{ID: "post-1", UserID: "alice"},
+		data.Post{ID: "post-2", UserID: "bob"},
+		data.Post{ID: "post-3", UserID: "carol"},
+	)
+
+	for _, upload := range []struct {
+		postID  string
+		pattern int
+	}{{"post-1", 0}, {"post-2", 0}, {"post-3", 2}} {
+		form := apitest.NewForm().Field("post_id", upload.postID).File("file", "photo.png", testPNG(t, upload.pattern))
+		app.client.Post("/v1/api/upload", form).ExpectStatus(http.StatusCreated)
+	}
+
+	app.client.Get("/v1/api/posts/post-1/duplicates").ExpectProblem(http.StatusUnauthorized, "unauthenticated")
+
+	app.client.As("bob").Get("/v1/api/posts/post-1/duplicates").ExpectProblem(http.StatusForbidden, "missing_role")
+
+	moderator := app.client.As("dave", "moderator")
+
+	moderator.Get("/v1/api/posts/missing/duplicates").ExpectProblem(http.StatusNotFound, "post_not_found")
+
+	var duplicates []Duplicate
+
+	moderator.Get("/v1/api/posts/post-1/duplicates").ExpectStatus(http.StatusOK).Data(&duplicates)
+
+	if len(duplicates) != 1 || duplicates[0].Post.ID != "post-2" || duplicates[0].Distance != 0 {
+		t.Errorf("duplicates = %+v, want post-2 at distance 0", duplicates)
+	}
+
+	// posts hidden by moderation are duplicates all the same.
+	moderator.Post("/v1/api/admin/posts/post-2/hide", nil).ExpectStatus(http.StatusOK)
+
+	moderator.Get("/v1/api/posts/post-1/duplicates").ExpectStatus(http.StatusOK).Data(&duplicates)
+
+	if len(duplicates) != 1 || duplicates[0].Post.ID != "post-2" {
+		t.Errorf("duplicates = %+v, want the hidden post-2", duplicates)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/evansopilo/visuai/pkg/data"
+	"github.com/evansopilo/visuai/pkg/log"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+// problemType prefixes the code of a problem to form its RFC 7807 type.
+const problemType = "urn:visuai:problem:"
+
+// problem is an error of a request answered with a status and a stable code.
+type problem struct {
+	status int
+	code   string
+	detail string
+	extra  map[string]interface{}
+}
+
+func newProblem(status int, code, detail string) *problem {
+	return &problem{status: status, code: code, detail: detail}
+}
+
+func (p *problem) Error() string { return p.detail }
+
+// with adds the extension member key to the problem document.
+func (p *problem) with(key string, value interface{}) *problem {
+
+	if p.extra == nil {
+		p.extra = make(map[string]interface{})
+	}
+
+	p.extra[key] = value
+
+	return p
+}
+
+var (
+	errNotOwner = data.NewForbidden("not_owner", "only the owner of the post can change it")
+
+	errFileRequired = newProblem(fiber.StatusBadRequest, "file_required", "a file must be uploaded in the file field")
+
+	errInvalidBody = newProblem(fiber.StatusBadRequest, "invalid_body", "the request body could not be parsed")
+
+	errAlreadyReported = newProblem(fiber.StatusConflict, "already_reported", "you already reported the post, it is waiting for a moderator")
+
+	errUploadCompleting = newProblem(fiber.StatusConflict, "upload_completing", "the upload is being completed by another request")
+)
+
+// problemFor maps err to the problem it is answered with, errors that are neither
+// problems, data errors nor fiber errors are internal errors whose details are
+// not disclosed.
+func problemFor(err error) *problem {
+
+	var (
+		p  *problem
+		de *data.Error
+		fe *fiber.Error
+	)
+
+	switch {
+	case errors.As(err, &p):
+		return p
+	case errors.As(err, &de):
+		status := fiber.StatusInternalServerError
+		switch {
+		case errors.Is(de, data.ErrNotFound):
+			status = fiber.StatusNotFound
+		case errors.Is(de, data.ErrConflict):
+			status = fiber.StatusConflict
+		case errors.Is(de, data.ErrValidation):
+			status = fiber.StatusUnprocessableEntity
+		case errors.Is(de, data.ErrForbidden):
+			status = fiber.StatusForbidden
+		case errors.Is(de, data.ErrTimeout):
+			status = fiber.StatusGatewayTimeout
+		case errors.Is(de, data.ErrUnavailable):
+			status = fiber.StatusServiceUnavailable
+		}
+		p = newProblem(status, de.Code, de.Message)
+		if de.Fields != nil {
+			p.with("errors", de.Fields)
+		}
+		return p
+	case errors.As(err, &fe):
+		return newProblem(fe.Code, strings.ReplaceAll(strings.ToLower(utils.StatusMessage(fe.Code)), " ", "_"), fe.Message)
+	default:
+		return newProblem(fiber.StatusInternalServerError, "internal_error", "the server encountered a problem and could not process the request")
+	}
+}
+
+// ErrorHandler answers the errors returned by handlers with an RFC 7807
+// problem+json document, internal errors are logged.
+func (app App) ErrorHandler(c *fiber.Ctx, err error) error {
+
+	p := problemFor(err)
+
+	if p.status >= fiber.StatusInternalServerError {
+		log.FromContext(c.UserContext()).Error(err.Error(), nil)
+	}
+
+	document := map[string]interface{}{
+		"type":     problemType + p.code,
+		"title":    utils.StatusMessage(p.status),
+		"status":   p.status,
+		"detail":   p.detail,
+		"instance": c.Path(),
+		"code":     p.code,
+	}
+
+	if id, ok := c.Locals("requestid").(string); ok {
+		document["request_id"] = id
+	}
+
+	for key, value := range p.extra {
+		document[key] = value
+	}
+
+	if data.Retryable(err) {
+		c.Set(fiber.HeaderRetryAfter, "1")
+	}
+
+	b, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+
+	c.Set(fiber.HeaderContentType, "application/problem+json")
+
+	return c.Status(p.status).Send(b)
+}
+
+// handleErrors answers the error of the rest of the chain within it, so the
+// middleware in front of it see the status of the problem.
+func (app App) handleErrors(c *fiber.Ctx) error {
+
+	if err := c.Next(); err != nil {
+		return app.ErrorHandler(c, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/evansopilo/visuai/pkg/data"
+)
+
+func TestErrorHandler(t *testing.T) {
+
+	t.Run("unknown route", func(t *testing.T) {
+		p := newTestApp(t).client.Get("/v1/api/unknown").ExpectProblem(http.StatusNotFound, "not_found")
+
+		if p.Type != problemType+"not_found" || p.Instance != "/v1/api/unknown" || p.RequestID == "" {
+			t.Errorf("problem = %+v, want its type, instance and request id", p)
+		}
+	})
+
+	t.Run("internal error", func(t *testing.T) {
+		app := newTestApp(t, func(app *App) {
+			app.Models.Post = failingPosts{PostStore: app.Models.Post, err: errors.New("boom")}
+		})
+
+		p := app.client.Get("/v1/api/posts").ExpectProblem(http.StatusInternalServerError, "internal_error")
+
+		if p.Detail == "boom" {
+			t.Errorf("detail = %q, want internal errors not to be disclosed", p.Detail)
+		}
+	})
+
+	t.Run("unavailable store", func(t *testing.T) {
+		app := newTestApp(t, func(app *App) {
+			app.Models.Post = failingPosts{PostStore: app.Models.Post, err: &data.Error{Kind: data.ErrUnavailable, Code: "unavailable", Message: "the database is unavailable"}}
+		})
+
+		res := app.client.Get("/v1/api/posts/post-1")
+
+		res.ExpectProblem(http.StatusServiceUnavailable, "unavailable")
+
+		if res.Header.Get("Retry-After") == "" {
+			t.Errorf("Retry-After is not set on a retryable error")
+		}
+	})
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	"github.com/evansopilo/visuai/pkg/blob"
+	"github.com/evansopilo/visuai/pkg/data"
+	"github.com/evansopilo/visuai/pkg/log"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// exportTTL is how long an export archive can be downloaded before it is deleted.
+	exportTTL = 7 * 24 * time.Hour
+	// exportURLTTL is how long the download urls of export archives stay valid, a new
+	// one is signed every time the job is read.
+	exportURLTTL = 15 * time.Minute
+	// exportBlockSize is the size of the blocks export archives are staged in.
+	exportBlockSize = 8 << 20
+)
+
+// ExportUserData queues a job that assembles the data of a user into a zip archive,
+// the archive can be downloaded from the job once it succeeds.
+func (app App) ExportUserData(c *fiber.Ctx) error {
+
+	ctx, cancel := context.WithTimeout(c.UserContext(), time.Second*3)
+	defer cancel()
+
+	job := data.Job{Kind: jobExportUserData, UserID: c.Params("user_id"), RequestedBy: identityFrom(c).UserID}
+
+	if err := app.enqueue(ctx, &job); err != nil {
+		return err
+	}
+
+	c.Location("/v1/api/jobs/" + job.ID)
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"status": "success",
+		"data":   job,
+	})
+}
+
+// exportManifest is the manifest.json of an export archive.
+type exportManifest struct {
+	UserID     string       `json:"user_id"`
+	ExportedAt time.Time    `json:"exported_at"`
+	Posts      []exportPost `json:"posts"`
+}
+
+type exportPost struct {
+	data.Post
+	// Files are the paths in the archive of the original blobs of the post's media.
+	Files []exportFile `json:"files,omitempty"`
+}
+
+type exportFile struct {
+	MediaID string `json:"media_id,omitempty"`
+	Path    string `json:"path,omitempty"`
+	// Missing is set when the blob could not be read, the file is then left out.
+	Missing bool `json:"missing,omitempty"`
+}
+
+// exportUserDataJob writes the data of the job's user to a zip archive and stores
+// the archive as the job's result. The archive is staged in blocks as it is written,
+// so only a block of it is held in memory.
+func (app App) exportUserDataJob(ctx context.Context, logger *log.Logger, job *data.Job) (map[string]int64, error) {
+
+	archive := blob.NewWriter(ctx, app.BlobModel, blob.NewKey("application/zip"), exportBlockSize, "application/zip", map[string]string{"user_id": job.UserID, "job_id": job.ID})
+
+	counts, err := app.writeExport(ctx, logger, job.UserID, archive)
+	if err == nil {
+		if err = archive.Close(); err != nil {
+			err = fmt.Errorf("store export archive: %w", err)
+		}
+	}
+	if err != nil {
+		if err := archive.Abort(); err != nil {
+			logger.Warn("failed to discard export archive", map[string]interface{}{"key": archive.Key(), "error": err.Error()})
+		}
+		return counts, err
+	}
+
+	counts["bytes"] = archive.Size()
+
+	job.ResultKey, job.ExpiresAt = archive.Key(), time.Now().Add(exportTTL)
+
+	return counts, nil
+}
+
+// writeExport writes every post of a user and the original blobs of their media to
+// a zip archive with a manifest.json describing them. The tree has no comments or
+// reactions yet, they belong in the manifest once it does.
+func (app App) writeExport(ctx context.Context, logger *log.Logger, userID string, dst io.Writer) (map[string]int64, error) {
+
+	counts := map[string]int64{"posts": 0, "blobs": 0, "blobs_failed": 0}
+
+	manifest := exportManifest{UserID: userID, ExportedAt: time.Now().UTC(), Posts: []exportPost{}}
+
+	archive := zip.NewWriter(dst)
+
+	addBlob := func(post *exportPost, mediaID, name, key string) error {
+
+		file := exportFile{MediaID: mediaID, Path: path.Join("media", post.ID, name+path.Ext(key))}
+
+		b, err := app.BlobModel.DownloadBlob(ctx, key)
+		if err != nil {
+			logger.Warn("failed to download blob", map[string]interface{}{"post_id": post.ID, "key": key, "error": err.Error()})
+			counts["blobs_failed"]++
+			post.Files = append(post.Files, exportFile{MediaID: mediaID, Missing: true})
+			return nil
+		}
+
+		w, err := archive.Create(file.Path)
+		if err != nil {
+			return err
+		}
+
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+
+		counts["blobs"]++
+		post.Files = append(post.Files, file)
+
+		return nil
+	}
+
+	for skip := int64(0); ; skip += purgeBatchSize {
+		posts, err := app.Models.Post.GetAllByUserID(ctx, userID, skip, purgeBatchSize)
+		if err != nil {
+			return counts, err
+		}
+
+		for _, post := range *posts {
+			exported := exportPost{Post: post}
+
+			if post.PhotoKey != "" && (len(post.Media) == 0 || post.Media[0].Key != post.PhotoKey) {
+				if err := addBlob(&exported, "", "photo", post.PhotoKey); err != nil {
+					return counts, err
+				}
+			}
+
+			for _, item := range post.Media {
+				if err := addBlob(&exported, item.ID, item.ID, item.Key); err != nil {
+					return counts, err
+				}
+			}
+
+			manifest.Posts = append(manifest.Posts, exported)
+			counts["posts"]++
+		}
+
+		if len(*posts) < purgeBatchSize {
+			break
+		}
+	}
+
+	w, err := archive.Create("manifest.json")
+	if err != nil {
+		return counts, err
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	if err := encoder.Encode(manifest); err != nil {
+		return counts, err
+	}
+
+	if err := archive.Close(); err != nil {
+		return counts, err
+	}
+
+	return counts, nil
+}
+
+// expireJobResults deletes the blobs of job results that expired.
+func (app App) expireJobResults(ctx context.Context) {
+
+	jobs, err := app.Models.Job.GetExpired(ctx, time.Now(), 100)
+	if err != nil {
+		app.Logger.Error(err.Error(), nil)
+		return
+	}
+
+	for _, job := range *jobs {
+		if err := app.BlobModel.DeleteBlob(ctx, job.ResultKey); err != nil {
+			app.Logger.Warn("failed to delete expired job result", map[string]interface{}{"job_id": job.ID, "key": job.ResultKey, "error": err.Error()})
+			continue
+		}
+
+		if err := app.Models.Job.ClearResult(ctx, job.ID); err != nil {
+			app.Logger.Error(err.Error(), nil)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/evansopilo/visuai/pkg/data"
+)
+
+func TestExportUserData(t *testing.T) {
+
+	app := newTestApp(t)
+	ctx := context.Background()
+
+	key, err := app.BlobModel.UploadBytesToBlob(ctx, testPNG(t, 0), "image/png", nil)
+	if err != nil {
+		t.Fatalf("upload blob: %v", err)
+	}
+
+	app.createPosts(t,
+		data.Post{ID: "p1", UserID: "alice", Title: "first", Media: []data.Media{{ID: "m1", Key: key}, {ID: "m2", Key: "missing.png"}}},
+		data.Post{ID: "p2", UserID: "alice", Visibility: data.VisibilityPrivate},
+		data.Post{ID: "p3", UserID: "bob"},
+	)
+
+	app.client.Post("/v1/api/users/alice/export", nil).ExpectProblem(http.StatusUnauthorized, "unauthenticated")
+	app.client.As("bob").Post("/v1/api/users/alice/export", nil).ExpectProblem(http.StatusForbidden, "not_account_owner")
+
+	var job data.Job
+
+	res := app.client.As("alice").Post("/v1/api/users/alice/export", nil).ExpectStatus(http.StatusAccepted)
+	res.Data(&job)
+
+	if job.Kind != jobExportUserData || job.Status != data.JobQueued {
+		t.Fatalf("job = %+v, want a queued export", job)
+	}
+
+	if location := res.Header.Get("Location"); location != "/v1/api/jobs/"+job.ID {
+		t.Errorf("location = %q, want the job", location)
+	}
+
+	app.runQueuedJobs(ctx)
+
+	app.client.As("alice").Get("/v1/api/jobs/" + job.ID).ExpectStatus(http.StatusOK).Data(&job)
+
+	if job.Status != data.JobSucceeded || job.Counts["posts"] != 2 || job.Counts["blobs"] != 1 || job.Counts["blobs_failed"] != 1 {
+		t.Fatalf("job = %+v, want an export of 2 posts and 1 blob", job)
+	}
+
+	if job.DownloadURL == "" || job.ExpiresAt.Before(time.Now()) {
+		t.Fatalf("job = %+v, want a download url until it expires", job)
+	}
+
+	archive := app.client.Get(job.DownloadURL).ExpectStatus(http.StatusOK).Body
+
+	r, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		t.Fatalf("read archive: %v", err)
+	}
+
+	files := map[string][]byte{}
+	for _, f := range r.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("open %v: %v", f.Name, err)
+		}
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("read %v: %v", f.Name, err)
+		}
+		files[f.Name] = b
+	}
+
+	if !bytes.Equal(files["media/p1/m1.png"], testPNG(t, 0)) {
+		t.Errorf("archive files = %v, want the original of m1", len(files))
+	}
+
+	var manifest exportManifest
+
+	if err := json.Unmarshal(files["manifest.json"], &manifest); err != nil {
+		t.Fatalf("decode manifest: %v", err)
+	}
+
+	if manifest.UserID != "alice" || len(manifest.Posts) != 2 || manifest.Posts[0].Title != "first" {
+		t.Fatalf("manifest = %+v, want alice's 2 posts", manifest)
+	}
+
+	if files := manifest.Posts[0].Files; len(files) != 2 || files[0].Path != "media/p1/m1.png" || !files[1].Missing {
+		t.Errorf("files = %+v, want m1 and m2 missing", files)
+	}
+
+	// Once the archive expires it is deleted and no longer offered.
+	stored, err := app.Models.Job.GetByID(ctx, job.ID)
+	if err != nil {
+		t.Fatalf("get job: %v", err)
+	}
+
+	stored.ExpiresAt = time.Now().Add(-time.Minute)
+	if err := app.Models.Job.Finish(ctx, stored); err != nil {
+		t.Fatalf("finish job: %v", err)
+	}
+
+	app.runQueuedJobs(ctx)
+
+	var expired data.Job
+
+	app.client.As("alice").Get("/v1/api/jobs/" + job.ID).ExpectStatus(http.StatusOK).Data(&expired)
+
+	if expired.DownloadURL != "" {
+		t.Errorf("download url = %q, want none once expired", expired.DownloadURL)
+	}
+
+	if _, err := app.BlobModel.DownloadBlob(ctx, stored.ResultKey); err == nil {
+		t.Errorf("archive %v was not deleted", stored.ResultKey)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/evansopilo/visuai/pkg/data"
+	"github.com/gofiber/fiber/v2"
+)
+
+func (app App) FollowUser(c *fiber.Ctx) error {
+
+	ctx, cancel := context.WithTimeout(c.UserContext(), time.Second*3)
+	defer cancel()
+
+	followerID := identityFrom(c).UserID
+
+	if followerID == c.Params("user_id") {
+		return newProblem(fiber.StatusBadRequest, "self_follow", "users can not follow themselves")
+	}
+
+	if err := app.Models.Follow.Create(ctx, followerID, c.Params("user_id")); err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"status": "success",
+	})
+}
+
+func (app App) UnfollowUser(c *fiber.Ctx) error {
+
+	ctx, cancel := context.WithTimeout(c.UserContext(), time.Second*3)
+	defer cancel()
+
+	if err := app.Models.Follow.Delete(ctx, identityFrom(c).UserID, c.Params("user_id")); err != nil {
+		if errors.Is(err, data.ErrNoDocument) {
+			return newProblem(fiber.StatusNotFound, "follow_not_found", fmt.Sprintf("not following user with id: %v", c.Params("user_id")))
+		}
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status": "success",
+	})
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/evansopilo/visuai/pkg/data"
+)
+
+func TestFollowUser(t *testing.T) {
+
+	app := newTestApp(t)
+
+	app.createPosts(t, data.Post{ID: "post-1", UserID: "alice", Visibility: data.VisibilityFollowers})
+
+	bob := app.client.As("bob")
+
+	app.client.Post("/v1/api/users/alice/followers", nil).ExpectProblem(http.StatusUnauthorized, "unauthenticated")
+
+	app.client.As("alice").Post("/v1/api/users/alice/followers", nil).ExpectProblem(http.StatusBadRequest, "self_follow")
+
+	bob.Get("/v1/api/posts/post-1").ExpectProblem(http.StatusNotFound, "post_not_found")
+
+	bob.Post("/v1/api/users/alice/followers", nil).ExpectStatus(http.StatusCreated)
+
+	bob.Post("/v1/api/users/alice/followers", nil).ExpectStatus(http.StatusCreated)
+
+	var posts []data.Post
+
+	bob.Get("/v1/api/users/alice/posts").ExpectStatus(http.StatusOK).Data(&posts)
+
+	assertPostIDs(t, posts, "post-1")
+
+	bob.Delete("/v1/api/users/alice/followers").ExpectStatus(http.StatusOK)
+
+	bob.Delete("/v1/api/users/alice/followers").ExpectProblem(http.StatusNotFound, "follow_not_found")
+
+	bob.Get("/v1/api/posts/post-1").ExpectProblem(http.StatusNotFound, "post_not_found")
+}
@@ -1,14 +1,97 @@
 package main
 
 import (
+	"context"
+	"sync"
 	"time"
 
+	"github.com/evansopilo/visuai/pkg/log"
 	"github.com/gofiber/fiber/v2"
 )
 
-func (app App) GetHealth(c *fiber.Ctx) error {
+// version and buildTime are set by the linker flags of the Makefile's build target.
+var (
+	version   = "dev"
+	buildTime = ""
+)
+
+// checkTimeout bounds each dependency check of the readiness probe.
+const checkTimeout = 2 * time.Second
+
+// Check checks that a dependency the application needs to serve requests is
+// reachable.
+type Check struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+type checkResult struct {
+	Status  string  `json:"status"`
+	Latency float64 `json:"latency_ms"`
+}
+
+// GetLiveness reports that the process is up, it does not check any dependency so
+// the orchestrator only restarts instances that stopped responding.
+func (app App) GetLiveness(c *fiber.Ctx) error {
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"status": "available",
-		"date":   time.Now(),
+		"status":     "available",
+		"date":       time.Now(),
+		"version":    version,
+		"build_time": buildTime,
+	})
+}
+
+// GetReadiness runs every dependency check concurrently and answers 503 when any of
+// them fails, so no traffic is routed to an instance that can not serve it.
+func (app App) GetReadiness(c *fiber.Ctx) error {
+
+	results := make(map[string]checkResult, len(app.Checks))
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+
+	for _, check := range app.Checks {
+		wg.Add(1)
+
+		go func(check Check) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(c.UserContext(), checkTimeout)
+			defer cancel()
+
+			start := time.Now()
+
+			err := check.Check(ctx)
+
+			result := checkResult{Status: "up", Latency: float64(time.Since(start).Microseconds()) / 1000}
+
+			if err != nil {
+				log.FromContext(c.UserContext()).Warn(err.Error(), map[string]interface{}{"check": check.Name})
+				result.Status = "down"
+			}
+
+			mu.Lock()
+			results[check.Name] = result
+			mu.Unlock()
+		}(check)
+	}
+
+	wg.Wait()
+
+	status, code := "ready", fiber.StatusOK
+
+	for _, result := range results {
+		if result.Status != "up" {
+			status, code = "unavailable", fiber.StatusServiceUnavailable
+		}
+	}
+
+	return c.Status(code).JSON(fiber.Map{
+		"status":     status,
+		"checks":     results,
+		"version":    version,
+		"build_time": buildTime,
 	})
 }
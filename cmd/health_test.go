@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestGetLiveness(t *testing.T) {
+
+	app := newTestApp(t)
+
+	var body struct {
+		Status  string `json:"status"`
+		Version string `json:"version"`
+	}
+
+	app.client.Get("/healthz").ExpectStatus(http.StatusOK).Decode(&body)
+
+	if body.Status != "available" || body.Version != version {
+		t.Errorf("liveness = %+v, want available %v", body, version)
+	}
+}
+
+func TestGetReadiness(t *testing.T) {
+
+	up := Check{Name: "up", Check: func(ctx context.Context) error { return nil }}
+	down := Check{Name: "down", Check: func(ctx context.Context) error { return errors.New("unreachable") }}
+
+	tests := []struct {
+		name   string
+		checks []Check
+		code   int
+		status string
+	}{
+		{"ready", []Check{up}, http.StatusOK, "ready"},
+		{"unavailable", []Check{up, down}, http.StatusServiceUnavailable, "unavailable"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			app := newTestApp(t, func(app *App) { app.Checks = tt.checks })
+
+			var body struct {
+				Status string                 `json:"status"`
+				Checks map[string]checkResult `json:"checks"`
+			}
+
+			app.client.Get("/readyz").ExpectStatus(tt.code).Decode(&body)
+
+			if body.Status != tt.status || len(body.Checks) != len(tt.checks) {
+				t.Errorf("readiness = %+v, want %v with %d checks", body, tt.status, len(tt.checks))
+			}
+
+			if len(tt.checks) > 1 && body.Checks["down"].Status != "down" {
+				t.Errorf("check down = %+v, want down", body.Checks["down"])
+			}
+		})
+	}
+}
+
+func TestMetrics(t *testing.T) {
+
+	app := newTestApp(t)
+
+	app.client.Get("/healthz").ExpectStatus(http.StatusOK)
+
+	res := app.client.Get("/metrics").ExpectStatus(http.StatusOK)
+
+	if !strings.Contains(string(res.Body), `http_requests_total{method="GET",route="/healthz",status="200"} 1`) {
+		t.Errorf("metrics do not count the liveness request:\n%s", res.Body)
+	}
+}
@@ -0,0 +1,660 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/evansopilo/visuai/pkg/data"
+	"github.com/evansopilo/visuai/pkg/log"
+	"github.com/evansopilo/visuai/pkg/phash"
+	"github.com/evansopilo/visuai/pkg/validator"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+const (
+	importConcurrency    = 4
+	maxImportConcurrency = 32
+	// importRowTimeout bounds fetching, storing and inserting the post of a row.
+	importRowTimeout = time.Minute
+	// maxImportPhotoSize is the largest photo fetched, the limits of each content
+	// type are checked once it is fetched.
+	maxImportPhotoSize = 100 << 20
+)
+
+// importNamespace derives the ids of imported posts from their external ids, so a
+// row is imported once however often it is imported again.
+var importNamespace = uuid.MustParse("30c43f17-ef54-496d-ad95-d87e1ef2554d")
+
+// importClient fetches the photos of imported rows. It only connects to public
+// addresses, so rows can not make the server reach the services of its network.
+var importClient = newImportClient(publicIP)
+
+// errPrivateAddress is returned for photos whose host, or the host they redirect
+// to, resolves to an address that is not public.
+var errPrivateAddress = errors.New("photo is not on a public address")
+
+// newImportClient returns a client that only connects to the addresses allow
+// accepts. Addresses are checked as they are dialed, after the host is resolved,
+// for the request and for each of its redirects.
+func newImportClient(allow func(ip net.IP) bool) *http.Client {
+
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !allow(ip) {
+				return fmt.Errorf("%w: %v", errPrivateAddress, host)
+			}
+			return nil
+		},
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// a proxy would be dialed in place of the photo's host.
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("redirect to unsupported scheme %q", req.URL.Scheme)
+			}
+			if ip := net.ParseIP(req.URL.Hostname()); ip != nil && !allow(ip) {
+				return fmt.Errorf("%w: %v", errPrivateAddress, ip)
+			}
+			return nil
+		},
+	}
+}
+
+// publicIP reports whether ip is a public unicast address, neither private,
+// loopback, link-local, multicast nor unspecified.
+func publicIP(ip net.IP) bool {
+	return ip.IsGlobalUnicast() && !ip.IsPrivate()
+}
+
+// Outcomes of importing a row.
+const (
+	importCreated = "created"
+	importSkipped = "skipped"
+	importFailed  = "failed"
+	// importValid is the outcome of the rows that would be created by a dry run.
+	importValid = "valid"
+)
+
+// importRow is a row of an import, it holds the fields of a post along with the id
+// of the post in the system it is imported from and the path or url of its photo.
+type importRow struct {
+	ExternalID string      `json:"external_id"`
+	UserID     string      `json:"user_id"`
+	Title      string      `json:"title"`
+	Desc       string      `json:"desc"`
+	DestURL    string      `json:"dest_url"`
+	Category   string      `json:"category"`
+	Tags       []string    `json:"tags"`
+	GeoTag     data.GeoTag `json:"geo_tag"`
+	Visibility string      `json:"visibility"`
+	CreatedAt  time.Time   `json:"created_at"`
+	Photo      string      `json:"photo"`
+	AltText    string      `json:"alt_text"`
+
+	line int
+	// err is why the row could not be read.
+	err error
+}
+
+func (row importRow) post() data.Post {
+
+	post := data.Post{
+		ID:         uuid.NewSHA1(importNamespace, []byte(row.ExternalID)).String(),
+		ExternalID: row.ExternalID,
+		UserID:     row.UserID,
+		Title:      row.Title,
+		Desc:       row.Desc,
+		DestURL:    row.DestURL,
+		Category:   row.Category,
+		Tags:       row.Tags,
+		GeoTag:     row.GeoTag,
+		Visibility: row.Visibility,
+		CreatedAt:  row.CreatedAt.UTC(),
+	}
+
+	if post.CreatedAt.IsZero() {
+		post.CreatedAt = time.Now().UTC()
+	}
+
+	return post
+}
+
+type importOptions struct {
+	// Format is jsonl or csv.
+	Format      string
+	Concurrency int
+	DryRun      bool
+	// AllowFiles lets rows refer to their photo by a local path, only the command
+	// line importer does.
+	AllowFiles bool
+}
+
+// importResult is the outcome of importing a row, the rows that are not imported
+// make up the report of an import.
+type importResult struct {
+	Line       int               `json:"line"`
+	ExternalID string            `json:"external_id,omitempty"`
+	PostID     string            `json:"post_id,omitempty"`
+	Status     string            `json:"status"`
+	Error      string            `json:"error,omitempty"`
+	Errors     map[string]string `json:"errors,omitempty"`
+}
+
+// importPosts imports the rows read from r, opts.Concurrency rows at a time, and
+// calls report with the result of every row. It returns the number of rows of each
+// outcome, a row that can not be parsed fails on its own but the import stops when
+// r can not be read any further.
+func (app App) importPosts(ctx context.Context, r io.Reader, opts importOptions, report func(importResult)) (map[string]int64, error) {
+
+	next, err := newRowReader(r, opts.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Concurrency < 1 {
+		opts.Concurrency = importConcurrency
+	}
+	if opts.Concurrency > maxImportConcurrency {
+		opts.Concurrency = maxImportConcurrency
+	}
+
+	counts := map[string]int64{"rows": 0, importCreated: 0, importSkipped: 0, importFailed: 0}
+	if opts.DryRun {
+		counts[importValid] = 0
+	}
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+
+	rows := make(chan importRow)
+
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for row := range rows {
+				result := app.importRow(ctx, row, opts)
+
+				mu.Lock()
+				counts["rows"]++
+				counts[result.Status]++
+				report(result)
+				mu.Unlock()
+			}
+		}()
+	}
+
+read:
+	for {
+		row, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			close(rows)
+			wg.Wait()
+			return counts, err
+		}
+
+		select {
+		case rows <- row:
+		case <-ctx.Done():
+			break read
+		}
+	}
+
+	close(rows)
+	wg.Wait()
+
+	return counts, ctx.Err()
+}
+
+// importRow creates the post of row with its photo, the same way a post is
+// published through the api. Rows whose post was already imported are skipped.
+func (app App) importRow(ctx context.Context, row importRow, opts importOptions) importResult {
+
+	result := importResult{Line: row.line, ExternalID: row.ExternalID}
+
+	fail := func(err error) importResult {
+		result.Status, result.Error = importFailed, err.Error()
+		var e *data.Error
+		if errors.As(err, &e) {
+			result.Errors = e.Fields
+		}
+		return result
+	}
+
+	if row.err != nil {
+		return fail(row.err)
+	}
+
+	post := row.post()
+
+	v := validator.New()
+
+	v.Check(row.ExternalID != "", "external_id", "must be provided")
+	v.Check(post.UserID != "", "user_id", "must be provided")
+	v.Check(row.Photo != "", "photo", "must be provided")
+
+	data.ValidatePost(v, &post)
+
+	if !v.Valid() {
+		return fail(data.NewValidation(v.Errors))
+	}
+
+	result.PostID = post.ID
+
+	ctx, cancel := context.WithTimeout(ctx, importRowTimeout)
+	defer cancel()
+
+	ctx = log.NewContext(ctx, log.FromContext(ctx).With(map[string]interface{}{"line": row.line, "external_id": row.ExternalID}))
+
+	_, err := app.Models.Post.GetByID(data.ContextWithViewer(ctx, data.Viewer{Moderator: true}), post.ID)
+	if err == nil {
+		result.Status = importSkipped
+		return result
+	}
+	if !errors.Is(err, data.ErrNoDocument) {
+		return fail(err)
+	}
+
+	file, err := fetchPhoto(ctx, row.Photo, opts.AllowFiles)
+	if err != nil {
+		return fail(err)
+	}
+
+	info, ok, err := app.probeMedia(ctx, file)
+	if !ok {
+		return fail(err)
+	}
+
+	item, img, _, err := app.inspectMedia(ctx, &post, file, info)
+	if err != nil {
+		return fail(err)
+	}
+
+	item.AltText = row.AltText
+
+	if opts.DryRun {
+		result.Status = importValid
+		return result
+	}
+
+	verdict := app.classify(ctx, file)
+	if verdict.Flagged {
+		post.ModerationState = data.ModerationPending
+	}
+
+	item, err = app.storeBlobs(ctx, file, img, item)
+	if err != nil {
+		return fail(err)
+	}
+
+	post.Media, post.PHash = []data.Media{item}, item.PHash
+
+	if hash, err := phash.Parse(item.PHash); err == nil {
+		post.PHashBands = hash.Bands()
+	}
+
+	if err := app.Models.Post.Create(ctx, &post); err != nil {
+		app.deleteBlobs(ctx, item)
+		// the same external id appeared on another row imported at the same time.
+		if errors.Is(err, data.ErrConflict) {
+			result.Status = importSkipped
+			return result
+		}
+		return fail(err)
+	}
+
+	if verdict.Flagged {
+		app.reportFlagged(ctx, post.ID, verdict)
+	}
+
+	result.Status = importCreated
+
+	return result
+}
+
+// fetchPhoto reads the photo of a row from an http or https url or, when allowFiles
+// is set, from a local path.
+func fetchPhoto(ctx context.Context, source string, allowFiles bool) ([]byte, error) {
+
+	var r io.Reader
+
+	if u, err := url.Parse(source); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		res, err := importClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("fetch photo: %w", err)
+		}
+		defer res.Body.Close()
+
+		if res.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("fetch photo: %v", res.Status)
+		}
+
+		r = res.Body
+	} else if allowFiles {
+		f, err := os.Open(source)
+		if err != nil {
+			return nil, fmt.Errorf("read photo: %w", err)
+		}
+		defer f.Close()
+
+		r = f
+	} else {
+		return nil, errors.New("photo must be an http or https url")
+	}
+
+	b, err := io.ReadAll(io.LimitReader(r, maxImportPhotoSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("read photo: %w", err)
+	}
+
+	if len(b) > maxImportPhotoSize {
+		return nil, fmt.Errorf("photo is larger than %v bytes", maxImportPhotoSize)
+	}
+
+	return b, nil
+}
+
+// importFormat returns the format of an import file by its extension.
+func importFormat(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".csv":
+		return "csv"
+	case ".jsonl", ".ndjson":
+		return "jsonl"
+	}
+	return ""
+}
+
+// newRowReader returns a function reading the rows of r in format one at a time, it
+// returns io.EOF after the last row.
+func newRowReader(r io.Reader, format string) (func() (importRow, error), error) {
+	switch format {
+	case "jsonl":
+		return jsonlRows(r), nil
+	case "csv":
+		return csvRows(r)
+	}
+	return nil, fmt.Errorf("unknown import format %q, it must be jsonl or csv", format)
+}
+
+// jsonlRows reads a json object on every line, blank lines are skipped.
+func jsonlRows(r io.Reader) func() (importRow, error) {
+
+	br, line := bufio.NewReader(r), 0
+
+	return func() (importRow, error) {
+		for {
+			b, err := br.ReadBytes('\n')
+			if len(b) == 0 && err != nil {
+				return importRow{}, err
+			}
+
+			line++
+
+			if b = bytes.TrimSpace(b); len(b) == 0 {
+				continue
+			}
+
+			row := importRow{line: line}
+
+			dec := json.NewDecoder(bytes.NewReader(b))
+			dec.DisallowUnknownFields()
+
+			if err := dec.Decode(&row); err != nil {
+				row.err = fmt.Errorf("invalid json: %w", err)
+			}
+
+			return row, nil
+		}
+	}
+}
+
+// csvColumns are the columns a csv import can hold, tags are separated by
+// semicolons and the geo tag is given by its longitude and latitude.
+var csvColumns = []string{"external_id", "user_id", "title", "desc", "dest_url", "category", "tags", "longitude", "latitude", "visibility", "created_at", "photo", "alt_text"}
+
+// csvRows reads the rows of a csv file whose first row names its columns.
+func csvRows(r io.Reader) (func() (importRow, error), error) {
+
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read csv header: %w", err)
+	}
+
+	for i, name := range header {
+		header[i] = strings.TrimSpace(name)
+		if !validator.In(header[i], csvColumns...) {
+			return nil, fmt.Errorf("unknown csv column %q", name)
+		}
+	}
+
+	return func() (importRow, error) {
+
+		record, err := cr.Read()
+
+		line, _ := cr.FieldPos(0)
+
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return importRow{line: parseErr.Line, err: err}, nil
+		}
+		if err != nil {
+			return importRow{}, err
+		}
+
+		row := importRow{line: line}
+
+		if len(record) != len(header) {
+			row.err = fmt.Errorf("row has %v fields, the header has %v", len(record), len(header))
+			return row, nil
+		}
+
+		var lng, lat string
+
+		for i, value := range record {
+			switch header[i] {
+			case "external_id":
+				row.ExternalID = value
+			case "user_id":
+				row.UserID = value
+			case "title":
+				row.Title = value
+			case "desc":
+				row.Desc = value
+			case "dest_url":
+				row.DestURL = value
+			case "category":
+				row.Category = value
+			case "tags":
+				for _, tag := range strings.Split(value, ";") {
+					if tag = strings.TrimSpace(tag); tag != "" {
+						row.Tags = append(row.Tags, tag)
+					}
+				}
+			case "longitude":
+				lng = value
+			case "latitude":
+				lat = value
+			case "visibility":
+				row.Visibility = value
+			case "created_at":
+				if value != "" {
+					if row.CreatedAt, err = time.Parse(time.RFC3339, value); err != nil {
+						row.err = errors.New("created_at must be an rfc 3339 time")
+					}
+				}
+			case "photo":
+				row.Photo = value
+			case "alt_text":
+				row.AltText = value
+			}
+		}
+
+		if lng != "" || lat != "" {
+			x, errX := strconv.ParseFloat(lng, 64)
+			y, errY := strconv.ParseFloat(lat, 64)
+			if errX != nil || errY != nil {
+				row.err = errors.New("longitude and latitude must be numbers")
+			}
+			row.GeoTag = data.GeoTag{Type: "Point", Coordinates: []float64{x, y}}
+		}
+
+		return row, nil
+	}, nil
+}
+
+// ImportPosts queues a job importing the posts of an uploaded jsonl or csv file
+// whose rows refer to their photos by url. The report of the rows that were not
+// imported can be downloaded from the job once it finishes. Imports larger than
+// the request body limit are run with the import command.
+func (app App) ImportPosts(c *fiber.Ctx) error {
+
+	ctx, cancel := context.WithTimeout(c.UserContext(), time.Second*10)
+	defer cancel()
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		return errFileRequired
+	}
+
+	format := c.FormValue("format", importFormat(header.Filename))
+
+	v := validator.New()
+
+	v.Check(validator.In(format, "jsonl", "csv"), "format", "must be jsonl or csv")
+
+	concurrency, err := strconv.Atoi(c.FormValue("concurrency", strconv.Itoa(importConcurrency)))
+	v.Check(err == nil && concurrency >= 1 && concurrency <= maxImportConcurrency, "concurrency", fmt.Sprintf("must be between 1 and %v", maxImportConcurrency))
+
+	dryRun, err := strconv.ParseBool(c.FormValue("dry_run", "false"))
+	v.Check(err == nil, "dry_run", "must be true or false")
+
+	if !v.Valid() {
+		return data.NewValidation(v.Errors)
+	}
+
+	file, err := readFormFile(header)
+	if err != nil {
+		return err
+	}
+
+	key, err := app.BlobModel.UploadBytesToBlob(ctx, file, importContentTypes[format], map[string]string{})
+	if err != nil {
+		return err
+	}
+
+	job := data.Job{
+		Kind:        jobImportPosts,
+		RequestedBy: identityFrom(c).UserID,
+		InputKey:    key,
+		Params: map[string]string{
+			"format":      format,
+			"concurrency": strconv.Itoa(concurrency),
+			"dry_run":     strconv.FormatBool(dryRun),
+		},
+	}
+
+	if err := app.enqueue(ctx, &job); err != nil {
+		app.deleteBlobs(ctx, data.Media{Key: key})
+		return err
+	}
+
+	c.Location("/v1/api/jobs/" + job.ID)
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"status": "success",
+		"data":   job,
+	})
+}
+
+// importContentTypes are the content types import files are stored with.
+var importContentTypes = map[string]string{
+	"jsonl": "application/x-ndjson",
+	"csv":   "text/csv",
+}
+
+// importPostsJob imports the file stored by ImportPosts and stores the report of the
+// rows that were not imported as its result, the file is deleted once imported.
+func (app App) importPostsJob(ctx context.Context, logger *log.Logger, job *data.Job) (map[string]int64, error) {
+
+	file, err := app.BlobModel.DownloadBlob(ctx, job.InputKey)
+	if err != nil {
+		return nil, fmt.Errorf("read import file: %w", err)
+	}
+
+	concurrency, _ := strconv.Atoi(job.Params["concurrency"])
+	dryRun, _ := strconv.ParseBool(job.Params["dry_run"])
+
+	opts := importOptions{Format: job.Params["format"], Concurrency: concurrency, DryRun: dryRun}
+
+	var report bytes.Buffer
+
+	enc := json.NewEncoder(&report)
+
+	counts, err := app.importPosts(ctx, bytes.NewReader(file), opts, func(result importResult) {
+		if result.Status == importFailed || result.Status == importSkipped {
+			_ = enc.Encode(result)
+		}
+	})
+	if err != nil {
+		return counts, err
+	}
+
+	key, err := app.BlobModel.UploadBytesToBlob(ctx, report.Bytes(), importContentTypes["jsonl"], map[string]string{"job_id": job.ID})
+	if err != nil {
+		return counts, fmt.Errorf("store import report: %w", err)
+	}
+
+	job.ResultKey, job.ExpiresAt = key, time.Now().Add(exportTTL)
+
+	if err := app.BlobModel.DeleteBlob(ctx, job.InputKey); err != nil {
+		logger.Warn("failed to delete import file", map[string]interface{}{"key": job.InputKey, "error": err.Error()})
+	}
+
+	return counts, nil
+}
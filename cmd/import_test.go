@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/evansopilo/visuai/pkg/apitest"
+	"github.com/evansopilo/visuai/pkg/data"
+)
+
+// photoServer serves a test png at every path but /missing and /redirect, which
+// redirects to its to query parameter. Photos are fetched from the loopback
+// address of the server until the test ends.
+func photoServer(t *testing.T) *httptest.Server {
+
+	t.Helper()
+
+	photo := testPNG(t, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/missing":
+			http.NotFound(w, r)
+		case "/redirect":
+			http.Redirect(w, r, r.URL.Query().Get("to"), http.StatusFound)
+		default:
+			_, _ = w.Write(photo)
+		}
+	}))
+
+	t.Cleanup(server.Close)
+
+	client := importClient
+	importClient = newImportClient(func(ip net.IP) bool { return ip.Equal(net.IPv4(127, 0, 0, 1)) })
+	t.Cleanup(func() { importClient = client })
+
+	return server
+}
+
+func importResults(t *testing.T, app *testApp, input string, opts importOptions) (map[string]int64, map[int]importResult) {
+
+	t.Helper()
+
+	results := map[int]importResult{}
+
+	counts, err := app.importPosts(context.Background(), strings.NewReader(input), opts, func(result importResult) {
+		results[result.Line] = result
+	})
+	if err != nil {
+		t.Fatalf("import posts: %v", err)
+	}
+
+	return counts, results
+}
+
+func TestImportPosts(t *testing.T) {
+
+	app := newTestApp(t)
+	server := photoServer(t)
+
+	path := filepath.Join(t.TempDir(), "photo.png")
+	if err := os.WriteFile(path, testPNG(t, 0), 0o600); err != nil {
+		t.Fatalf("write photo: %v", err)
+	}
+
+	input := strings.Join([]string{
+		`{"external_id":"legacy-1","user_id":"alice","title":"first","tags":["sea"],"photo":"` + path + `"}`,
+		`{"external_id":"legacy-2","user_id":"alice","photo":"` + server.URL + `/2.png","alt_text":"a photo"}`,
+		``,
+		`{"external_id":"legacy-1","user_id":"alice","photo":"` + path + `"}`,
+		`{"external_id":"legacy-3","photo":"` + path + `"}`,
+		`{"external_id":"legacy-4","user_id":"alice","photo":"` + server.URL + `/missing"}`,
+		`{"external_id":"legacy-5","user_id":"alice","unknown":true}`,
+	}, "\n")
+
+	opts := importOptions{Format: "jsonl", Concurrency: 1, AllowFiles: true}
+
+	counts, results := importResults(t, app, input, opts)
+
+	want := map[string]int64{"rows": 6, importCreated: 2, importSkipped: 1, importFailed: 3}
+	for name, count := range want {
+		if counts[name] != count {
+			t.Fatalf("counts = %v, want %v", counts, want)
+		}
+	}
+
+	if results[4].Status != importSkipped || results[4].PostID != results[1].PostID {
+		t.Errorf("line 4 = %+v, want the row of line 1 skipped", results[4])
+	}
+
+	if results[5].Errors["user_id"] == "" {
+		t.Errorf("line 5 = %+v, want a user_id error", results[5])
+	}
+
+	for _, line := range []int{6, 7} {
+		if results[line].Status != importFailed || results[line].Error == "" {
+			t.Errorf("line %v = %+v, want it failed", line, results[line])
+		}
+	}
+
+	post := app.post(t, results[1].PostID)
+
+	if post.ExternalID != "legacy-1" || post.UserID != "alice" || post.Title != "first" || len(post.Media) != 1 || post.PHash == "" || post.CreatedAt.IsZero() {
+		t.Errorf("post = %+v, want the imported post with its photo", post)
+	}
+
+	if b, err := app.BlobModel.DownloadBlob(context.Background(), post.Media[0].Key); err != nil || !bytes.Equal(b, testPNG(t, 0)) {
+		t.Errorf("photo of the imported post was not stored: %v", err)
+	}
+
+	if post := app.post(t, results[2].PostID); post.Media[0].AltText != "a photo" {
+		t.Errorf("media = %+v, want its alt text", post.Media[0])
+	}
+
+	// importing the file again skips the rows already imported.
+	counts, _ = importResults(t, app, input, importOptions{Format: "jsonl", Concurrency: 4, AllowFiles: true})
+
+	if counts[importCreated] != 0 || counts[importSkipped] != 3 {
+		t.Errorf("counts = %v, want the imported rows skipped", counts)
+	}
+}
+
+func TestImportPostsCSV(t *testing.T) {
+
+	app := newTestApp(t)
+	server := photoServer(t)
+
+	input := "external_id,user_id,title,tags,longitude,latitude,created_at,photo\n" +
+		"legacy-1,alice,\"a, title\",sea;sand,36.8,-1.3,2019-05-01T10:00:00Z," + server.URL + "/1.png\n" +
+		"legacy-2,alice,second,,x,1,," + server.URL + "/2.png\n" +
+		"legacy-3,alice\n"
+
+	counts, results := importResults(t, app, input, importOptions{Format: "csv"})
+
+	if counts[importCreated] != 1 || counts[importFailed] != 2 {
+		t.Fatalf("counts = %v, results = %+v, want 1 created and 2 failed", counts, results)
+	}
+
+	post := app.post(t, results[2].PostID)
+
+	if post.Title != "a, title" || len(post.Tags) != 2 || post.GeoTag.Coordinates[0] != 36.8 || post.CreatedAt.Year() != 2019 {
+		t.Errorf("post = %+v, want the fields of the row", post)
+	}
+
+	if _, err := app.importPosts(context.Background(), strings.NewReader("id,photo\n"), importOptions{Format: "csv"}, func(importResult) {}); err == nil {
+		t.Errorf("import of a csv with an unknown column succeeded")
+	}
+}
+
+func TestImportPostsDryRun(t *testing.T) {
+
+	app := newTestApp(t)
+	server := photoServer(t)
+
+	input := `{"external_id":"legacy-1","user_id":"alice","photo":"` + server.URL + `/1.png"}` + "\n" +
+		`{"external_id":"legacy-2","user_id":"alice","photo":"/etc/hostname"}`
+
+	counts, results := importResults(t, app, input, importOptions{Format: "jsonl", DryRun: true})
+
+	if counts[importValid] != 1 || counts[importFailed] != 1 || counts[importCreated] != 0 {
+		t.Fatalf("counts = %v, want 1 valid and 1 failed", counts)
+	}
+
+	if !strings.Contains(results[2].Error, "http or https url") {
+		t.Errorf("line 2 = %+v, want local paths refused", results[2])
+	}
+
+	if _, err := app.Models.Post.GetByID(data.ContextWithViewer(context.Background(), data.Viewer{Moderator: true}), results[1].PostID); err == nil {
+		t.Errorf("dry run created a post")
+	}
+}
+
+func TestImportPostsEndpoint(t *testing.T) {
+
+	app := newTestApp(t)
+	server := photoServer(t)
+
+	input := `{"external_id":"legacy-1","user_id":"alice","photo":"` + server.URL + `/1.png"}` + "\n" +
+		`{"external_id":"legacy-2","user_id":"alice","photo":"` + server.URL + `/missing"}` + "\n"
+
+	form := func() *apitest.Form { return apitest.NewForm().File("file", "posts.jsonl", []byte(input)) }
+
+	app.client.As("mod", "moderator").Post("/v1/api/admin/imports", form()).ExpectProblem(http.StatusForbidden, "missing_role")
+
+	admin := app.client.As("root", "admin")
+
+	admin.Post("/v1/api/admin/imports", apitest.NewForm().File("file", "posts.txt", []byte(input))).ExpectProblem(http.StatusUnprocessableEntity, "validation_failed")
+
+	var job data.Job
+
+	admin.Post("/v1/api/admin/imports", form()).ExpectStatus(http.StatusAccepted).Data(&job)
+
+	if job.Kind != jobImportPosts || job.Params["format"] != "jsonl" {
+		t.Fatalf("job = %+v, want a queued jsonl import", job)
+	}
+
+	app.runQueuedJobs(context.Background())
+
+	admin.Get("/v1/api/jobs/" + job.ID).ExpectStatus(http.StatusOK).Data(&job)
+
+	if job.Status != data.JobSucceeded || job.Counts[importCreated] != 1 || job.Counts[importFailed] != 1 || job.DownloadURL == "" {
+		t.Fatalf("job = %+v, want 1 row created and 1 failed with a report", job)
+	}
+
+	var result importResult
+
+	if err := json.Unmarshal(admin.Get(job.DownloadURL).ExpectStatus(http.StatusOK).Body, &result); err != nil {
+		t.Fatalf("decode report: %v", err)
+	}
+
+	if result.Line != 2 || result.Status != importFailed {
+		t.Errorf("report = %+v, want the failed row", result)
+	}
+}
+
+func TestFetchPhoto(t *testing.T) {
+
+	server := photoServer(t)
+	ctx := context.Background()
+
+	if _, err := fetchPhoto(ctx, server.URL+"/1.png", false); err != nil {
+		t.Fatalf("fetch photo: %v", err)
+	}
+
+	// the server is on 127.0.0.1, the only address the client of the test accepts.
+	other := strings.Replace(server.URL, "127.0.0.1", "127.0.0.2", 1)
+
+	for name, source := range map[string]string{
+		"address":  other + "/1.png",
+		"redirect": server.URL + "/redirect?to=" + url.QueryEscape(other+"/1.png"),
+	} {
+		if _, err := fetchPhoto(ctx, source, false); !errors.Is(err, errPrivateAddress) {
+			t.Errorf("fetch photo at a private %v = %v, want errPrivateAddress", name, err)
+		}
+	}
+}
+
+func TestPublicIP(t *testing.T) {
+
+	for address, want := range map[string]bool{
+		"93.184.216.34":   true,
+		"2606:4700::1111": true,
+		"127.0.0.1":       false,
+		"::1":             false,
+		"10.1.2.3":        false,
+		"172.16.0.1":      false,
+		"192.168.1.1":     false,
+		"169.254.169.254": false,
+		"fe80::1":         false,
+		"fd00::1":         false,
+		"0.0.0.0":         false,
+		"::ffff:10.0.0.1": false,
+	} {
+		if got := publicIP(net.ParseIP(address)); got != want {
+			t.Errorf("publicIP(%v) = %v, want %v", address, got, want)
+		}
+	}
+}
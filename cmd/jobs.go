@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/evansopilo/visuai/pkg/data"
+	"github.com/evansopilo/visuai/pkg/log"
+	"github.com/gofiber/fiber/v2"
+)
+
+// jobTimeout bounds how long a job runs, jobs still running after it were
+// interrupted and are queued again.
+const jobTimeout = 30 * time.Minute
+
+// Kinds of jobs.
+const (
+	jobPurgePosts     = "purge_posts"
+	jobExportUserData = "export_user_data"
+	jobImportPosts    = "import_posts"
+)
+
+// jobFunc runs a job and returns the counts of what it did, jobs that produce a blob
+// set the job's ResultKey and ExpiresAt.
+type jobFunc func(ctx context.Context, logger *log.Logger, job *data.Job) (map[string]int64, error)
+
+// call runs the job, a panic of the job fails it instead of stopping the worker.
+func (fn jobFunc) call(ctx context.Context, logger *log.Logger, job *data.Job) (counts map[string]int64, err error) {
+
+	defer func() {
+		if r := recover(); r != nil {
+			counts, err = nil, fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+
+	return fn(ctx, logger, job)
+}
+
+func (app App) jobFuncs() map[string]jobFunc {
+	return map[string]jobFunc{
+		jobPurgePosts:     app.purgePostsJob,
+		jobExportUserData: app.exportUserDataJob,
+		jobImportPosts:    app.importPostsJob,
+	}
+}
+
+// enqueue queues job and wakes the job worker.
+func (app App) enqueue(ctx context.Context, job *data.Job) error {
+
+	if err := app.Models.Job.Create(ctx, job); err != nil {
+		return err
+	}
+
+	if app.jobWake != nil {
+		select {
+		case app.jobWake <- struct{}{}:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// RunJobs runs queued jobs one at a time every interval, or as soon as one is
+// queued, until ctx is done.
+func (app App) RunJobs(ctx context.Context, interval time.Duration) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		app.runQueuedJobs(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-app.jobWake:
+		}
+	}
+}
+
+func (app App) runQueuedJobs(ctx context.Context) {
+
+	if n, err := app.Models.Job.RequeueRunning(ctx, time.Now().Add(-jobTimeout)); err != nil {
+		app.Logger.Error(err.Error(), nil)
+	} else if n > 0 {
+		app.Logger.Warn("queued interrupted jobs again", map[string]interface{}{"count": n})
+	}
+
+	app.expireJobResults(ctx)
+
+	for ctx.Err() == nil {
+		app.recordQueueDepth(ctx)
+
+		job, err := app.Models.Job.ClaimNext(ctx)
+		if err != nil {
+			if !errors.Is(err, data.ErrNoDocument) {
+				app.Logger.Error(err.Error(), nil)
+			}
+			return
+		}
+
+		app.runJob(ctx, job)
+	}
+}
+
+// runJob runs job and records its outcome. Jobs interrupted by ctx are left
+// running, to be queued again once they time out.
+func (app App) runJob(ctx context.Context, job *data.Job) {
+
+	logger := app.Logger.With(map[string]interface{}{"job_id": job.ID, "kind": job.Kind})
+
+	fn, ok := app.jobFuncs()[job.Kind]
+
+	var err error
+
+	if !ok {
+		err = errors.New("unknown job kind")
+	} else {
+		jobCtx, cancel := context.WithTimeout(log.NewContext(ctx, logger), jobTimeout)
+		job.Counts, err = fn.call(jobCtx, logger, job)
+		cancel()
+	}
+
+	if ctx.Err() != nil {
+		logger.Warn("job interrupted", nil)
+		return
+	}
+
+	job.Status = data.JobSucceeded
+	if err != nil {
+		job.Status, job.Error = data.JobFailed, err.Error()
+	}
+
+	finishCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := app.Models.Job.Finish(finishCtx, job); err != nil {
+		logger.Error(err.Error(), nil)
+		return
+	}
+
+	if job.Status == data.JobFailed {
+		logger.Error("job failed", map[string]interface{}{"error": job.Error})
+		return
+	}
+
+	logger.Info("job succeeded", map[string]interface{}{"counts": job.Counts})
+}
+
+func (app App) recordQueueDepth(ctx context.Context) {
+
+	if app.Metrics == nil {
+		return
+	}
+
+	depth, err := app.Models.Job.CountQueued(ctx)
+	if err != nil {
+		return
+	}
+
+	app.Metrics.JobQueueDepth.WithLabelValues("jobs").Set(float64(depth))
+}
+
+// GetJob returns the status of a job to the user who requested it, the user it is
+// about and moderators, along with a url to download its result until it expires.
+func (app App) GetJob(c *fiber.Ctx) error {
+
+	ctx, cancel := context.WithTimeout(c.UserContext(), time.Second*3)
+	defer cancel()
+
+	identity := identityFrom(c)
+
+	job, err := app.Models.Job.GetByID(ctx, c.Params("job_id"))
+	if err != nil {
+		if errors.Is(err, data.ErrNoDocument) {
+			return data.NewNotFound("job", c.Params("job_id"))
+		}
+		return err
+	}
+
+	if job.RequestedBy != identity.UserID && job.UserID != identity.UserID && !identity.HasRole("moderator") {
+		return data.NewNotFound("job", c.Params("job_id"))
+	}
+
+	if job.ResultKey != "" && time.Now().Before(job.ExpiresAt) {
+		if job.DownloadURL, err = app.BlobModel.SignedURL(job.ResultKey, exportURLTTL); err != nil {
+			return err
+		}
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status": "success",
+		"data":   job,
+	})
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/evansopilo/visuai/pkg/data"
+	"github.com/evansopilo/visuai/pkg/log"
+)
+
+func TestGetJob(t *testing.T) {
+
+	app := newTestApp(t)
+
+	job := data.Job{Kind: jobPurgePosts, UserID: "alice", RequestedBy: "mod"}
+	if err := app.Models.Job.Create(context.Background(), &job); err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+
+	for _, caller := range []string{"alice", "mod"} {
+		var got data.Job
+		app.client.As(caller).Get("/v1/api/jobs/" + job.ID).ExpectStatus(http.StatusOK).Data(&got)
+		if got.ID != job.ID || got.Status != data.JobQueued {
+			t.Errorf("%v: job = %+v, want %+v", caller, got, job)
+		}
+	}
+
+	app.client.As("carol", "moderator").Get("/v1/api/jobs/" + job.ID).ExpectStatus(http.StatusOK)
+
+	app.client.Get("/v1/api/jobs/"+job.ID).ExpectProblem(http.StatusUnauthorized, "unauthenticated")
+	app.client.As("bob").Get("/v1/api/jobs/"+job.ID).ExpectProblem(http.StatusNotFound, "job_not_found")
+	app.client.As("alice").Get("/v1/api/jobs/missing").ExpectProblem(http.StatusNotFound, "job_not_found")
+}
+
+func TestRunJobs(t *testing.T) {
+
+	t.Run("records failures", func(t *testing.T) {
+		app := newTestApp(t, func(app *App) {
+			app.Models.Post = failingPosts{PostStore: app.Models.Post, err: errors.New("boom")}
+		})
+		ctx := context.Background()
+
+		jobs := []data.Job{{Kind: jobPurgePosts, UserID: "alice"}, {Kind: "unknown"}}
+		for i := range jobs {
+			if err := app.Models.Job.Create(ctx, &jobs[i]); err != nil {
+				t.Fatalf("create job: %v", err)
+			}
+		}
+
+		app.runQueuedJobs(ctx)
+
+		for _, job := range jobs {
+			got, err := app.Models.Job.GetByID(ctx, job.ID)
+			if err != nil {
+				t.Fatalf("get job: %v", err)
+			}
+			if got.Status != data.JobFailed || got.Error == "" {
+				t.Errorf("job = %+v, want it failed", got)
+			}
+		}
+	})
+
+	t.Run("runs queued jobs until stopped", func(t *testing.T) {
+		wake := make(chan struct{}, 1)
+		app := newTestApp(t, func(app *App) { app.jobWake = wake })
+		app.createPosts(t, data.Post{ID: "p1", UserID: "alice"})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+
+		go func() {
+			app.RunJobs(ctx, time.Hour)
+			close(done)
+		}()
+
+		job := data.Job{Kind: jobPurgePosts, UserID: "alice"}
+		if err := app.enqueue(ctx, &job); err != nil {
+			t.Fatalf("enqueue job: %v", err)
+		}
+
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			got, err := app.Models.Job.GetByID(ctx, job.ID)
+			if err != nil {
+				t.Fatalf("get job: %v", err)
+			}
+			if got.Status == data.JobSucceeded {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("job = %+v, want it to succeed", got)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		cancel()
+		<-done
+	})
+}
+
+func TestJobFuncPanic(t *testing.T) {
+
+	fn := jobFunc(func(ctx context.Context, logger *log.Logger, job *data.Job) (map[string]int64, error) {
+		panic("boom")
+	})
+
+	if _, err := fn.call(context.Background(), log.New("json", io.Discard, -1), &data.Job{}); err == nil {
+		t.Errorf("call of a panicking job = nil, want an error")
+	}
+}
@@ -2,122 +2,403 @@ package main
 
 import (
 	"context"
-
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"os"
 	"time"
 
 	"github.com/evansopilo/visuai/pkg/blob"
+	"github.com/evansopilo/visuai/pkg/config"
 	"github.com/evansopilo/visuai/pkg/data"
+	"github.com/evansopilo/visuai/pkg/lifecycle"
 	"github.com/evansopilo/visuai/pkg/log"
+	"github.com/evansopilo/visuai/pkg/metrics"
+	"github.com/evansopilo/visuai/pkg/moderation"
 	"github.com/evansopilo/visuai/pkg/secret"
+	"github.com/evansopilo/visuai/pkg/signer"
+	"github.com/evansopilo/visuai/pkg/tracing"
+	"github.com/evansopilo/visuai/pkg/validator"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.opentelemetry.io/contrib/instrumentation/go.mongodb.org/mongo-driver/mongo/otelmongo"
 )
 
 type App struct {
-	Models    data.Models
-	BlobModel interface {
-		UploadBytesToBlob(data []byte, metadata map[string]string) (string, error)
+	Models     data.Models
+	Schema     *data.Schema
+	BlobModel  blob.Store
+	Classifier moderation.Classifier
+	Signer     *signer.Signer
+	Checks     []Check
+	Metrics    *metrics.Metrics
+	Logger     *log.Logger
+	// LogSampleRate is one in how many access log entries of successful requests
+	// are written.
+	LogSampleRate int
+	// Tenants are the Apps serving the tenants of the deployment from their own
+	// databases, requests for no tenant are served by this App.
+	Tenants []Tenant
+	// jobWake wakes the job worker when a job is queued.
+	jobWake chan struct{}
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+// setup loads the application configuration from args, parsed with the flags of
+// fs, and the environment and wires an App to its clients, which the returned
+// lifecycle manager closes. Logs are written to logOut. It exits the process when
+// the application can not be set up or mongodb can not be reached.
+func setup(fs *flag.FlagSet, args []string, logOut io.Writer) (*App, *config.Config, *lifecycle.Manager) {
+
+	cfg, logger, err := loadConfig(fs, args, logOut)
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		logger.Fatal("failed to load application configuration", map[string]interface{}{"error": err.Error()})
 	}
-	Logger interface {
-		Trace(args string, fields map[string]interface{})
 
-		Debug(args string, fields map[string]interface{})
+	app, lc := mustApp(cfg, logger, logOut)
 
-		Info(args string, fields map[string]interface{})
+	return app, cfg, lc
+}
 
-		Warn(args string, fields map[string]interface{})
+// loadConfig loads the application configuration from args, parsed with the flags
+// of fs, and the environment, and returns it with the logger it configures. The
+// logger writes json to logOut when the configuration can not be loaded.
+func loadConfig(fs *flag.FlagSet, args []string, logOut io.Writer) (*config.Config, *log.Logger, error) {
 
-		Error(args string, fields map[string]interface{})
+	logger := log.New("json", logOut, -1)
 
-		Fatal(msg string, fields map[string]interface{})
+	cfg, err := config.LoadFlags(fs, args, os.LookupEnv)
+	if err != nil {
+		return nil, logger, err
+	}
 
-		Panic(args string, fields map[string]interface{})
+	level, err := log.ParseLevel(cfg.Log.Level)
+	if err != nil {
+		return nil, logger, fmt.Errorf("invalid log level: %w", err)
 	}
-}
 
-func main() {
+	return cfg, log.New(cfg.Log.Format, logOut, level), nil
+}
 
-	logger := log.New("json", os.Stdout, -1)
+// mustApp wires an App to its clients for cfg and checks that mongodb can be
+// reached, it exits the process when it can not.
+func mustApp(cfg *config.Config, logger *log.Logger, logOut io.Writer) (*App, *lifecycle.Manager) {
 
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 
 	defer cancel()
 
-	logger.Info("connect to azure key vault with the uri obtained from the application env variables", nil)
+	lc := lifecycle.New(logger)
 
-	secret, err := secret.New(os.Getenv("azure_vault_uri"))
+	app, err := newApp(ctx, cfg, logger, logOut, lc)
 	if err != nil {
-		logger.Fatal("failed to connect to azure key vault with the uri obtained from the application env variables", nil)
+		logger.Fatal("failed to set up the application", map[string]interface{}{"error": err.Error()})
+	}
+
+	logger.Info("connect to mongodb database with the uri obtained from the application configuration", nil)
+
+	for _, check := range app.Checks {
+		if check.Name != "mongodb" {
+			continue
+		}
+
+		if err := check.Check(ctx); err != nil {
+			logger.Fatal("failed to connect to mongodb database with the uri obtained from the application configuration", map[string]interface{}{"error": err.Error()})
+		}
+	}
+
+	logger.Info("connected to mongodb database with the uri obtained from the application configuration", nil)
+
+	return app, lc
+}
+
+// errSecrets is wrapped by the errors of newApp that come from looking up the
+// secrets the configuration refers to.
+var errSecrets = errors.New("failed to look up secrets")
+
+// newSecrets returns the secret provider of cfg, cached and refreshed by a worker of
+// lc when cfg sets a cache ttl, and reports whether it is cached. It is nil when
+// cfg has no provider.
+func newSecrets(cfg *config.Config, logger *log.Logger, lc *lifecycle.Manager) (config.SecretProvider, bool, error) {
+
+	var secrets config.SecretProvider
+
+	switch cfg.Secrets.SecretProvider() {
+	case "azure":
+		logger.Info("connect to azure key vault with the uri obtained from the application configuration", nil)
+
+		vault, err := secret.New(cfg.Secrets.VaultURI)
+		if err != nil {
+			return nil, false, fmt.Errorf("%w: connect to azure key vault: %v", errSecrets, err)
+		}
+
+		secrets = vault
+	case "env":
+		secrets = secret.NewEnv("secret_")
+	case "file":
+		secrets = secret.NewFile(cfg.Secrets.Path)
+	case "none":
+		return nil, false, nil
+	default:
+		return nil, false, fmt.Errorf("unknown secret provider: %v", cfg.Secrets.Provider)
+	}
+
+	if cfg.Secrets.CacheTTL <= 0 {
+		return secrets, false, nil
 	}
 
-	logger.Info("connected to azure key vault with the uri obtained from the application env variables", nil)
+	cache := secret.NewCache(secrets, cfg.Secrets.CacheTTL)
 
-	logger.Info("get to get mongodb database uri provided in azure key vault", nil)
+	lc.Go("secret refresh", func(ctx context.Context) {
+		cache.Refresh(ctx, cfg.Secrets.CacheTTL, func(name string, err error) {
+			logger.Warn("failed to refresh secret, serving its cached value", map[string]interface{}{"secret": name, "error": err.Error()})
+		})
+	})
 
-	mongoUri, err := secret.GetSecret(ctx, "mongo_uri", "")
+	return cache, true, nil
+}
+
+// newApp resolves the secrets cfg refers to, validates it and wires an App to its
+// clients, which lc closes, tracing logs are written to logOut. The dependencies of
+// the App are not reached, its Checks reach them.
+func newApp(ctx context.Context, cfg *config.Config, logger *log.Logger, logOut io.Writer, lc *lifecycle.Manager) (*App, error) {
+
+	secrets, cached, err := newSecrets(cfg, logger, lc)
 	if err != nil {
-		logger.Fatal("failed to get mongodb database uri provided in azure key vault", nil)
+		return nil, err
 	}
 
-	logger.Info("connect to mongodb database with the uri secret obtained from azure key vault", nil)
+	refs := cfg.SecretRefs()
+
+	// the blob account key is looked up on every request so rotated keys are used.
+	blobKeyRef, rotateBlobKey := config.SecretRef(cfg.Blob.AccountKey)
+
+	// the signing key is looked up as urls and links are signed when secrets are
+	// cached, so a rotated key is used once the cache is refreshed.
+	signingKeyRef, rotateSigningKey := config.SecretRef(cfg.SigningKey)
+	rotateSigningKey = rotateSigningKey && cached
+
+	// the other secrets, the mongo uri among them, are resolved once and a rotated
+	// value is only used once the process is restarted.
+	logger.Info("resolve secrets referenced by the application configuration", nil)
+
+	if err := cfg.ResolveSecrets(ctx, secrets); err != nil {
+		return nil, fmt.Errorf("%w: %v", errSecrets, err)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	for name := range cfg.Mongo.Collections {
+		if !validator.In(name, data.CollectionNames...) {
+			return nil, fmt.Errorf("unknown collection in the application configuration: %v", name)
+		}
+	}
+
+	shutdownTracing, err := tracing.Setup(ctx, tracing.Config{
+		Exporter:    cfg.Tracing.Exporter,
+		Endpoint:    cfg.Tracing.Endpoint,
+		Insecure:    cfg.Tracing.Insecure,
+		SampleRatio: cfg.Tracing.SampleRatio,
+	}, version, logOut)
+	if err != nil {
+		return nil, fmt.Errorf("set up tracing: %w", err)
+	}
 
-	opts := options.Client().ApplyURI(*mongoUri)
+	lc.OnClose("tracing", shutdownTracing)
+
+	meter := metrics.New()
+
+	opts := options.Client().ApplyURI(cfg.Mongo.URI).SetMonitor(data.CommandMonitors(meter.CommandMonitor(), otelmongo.NewMonitor()))
 
 	client, err := mongo.NewClient(opts)
 	if err != nil {
-		logger.Fatal("failed to get mongodb database uri provided in azure key vault", nil)
+		return nil, fmt.Errorf("create mongodb client: %w", err)
 	}
 
 	if err := client.Connect(ctx); err != nil {
-		logger.Fatal("failed to get mongodb database uri provided in azure key vault", nil)
+		return nil, fmt.Errorf("connect to mongodb: %w", err)
 	}
 
-	if err := client.Ping(ctx, readpref.Primary()); err != nil {
-		logger.Fatal("failed to get mongodb database uri provided in azure key vault", nil)
+	lc.OnClose("mongodb", client.Disconnect)
+
+	collections := data.Collections(cfg.Mongo.Collections)
+
+	models := func(database string) data.Models {
+		models := data.NewModels(client, database, collections)
+		models.Post = meter.Post(models.Post)
+		return models
 	}
 
-	logger.Info("connected to mongodb database with the uri secret obtained from azure key vault", nil)
+	sign := signer.New([]byte(cfg.SigningKey))
+
+	if rotateSigningKey {
+		sign, err = signer.NewWithKeyFunc(func() ([]byte, error) {
+			key, err := secrets.GetSecret(context.Background(), signingKeyRef, "")
+			if err != nil {
+				return nil, err
+			}
+			return []byte(*key), nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%w: signing key: %v", errSecrets, err)
+		}
+	}
 
-	logger.Info("get blob endpoint of azure blob provided in azure key vault", nil)
+	app := App{
+		Models:        models(cfg.Mongo.Database),
+		Schema:        data.NewSchema(client, cfg.Mongo.Database, collections),
+		Classifier:    moderation.Noop{},
+		Signer:        sign,
+		Metrics:       meter,
+		Logger:        logger,
+		LogSampleRate: cfg.Log.SampleRate,
+		jobWake:       make(chan struct{}, 1),
+	}
 
-	blobEndpoint, err := secret.GetSecret(ctx, "blob_endpoint", "")
-	if err != nil {
-		logger.Fatal("failed get blob endpoint of azure blob provided in azure key vault", nil)
+	if cfg.Blob.Backend == "local" {
+		logger.Info("use local blob store in the directory obtained from the application configuration", nil)
+
+		local, err := blob.NewLocal(cfg.Blob.LocalDir, cfg.Blob.BaseURL, app.Signer)
+		if err != nil {
+			return nil, fmt.Errorf("use local blob store: %w", err)
+		}
+
+		app.BlobModel = local
+	} else if rotateBlobKey {
+		app.BlobModel = blob.NewWithKeyFunc(cfg.Blob.Endpoint, cfg.Blob.Container, func() (string, error) {
+			key, err := secrets.GetSecret(context.Background(), blobKeyRef, "")
+			if err != nil {
+				return "", err
+			}
+			return *key, nil
+		}, cfg.Blob.AccountName)
+	} else {
+		app.BlobModel = blob.New(cfg.Blob.Endpoint, cfg.Blob.Container, cfg.Blob.AccountKey, cfg.Blob.AccountName)
 	}
 
-	logger.Info("get blob container of azure blob provided in azure key vault", nil)
+	app.BlobModel = meter.Blob(tracing.NewBlob(app.BlobModel))
 
-	blobContainer, err := secret.GetSecret(ctx, "blob_container", "")
-	if err != nil {
-		logger.Info("failed to get blob container of azure blob provided in azure key vault", nil)
+	app.Checks = append(app.Checks,
+		Check{Name: "mongodb", Check: func(ctx context.Context) error { return client.Ping(ctx, readpref.Primary()) }},
+		Check{Name: "blob", Check: app.BlobModel.Ping},
+	)
+
+	if checker, ok := secrets.(interface{ Err() error }); ok {
+		app.Checks = append(app.Checks, Check{Name: "secrets", Check: func(ctx context.Context) error { return checker.Err() }})
+	} else if secrets != nil && len(refs) > 0 {
+		app.Checks = append(app.Checks, Check{Name: "secrets", Check: func(ctx context.Context) error {
+			_, err := secrets.GetSecret(ctx, refs[0], "")
+			return err
+		}})
 	}
 
-	logger.Info("get azure key of azure blob provided in azure key vault", nil)
+	tenants := make([]Tenant, 0, len(cfg.Tenants))
 
-	blobAzrKey, err := secret.GetSecret(ctx, "blob_azr_key", "")
-	if err != nil {
-		logger.Fatal("failed to get azure key of azure blob provided in azure key vault", nil)
+	for _, t := range cfg.Tenants {
+		tenant := app
+
+		tenant.Models = models(t.Database)
+		tenant.Schema = data.NewSchema(client, t.Database, collections)
+		tenant.Signer = app.Signer.Derive("tenant\n" + t.Name)
+		tenant.Logger = logger.With(map[string]interface{}{"tenant": t.Name})
+		tenant.jobWake = make(chan struct{}, 1)
+
+		tenants = append(tenants, Tenant{Name: t.Name, Hosts: t.Hosts, App: &tenant})
 	}
 
-	logger.Info("get azure account name of azure blob provided in azure key vault", nil)
+	app.Tenants = tenants
 
-	accountName, err := secret.GetSecret(ctx, "account_name", "")
-	if err != nil {
-		logger.Info("failed get azure account name of azure blob provided in azure key vault", nil)
+	return &app, nil
+}
+
+// serve runs the api server along with its background workers until the process is
+// asked to stop, it returns the exit code of the process.
+func serve(args []string) int {
+
+	app, cfg, lc := setup(flag.NewFlagSet("serve", flag.ContinueOnError), args, os.Stdout)
+
+	logger := app.Logger
+
+	if cfg.Mongo.Migrate {
+		for _, app := range app.apps() {
+			if err := app.migrate(context.Background()); err != nil {
+				app.Logger.Error("failed to migrate the database", map[string]interface{}{"error": err.Error()})
+				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+				defer cancel()
+				lc.Shutdown(ctx)
+				return 1
+			}
+		}
 	}
 
-	app := App{
-		Models: data.Models{
-			Post: data.NewPostModel(client),
-		},
-		BlobModel: blob.New(*blobEndpoint, *blobContainer, *blobAzrKey, *accountName),
-		Logger:    logger,
+	for name, app := range app.apps() {
+		app, suffix := app, ""
+		if name != "" {
+			suffix = " of tenant " + name
+		}
+
+		lc.Go("upload session cleanup"+suffix, func(ctx context.Context) {
+			app.CleanupUploadSessions(ctx, 10*time.Minute)
+		})
+
+		lc.Go("jobs"+suffix, func(ctx context.Context) {
+			app.RunJobs(ctx, time.Minute)
+		})
+	}
+
+	server := app.TenantRouter()
+
+	lc.OnShutdown("http", func(ctx context.Context) error {
+		return server.ShutdownWithTimeout(cfg.HTTP.ShutdownTimeout)
+	})
+
+	addr := fmt.Sprintf(":%d", cfg.HTTP.Port)
+
+	logger.Info(fmt.Sprintf("start application server to listen to port: %d", cfg.HTTP.Port), nil)
+
+	// the shutdown is given time to drain requests, stop the workers and close clients.
+	if err := lc.Run(func() error { return server.Listen(addr) }, 2*cfg.HTTP.ShutdownTimeout); err != nil {
+		logger.Error("application stopped with an error", map[string]interface{}{"error": err.Error()})
+		return 1
+	}
+
+	logger.Info("application stopped", nil)
+
+	return 0
+}
+
+// migrateTimeout bounds applying the migrations and creating the indexes as the
+// server starts, which includes waiting for another instance doing so.
+const migrateTimeout = 10 * time.Minute
+
+// migrate applies the pending migrations and then creates the missing indexes, so
+// the indexes are built over migrated documents.
+func (app App) migrate(ctx context.Context) error {
+
+	ctx, cancel := context.WithTimeout(ctx, migrateTimeout)
+	defer cancel()
+
+	migrations, err := app.Schema.Migrate(ctx, false)
+	if err != nil {
+		return err
 	}
 
-	logger.Info("start application server to listen to port: 8080", nil)
-	if err := app.Router().Listen(":8080"); err != nil {
-		logger.Info("failed to start application server to listen to port: 8080", nil)
+	indexes, err := app.Schema.CreateIndexes(ctx, false)
+	if err != nil {
+		return err
 	}
+
+	app.Logger.Info("database migrated", map[string]interface{}{"migrations": len(migrations), "indexes": len(indexes)})
+
+	return nil
 }
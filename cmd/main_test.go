@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"testing"
+
+	"github.com/evansopilo/visuai/pkg/apitest"
+	"github.com/evansopilo/visuai/pkg/blob"
+	"github.com/evansopilo/visuai/pkg/data"
+	"github.com/evansopilo/visuai/pkg/log"
+	"github.com/evansopilo/visuai/pkg/metrics"
+	"github.com/evansopilo/visuai/pkg/moderation"
+	"github.com/evansopilo/visuai/pkg/signer"
+)
+
+// testApp is an App whose models are stored in memory and whose blobs are stored
+// in a temporary directory, along with a client of its router.
+type testApp struct {
+	App
+	client *apitest.Client
+}
+
+// newTestApp returns a test app, options are applied to the App before its router
+// is built.
+func newTestApp(t *testing.T, options ...func(app *App)) *testApp {
+
+	t.Helper()
+
+	app := App{
+		Models:     data.NewMemoryModels(),
+		Classifier: moderation.Noop{},
+		Signer:     signer.New([]byte("test signing key")),
+		Metrics:    metrics.New(),
+		Logger:     log.New("json", io.Discard, -1),
+	}
+
+	store, err := blob.NewLocal(t.TempDir(), "", app.Signer)
+	if err != nil {
+		t.Fatalf("create local blob store: %v", err)
+	}
+
+	app.BlobModel = store
+
+	for _, option := range options {
+		option(&app)
+	}
+
+	return &testApp{App: app, client: apitest.New(t, app.Router())}
+}
+
+// createPosts stores posts directly in the app's post store.
+func (app *testApp) createPosts(t *testing.T, posts ...data.Post) {
+
+	t.Helper()
+
+	for i := range posts {
+		if err := app.Models.Post.Create(context.Background(), &posts[i]); err != nil {
+			t.Fatalf("create post %v: %v", posts[i].ID, err)
+		}
+	}
+}
+
+// post reads a post as a moderator, who can see every post.
+func (app *testApp) post(t *testing.T, id string) *data.Post {
+
+	t.Helper()
+
+	post, err := app.Models.Post.GetByID(data.ContextWithViewer(context.Background(), data.Viewer{Moderator: true}), id)
+	if err != nil {
+		t.Fatalf("get post %v: %v", id, err)
+	}
+
+	return post
+}
+
+// testPNG returns a png whose pattern, and so its perceptual hash, differs for
+// every value of pattern.
+func testPNG(t *testing.T, pattern int) []byte {
+
+	t.Helper()
+
+	img := image.NewGray(image.Rect(0, 0, 64, 64))
+
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			var v int
+			switch pattern % 4 {
+			case 0:
+				v = x * 4
+			case 1:
+				v = y * 4
+			case 2:
+				v = ((x / 8) + (y / 8)) % 2 * 255
+			default:
+				v = (x + y) * 2
+			}
+			img.SetGray(x, y, color.Gray{Y: uint8(v)})
+		}
+	}
+
+	var buf bytes.Buffer
+
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+
+	return buf.Bytes()
+}
+
+// classifierFunc adapts a function to a moderation.Classifier.
+type classifierFunc func(ctx context.Context, file []byte) (moderation.Verdict, error)
+
+func (f classifierFunc) Classify(ctx context.Context, file []byte) (moderation.Verdict, error) {
+	return f(ctx, file)
+}
+
+// failingPosts is a post store whose reads fail with err.
+type failingPosts struct {
+	data.PostStore
+	err error
+}
+
+func (f failingPosts) Get(ctx context.Context, skip, limit int64) (*[]data.Post, error) {
+	return nil, f.err
+}
+
+func (f failingPosts) GetByID(ctx context.Context, id string) (*data.Post, error) {
+	return nil, f.err
+}
+
+func (f failingPosts) GetAllByUserID(ctx context.Context, id string, skip, limit int64) (*[]data.Post, error) {
+	return nil, f.err
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/evansopilo/visuai/pkg/data"
+	"github.com/evansopilo/visuai/pkg/log"
+	"github.com/evansopilo/visuai/pkg/media"
+	"github.com/evansopilo/visuai/pkg/phash"
+)
+
+// orphanGrace is how old a blob no post or job refers to must be before it is
+// purged, so blobs stored by an upload whose post is not inserted yet are kept.
+const orphanGrace = 24 * time.Hour
+
+// errPendingMigrations refuses to purge orphaned blobs while migrations that set
+// the keys of the blobs posts refer to may not be applied yet.
+var errPendingMigrations = errors.New("migrations are pending, apply them with the migrate command before purging orphaned blobs")
+
+// reindexPosts recomputes the perceptual hashes of the photos of every post that
+// lack one, or of every photo when all is set, along with the duplicate bands of
+// the posts. When dryRun is set the posts that would change are only counted.
+func (app App) reindexPosts(ctx context.Context, logger *log.Logger, all, dryRun bool) (map[string]int64, error) {
+
+	counts := map[string]int64{"posts": 0, "photos": 0, "hashed": 0, "updated": 0, "failed": 0}
+
+	for after := ""; ; {
+		posts, err := app.Models.Post.Scan(ctx, after, purgeBatchSize)
+		if err != nil {
+			return counts, err
+		}
+
+		for i := range *posts {
+			post := &(*posts)[i]
+
+			counts["posts"]++
+
+			items, photoHash, changed := app.rehashPost(ctx, logger, post, all, counts)
+			if !changed {
+				continue
+			}
+
+			counts["updated"]++
+
+			if dryRun {
+				continue
+			}
+
+			hashed := *post
+			hashed.Media, hashed.PHash = items, photoHash
+
+			err := app.Models.Post.SetHashes(ctx, post.ID, items, photoHash, hashBands(&hashed))
+			if errors.Is(err, data.ErrEditConflict) {
+				logger.Warn("post changed while it was reindexed", map[string]interface{}{"post_id": post.ID})
+				counts["updated"]--
+				counts["failed"]++
+				continue
+			}
+			if err != nil {
+				return counts, err
+			}
+		}
+
+		if len(*posts) < purgeBatchSize {
+			break
+		}
+
+		after = (*posts)[len(*posts)-1].ID
+	}
+
+	return counts, nil
+}
+
+// rehashPost returns the media and the photo hash of post with their perceptual
+// hashes recomputed, and whether they or the post's bands changed.
+func (app App) rehashPost(ctx context.Context, logger *log.Logger, post *data.Post, all bool, counts map[string]int64) ([]data.Media, string, bool) {
+
+	changed := false
+
+	rehash := func(key, current string) string {
+		counts["photos"]++
+
+		if current != "" && !all {
+			return current
+		}
+
+		hash, err := app.hashBlob(ctx, key)
+		if err != nil {
+			logger.Warn("failed to hash photo", map[string]interface{}{"post_id": post.ID, "key": key, "error": err.Error()})
+			counts["failed"]++
+			return current
+		}
+
+		counts["hashed"]++
+
+		if value := hash.String(); value != current {
+			changed = true
+			return value
+		}
+
+		return current
+	}
+
+	items := make([]data.Media, len(post.Media))
+	copy(items, post.Media)
+
+	for i := range items {
+		if items[i].Kind != media.KindVideo && items[i].Key != "" {
+			items[i].PHash = rehash(items[i].Key, items[i].PHash)
+		}
+	}
+
+	photoHash := post.PHash
+
+	// the photo of posts uploaded before multi-photo posts.
+	if len(items) == 0 && post.PhotoKey != "" {
+		photoHash = rehash(post.PhotoKey, photoHash)
+	}
+
+	hashed := *post
+	hashed.Media, hashed.PHash = items, photoHash
+
+	if !sameBands(hashBands(&hashed), post.PHashBands) {
+		changed = true
+	}
+
+	return items, photoHash, changed
+}
+
+// hashBlob returns the perceptual hash of the image stored with key.
+func (app App) hashBlob(ctx context.Context, key string) (phash.Hash, error) {
+
+	file, err := app.BlobModel.DownloadBlob(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+
+	img, err := media.DecodeImage(file)
+	if err != nil {
+		return 0, err
+	}
+
+	return phash.FromImage(img), nil
+}
+
+// hashBands returns the duplicate bands of the hashes of every photo of post.
+func hashBands(post *data.Post) []string {
+
+	var bands []string
+
+	seen := map[string]bool{}
+
+	for _, hash := range postHashes(post) {
+		for _, band := range hash.Bands() {
+			if !seen[band] {
+				seen[band] = true
+				bands = append(bands, band)
+			}
+		}
+	}
+
+	return bands
+}
+
+func sameBands(a, b []string) bool {
+
+	set := make(map[string]bool, len(a))
+	for _, band := range a {
+		set[band] = true
+	}
+
+	other := make(map[string]bool, len(b))
+	for _, band := range b {
+		if !set[band] {
+			return false
+		}
+		other[band] = true
+	}
+
+	return len(set) == len(other)
+}
+
+// purgeOrphans deletes the blobs older than grace that no post or job of app or
+// of its tenants, who share the blob store, refers to and returns the keys of the
+// orphaned blobs. When dryRun is set they are only listed. Parts staged by open
+// upload sessions are not blobs yet and are left to CleanupUploadSessions. Nothing
+// is purged while the migrations of app or of a tenant are pending.
+func (app App) purgeOrphans(ctx context.Context, logger *log.Logger, grace time.Duration, dryRun bool) (map[string]int64, []string, error) {
+
+	counts := map[string]int64{"blobs": 0, "referenced": 0, "recent": 0, "orphans": 0, "deleted": 0, "failed": 0}
+
+	referenced := map[string]bool{}
+
+	for _, app := range app.apps() {
+		if err := app.checkMigrated(ctx); err != nil {
+			return counts, nil, err
+		}
+
+		if err := app.referencedBlobs(ctx, referenced); err != nil {
+			return counts, nil, err
+		}
+	}
+
+	cutoff := time.Now().Add(-grace)
+
+	orphans := []string{}
+
+	err := app.BlobModel.List(ctx, func(key string, modified time.Time) error {
+		counts["blobs"]++
+
+		if referenced[key] {
+			counts["referenced"]++
+			return nil
+		}
+
+		if modified.After(cutoff) {
+			counts["recent"]++
+			return nil
+		}
+
+		counts["orphans"]++
+		orphans = append(orphans, key)
+
+		if dryRun {
+			return nil
+		}
+
+		if err := app.BlobModel.DeleteBlob(ctx, key); err != nil {
+			logger.Warn("failed to delete orphaned blob", map[string]interface{}{"key": key, "error": err.Error()})
+			counts["failed"]++
+			return ctx.Err()
+		}
+
+		counts["deleted"]++
+
+		return nil
+	})
+
+	return counts, orphans, err
+}
+
+// checkMigrated returns errPendingMigrations when a migration of the database of
+// app is not applied yet.
+func (app App) checkMigrated(ctx context.Context) error {
+
+	if app.Schema == nil {
+		return nil
+	}
+
+	changes, err := app.Schema.Migrate(ctx, true)
+	if err != nil {
+		return err
+	}
+
+	for _, change := range changes {
+		if change.Status == data.SchemaPending {
+			return errPendingMigrations
+		}
+	}
+
+	return nil
+}
+
+// referencedBlobs adds the keys of the blobs the posts and jobs of app refer to
+// to referenced.
+func (app App) referencedBlobs(ctx context.Context, referenced map[string]bool) error {
+
+	for after := ""; ; {
+		posts, err := app.Models.Post.Scan(ctx, after, purgeBatchSize)
+		if err != nil {
+			return err
+		}
+
+		for i := range *posts {
+			for _, key := range blobKeys(&(*posts)[i]) {
+				referenced[key] = true
+			}
+		}
+
+		if len(*posts) < purgeBatchSize {
+			break
+		}
+
+		after = (*posts)[len(*posts)-1].ID
+	}
+
+	for skip := int64(0); ; skip += purgeBatchSize {
+		jobs, err := app.Models.Job.GetWithBlobs(ctx, skip, purgeBatchSize)
+		if err != nil {
+			return err
+		}
+
+		for _, job := range *jobs {
+			referenced[job.InputKey], referenced[job.ResultKey] = true, true
+		}
+
+		if len(*jobs) < purgeBatchSize {
+			return nil
+		}
+	}
+}
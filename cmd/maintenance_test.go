@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/evansopilo/visuai/pkg/data"
+	"github.com/evansopilo/visuai/pkg/media"
+	"github.com/evansopilo/visuai/pkg/phash"
+)
+
+func TestReindexPosts(t *testing.T) {
+
+	app := newTestApp(t)
+	ctx := context.Background()
+
+	upload := func(pattern int) (string, string) {
+		file := testPNG(t, pattern)
+
+		key, err := app.BlobModel.UploadBytesToBlob(ctx, file, "image/png", nil)
+		if err != nil {
+			t.Fatalf("upload blob: %v", err)
+		}
+
+		img, err := media.DecodeImage(file)
+		if err != nil {
+			t.Fatalf("decode png: %v", err)
+		}
+
+		return key, phash.FromImage(img).String()
+	}
+
+	key1, hash1 := upload(0)
+	key2, hash2 := upload(1)
+	key3, hash3 := upload(2)
+
+	current, _ := phash.Parse(hash3)
+
+	app.createPosts(t,
+		data.Post{ID: "p1", Media: []data.Media{{ID: "m1", Key: key1}}},
+		data.Post{ID: "p2", PhotoKey: key2},
+		data.Post{ID: "p3", Media: []data.Media{{ID: "m3", Key: key3, PHash: hash3}}, PHashBands: current.Bands()},
+		data.Post{ID: "p4", Media: []data.Media{{ID: "m4", Key: "video", Kind: media.KindVideo}}},
+		data.Post{ID: "p5", Media: []data.Media{{ID: "m5", Key: "missing"}}},
+	)
+
+	counts, err := app.reindexPosts(ctx, app.Logger, false, true)
+	if err != nil {
+		t.Fatalf("reindexPosts: %v", err)
+	}
+
+	if counts["posts"] != 5 || counts["updated"] != 2 || counts["failed"] != 1 {
+		t.Errorf("dry run counts = %v, want 5 posts, 2 updated and 1 failed", counts)
+	}
+
+	if post := app.post(t, "p1"); post.Media[0].PHash != "" {
+		t.Errorf("dry run hashed p1 to %v", post.Media[0].PHash)
+	}
+
+	if _, err := app.reindexPosts(ctx, app.Logger, false, false); err != nil {
+		t.Fatalf("reindexPosts: %v", err)
+	}
+
+	if post := app.post(t, "p1"); post.Media[0].PHash != hash1 || len(post.PHashBands) == 0 {
+		t.Errorf("p1 = %+v, want phash %v and its bands", post, hash1)
+	}
+
+	if post := app.post(t, "p2"); post.PHash != hash2 || len(post.PHashBands) == 0 {
+		t.Errorf("p2 = %+v, want phash %v and its bands", post, hash2)
+	}
+
+	counts, err = app.reindexPosts(ctx, app.Logger, true, false)
+	if err != nil {
+		t.Fatalf("reindexPosts: %v", err)
+	}
+
+	if counts["hashed"] != 3 || counts["updated"] != 0 {
+		t.Errorf("counts of a reindex of every photo = %v, want 3 hashed and none updated", counts)
+	}
+}
+
+func TestPurgeOrphans(t *testing.T) {
+
+	app := newTestApp(t)
+	ctx := context.Background()
+
+	upload := func(contentType string) string {
+		key, err := app.BlobModel.UploadBytesToBlob(ctx, testPNG(t, 0), contentType, nil)
+		if err != nil {
+			t.Fatalf("upload blob: %v", err)
+		}
+		return key
+	}
+
+	photo, input, orphan, legacy := upload("image/png"), upload("image/png"), upload("image/png"), upload("image/jpeg")
+
+	app.createPosts(t,
+		data.Post{ID: "p1", Media: []data.Media{{ID: "m1", Key: photo}}},
+		// a post uploaded before blob keys were stored, its blob is named by its photo url.
+		data.Post{ID: "p2", PhotoURL: "https://example.blob.core.windows.net/photos/" + legacy},
+	)
+
+	if err := app.Models.Job.Create(ctx, &data.Job{Kind: jobImportPosts, InputKey: input}); err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+
+	counts, orphans, err := app.purgeOrphans(ctx, app.Logger, time.Hour, false)
+	if err != nil {
+		t.Fatalf("purgeOrphans: %v", err)
+	}
+
+	if counts["blobs"] != 4 || counts["referenced"] != 3 || counts["recent"] != 1 || len(orphans) != 0 {
+		t.Errorf("counts = %v, orphans = %v, want the recent blob kept", counts, orphans)
+	}
+
+	counts, orphans, err = app.purgeOrphans(ctx, app.Logger, 0, true)
+	if err != nil {
+		t.Fatalf("purgeOrphans: %v", err)
+	}
+
+	if len(orphans) != 1 || orphans[0] != orphan || counts["deleted"] != 0 {
+		t.Errorf("dry run counts = %v, orphans = %v, want only %v listed", counts, orphans, orphan)
+	}
+
+	if _, err := app.BlobModel.DownloadBlob(ctx, orphan); err != nil {
+		t.Errorf("dry run deleted the orphan: %v", err)
+	}
+
+	if counts, _, err = app.purgeOrphans(ctx, app.Logger, 0, false); err != nil || counts["deleted"] != 1 {
+		t.Fatalf("purgeOrphans = %v, %v, want 1 deleted", counts, err)
+	}
+
+	if _, err := app.BlobModel.DownloadBlob(ctx, orphan); err == nil {
+		t.Errorf("orphan %v was not deleted", orphan)
+	}
+
+	for _, key := range []string{photo, input, legacy} {
+		if _, err := app.BlobModel.DownloadBlob(ctx, key); err != nil {
+			t.Errorf("referenced blob %v was deleted: %v", key, err)
+		}
+	}
+}
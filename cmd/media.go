@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/evansopilo/visuai/pkg/blob"
+	"github.com/evansopilo/visuai/pkg/data"
+	"github.com/evansopilo/visuai/pkg/log"
+	"github.com/gofiber/fiber/v2"
+)
+
+// mediaURLTTL is how long the signed photo urls returned in responses stay valid.
+const mediaURLTTL = 15 * time.Minute
+
+// signPhotoURL replaces the stored blob keys of post's photos with short-lived signed
+// urls. The post's photo_url is its cover photo, posts uploaded before keys were
+// stored keep their original photo url.
+func (app App) signPhotoURL(post *data.Post) error {
+
+	for i := range post.Media {
+		item := &post.Media[i]
+
+		url, err := app.BlobModel.SignedURL(item.Key, mediaURLTTL)
+		if err != nil {
+			return err
+		}
+		item.URL = url
+
+		for j := range item.Variants {
+			url, err := app.BlobModel.SignedURL(item.Variants[j].Key, mediaURLTTL)
+			if err != nil {
+				return err
+			}
+			item.Variants[j].URL = url
+		}
+	}
+
+	switch {
+	case len(post.Media) > 0:
+		post.PhotoURL = post.Media[0].URL
+	case post.PhotoKey != "":
+		url, err := app.BlobModel.SignedURL(post.PhotoKey, mediaURLTTL)
+		if err != nil {
+			return err
+		}
+		post.PhotoURL = url
+	}
+
+	return nil
+}
+
+func (app App) signPhotoURLs(posts []data.Post) error {
+	for i := range posts {
+		if err := app.signPhotoURL(&posts[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetMedia serves blobs of the local blob store through the urls it signs, other
+// blob stores hand out urls that point to the store itself.
+func (app App) GetMedia(c *fiber.Ctx) error {
+
+	local, ok := app.BlobModel.(blob.PathVerifier)
+	if !ok {
+		return fiber.ErrNotFound
+	}
+
+	path, err := local.VerifyPath(c.Params("key"), c.Query("expires"), c.Query("signature"))
+	if err != nil {
+		log.FromContext(c.UserContext()).Warn(err.Error(), nil)
+		return data.NewForbidden("invalid_signature", "the media url is invalid or has expired")
+	}
+
+	return c.SendFile(path)
+}
+
+func (app App) AppendPostMedia(c *fiber.Ctx) error {
+	return app.uploadMedia(c, c.Params("post_id"), true)
+}
+
+// ownedPost loads the post of the request that the caller owns, when the post can not
+// be loaded or is owned by someone else a nil post is returned along with the error
+// the request is answered with.
+func (app App) ownedPost(ctx context.Context, c *fiber.Ctx) (*data.Post, error) {
+
+	identity := identityFrom(c)
+
+	post, err := app.Models.Post.GetByID(data.ContextWithViewer(ctx, data.Viewer{UserID: identity.UserID}), c.Params("post_id"))
+	if err != nil {
+		if errors.Is(err, data.ErrNoDocument) {
+			return nil, data.NewNotFound("post", c.Params("post_id"))
+		}
+		return nil, err
+	}
+
+	if post.UserID != identity.UserID {
+		return nil, errNotOwner
+	}
+
+	return post, nil
+}
+
+func (app App) ReorderPostMedia(c *fiber.Ctx) error {
+
+	ctx, cancel := context.WithTimeout(c.UserContext(), time.Second*3)
+	defer cancel()
+
+	var input struct {
+		IDs []string `json:"ids"`
+	}
+
+	if err := c.BodyParser(&input); err != nil {
+		return errInvalidBody
+	}
+
+	post, err := app.ownedPost(ctx, c)
+	if post == nil {
+		return err
+	}
+
+	items := make(map[string]data.Media, len(post.Media))
+	for _, item := range post.Media {
+		items[item.ID] = item
+	}
+
+	media := make([]data.Media, 0, len(input.IDs))
+	for _, id := range input.IDs {
+		item, ok := items[id]
+		if !ok {
+			break
+		}
+		media = append(media, item)
+		delete(items, id)
+	}
+
+	if len(media) != len(input.IDs) || len(items) != 0 {
+		return newProblem(fiber.StatusBadRequest, "invalid_media_order", "ids must list every media item of the post exactly once")
+	}
+
+	if err := app.Models.Post.ReorderMedia(ctx, post.ID, media); err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status": "success",
+	})
+}
+
+func (app App) RemovePostMedia(c *fiber.Ctx) error {
+
+	ctx, cancel := context.WithTimeout(c.UserContext(), time.Second*3)
+	defer cancel()
+
+	post, err := app.ownedPost(ctx, c)
+	if post == nil {
+		return err
+	}
+
+	// the bands of the photos left, without the photo of posts uploaded before
+	// multi-photo posts when it is the removed cover.
+	remaining := *post
+	remaining.Media = nil
+	for _, item := range post.Media {
+		if item.ID != c.Params("media_id") {
+			remaining.Media = append(remaining.Media, item)
+		} else if item.Key != "" && item.Key == post.PhotoKey {
+			remaining.PHash = ""
+		}
+	}
+
+	if err := app.Models.Post.RemoveMedia(ctx, post, c.Params("media_id"), hashBands(&remaining)); err != nil {
+		if errors.Is(err, data.ErrNoDocument) {
+			return data.NewNotFound("media", c.Params("media_id"))
+		}
+		return err
+	}
+
+	for _, item := range post.Media {
+		if item.ID == c.Params("media_id") {
+			app.deleteBlobs(ctx, item)
+		}
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status": "success",
+	})
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/evansopilo/visuai/pkg/data"
+	"github.com/evansopilo/visuai/pkg/phash"
+)
+
+func TestGetMedia(t *testing.T) {
+
+	app := newTestApp(t)
+
+	file := testPNG(t, 0)
+
+	key, err := app.BlobModel.UploadBytesToBlob(context.Background(), file, "image/png", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url, err := app.BlobModel.SignedURL(key, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := app.client.Get(url).ExpectStatus(http.StatusOK)
+
+	if !bytes.Equal(res.Body, file) {
+		t.Errorf("media body differs from the uploaded file")
+	}
+
+	app.client.Get(url+"0").ExpectProblem(http.StatusForbidden, "invalid_signature")
+
+	app.client.Get("/v1/api/media/"+key).ExpectProblem(http.StatusForbidden, "invalid_signature")
+}
+
+func TestReorderPostMedia(t *testing.T) {
+
+	app := newTestApp(t)
+
+	app.createPosts(t, data.Post{ID: "post-1", UserID: "alice", Media: []data.Media{{ID: "a"}, {ID: "b"}, {ID: "c"}}})
+
+	order := func(ids ...string) interface{} {
+		return map[string][]string{"ids": ids}
+	}
+
+	app.client.Put("/v1/api/posts/post-1/media/order", order("c", "b", "a")).ExpectProblem(http.StatusUnauthorized, "unauthenticated")
+
+	alice := app.client.As("alice")
+
+	alice.Put("/v1/api/posts/post-1/media/order", "{").ExpectProblem(http.StatusBadRequest, "invalid_body")
+
+	app.client.As("bob").Put("/v1/api/posts/post-1/media/order", order("c", "b", "a")).ExpectProblem(http.StatusForbidden, "not_owner")
+
+	alice.Put("/v1/api/posts/missing/media/order", order("c", "b", "a")).ExpectProblem(http.StatusNotFound, "post_not_found")
+
+	for _, ids := range [][]string{{"c", "b"}, {"c", "b", "a", "a"}, {"c", "b", "d"}} {
+		alice.Put("/v1/api/posts/post-1/media/order", order(ids...)).ExpectProblem(http.StatusBadRequest, "invalid_media_order")
+	}
+
+	alice.Put("/v1/api/posts/post-1/media/order", order("c", "a", "b")).ExpectStatus(http.StatusOK)
+
+	media := app.post(t, "post-1").Media
+
+	if len(media) != 3 || media[0].ID != "c" || media[1].ID != "a" || media[2].ID != "b" {
+		t.Errorf("media = %+v, want [c a b]", media)
+	}
+}
+
+func TestRemovePostMedia(t *testing.T) {
+
+	app := newTestApp(t)
+
+	key, err := app.BlobModel.UploadBytesToBlob(context.Background(), testPNG(t, 0), "image/png", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app.createPosts(t, data.Post{ID: "post-1", UserID: "alice", Media: []data.Media{{ID: "a", Key: key}, {ID: "b"}}})
+
+	app.client.Delete("/v1/api/posts/post-1/media/a").ExpectProblem(http.StatusUnauthorized, "unauthenticated")
+
+	app.client.As("bob").Delete("/v1/api/posts/post-1/media/a").ExpectProblem(http.StatusForbidden, "not_owner")
+
+	app.client.As("alice").Delete("/v1/api/posts/post-1/media/missing").ExpectProblem(http.StatusNotFound, "media_not_found")
+
+	app.client.As("alice").Delete("/v1/api/posts/post-1/media/a").ExpectStatus(http.StatusOK)
+
+	if media := app.post(t, "post-1").Media; len(media) != 1 || media[0].ID != "b" {
+		t.Errorf("media = %+v, want [b]", media)
+	}
+
+	if _, err := app.BlobModel.DownloadBlob(context.Background(), key); err == nil {
+		t.Errorf("the blob of the removed item was not deleted")
+	}
+}
+
+func TestRemovePostMediaLegacyCover(t *testing.T) {
+
+	app := newTestApp(t)
+
+	key, err := app.BlobModel.UploadBytesToBlob(context.Background(), testPNG(t, 0), "image/jpeg", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cover, photo := "00000000000000ff", "ff00000000000000"
+
+	// a post uploaded before multi-photo posts whose photo became its cover when a
+	// photo was added to it.
+	post := data.Post{
+		ID:       "post-1",
+		UserID:   "alice",
+		PhotoURL: "https://example.blob.core.windows.net/photos/" + key,
+		PhotoKey: key,
+		PHash:    cover,
+		Media:    []data.Media{{ID: "cover", Key: key, PHash: cover}, {ID: "b", PHash: photo}},
+	}
+	post.PHashBands = hashBands(&post)
+
+	app.createPosts(t, post)
+
+	app.client.As("alice").Delete("/v1/api/posts/post-1/media/cover").ExpectStatus(http.StatusOK)
+
+	removed := app.post(t, "post-1")
+
+	if removed.PhotoKey != "" || removed.PhotoURL != "" || removed.PHash != "" || len(removed.Media) != 1 {
+		t.Errorf("post = %+v, want its photo removed along with its cover", removed)
+	}
+
+	hash, _ := phash.Parse(photo)
+
+	if !sameBands(removed.PHashBands, hash.Bands()) {
+		t.Errorf("bands = %v, want the bands of the photo left %v", removed.PHashBands, hash.Bands())
+	}
+
+	if _, err := app.BlobModel.DownloadBlob(context.Background(), key); err == nil {
+		t.Errorf("the blob of the removed cover was not deleted")
+	}
+}
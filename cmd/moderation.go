@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/evansopilo/visuai/pkg/data"
+	"github.com/evansopilo/visuai/pkg/log"
+	"github.com/evansopilo/visuai/pkg/moderation"
+	"github.com/gofiber/fiber/v2"
+)
+
+// reportThreshold is the number of users with an open report of a post after which
+// it is flagged and hidden until a moderator reviews it.
+const reportThreshold = 3
+
+// moderationActions maps the admin actions to the moderation state they apply.
+var moderationActions = map[string]string{
+	"approve": data.ModerationApproved,
+	"hide":    data.ModerationHidden,
+	"remove":  data.ModerationRemoved,
+}
+
+func (app App) ReportPost(c *fiber.Ctx) error {
+
+	ctx, cancel := context.WithTimeout(c.UserContext(), time.Second*3)
+	defer cancel()
+
+	var input struct {
+		Reason string `json:"reason"`
+	}
+
+	if err := c.BodyParser(&input); err != nil || input.Reason == "" {
+		return newProblem(fiber.StatusBadRequest, "reason_required", "a reason is required to report a post")
+	}
+
+	viewer, err := app.viewer(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	post, err := app.Models.Post.GetByID(data.ContextWithViewer(ctx, viewer), c.Params("post_id"))
+	if err != nil {
+		if errors.Is(err, data.ErrNoDocument) {
+			return data.NewNotFound("post", c.Params("post_id"))
+		}
+		return err
+	}
+
+	report := data.Report{
+		PostID:     post.ID,
+		ReporterID: identityFrom(c).UserID,
+		Source:     data.ReportSourceUser,
+		Reason:     input.Reason,
+	}
+
+	if err := app.Models.Report.Create(ctx, &report); err != nil {
+		if errors.Is(err, data.ErrConflict) {
+			return errAlreadyReported
+		}
+		return err
+	}
+
+	if !post.Hidden() {
+		count, err := app.Models.Report.CountOpenReporters(ctx, post.ID)
+		if err != nil {
+			log.FromContext(c.UserContext()).Error(err.Error(), nil)
+		} else if count >= reportThreshold {
+			if err := app.Models.Post.SetModerationState(ctx, post.ID, data.ModerationFlagged); err != nil {
+				log.FromContext(c.UserContext()).Error(err.Error(), nil)
+			}
+		}
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"status": "success",
+		"data": map[string]string{
+			"id": report.ID,
+		},
+	})
+}
+
+func (app App) GetModerationQueue(c *fiber.Ctx) error {
+
+	ctx, cancel := context.WithTimeout(c.UserContext(), time.Second*3)
+	defer cancel()
+
+	page_num, _ := strconv.Atoi(c.Query("page_num", "1"))
+
+	page_size, _ := strconv.Atoi(c.Query("page_size", "10"))
+
+	skips := page_size * (page_num - 1)
+
+	reports, err := app.Models.Report.GetOpen(ctx, int64(skips), int64(page_size))
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status": "success",
+		"data":   reports,
+	})
+}
+
+func (app App) ModeratePost(c *fiber.Ctx) error {
+
+	ctx, cancel := context.WithTimeout(c.UserContext(), time.Second*3)
+	defer cancel()
+
+	state, ok := moderationActions[c.Params("action")]
+	if !ok {
+		return newProblem(fiber.StatusBadRequest, "unknown_action", fmt.Sprintf("unknown moderation action: %v", c.Params("action")))
+	}
+
+	if err := app.Models.Post.SetModerationState(ctx, c.Params("post_id"), state); err != nil {
+		if errors.Is(err, data.ErrNoDocument) {
+			return data.NewNotFound("post", c.Params("post_id"))
+		}
+		return err
+	}
+
+	if err := app.Models.Report.ResolveByPostID(ctx, c.Params("post_id"), c.Params("action"), identityFrom(c).UserID); err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status": "success",
+	})
+}
+
+// prescreen runs the safety classifier over an uploaded file and files a report on
+// the post when it is flagged, it returns the moderation state the post should be in.
+func (app App) prescreen(ctx context.Context, postID string, file []byte) string {
+
+	verdict := app.classify(ctx, file)
+	if !verdict.Flagged {
+		return ""
+	}
+
+	app.reportFlagged(ctx, postID, verdict)
+
+	return data.ModerationPending
+}
+
+// classify runs the safety classifier over an uploaded file, files that can not be
+// classified are flagged for review.
+func (app App) classify(ctx context.Context, file []byte) moderation.Verdict {
+
+	if app.Classifier == nil {
+		return moderation.Verdict{}
+	}
+
+	verdict, err := app.Classifier.Classify(ctx, file)
+	if err != nil {
+		log.FromContext(ctx).Warn("failed to classify uploaded file, holding post for review", map[string]interface{}{"error": err.Error()})
+		verdict.Flagged, verdict.Labels = true, []string{"classifier_error"}
+	}
+
+	return verdict
+}
+
+// reportFlagged files a report on the post for a file the classifier flagged.
+func (app App) reportFlagged(ctx context.Context, postID string, verdict moderation.Verdict) {
+
+	report := data.Report{
+		PostID: postID,
+		Source: data.ReportSourceClassifier,
+		Reason: "flagged by automated pre-screen",
+		Labels: verdict.Labels,
+	}
+
+	if err := app.Models.Report.Create(ctx, &report); err != nil {
+		log.FromContext(ctx).Error(err.Error(), nil)
+	}
+}
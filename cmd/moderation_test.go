@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/evansopilo/visuai/pkg/data"
+)
+
+func TestReportPost(t *testing.T) {
+
+	app := newTestApp(t)
+
+	app.createPosts(t, data.Post{ID: "post-1", UserID: "alice"})
+
+	reason := map[string]string{"reason": "spam"}
+
+	app.client.Post("/v1/api/posts/post-1/reports", reason).ExpectProblem(http.StatusUnauthorized, "unauthenticated")
+
+	app.client.As("bob").Post("/v1/api/posts/post-1/reports", map[string]string{}).ExpectProblem(http.StatusBadRequest, "reason_required")
+
+	app.client.As("bob").Post("/v1/api/posts/missing/reports", reason).ExpectProblem(http.StatusNotFound, "post_not_found")
+
+	for i, reporter := range []string{"bob", "carol", "dave"} {
+		if state := app.post(t, "post-1").ModerationState; state != "" {
+			t.Fatalf("moderation state = %q after %d reports, want the post listed until %d", state, i, reportThreshold)
+		}
+
+		app.client.As(reporter).Post("/v1/api/posts/post-1/reports", reason).ExpectStatus(http.StatusCreated)
+	}
+
+	if state := app.post(t, "post-1").ModerationState; state != data.ModerationFlagged {
+		t.Errorf("moderation state = %q, want %q", state, data.ModerationFlagged)
+	}
+}
+
+func TestReportPostTwice(t *testing.T) {
+
+	app := newTestApp(t)
+
+	app.createPosts(t, data.Post{ID: "post-1", UserID: "alice"})
+
+	bob := app.client.As("bob")
+
+	bob.Post("/v1/api/posts/post-1/reports", map[string]string{"reason": "spam"}).ExpectStatus(http.StatusCreated)
+
+	for i := 1; i < reportThreshold; i++ {
+		bob.Post("/v1/api/posts/post-1/reports", map[string]string{"reason": "spam"}).ExpectProblem(http.StatusConflict, "already_reported")
+	}
+
+	if state := app.post(t, "post-1").ModerationState; state != "" {
+		t.Errorf("moderation state = %q after one user reported the post %d times, want it listed", state, reportThreshold)
+	}
+
+	// the user can report the post again once their report is resolved.
+	app.client.As("carol", "moderator").Post("/v1/api/admin/posts/post-1/approve", nil).ExpectStatus(http.StatusOK)
+
+	bob.Post("/v1/api/posts/post-1/reports", map[string]string{"reason": "spam"}).ExpectStatus(http.StatusCreated)
+}
+
+func TestModerationQueue(t *testing.T) {
+
+	app := newTestApp(t)
+
+	app.createPosts(t, data.Post{ID: "post-1", UserID: "alice"})
+
+	app.client.As("bob").Post("/v1/api/posts/post-1/reports", map[string]string{"reason": "spam"}).ExpectStatus(http.StatusCreated)
+
+	app.client.Get("/v1/api/admin/reports").ExpectProblem(http.StatusUnauthorized, "unauthenticated")
+
+	app.client.As("bob").Get("/v1/api/admin/reports").ExpectProblem(http.StatusForbidden, "missing_role")
+
+	moderator := app.client.As("carol", "moderator")
+
+	var reports []data.Report
+
+	moderator.Get("/v1/api/admin/reports").ExpectStatus(http.StatusOK).Data(&reports)
+
+	if len(reports) != 1 || reports[0].PostID != "post-1" || reports[0].Reason != "spam" {
+		t.Fatalf("reports = %+v, want bob's report", reports)
+	}
+
+	moderator.Post("/v1/api/admin/posts/post-1/ban", nil).ExpectProblem(http.StatusBadRequest, "unknown_action")
+
+	moderator.Post("/v1/api/admin/posts/missing/hide", nil).ExpectProblem(http.StatusNotFound, "post_not_found")
+
+	moderator.Post("/v1/api/admin/posts/post-1/hide", nil).ExpectStatus(http.StatusOK)
+
+	if state := app.post(t, "post-1").ModerationState; state != data.ModerationHidden {
+		t.Errorf("moderation state = %q, want %q", state, data.ModerationHidden)
+	}
+
+	moderator.Get("/v1/api/admin/reports").ExpectStatus(http.StatusOK).Data(&reports)
+
+	if len(reports) != 0 {
+		t.Errorf("reports = %+v, want the queue emptied by the action", reports)
+	}
+}
@@ -3,35 +3,39 @@ package main
 import (
 	"context"
 	"errors"
-	"fmt"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/evansopilo/visuai/pkg/data"
+	"github.com/evansopilo/visuai/pkg/validator"
 	"github.com/gofiber/fiber/v2"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 func (app App) CreatePost(c *fiber.Ctx) error {
 
-	ctx, cancel := context.WithTimeout(c.Context(), time.Second*3)
+	ctx, cancel := context.WithTimeout(c.UserContext(), time.Second*3)
 	defer cancel()
 
 	var post data.Post
 
 	if err := c.BodyParser(&post); err != nil {
-		app.Logger.Error(err.Error(), map[string]interface{}{"requestid": c.Locals("requestid")})
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error",
-		})
+		return errInvalidBody
+	}
+
+	post.UserID = identityFrom(c).UserID
+
+	// moderation state and media are only changed through their own endpoints.
+	post.ModerationState, post.Media = "", nil
+
+	v := validator.New()
+
+	if data.ValidatePost(v, &post); !v.Valid() {
+		return data.NewValidation(v.Errors)
 	}
 
 	if err := app.Models.Post.Create(ctx, &post); err != nil {
-		app.Logger.Error(err.Error(), map[string]interface{}{"requestid": c.Locals("requestid")})
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"status": "error",
-		})
+		return err
 	}
 
 	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
@@ -44,24 +48,30 @@ func (app App) CreatePost(c *fiber.Ctx) error {
 
 func (app App) GetPostByID(c *fiber.Ctx) error {
 
-	ctx, cancel := context.WithTimeout(c.Context(), time.Second*3)
+	ctx, cancel := context.WithTimeout(c.UserContext(), time.Second*3)
 	defer cancel()
 
-	post, err := app.Models.Post.GetByID(ctx, c.Params("post_id"))
+	viewer, err := app.viewer(ctx, c)
 	if err != nil {
-		switch {
-		case errors.Is(err, mongo.ErrNoDocuments):
-			app.Logger.Error(err.Error(), map[string]interface{}{"requestid": c.Locals("requestid")})
-			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-				"status":  "error",
-				"message": fmt.Sprintf("document with id: %v not found", c.Params("post_id")),
-			})
-		default:
-			app.Logger.Error(err.Error(), map[string]interface{}{"requestid": c.Locals("requestid")})
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"status": "error",
-			})
+		return err
+	}
+
+	viewer.Moderator = identityFrom(c).HasRole("moderator")
+
+	post, err := app.Models.Post.GetByID(data.ContextWithViewer(ctx, viewer), c.Params("post_id"))
+	if err != nil {
+		if errors.Is(err, data.ErrNoDocument) {
+			return data.NewNotFound("post", c.Params("post_id"))
 		}
+		return err
+	}
+
+	if identity := identityFrom(c); post.Hidden() && post.UserID != identity.UserID && !identity.HasRole("moderator") {
+		return data.NewNotFound("post", c.Params("post_id"))
+	}
+
+	if err := app.signPhotoURL(post); err != nil {
+		return err
 	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
@@ -72,7 +82,7 @@ func (app App) GetPostByID(c *fiber.Ctx) error {
 
 func (app App) GetPostByUserID(c *fiber.Ctx) error {
 
-	ctx, cancel := context.WithTimeout(c.Context(), time.Second*3)
+	ctx, cancel := context.WithTimeout(c.UserContext(), time.Second*3)
 	defer cancel()
 
 	page_num, _ := strconv.Atoi(c.Query("page_num", "1"))
@@ -81,12 +91,18 @@ func (app App) GetPostByUserID(c *fiber.Ctx) error {
 
 	skips := page_size * (page_num - 1)
 
-	post, err := app.Models.Post.GetByUserID(ctx, c.Params("user_id"), int64(skips), int64(page_size))
+	viewer, err := app.viewer(ctx, c)
 	if err != nil {
-		app.Logger.Error(err.Error(), map[string]interface{}{"requestid": c.Locals("requestid")})
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"status": "error",
-		})
+		return err
+	}
+
+	post, err := app.Models.Post.GetByUserID(data.ContextWithViewer(ctx, viewer), c.Params("user_id"), int64(skips), int64(page_size))
+	if err != nil {
+		return err
+	}
+
+	if err := app.signPhotoURLs(*post); err != nil {
+		return err
 	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
@@ -97,7 +113,7 @@ func (app App) GetPostByUserID(c *fiber.Ctx) error {
 
 func (app App) GetPostByCategory(c *fiber.Ctx) error {
 
-	ctx, cancel := context.WithTimeout(c.Context(), time.Second*3)
+	ctx, cancel := context.WithTimeout(c.UserContext(), time.Second*3)
 	defer cancel()
 
 	page_num, _ := strconv.Atoi(c.Query("page_num", "1"))
@@ -106,12 +122,18 @@ func (app App) GetPostByCategory(c *fiber.Ctx) error {
 
 	skips := page_size * (page_num - 1)
 
-	post, err := app.Models.Post.GetByCategory(ctx, c.Params("category"), int64(skips), int64(page_size))
+	viewer, err := app.viewer(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	post, err := app.Models.Post.GetByCategory(data.ContextWithViewer(ctx, viewer), c.Params("category"), int64(skips), int64(page_size))
 	if err != nil {
-		app.Logger.Error(err.Error(), map[string]interface{}{"requestid": c.Locals("requestid")})
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"status": "error",
-		})
+		return err
+	}
+
+	if err := app.signPhotoURLs(*post); err != nil {
+		return err
 	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
@@ -122,7 +144,7 @@ func (app App) GetPostByCategory(c *fiber.Ctx) error {
 
 func (app App) GetPostByTags(c *fiber.Ctx) error {
 
-	ctx, cancel := context.WithTimeout(c.Context(), time.Second*3)
+	ctx, cancel := context.WithTimeout(c.UserContext(), time.Second*3)
 	defer cancel()
 
 	page_num, _ := strconv.Atoi(c.Query("page_num", "1"))
@@ -131,12 +153,18 @@ func (app App) GetPostByTags(c *fiber.Ctx) error {
 
 	skips := page_size * (page_num - 1)
 
-	post, err := app.Models.Post.GetByTags(ctx, strings.Split(c.Query("v"), ","), int64(skips), int64(page_size))
+	viewer, err := app.viewer(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	post, err := app.Models.Post.GetByTags(data.ContextWithViewer(ctx, viewer), strings.Split(c.Query("v"), ","), int64(skips), int64(page_size))
 	if err != nil {
-		app.Logger.Error(err.Error(), map[string]interface{}{"requestid": c.Locals("requestid")})
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"status": "error",
-		})
+		return err
+	}
+
+	if err := app.signPhotoURLs(*post); err != nil {
+		return err
 	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
@@ -147,7 +175,7 @@ func (app App) GetPostByTags(c *fiber.Ctx) error {
 
 func (app App) GetPost(c *fiber.Ctx) error {
 
-	ctx, cancel := context.WithTimeout(c.Context(), time.Second*3)
+	ctx, cancel := context.WithTimeout(c.UserContext(), time.Second*3)
 	defer cancel()
 
 	page_num, _ := strconv.Atoi(c.Query("page_num", "1"))
@@ -156,12 +184,18 @@ func (app App) GetPost(c *fiber.Ctx) error {
 
 	skips := page_size * (page_num - 1)
 
-	post, err := app.Models.Post.Get(ctx, int64(skips), int64(page_size))
+	viewer, err := app.viewer(ctx, c)
 	if err != nil {
-		app.Logger.Error(err.Error(), map[string]interface{}{"requestid": c.Locals("requestid")})
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"status": "error",
-		})
+		return err
+	}
+
+	post, err := app.Models.Post.Get(data.ContextWithViewer(ctx, viewer), int64(skips), int64(page_size))
+	if err != nil {
+		return err
+	}
+
+	if err := app.signPhotoURLs(*post); err != nil {
+		return err
 	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
@@ -172,58 +206,35 @@ func (app App) GetPost(c *fiber.Ctx) error {
 
 func (app App) UpdatePost(c *fiber.Ctx) error {
 
-	ctx, cancel := context.WithTimeout(c.Context(), time.Second*3)
+	ctx, cancel := context.WithTimeout(c.UserContext(), time.Second*3)
 	defer cancel()
 
 	var post data.Post
 
 	if err := c.BodyParser(&post); err != nil {
-		app.Logger.Error(err.Error(), map[string]interface{}{"requestid": c.Locals("requestid")})
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"status": "error",
-		})
+		return errInvalidBody
 	}
 
-	if err := app.Models.Post.UpdateByID(ctx, c.Params("post_id"), &post); err != nil {
-		switch {
-		case errors.Is(err, data.ErrNoDocument):
-			app.Logger.Error(err.Error(), map[string]interface{}{"requestid": c.Locals("requestid")})
-			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-				"status":  "error",
-				"message": fmt.Sprintf("document with id: %v not found", c.Params("post_id")),
-			})
-		default:
-			app.Logger.Error(err.Error(), map[string]interface{}{"requestid": c.Locals("requestid")})
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"status": "error",
-			})
-		}
-	}
+	// the id and owner of a post never change, its moderation state and media are
+	// only changed through their own endpoints and its hash is set by the server.
+	post.ID, post.UserID, post.ModerationState, post.Media = "", "", "", nil
+	post.PhotoURL, post.PHash, post.CreatedAt = "", "", time.Time{}
 
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"status": "success",
-	})
-}
+	v := validator.New()
 
-func (app App) DeletePostByID(c *fiber.Ctx) error {
+	if data.ValidatePost(v, &post); !v.Valid() {
+		return data.NewValidation(v.Errors)
+	}
 
-	ctx, cancel := context.WithTimeout(c.Context(), time.Second*3)
-	defer cancel()
+	if owned, err := app.ownedPost(ctx, c); owned == nil {
+		return err
+	}
 
-	if err := app.Models.Post.DeleteByID(ctx, c.Params("post_id")); err != nil {
-		switch {
-		case errors.Is(err, data.ErrNoDocument):
-			app.Logger.Error(err.Error(), map[string]interface{}{"requestid": c.Locals("requestid")})
-			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-				"status":  "error",
-				"message": fmt.Sprintf("document with id: %v not found", c.Params("post_id")),
-			})
-		default:
-			app.Logger.Error(err.Error(), map[string]interface{}{"requestid": c.Locals("requestid")})
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"status": "error",
-			})
+	if err := app.Models.Post.UpdateByID(ctx, c.Params("post_id"), &post); err != nil {
+		if errors.Is(err, data.ErrNoDocument) {
+			return data.NewNotFound("post", c.Params("post_id"))
 		}
+		return err
 	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
@@ -231,25 +242,20 @@ func (app App) DeletePostByID(c *fiber.Ctx) error {
 	})
 }
 
-func (app App) DeletePostByUserID(c *fiber.Ctx) error {
+func (app App) DeletePostByID(c *fiber.Ctx) error {
 
-	ctx, cancel := context.WithTimeout(c.Context(), time.Second*3)
+	ctx, cancel := context.WithTimeout(c.UserContext(), time.Second*3)
 	defer cancel()
 
-	if err := app.Models.Post.DeleteByID(ctx, c.Params("user_id")); err != nil {
-		switch {
-		case errors.Is(err, data.ErrNoDocument):
-			app.Logger.Error(err.Error(), map[string]interface{}{"requestid": c.Locals("requestid")})
-			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-				"status":  "error",
-				"message": fmt.Sprintf("document with user id: %v not found", c.Params("user_id")),
-			})
-		default:
-			app.Logger.Error(err.Error(), map[string]interface{}{"requestid": c.Locals("requestid")})
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"status": "error",
-			})
+	if owned, err := app.ownedPost(ctx, c); owned == nil {
+		return err
+	}
+
+	if err := app.Models.Post.DeleteByID(ctx, c.Params("post_id")); err != nil {
+		if errors.Is(err, data.ErrNoDocument) {
+			return data.NewNotFound("post", c.Params("post_id"))
 		}
+		return err
 	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/evansopilo/visuai/pkg/data"
+)
+
+func TestCreatePost(t *testing.T) {
+
+	app := newTestApp(t)
+
+	app.client.Post("/v1/api/posts", data.Post{ID: "post-1"}).ExpectProblem(http.StatusUnauthorized, "unauthenticated")
+
+	alice := app.client.As("alice")
+
+	alice.Post("/v1/api/posts", "{").ExpectProblem(http.StatusBadRequest, "invalid_body")
+
+	alice.Post("/v1/api/posts", data.Post{ID: "post-1", Visibility: "friends"}).ExpectProblem(http.StatusUnprocessableEntity, "validation_failed")
+
+	var created struct {
+		ID string `json:"id"`
+	}
+
+	alice.Post("/v1/api/posts", data.Post{ID: "post-1", UserID: "bob", Title: "title", ModerationState: data.ModerationApproved}).
+		ExpectStatus(http.StatusCreated).Data(&created)
+
+	if created.ID != "post-1" {
+		t.Errorf("id = %q, want post-1", created.ID)
+	}
+
+	if post := app.post(t, "post-1"); post.Title != "title" || post.UserID != "alice" || post.ModerationState != "" {
+		t.Errorf("post = %+v, want the title set, the caller as its user and the moderation state ignored", post)
+	}
+
+	alice.Post("/v1/api/posts", data.Post{ID: "post-1"}).ExpectProblem(http.StatusConflict, "duplicate")
+}
+
+func TestGetPostByID(t *testing.T) {
+
+	app := newTestApp(t)
+
+	app.createPosts(t,
+		data.Post{ID: "public", UserID: "alice", Media: []data.Media{{ID: "media-1", Key: "key-1"}}},
+		data.Post{ID: "private", UserID: "alice", Visibility: data.VisibilityPrivate},
+		data.Post{ID: "hidden", UserID: "alice", ModerationState: data.ModerationHidden},
+	)
+
+	var post data.Post
+
+	app.client.Get("/v1/api/posts/public").ExpectStatus(http.StatusOK).Data(&post)
+
+	if post.PhotoURL == "" || post.Media[0].URL != post.PhotoURL {
+		t.Errorf("post = %+v, want its media urls signed", post)
+	}
+
+	app.client.Get("/v1/api/posts/missing").ExpectProblem(http.StatusNotFound, "post_not_found")
+
+	for _, id := range []string{"private", "hidden"} {
+		app.client.Get("/v1/api/posts/"+id).ExpectProblem(http.StatusNotFound, "post_not_found")
+		app.client.As("bob").Get("/v1/api/posts/"+id).ExpectProblem(http.StatusNotFound, "post_not_found")
+		app.client.As("alice").Get("/v1/api/posts/" + id).ExpectStatus(http.StatusOK)
+		app.client.As("carol", "moderator").Get("/v1/api/posts/" + id).ExpectStatus(http.StatusOK)
+	}
+}
+
+func TestListPosts(t *testing.T) {
+
+	app := newTestApp(t)
+
+	app.createPosts(t,
+		data.Post{ID: "post-1", UserID: "alice", Category: "travel", Tags: []string{"a", "b"}},
+		data.Post{ID: "post-2", UserID: "bob", Category: "food", Tags: []string{"a"}},
+		data.Post{ID: "post-3", UserID: "alice", Category: "travel", Visibility: data.VisibilityPrivate},
+		data.Post{ID: "post-4", UserID: "alice", ModerationState: data.ModerationFlagged},
+	)
+
+	tests := []struct {
+		name string
+		path string
+		want []string
+	}{
+		{"all", "/v1/api/posts", []string{"post-1", "post-2"}},
+		{"page", "/v1/api/posts?page_num=2&page_size=1", []string{"post-2"}},
+		{"tags", "/v1/api/posts/tags?v=a,b", []string{"post-1"}},
+		{"tag", "/v1/api/posts/tags?v=a", []string{"post-1", "post-2"}},
+		{"user", "/v1/api/users/alice/posts", []string{"post-1"}},
+		{"category", "/v1/api/category/travel/posts", []string{"post-1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			var posts []data.Post
+
+			app.client.Get(tt.path).ExpectStatus(http.StatusOK).Data(&posts)
+
+			assertPostIDs(t, posts, tt.want...)
+		})
+	}
+
+	t.Run("owner", func(t *testing.T) {
+
+		var posts []data.Post
+
+		app.client.As("alice").Get("/v1/api/users/alice/posts").ExpectStatus(http.StatusOK).Data(&posts)
+
+		assertPostIDs(t, posts, "post-1", "post-3")
+	})
+}
+
+func TestUpdatePost(t *testing.T) {
+
+	app := newTestApp(t)
+
+	app.createPosts(t, data.Post{ID: "post-1", UserID: "alice", Title: "title", Desc: "desc", Visibility: data.VisibilityPrivate})
+
+	alice := app.client.As("alice")
+
+	app.client.Patch("/v1/api/posts/post-1", data.Post{Visibility: data.VisibilityPublic}).ExpectProblem(http.StatusUnauthorized, "unauthenticated")
+
+	app.client.As("bob").Patch("/v1/api/posts/post-1", data.Post{Visibility: data.VisibilityPublic}).ExpectProblem(http.StatusNotFound, "post_not_found")
+
+	alice.Patch("/v1/api/posts/post-1", "{").ExpectProblem(http.StatusBadRequest, "invalid_body")
+
+	alice.Patch("/v1/api/posts/post-1", data.Post{Visibility: "friends"}).ExpectProblem(http.StatusUnprocessableEntity, "validation_failed")
+
+	alice.Patch("/v1/api/posts/post-1", data.Post{DestURL: "javascript:alert(1)"}).ExpectProblem(http.StatusUnprocessableEntity, "validation_failed")
+
+	alice.Patch("/v1/api/posts/missing", data.Post{Title: "new title"}).ExpectProblem(http.StatusNotFound, "post_not_found")
+
+	alice.Patch("/v1/api/posts/post-1", data.Post{ID: "post-2", UserID: "bob", Title: "new title", ModerationState: data.ModerationApproved}).ExpectStatus(http.StatusOK)
+
+	if post := app.post(t, "post-1"); post.Title != "new title" || post.Desc != "desc" || post.UserID != "alice" || post.ModerationState != "" {
+		t.Errorf("post = %+v, want only its title changed", post)
+	}
+
+	app.createPosts(t, data.Post{ID: "post-3", UserID: "carol"})
+
+	alice.Patch("/v1/api/posts/post-3", data.Post{Title: "mine"}).ExpectProblem(http.StatusForbidden, "not_owner")
+}
+
+func TestDeletePostByID(t *testing.T) {
+
+	app := newTestApp(t)
+
+	app.createPosts(t, data.Post{ID: "post-1", UserID: "alice"})
+
+	app.client.Delete("/v1/api/posts/post-1").ExpectProblem(http.StatusUnauthorized, "unauthenticated")
+
+	app.client.As("bob").Delete("/v1/api/posts/post-1").ExpectProblem(http.StatusForbidden, "not_owner")
+
+	app.client.As("alice").Delete("/v1/api/posts/post-1").ExpectStatus(http.StatusOK)
+
+	app.client.As("alice").Delete("/v1/api/posts/post-1").ExpectProblem(http.StatusNotFound, "post_not_found")
+}
+
+func assertPostIDs(t *testing.T, posts []data.Post, want ...string) {
+
+	t.Helper()
+
+	got := make([]string, 0, len(posts))
+	for _, post := range posts {
+		got = append(got, post.ID)
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("posts = %v, want %v", got, want)
+	}
+
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("posts = %v, want %v", got, want)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"mime/multipart"
+	"time"
+
+	"github.com/evansopilo/visuai/pkg/data"
+	"github.com/evansopilo/visuai/pkg/moderation"
+	"github.com/evansopilo/visuai/pkg/phash"
+	"github.com/evansopilo/visuai/pkg/validator"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// publishTimeout bounds creating a post along with the upload of all its media.
+const publishTimeout = 30 * time.Second
+
+// CreatePostWithMedia creates a post together with its media from a multipart form
+// holding the post as json in the post field, its files in order in the file fields
+// and their alt text at the same positions in the alt_text fields. The post is only
+// inserted once all its media is stored, and the stored blobs are deleted when any
+// step fails so no blob is left without a post.
+func (app App) CreatePostWithMedia(c *fiber.Ctx) error {
+
+	ctx, cancel := context.WithTimeout(c.UserContext(), publishTimeout)
+	defer cancel()
+
+	form, err := c.MultipartForm()
+	if err != nil || len(form.Value["post"]) == 0 {
+		return newProblem(fiber.StatusBadRequest, "post_field_required", "a multipart form with a post field is required")
+	}
+
+	var post data.Post
+
+	if err := json.Unmarshal([]byte(form.Value["post"][0]), &post); err != nil {
+		return newProblem(fiber.StatusBadRequest, "invalid_post", "the post field must hold a json post")
+	}
+
+	post.UserID, post.CreatedAt = identityFrom(c).UserID, time.Now().UTC()
+
+	// moderation state and media are set by the server.
+	post.ModerationState, post.Media, post.PhotoKey, post.PhotoURL = "", nil, "", ""
+	post.PHash, post.PHashBands = "", nil
+
+	if post.ID == "" {
+		post.ID = uuid.NewString()
+	}
+
+	v := validator.New()
+
+	data.ValidatePost(v, &post)
+
+	files := form.File["file"]
+
+	v.Check(len(files) > 0, "file", "must be provided")
+	v.Check(len(files) <= data.MaxMediaItems, "file", fmt.Sprintf("must not hold more than %v files", data.MaxMediaItems))
+
+	if !v.Valid() {
+		return data.NewValidation(v.Errors)
+	}
+
+	type upload struct {
+		file    []byte
+		img     image.Image
+		item    data.Media
+		verdict moderation.Verdict
+	}
+
+	uploads := make([]upload, 0, len(files))
+
+	var duplicates []Duplicate
+
+	for i, header := range files {
+		file, err := readFormFile(header)
+		if err != nil {
+			return err
+		}
+
+		info, ok, err := app.probeMedia(ctx, file)
+		if !ok {
+			return err
+		}
+
+		item, img, found, err := app.inspectMedia(ctx, &post, file, info)
+		if err != nil {
+			return err
+		}
+
+		if altText := form.Value["alt_text"]; i < len(altText) {
+			item.AltText = altText[i]
+		}
+
+		duplicates = append(duplicates, found...)
+		uploads = append(uploads, upload{file: file, img: img, item: item})
+	}
+
+	if len(duplicates) > 0 && c.FormValue("allow_duplicate") != "true" {
+		return duplicateConflict(duplicates)
+	}
+
+	for i := range uploads {
+		uploads[i].verdict = app.classify(ctx, uploads[i].file)
+		if uploads[i].verdict.Flagged {
+			post.ModerationState = data.ModerationPending
+		}
+	}
+
+	for i := range uploads {
+		item, err := app.storeBlobs(ctx, uploads[i].file, uploads[i].img, uploads[i].item)
+		if err != nil {
+			app.deleteBlobs(ctx, post.Media...)
+			return err
+		}
+
+		post.Media = append(post.Media, item)
+
+		if hash, err := phash.Parse(item.PHash); err == nil {
+			post.PHashBands = append(post.PHashBands, hash.Bands()...)
+		}
+	}
+
+	if err := app.Models.Post.Create(ctx, &post); err != nil {
+		app.deleteBlobs(ctx, post.Media...)
+		return err
+	}
+
+	for _, u := range uploads {
+		if u.verdict.Flagged {
+			app.reportFlagged(ctx, post.ID, u.verdict)
+		}
+	}
+
+	mediaIDs := make([]string, 0, len(post.Media))
+	for _, item := range post.Media {
+		mediaIDs = append(mediaIDs, item.ID)
+	}
+
+	response := fiber.Map{
+		"status": "success",
+		"data": fiber.Map{
+			"id":        post.ID,
+			"media_ids": mediaIDs,
+		},
+	}
+
+	if len(duplicates) > 0 {
+		response["warning"] = "a near-duplicate of this photo has already been uploaded"
+		response["duplicates"] = duplicateIDs(duplicates)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(response)
+}
+
+func readFormFile(header *multipart.FileHeader) ([]byte, error) {
+
+	f, err := header.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return io.ReadAll(f)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/evansopilo/visuai/pkg/apitest"
+	"github.com/evansopilo/visuai/pkg/blob"
+	"github.com/evansopilo/visuai/pkg/data"
+)
+
+func postField(t *testing.T, post data.Post) string {
+
+	t.Helper()
+
+	b, err := json.Marshal(post)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(b)
+}
+
+func TestCreatePostWithMedia(t *testing.T) {
+
+	app := newTestApp(t)
+
+	form := func() *apitest.Form {
+		return apitest.NewForm().
+			Field("post", postField(t, data.Post{Title: "title", Visibility: data.VisibilityPublic})).
+			File("file", "first.png", testPNG(t, 0)).
+			File("file", "second.png", testPNG(t, 1)).
+			Field("alt_text", "first").
+			Field("alt_text", "second")
+	}
+
+	app.client.Post("/v1/api/posts/with-media", form()).ExpectProblem(http.StatusUnauthorized, "unauthenticated")
+
+	alice := app.client.As("alice")
+
+	alice.Post("/v1/api/posts/with-media", apitest.NewForm().File("file", "photo.png", testPNG(t, 0))).
+		ExpectProblem(http.StatusBadRequest, "post_field_required")
+
+	alice.Post("/v1/api/posts/with-media", apitest.NewForm().Field("post", "{")).
+		ExpectProblem(http.StatusBadRequest, "invalid_post")
+
+	p := alice.Post("/v1/api/posts/with-media", apitest.NewForm().Field("post", postField(t, data.Post{DestURL: "ftp://example.com"}))).
+		ExpectProblem(http.StatusUnprocessableEntity, "validation_failed")
+
+	if p.Errors["file"] == "" || p.Errors["dest_url"] == "" {
+		t.Errorf("errors = %v, want the file and dest_url fields", p.Errors)
+	}
+
+	alice.Post("/v1/api/posts/with-media", apitest.NewForm().Field("post", postField(t, data.Post{})).File("file", "notes.txt", []byte("notes"))).
+		ExpectProblem(http.StatusUnsupportedMediaType, "unsupported_media_type")
+
+	var created struct {
+		ID       string   `json:"id"`
+		MediaIDs []string `json:"media_ids"`
+	}
+
+	alice.Post("/v1/api/posts/with-media", form()).ExpectStatus(http.StatusCreated).Data(&created)
+
+	post := app.post(t, created.ID)
+
+	if post.UserID != "alice" || len(post.Media) != 2 || post.Media[0].ID != created.MediaIDs[0] || post.Media[1].AltText != "second" {
+		t.Errorf("post = %+v, want alice's post with both items", post)
+	}
+
+	alice.Post("/v1/api/posts/with-media", form()).ExpectProblem(http.StatusConflict, "near_duplicate")
+}
+
+func TestCreatePostWithMediaRollsBackBlobs(t *testing.T) {
+
+	dir := t.TempDir()
+
+	app := newTestApp(t, func(app *App) {
+		store, err := blob.NewLocal(dir, "", app.Signer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		app.BlobModel = store
+	})
+
+	app.createPosts(t, data.Post{ID: "post-1", UserID: "bob"})
+
+	form := apitest.NewForm().
+		Field("post", postField(t, data.Post{ID: "post-1"})).
+		File("file", "photo.png", testPNG(t, 0))
+
+	app.client.As("alice").Post("/v1/api/posts/with-media", form).ExpectProblem(http.StatusConflict, "duplicate")
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			t.Errorf("blob %v was left behind by a failed create", filepath.Join(dir, entry.Name()))
+		}
+	}
+}
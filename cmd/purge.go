@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/evansopilo/visuai/pkg/data"
+	"github.com/evansopilo/visuai/pkg/log"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// purgeSyncLimit is the largest number of posts purged within the request, the
+	// posts of users with more are purged by a background job.
+	purgeSyncLimit = 50
+	purgeBatchSize = 100
+	purgeTimeout   = 30 * time.Second
+)
+
+// DeletePostByUserID purges the posts of a user, it answers with the counts of what
+// was deleted or, for users with many posts, with the job purging them.
+func (app App) DeletePostByUserID(c *fiber.Ctx) error {
+
+	ctx, cancel := context.WithTimeout(c.UserContext(), purgeTimeout)
+	defer cancel()
+
+	identity, userID := identityFrom(c), c.Params("user_id")
+
+	posts, err := app.Models.Post.GetAllByUserID(ctx, userID, 0, purgeSyncLimit+1)
+	if err != nil {
+		return err
+	}
+
+	if len(*posts) == 0 {
+		return newProblem(fiber.StatusNotFound, "posts_not_found", "user with id: "+userID+" has no posts")
+	}
+
+	if len(*posts) > purgeSyncLimit {
+		job := data.Job{Kind: jobPurgePosts, UserID: userID, RequestedBy: identity.UserID}
+
+		if err := app.enqueue(ctx, &job); err != nil {
+			return err
+		}
+
+		c.Location("/v1/api/jobs/" + job.ID)
+
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+			"status": "success",
+			"data":   job,
+		})
+	}
+
+	counts, err := app.purgePosts(ctx, log.FromContext(c.UserContext()), userID)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status": "success",
+		"data":   counts,
+	})
+}
+
+func (app App) purgePostsJob(ctx context.Context, logger *log.Logger, job *data.Job) (map[string]int64, error) {
+	return app.purgePosts(ctx, logger, job.UserID)
+}
+
+// purgePosts deletes every post of a user along with the blobs of their media and
+// the reports filed on them, and returns how many of each were deleted. Blobs that
+// can not be deleted are logged and counted as failed. It can be run again to
+// finish a purge that failed.
+//
+// Posts have no comments or reactions, the models hold no store of them, so reports
+// are the only content of other users that is deleted along with a post. Stores of
+// content filed on posts that are added later must be purged here as well.
+func (app App) purgePosts(ctx context.Context, logger *log.Logger, userID string) (map[string]int64, error) {
+
+	counts := map[string]int64{"posts": 0, "blobs": 0, "blobs_failed": 0, "reports": 0}
+
+	for skip := int64(0); ; skip += purgeBatchSize {
+		posts, err := app.Models.Post.GetAllByUserID(ctx, userID, skip, purgeBatchSize)
+		if err != nil {
+			return counts, err
+		}
+
+		for _, post := range *posts {
+			for _, key := range blobKeys(&post) {
+				if err := app.BlobModel.DeleteBlob(ctx, key); err != nil {
+					logger.Warn("failed to delete blob", map[string]interface{}{"post_id": post.ID, "key": key, "error": err.Error()})
+					counts["blobs_failed"]++
+					continue
+				}
+				counts["blobs"]++
+			}
+
+			deleted, err := app.Models.Report.DeleteByPostID(ctx, post.ID)
+			if err != nil {
+				return counts, err
+			}
+			counts["reports"] += deleted
+		}
+
+		if len(*posts) < purgeBatchSize {
+			break
+		}
+	}
+
+	deleted, err := app.Models.Post.DeleteByUserID(ctx, userID)
+	if err != nil && !errors.Is(err, data.ErrNoDocument) {
+		return counts, err
+	}
+
+	counts["posts"] = deleted
+
+	return counts, nil
+}
+
+// blobKeys returns the keys of the blobs of every media item of post and their
+// variants, along with the photo of posts uploaded before multi-photo posts and
+// the blob named by the photo url of posts uploaded before blob keys were stored.
+func blobKeys(post *data.Post) []string {
+
+	var keys []string
+
+	seen := map[string]bool{"": true}
+
+	add := func(key string) {
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+
+	add(post.PhotoKey)
+
+	if key, ok := data.LegacyPhotoKey(post.PhotoURL); ok {
+		add(key)
+	}
+
+	for _, item := range post.Media {
+		add(item.Key)
+		for _, variant := range item.Variants {
+			add(variant.Key)
+		}
+	}
+
+	return keys
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/evansopilo/visuai/pkg/data"
+)
+
+func TestDeletePostByUserID(t *testing.T) {
+
+	t.Run("requires the user or a moderator", func(t *testing.T) {
+		app := newTestApp(t)
+		app.createPosts(t, data.Post{ID: "p1", UserID: "alice"})
+
+		app.client.Delete("/v1/api/users/alice/posts").ExpectProblem(http.StatusUnauthorized, "unauthenticated")
+		app.client.As("bob").Delete("/v1/api/users/alice/posts").ExpectProblem(http.StatusForbidden, "not_account_owner")
+	})
+
+	t.Run("user without posts", func(t *testing.T) {
+		app := newTestApp(t)
+
+		app.client.As("alice").Delete("/v1/api/users/alice/posts").ExpectProblem(http.StatusNotFound, "posts_not_found")
+	})
+
+	t.Run("purges posts, blobs and reports", func(t *testing.T) {
+		app := newTestApp(t)
+		ctx := context.Background()
+
+		key, err := app.BlobModel.UploadBytesToBlob(ctx, testPNG(t, 0), "image/png", nil)
+		if err != nil {
+			t.Fatalf("upload blob: %v", err)
+		}
+
+		app.createPosts(t,
+			data.Post{ID: "p1", UserID: "alice", Media: []data.Media{{ID: "m1", Key: key}}},
+			data.Post{ID: "p2", UserID: "alice", PhotoKey: "missing", ModerationState: data.ModerationHidden},
+			data.Post{ID: "p3", UserID: "bob"},
+		)
+
+		for _, report := range []data.Report{{PostID: "p1", ReporterID: "bob"}, {PostID: "p3", ReporterID: "alice"}} {
+			report := report
+			if err := app.Models.Report.Create(ctx, &report); err != nil {
+				t.Fatalf("create report: %v", err)
+			}
+		}
+
+		var counts map[string]int64
+
+		app.client.As("mod", "moderator").Delete("/v1/api/users/alice/posts").ExpectStatus(http.StatusOK).Data(&counts)
+
+		want := map[string]int64{"posts": 2, "blobs": 1, "blobs_failed": 1, "reports": 1}
+		for name, count := range want {
+			if counts[name] != count {
+				t.Errorf("counts = %v, want %v", counts, want)
+				break
+			}
+		}
+
+		if _, err := app.BlobModel.DownloadBlob(ctx, key); err == nil {
+			t.Errorf("blob %v was not deleted", key)
+		}
+
+		app.post(t, "p3")
+
+		reports, err := app.Models.Report.GetOpen(ctx, 0, 10)
+		if err != nil {
+			t.Fatalf("get open reports: %v", err)
+		}
+		if len(*reports) != 1 || (*reports)[0].PostID != "p3" {
+			t.Errorf("open reports = %+v, want the report on p3", *reports)
+		}
+	})
+
+	t.Run("queues a job for many posts", func(t *testing.T) {
+		app := newTestApp(t)
+
+		for i := 0; i <= purgeSyncLimit; i++ {
+			app.createPosts(t, data.Post{ID: fmt.Sprintf("p%03d", i), UserID: "alice"})
+		}
+
+		var job data.Job
+
+		res := app.client.As("alice").Delete("/v1/api/users/alice/posts").ExpectStatus(http.StatusAccepted)
+		res.Data(&job)
+
+		if job.Kind != jobPurgePosts || job.Status != data.JobQueued || job.UserID != "alice" || job.RequestedBy != "alice" {
+			t.Fatalf("job = %+v, want a queued purge of alice's posts", job)
+		}
+
+		if location := res.Header.Get("Location"); location != "/v1/api/jobs/"+job.ID {
+			t.Errorf("location = %q, want the job", location)
+		}
+
+		app.runQueuedJobs(context.Background())
+
+		app.client.As("alice").Get("/v1/api/jobs/" + job.ID).ExpectStatus(http.StatusOK).Data(&job)
+
+		if job.Status != data.JobSucceeded || job.Counts["posts"] != purgeSyncLimit+1 {
+			t.Errorf("job = %+v, want it to have purged %d posts", job, purgeSyncLimit+1)
+		}
+
+		app.client.As("alice").Delete("/v1/api/users/alice/posts").ExpectProblem(http.StatusNotFound, "posts_not_found")
+	})
+}
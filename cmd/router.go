@@ -1,19 +1,59 @@
 package main
 
 import (
+	"github.com/evansopilo/visuai/pkg/log"
+	"github.com/evansopilo/visuai/pkg/tracing"
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/recover"
 	"github.com/gofiber/fiber/v2/middleware/requestid"
+	"github.com/valyala/fasthttp/fasthttpadaptor"
 )
 
+// maxBodySize is the largest request body accepted, larger clips are uploaded in parts.
+const maxBodySize = 32 << 20
+
 func (app App) Router() *fiber.App {
 
-	r := fiber.New()
+	r := fiber.New(fiber.Config{BodyLimit: maxBodySize, ErrorHandler: app.ErrorHandler})
+
+	r.Use(tracing.Middleware(), requestid.New(), log.Middleware(app.Logger, app.LogSampleRate))
+
+	if app.Metrics != nil {
+		r.Use(app.Metrics.Middleware())
+
+		metrics := fasthttpadaptor.NewFastHTTPHandler(app.Metrics.Handler())
+
+		r.Get("/metrics", func(c *fiber.Ctx) error {
+			metrics(c.Context())
+			return nil
+		})
+	}
+
+	r.Use(app.handleErrors, recover.New())
+
+	r.Get("/healthz", app.GetLiveness)
 
-	v1 := r.Group("/v1/api").Use(requestid.New())
+	r.Get("/readyz", app.GetReadiness)
+
+	v1 := r.Group("/v1/api").Use(app.Identify)
 	{
 		v1.Post("/upload", app.UploadFile)
 
-		v1.Post("/posts", app.CreatePost)
+		v1.Get("/media/:key", app.GetMedia)
+
+		v1.Post("/uploads", app.RequireUser, app.CreateUploadSession)
+
+		v1.Get("/uploads/:upload_id", app.RequireUser, app.GetUploadSession)
+
+		v1.Put("/uploads/:upload_id/parts/:part_number", app.RequireUser, app.UploadPart)
+
+		v1.Post("/uploads/:upload_id/complete", app.RequireUser, app.CompleteUploadSession)
+
+		v1.Delete("/uploads/:upload_id", app.RequireUser, app.AbortUploadSession)
+
+		v1.Post("/posts", app.RequireUser, app.CreatePost)
+
+		v1.Post("/posts/with-media", app.RequireUser, app.CreatePostWithMedia)
 
 		v1.Get("/posts", app.GetPost)
 
@@ -21,15 +61,46 @@ func (app App) Router() *fiber.App {
 
 		v1.Get("/posts/:post_id", app.GetPostByID)
 
+		v1.Get("/posts/:post_id/duplicates", app.RequireRole("moderator"), app.GetPostDuplicates)
+
+		v1.Post("/posts/:post_id/media", app.RequireUser, app.AppendPostMedia)
+
+		v1.Put("/posts/:post_id/media/order", app.RequireUser, app.ReorderPostMedia)
+
+		v1.Delete("/posts/:post_id/media/:media_id", app.RequireUser, app.RemovePostMedia)
+
+		v1.Post("/posts/:post_id/reports", app.RequireUser, app.ReportPost)
+
+		v1.Post("/posts/:post_id/share", app.RequireUser, app.CreateShareLink)
+
+		v1.Get("/shared/:token", app.GetSharedPost)
+
 		v1.Get("/users/:user_id/posts", app.GetPostByUserID)
 
+		v1.Post("/users/:user_id/followers", app.RequireUser, app.FollowUser)
+
+		v1.Delete("/users/:user_id/followers", app.RequireUser, app.UnfollowUser)
+
 		v1.Get("/category/:category/posts", app.GetPostByCategory)
 
-		v1.Patch("/posts/:post_id", app.UpdatePost)
+		v1.Patch("/posts/:post_id", app.RequireUser, app.UpdatePost)
+
+		v1.Delete("/posts/:post_id", app.RequireUser, app.DeletePostByID)
+
+		v1.Delete("/users/:user_id/posts", app.RequireAccount, app.DeletePostByUserID)
+
+		v1.Post("/users/:user_id/export", app.RequireAccount, app.ExportUserData)
+
+		v1.Get("/jobs/:job_id", app.RequireUser, app.GetJob)
+	}
+
+	admin := v1.Group("/admin")
+	{
+		admin.Get("/reports", app.RequireRole("moderator"), app.GetModerationQueue)
 
-		v1.Delete("/posts/:post_id", app.DeletePostByID)
+		admin.Post("/posts/:post_id/:action", app.RequireRole("moderator"), app.ModeratePost)
 
-		v1.Delete("/users/:user_id/posts", app.DeletePostByUserID)
+		admin.Post("/imports", app.RequireRole("admin"), app.ImportPosts)
 	}
 
 	return r
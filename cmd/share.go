@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/evansopilo/visuai/pkg/data"
+	"github.com/evansopilo/visuai/pkg/signer"
+	"github.com/gofiber/fiber/v2"
+)
+
+// maxShareLinkTTL bounds how long a share link stays valid.
+const maxShareLinkTTL = 30 * 24 * time.Hour
+
+// shareSigner signs share links with a key of their own, so the signature of a
+// media url is not a share token and the other way around.
+func (app App) shareSigner() *signer.Signer {
+	return app.Signer.Derive("share")
+}
+
+// CreateShareLink returns a signed link to an unlisted post that can be opened
+// without being able to list the post.
+func (app App) CreateShareLink(c *fiber.Ctx) error {
+
+	ctx, cancel := context.WithTimeout(c.UserContext(), time.Second*3)
+	defer cancel()
+
+	identity := identityFrom(c)
+
+	post, err := app.Models.Post.GetByID(data.ContextWithViewer(ctx, data.Viewer{UserID: identity.UserID}), c.Params("post_id"))
+	if err != nil {
+		if errors.Is(err, data.ErrNoDocument) {
+			return data.NewNotFound("post", c.Params("post_id"))
+		}
+		return err
+	}
+
+	if post.UserID != identity.UserID {
+		return errNotOwner
+	}
+
+	if !shareable(post) {
+		return newProblem(fiber.StatusBadRequest, "not_shareable", fmt.Sprintf("posts with visibility: %v can not be shared by link", post.Visibility))
+	}
+
+	hours, _ := strconv.Atoi(c.Query("expires_in", "168"))
+
+	ttl := time.Duration(hours) * time.Hour
+	if ttl <= 0 || ttl > maxShareLinkTTL {
+		ttl = maxShareLinkTTL
+	}
+
+	expires := time.Now().Add(ttl)
+	token := app.shareSigner().Token(post.ID, expires)
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"status": "success",
+		"data": fiber.Map{
+			"token":      token,
+			"url":        fmt.Sprintf("%s/v1/api/shared/%s", c.BaseURL(), token),
+			"expires_at": expires,
+		},
+	})
+}
+
+func (app App) GetSharedPost(c *fiber.Ctx) error {
+
+	ctx, cancel := context.WithTimeout(c.UserContext(), time.Second*3)
+	defer cancel()
+
+	id, err := app.shareSigner().ParseToken(c.Params("token"))
+	if err != nil {
+		return newProblem(fiber.StatusNotFound, "invalid_share_link", "share link is invalid or has expired")
+	}
+
+	post, err := app.Models.Post.GetByID(data.ContextWithViewer(ctx, data.Viewer{Moderator: true}), id)
+	if err != nil {
+		if errors.Is(err, data.ErrNoDocument) {
+			return newProblem(fiber.StatusNotFound, "invalid_share_link", "share link is invalid or has expired")
+		}
+		return err
+	}
+
+	// the owner may have made the post private since the link was created.
+	if !shareable(post) || post.Hidden() {
+		return newProblem(fiber.StatusNotFound, "invalid_share_link", "share link is invalid or has expired")
+	}
+
+	if err := app.signPhotoURL(post); err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status": "success",
+		"data":   post,
+	})
+}
+
+func shareable(post *data.Post) bool {
+	switch post.Visibility {
+	case "", data.VisibilityPublic, data.VisibilityUnlisted:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/evansopilo/visuai/pkg/data"
+)
+
+func TestShareLink(t *testing.T) {
+
+	app := newTestApp(t)
+
+	app.createPosts(t,
+		data.Post{ID: "unlisted", UserID: "alice", Visibility: data.VisibilityUnlisted},
+		data.Post{ID: "private", UserID: "alice", Visibility: data.VisibilityPrivate},
+	)
+
+	app.client.Post("/v1/api/posts/unlisted/share", nil).ExpectProblem(http.StatusUnauthorized, "unauthenticated")
+
+	app.client.As("bob").Post("/v1/api/posts/unlisted/share", nil).ExpectProblem(http.StatusForbidden, "not_owner")
+
+	app.client.As("alice").Post("/v1/api/posts/missing/share", nil).ExpectProblem(http.StatusNotFound, "post_not_found")
+
+	app.client.As("alice").Post("/v1/api/posts/private/share", nil).ExpectProblem(http.StatusBadRequest, "not_shareable")
+
+	var link struct {
+		Token string `json:"token"`
+	}
+
+	app.client.As("alice").Post("/v1/api/posts/unlisted/share?expires_in=1", nil).ExpectStatus(http.StatusCreated).Data(&link)
+
+	var post data.Post
+
+	app.client.Get("/v1/api/shared/" + link.Token).ExpectStatus(http.StatusOK).Data(&post)
+
+	if post.ID != "unlisted" {
+		t.Errorf("shared post = %q, want unlisted", post.ID)
+	}
+
+	app.client.Get("/v1/api/shared/"+link.Token+"x").ExpectProblem(http.StatusNotFound, "invalid_share_link")
+
+	// the signature of a media url is not a share token.
+	media := app.Signer.Derive("media").Token("unlisted", time.Now().Add(time.Hour))
+
+	app.client.Get("/v1/api/shared/"+media).ExpectProblem(http.StatusNotFound, "invalid_share_link")
+
+	app.client.As("alice").Patch("/v1/api/posts/unlisted", data.Post{Visibility: data.VisibilityPrivate}).ExpectStatus(http.StatusOK)
+
+	app.client.Get("/v1/api/shared/"+link.Token).ExpectProblem(http.StatusNotFound, "invalid_share_link")
+}
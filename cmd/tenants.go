@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/evansopilo/visuai/pkg/data"
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+// Tenant is an App serving a tenant from the tenant's database, for the requests
+// to its hosts or that the gateway asserts are made for it. Tenants share the blob
+// store and the other clients of the deployment, their signer is derived from the
+// signer of the deployment so the share links of one tenant are not valid for
+// another.
+type Tenant struct {
+	Name  string
+	Hosts []string
+	App   *App
+}
+
+// apps returns app and the Apps of its tenants by the name of their tenant, app's
+// name is empty.
+func (app App) apps() map[string]*App {
+
+	apps := map[string]*App{"": &app}
+
+	for _, tenant := range app.Tenants {
+		apps[tenant.Name] = tenant.App
+	}
+
+	return apps
+}
+
+// tenant returns the App of the tenant with name, or app when name is empty.
+func (app App) tenant(name string) (*App, error) {
+
+	if name == "" {
+		return &app, nil
+	}
+
+	for _, tenant := range app.Tenants {
+		if tenant.Name == name {
+			return tenant.App, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown tenant %q", name)
+}
+
+// TenantRouter returns the router of app when it serves no tenants, otherwise a
+// router that hands every request to the router of its tenant. The tenant is the
+// one the gateway asserts in the X-Tenant-ID header, which must agree with the
+// tenant of the request's host, or else the tenant of the host. The host is the
+// Host header of the request, X-Forwarded-Host is not trusted. Requests for no
+// tenant are served by app.
+func (app App) TenantRouter() *fiber.App {
+
+	if len(app.Tenants) == 0 {
+		return app.Router()
+	}
+
+	handlers := map[string]fasthttp.RequestHandler{"": app.Router().Handler()}
+	hosts := map[string]string{}
+
+	for _, tenant := range app.Tenants {
+		handlers[tenant.Name] = tenant.App.Router().Handler()
+
+		for _, host := range tenant.Hosts {
+			hosts[strings.ToLower(host)] = tenant.Name
+		}
+	}
+
+	r := fiber.New(fiber.Config{BodyLimit: maxBodySize, ErrorHandler: app.ErrorHandler})
+
+	r.Use(func(c *fiber.Ctx) error {
+
+		host := strings.ToLower(string(c.Request().URI().Host()))
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+
+		name := hosts[host]
+
+		if asserted := c.Get("X-Tenant-ID"); asserted != "" {
+			if name != "" && asserted != name {
+				return data.NewForbidden("tenant_mismatch", "the tenant of the request is not the tenant of its host")
+			}
+			name = asserted
+		}
+
+		handler, ok := handlers[name]
+		if !ok {
+			return data.NewNotFound("tenant", name)
+		}
+
+		handler(c.Context())
+
+		return nil
+	})
+
+	return r
+}
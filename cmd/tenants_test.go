@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/evansopilo/visuai/pkg/apitest"
+	"github.com/evansopilo/visuai/pkg/data"
+)
+
+// newTenantApp returns a test app serving the brand tenant at brand.example.com
+// from models of its own, along with the tenant's App and a client of the router
+// of both.
+func newTenantApp(t *testing.T) (*testApp, *App, *apitest.Client) {
+
+	brand := &App{}
+
+	app := newTestApp(t, func(app *App) {
+		*brand = *app
+		brand.Models = data.NewMemoryModels()
+		brand.Signer = app.Signer.Derive("tenant\nbrand")
+
+		app.Tenants = []Tenant{{Name: "brand", Hosts: []string{"brand.example.com"}, App: brand}}
+	})
+
+	return app, brand, apitest.New(t, app.TenantRouter())
+}
+
+func TestTenantRouter(t *testing.T) {
+
+	app, brand, client := newTenantApp(t)
+
+	app.createPosts(t, data.Post{ID: "default-post", UserID: "alice"})
+
+	if err := brand.Models.Post.Create(context.Background(), &data.Post{ID: "brand-post", UserID: "alice"}); err != nil {
+		t.Fatalf("create post: %v", err)
+	}
+
+	t.Run("requests for no tenant", func(t *testing.T) {
+		client.Get("/v1/api/posts/default-post").ExpectStatus(http.StatusOK)
+		client.Get("/v1/api/posts/brand-post").ExpectProblem(http.StatusNotFound, "post_not_found")
+	})
+
+	t.Run("tenant asserted by the gateway", func(t *testing.T) {
+		brandClient := client.With("X-Tenant-ID", "brand")
+
+		brandClient.Get("/v1/api/posts/brand-post").ExpectStatus(http.StatusOK)
+		brandClient.Get("/v1/api/posts/default-post").ExpectProblem(http.StatusNotFound, "post_not_found")
+	})
+
+	t.Run("tenant of the host", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "http://brand.example.com:8080/v1/api/posts/brand-post", nil)
+
+		client.Request(req).ExpectStatus(http.StatusOK)
+	})
+
+	t.Run("forwarded host", func(t *testing.T) {
+		client.With("X-Forwarded-Host", "brand.example.com").Get("/v1/api/posts/brand-post").ExpectProblem(http.StatusNotFound, "post_not_found")
+	})
+
+	t.Run("tenant asserted for the host of another tenant", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "http://brand.example.com/v1/api/posts/brand-post", nil)
+		req.Header.Set("X-Tenant-ID", "other")
+
+		client.Request(req).ExpectProblem(http.StatusForbidden, "tenant_mismatch")
+	})
+
+	t.Run("unknown tenant", func(t *testing.T) {
+		client.With("X-Tenant-ID", "other").Get("/v1/api/posts/brand-post").ExpectProblem(http.StatusNotFound, "tenant_not_found")
+	})
+}
+
+func TestPurgeOrphansOfTenants(t *testing.T) {
+
+	app, brand, _ := newTenantApp(t)
+	ctx := context.Background()
+
+	key, err := app.BlobModel.UploadBytesToBlob(ctx, testPNG(t, 0), "image/png", nil)
+	if err != nil {
+		t.Fatalf("upload blob: %v", err)
+	}
+
+	if err := brand.Models.Post.Create(ctx, &data.Post{ID: "p1", Media: []data.Media{{ID: "m1", Key: key}}}); err != nil {
+		t.Fatalf("create post: %v", err)
+	}
+
+	counts, orphans, err := app.purgeOrphans(ctx, app.Logger, 0, false)
+	if err != nil {
+		t.Fatalf("purgeOrphans: %v", err)
+	}
+
+	if counts["referenced"] != 1 || len(orphans) != 0 {
+		t.Errorf("counts = %v, orphans = %v, want the blob of the tenant's post kept", counts, orphans)
+	}
+}
+
+func TestShareLinkOfTenant(t *testing.T) {
+
+	app, brand, client := newTenantApp(t)
+
+	for _, app := range []*App{&app.App, brand} {
+		if err := app.Models.Post.Create(context.Background(), &data.Post{ID: "unlisted", UserID: "alice", Visibility: data.VisibilityUnlisted}); err != nil {
+			t.Fatalf("create post: %v", err)
+		}
+	}
+
+	var link struct {
+		Token string `json:"token"`
+	}
+
+	client.With("X-Tenant-ID", "brand").As("alice").Post("/v1/api/posts/unlisted/share", nil).ExpectStatus(http.StatusCreated).Data(&link)
+
+	client.With("X-Tenant-ID", "brand").Get("/v1/api/shared/" + link.Token).ExpectStatus(http.StatusOK)
+
+	// the post of the same id of another tenant is not shared by the link.
+	client.Get("/v1/api/shared/"+link.Token).ExpectProblem(http.StatusNotFound, "invalid_share_link")
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/evansopilo/visuai/pkg/blob"
+	"github.com/evansopilo/visuai/pkg/data"
+	"github.com/evansopilo/visuai/pkg/log"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// uploadPartSize is the part size clients are asked to use, only the last part
+	// of an upload may be smaller.
+	uploadPartSize = 2 << 20
+	maxUploadParts = 10000
+	// uploadSessionTTL is how long clients have to complete an upload before its
+	// staged parts are discarded.
+	uploadSessionTTL = 24 * time.Hour
+)
+
+func (app App) CreateUploadSession(c *fiber.Ctx) error {
+
+	ctx, cancel := context.WithTimeout(c.UserContext(), time.Second*3)
+	defer cancel()
+
+	var input struct {
+		PostID      string `json:"post_id"`
+		Size        int64  `json:"size"`
+		ContentType string `json:"content_type"`
+		AltText     string `json:"alt_text"`
+	}
+
+	if err := c.BodyParser(&input); err != nil {
+		return errInvalidBody
+	}
+
+	limit, ok := maxMediaSize[input.ContentType]
+	if !ok {
+		return newProblem(fiber.StatusUnsupportedMediaType, "unsupported_media_type", "only jpeg, png and gif images and mp4 and webm videos can be uploaded")
+	}
+
+	if input.Size <= 0 || input.Size > limit {
+		return newProblem(fiber.StatusBadRequest, "invalid_upload_size", fmt.Sprintf("size of %v files must be between 1 and %v bytes", input.ContentType, limit))
+	}
+
+	viewer, err := app.viewer(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	post, err := app.Models.Post.GetByID(data.ContextWithViewer(ctx, viewer), input.PostID)
+	if err != nil {
+		if errors.Is(err, data.ErrNoDocument) {
+			return data.NewNotFound("post", input.PostID)
+		}
+		return err
+	}
+
+	if post.UserID != viewer.UserID {
+		return errNotOwner
+	}
+
+	if len(post.Media) >= data.MaxMediaItems {
+		return newProblem(fiber.StatusBadRequest, "too_many_media", fmt.Sprintf("a post can hold at most %v media items", data.MaxMediaItems))
+	}
+
+	session := data.UploadSession{
+		PostID:      post.ID,
+		UserID:      viewer.UserID,
+		BlobKey:     blob.NewKey(input.ContentType),
+		Size:        input.Size,
+		ContentType: input.ContentType,
+		AltText:     input.AltText,
+		ExpiresAt:   time.Now().Add(uploadSessionTTL),
+	}
+
+	if err := app.Models.UploadSession.Create(ctx, &session); err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"status": "success",
+		"data": fiber.Map{
+			"id":         session.ID,
+			"part_size":  uploadPartSize,
+			"expires_at": session.ExpiresAt,
+		},
+	})
+}
+
+// openUploadSession loads the caller's open upload session, when the session can not
+// be used a nil session is returned along with the error the request is answered with.
+func (app App) openUploadSession(ctx context.Context, c *fiber.Ctx) (*data.UploadSession, error) {
+
+	session, err := app.Models.UploadSession.GetByID(ctx, c.Params("upload_id"))
+	if err != nil {
+		if errors.Is(err, data.ErrNoDocument) {
+			return nil, data.NewNotFound("upload", c.Params("upload_id"))
+		}
+		return nil, err
+	}
+
+	if session.UserID != identityFrom(c).UserID {
+		return nil, data.NewNotFound("upload", c.Params("upload_id"))
+	}
+
+	if session.Status == data.UploadCompleting {
+		return nil, errUploadCompleting
+	}
+
+	if session.Status != data.UploadOpen || time.Now().After(session.ExpiresAt) {
+		return nil, newProblem(fiber.StatusGone, "upload_closed", fmt.Sprintf("upload with id: %v is no longer open", c.Params("upload_id")))
+	}
+
+	return session, nil
+}
+
+func (app App) GetUploadSession(c *fiber.Ctx) error {
+
+	ctx, cancel := context.WithTimeout(c.UserContext(), time.Second*3)
+	defer cancel()
+
+	session, err := app.openUploadSession(ctx, c)
+	if session == nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status": "success",
+		"data":   session,
+	})
+}
+
+func (app App) UploadPart(c *fiber.Ctx) error {
+
+	ctx, cancel := context.WithTimeout(c.UserContext(), time.Second*30)
+	defer cancel()
+
+	part, err := strconv.Atoi(c.Params("part_number"))
+	if err != nil || part < 1 || part > maxUploadParts {
+		return newProblem(fiber.StatusBadRequest, "invalid_part_number", fmt.Sprintf("part number must be between 1 and %v", maxUploadParts))
+	}
+
+	body := c.Body()
+	if len(body) == 0 || len(body) > uploadPartSize {
+		return newProblem(fiber.StatusBadRequest, "invalid_part_size", fmt.Sprintf("part size must be between 1 and %v bytes", uploadPartSize))
+	}
+
+	session, err := app.openUploadSession(ctx, c)
+	if session == nil {
+		return err
+	}
+
+	if err := app.BlobModel.StageBlock(ctx, session.BlobKey, part, body); err != nil {
+		return err
+	}
+
+	if err := app.Models.UploadSession.AddPart(ctx, session.ID, part, int64(len(body))); err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status": "success",
+	})
+}
+
+// CompleteUploadSession commits the staged parts of an upload in order and attaches
+// the assembled photo to the upload's post. An upload of a near-duplicate is answered
+// with a conflict and stays open, to be completed with allow_duplicate or aborted.
+func (app App) CompleteUploadSession(c *fiber.Ctx) error {
+
+	ctx, cancel := context.WithTimeout(c.UserContext(), time.Second*30)
+	defer cancel()
+
+	session, err := app.openUploadSession(ctx, c)
+	if session == nil {
+		return err
+	}
+
+	parts, size := make([]int, 0, len(session.Parts)), int64(0)
+	for number, partSize := range session.Parts {
+		part, _ := strconv.Atoi(number)
+		parts, size = append(parts, part), size+partSize
+	}
+	sort.Ints(parts)
+
+	for i, part := range parts {
+		if part != i+1 {
+			return newProblem(fiber.StatusBadRequest, "missing_part", fmt.Sprintf("part number: %v is missing", i+1))
+		}
+	}
+
+	if size != session.Size {
+		return newProblem(fiber.StatusBadRequest, "incomplete_upload", fmt.Sprintf("received %v of %v bytes", size, session.Size))
+	}
+
+	viewer, err := app.viewer(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	post, err := app.Models.Post.GetByID(data.ContextWithViewer(ctx, viewer), session.PostID)
+	if err != nil {
+		if errors.Is(err, data.ErrNoDocument) {
+			return data.NewNotFound("post", session.PostID)
+		}
+		return err
+	}
+
+	// the session is claimed so one request commits its parts and attaches the photo,
+	// the claim is released to status once the request is answered.
+	if err := app.Models.UploadSession.SetStatus(ctx, session.ID, data.UploadOpen, data.UploadCompleting); err != nil {
+		if errors.Is(err, data.ErrNoDocument) {
+			return errUploadCompleting
+		}
+		return err
+	}
+
+	status := data.UploadOpen
+
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+		defer cancel()
+
+		if err := app.Models.UploadSession.SetStatus(ctx, session.ID, data.UploadCompleting, status); err != nil {
+			log.FromContext(c.UserContext()).Error(err.Error(), nil)
+		}
+	}()
+
+	// an upload refused as a near-duplicate is committed already and stays open, so
+	// it can be completed again with allow_duplicate.
+	file, err := app.BlobModel.DownloadBlob(ctx, session.BlobKey)
+	if errors.Is(err, blob.ErrNotFound) {
+		if err := app.BlobModel.CommitBlocks(ctx, session.BlobKey, parts, session.ContentType, map[string]string{}); err != nil {
+			return err
+		}
+
+		file, err = app.BlobModel.DownloadBlob(ctx, session.BlobKey)
+	}
+	if err != nil {
+		return err
+	}
+
+	info, ok, err := app.probeMedia(ctx, file)
+	if ok && info.ContentType != session.ContentType {
+		ok, err = false, newProblem(fiber.StatusBadRequest, "content_type_mismatch", fmt.Sprintf("upload was declared as %v but is %v", session.ContentType, info.ContentType))
+	}
+	if !ok {
+		status = data.UploadAborted
+		app.discardUpload(ctx, c, session)
+		return err
+	}
+
+	item, img, duplicates, err := app.inspectMedia(ctx, post, file, info)
+	if err != nil {
+		return err
+	}
+
+	if len(duplicates) > 0 && c.Query("allow_duplicate") != "true" {
+		return duplicateConflict(duplicates)
+	}
+
+	item.Key, item.AltText = session.BlobKey, session.AltText
+
+	if err := app.storeMedia(ctx, post, file, img, item); err != nil {
+		return err
+	}
+
+	status = data.UploadCompleted
+
+	return uploadCreated(c, post.ID, item.ID, duplicates)
+}
+
+// discardUpload deletes the committed blob of an upload that was refused, its
+// session is aborted as the claim of the request completing it is released.
+func (app App) discardUpload(ctx context.Context, c *fiber.Ctx, session *data.UploadSession) {
+
+	if err := app.BlobModel.DeleteBlob(ctx, session.BlobKey); err != nil {
+		log.FromContext(c.UserContext()).Error(err.Error(), nil)
+	}
+}
+
+func (app App) AbortUploadSession(c *fiber.Ctx) error {
+
+	ctx, cancel := context.WithTimeout(c.UserContext(), time.Second*3)
+	defer cancel()
+
+	session, err := app.openUploadSession(ctx, c)
+	if session == nil {
+		return err
+	}
+
+	if err := app.BlobModel.AbortBlocks(ctx, session.BlobKey); err != nil {
+		return err
+	}
+
+	if err := app.Models.UploadSession.SetStatus(ctx, session.ID, data.UploadOpen, data.UploadAborted); err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status": "success",
+	})
+}
+
+// CleanupUploadSessions expires abandoned upload sessions and discards their staged
+// parts every interval until ctx is done.
+func (app App) CleanupUploadSessions(ctx context.Context, interval time.Duration) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			app.cleanupUploadSessions(ctx)
+		}
+	}
+}
+
+func (app App) cleanupUploadSessions(ctx context.Context) {
+
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	sessions, err := app.Models.UploadSession.GetExpired(ctx, time.Now(), 100)
+	if err != nil {
+		app.Logger.Error(err.Error(), nil)
+		return
+	}
+
+	for _, session := range *sessions {
+		if err := app.BlobModel.AbortBlocks(ctx, session.BlobKey); err != nil {
+			app.Logger.Error(err.Error(), map[string]interface{}{"upload_id": session.ID})
+			continue
+		}
+
+		if err := app.Models.UploadSession.SetStatus(ctx, session.ID, data.UploadOpen, data.UploadExpired); err != nil {
+			app.Logger.Error(err.Error(), map[string]interface{}{"upload_id": session.ID})
+		}
+	}
+
+	if len(*sessions) > 0 {
+		app.Logger.Info("expired abandoned upload sessions", map[string]interface{}{"count": len(*sessions)})
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/evansopilo/visuai/pkg/apitest"
+	"github.com/evansopilo/visuai/pkg/blob"
+	"github.com/evansopilo/visuai/pkg/data"
+)
+
+type uploadInput struct {
+	PostID      string `json:"post_id"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type"`
+	AltText     string `json:"alt_text"`
+}
+
+func TestCreateUploadSession(t *testing.T) {
+
+	app := newTestApp(t)
+
+	app.createPosts(t, data.Post{ID: "post-1", UserID: "alice"}, data.Post{ID: "post-2", UserID: "bob"})
+
+	input := uploadInput{PostID: "post-1", Size: 100, ContentType: "image/png"}
+
+	app.client.Post("/v1/api/uploads", input).ExpectProblem(http.StatusUnauthorized, "unauthenticated")
+
+	alice := app.client.As("alice")
+
+	alice.Post("/v1/api/uploads", "{").ExpectProblem(http.StatusBadRequest, "invalid_body")
+
+	alice.Post("/v1/api/uploads", uploadInput{PostID: "post-1", Size: 100, ContentType: "image/bmp"}).
+		ExpectProblem(http.StatusUnsupportedMediaType, "unsupported_media_type")
+
+	alice.Post("/v1/api/uploads", uploadInput{PostID: "post-1", Size: 0, ContentType: "image/png"}).
+		ExpectProblem(http.StatusBadRequest, "invalid_upload_size")
+
+	alice.Post("/v1/api/uploads", uploadInput{PostID: "missing", Size: 100, ContentType: "image/png"}).
+		ExpectProblem(http.StatusNotFound, "post_not_found")
+
+	alice.Post("/v1/api/uploads", uploadInput{PostID: "post-2", Size: 100, ContentType: "image/png"}).
+		ExpectProblem(http.StatusForbidden, "not_owner")
+
+	var session struct {
+		ID       string `json:"id"`
+		PartSize int    `json:"part_size"`
+	}
+
+	alice.Post("/v1/api/uploads", input).ExpectStatus(http.StatusCreated).Data(&session)
+
+	if session.ID == "" || session.PartSize != uploadPartSize {
+		t.Errorf("session = %+v, want its id and part size", session)
+	}
+
+	alice.Get("/v1/api/uploads/" + session.ID).ExpectStatus(http.StatusOK)
+
+	app.client.As("bob").Get("/v1/api/uploads/"+session.ID).ExpectProblem(http.StatusNotFound, "upload_not_found")
+
+	alice.Get("/v1/api/uploads/missing").ExpectProblem(http.StatusNotFound, "upload_not_found")
+}
+
+func TestCompleteUploadSession(t *testing.T) {
+
+	app := newTestApp(t)
+
+	app.createPosts(t, data.Post{ID: "post-1", UserID: "alice"})
+
+	alice := app.client.As("alice")
+
+	file := testPNG(t, 2)
+
+	var session struct {
+		ID string `json:"id"`
+	}
+
+	alice.Post("/v1/api/uploads", uploadInput{PostID: "post-1", Size: int64(len(file)), ContentType: "image/png", AltText: "squares"}).
+		ExpectStatus(http.StatusCreated).Data(&session)
+
+	path := "/v1/api/uploads/" + session.ID
+
+	alice.Put(path+"/parts/0", file).ExpectProblem(http.StatusBadRequest, "invalid_part_number")
+
+	alice.Put(path+"/parts/1", []byte{}).ExpectProblem(http.StatusBadRequest, "invalid_part_size")
+
+	alice.Post(path+"/complete", nil).ExpectProblem(http.StatusBadRequest, "incomplete_upload")
+
+	alice.Put(path+"/parts/2", file[len(file)/2:]).ExpectStatus(http.StatusOK)
+
+	alice.Post(path+"/complete", nil).ExpectProblem(http.StatusBadRequest, "missing_part")
+
+	alice.Put(path+"/parts/1", file[:len(file)/2]).ExpectStatus(http.StatusOK)
+
+	var created struct {
+		MediaID string `json:"media_id"`
+	}
+
+	alice.Post(path+"/complete", nil).ExpectStatus(http.StatusCreated).Data(&created)
+
+	media := app.post(t, "post-1").Media
+
+	if len(media) != 1 || media[0].ID != created.MediaID || media[0].AltText != "squares" {
+		t.Errorf("media = %+v, want the uploaded item", media)
+	}
+
+	alice.Post(path+"/complete", nil).ExpectProblem(http.StatusGone, "upload_closed")
+}
+
+func TestCompleteUploadSessionDuplicate(t *testing.T) {
+
+	app := newTestApp(t)
+
+	file := testPNG(t, 0)
+
+	app.createPosts(t, data.Post{ID: "post-1", UserID: "alice"}, data.Post{ID: "post-2", UserID: "alice"})
+
+	alice := app.client.As("alice")
+
+	alice.Post("/v1/api/upload", apitest.NewForm().Field("post_id", "post-1").File("file", "photo.png", file)).ExpectStatus(http.StatusCreated)
+
+	var session struct {
+		ID string `json:"id"`
+	}
+
+	alice.Post("/v1/api/uploads", uploadInput{PostID: "post-2", Size: int64(len(file)), ContentType: "image/png"}).
+		ExpectStatus(http.StatusCreated).Data(&session)
+
+	path := "/v1/api/uploads/" + session.ID
+
+	alice.Put(path+"/parts/1", file).ExpectStatus(http.StatusOK)
+
+	alice.Post(path+"/complete", nil).ExpectProblem(http.StatusConflict, "near_duplicate")
+
+	// the session stays open to be completed anyway.
+	alice.Get(path).ExpectStatus(http.StatusOK)
+
+	alice.Post(path+"/complete?allow_duplicate=true", nil).ExpectStatus(http.StatusCreated)
+
+	if media := app.post(t, "post-2").Media; len(media) != 1 {
+		t.Fatalf("media = %+v, want the duplicate", media)
+	}
+
+	if _, err := app.BlobModel.DownloadBlob(context.Background(), app.post(t, "post-2").Media[0].Key); err != nil {
+		t.Errorf("blob of the duplicate: %v", err)
+	}
+}
+
+func TestCompleteUploadSessionClaimed(t *testing.T) {
+
+	var store *failingDownloads
+
+	app := newTestApp(t, func(app *App) {
+		store = &failingDownloads{Store: app.BlobModel}
+		app.BlobModel = store
+	})
+
+	app.createPosts(t, data.Post{ID: "post-1", UserID: "alice"})
+
+	alice := app.client.As("alice")
+
+	file := testPNG(t, 0)
+
+	var session struct {
+		ID string `json:"id"`
+	}
+
+	alice.Post("/v1/api/uploads", uploadInput{PostID: "post-1", Size: int64(len(file)), ContentType: "image/png"}).
+		ExpectStatus(http.StatusCreated).Data(&session)
+
+	path := "/v1/api/uploads/" + session.ID
+
+	alice.Put(path+"/parts/1", file).ExpectStatus(http.StatusOK)
+
+	// a download that fails for another reason than a missing blob does not commit
+	// the parts again, and releases the claim.
+	store.err = errors.New("blob store unavailable")
+
+	alice.Post(path+"/complete", nil).ExpectProblem(http.StatusInternalServerError, "internal_error")
+
+	if store.commits != 0 {
+		t.Errorf("parts committed %v times after a failed download, want 0", store.commits)
+	}
+
+	store.err = nil
+
+	// another request claimed the session first.
+	if err := app.Models.UploadSession.SetStatus(context.Background(), session.ID, data.UploadOpen, data.UploadCompleting); err != nil {
+		t.Fatal(err)
+	}
+
+	alice.Post(path+"/complete", nil).ExpectProblem(http.StatusConflict, "upload_completing")
+
+	alice.Put(path+"/parts/1", file).ExpectProblem(http.StatusConflict, "upload_completing")
+
+	if media := app.post(t, "post-1").Media; len(media) != 0 {
+		t.Errorf("media = %+v, want none attached by the losing request", media)
+	}
+
+	if err := app.Models.UploadSession.SetStatus(context.Background(), session.ID, data.UploadCompleting, data.UploadOpen); err != nil {
+		t.Fatal(err)
+	}
+
+	alice.Post(path+"/complete", nil).ExpectStatus(http.StatusCreated)
+
+	if store.commits != 1 {
+		t.Errorf("parts committed %v times, want once", store.commits)
+	}
+}
+
+// failingDownloads fails the downloads of a blob store with err while it is set and
+// counts its commits.
+type failingDownloads struct {
+	blob.Store
+
+	err     error
+	commits int
+}
+
+func (s *failingDownloads) DownloadBlob(ctx context.Context, key string) ([]byte, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.Store.DownloadBlob(ctx, key)
+}
+
+func (s *failingDownloads) CommitBlocks(ctx context.Context, key string, parts []int, contentType string, metadata map[string]string) error {
+	s.commits++
+	return s.Store.CommitBlocks(ctx, key, parts, contentType, metadata)
+}
+
+func TestCompleteUploadSessionContentTypeMismatch(t *testing.T) {
+
+	app := newTestApp(t)
+
+	app.createPosts(t, data.Post{ID: "post-1", UserID: "alice"})
+
+	alice := app.client.As("alice")
+
+	file := testPNG(t, 0)
+
+	var session struct {
+		ID string `json:"id"`
+	}
+
+	alice.Post("/v1/api/uploads", uploadInput{PostID: "post-1", Size: int64(len(file)), ContentType: "image/jpeg"}).
+		ExpectStatus(http.StatusCreated).Data(&session)
+
+	alice.Put(fmt.Sprintf("/v1/api/uploads/%v/parts/1", session.ID), file).ExpectStatus(http.StatusOK)
+
+	alice.Post(fmt.Sprintf("/v1/api/uploads/%v/complete", session.ID), nil).ExpectProblem(http.StatusBadRequest, "content_type_mismatch")
+
+	stored, err := app.Models.UploadSession.GetByID(context.Background(), session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stored.Status != data.UploadAborted {
+		t.Errorf("status = %q, want %q", stored.Status, data.UploadAborted)
+	}
+}
+
+func TestAbortUploadSession(t *testing.T) {
+
+	app := newTestApp(t)
+
+	app.createPosts(t, data.Post{ID: "post-1", UserID: "alice"})
+
+	alice := app.client.As("alice")
+
+	var session struct {
+		ID string `json:"id"`
+	}
+
+	alice.Post("/v1/api/uploads", uploadInput{PostID: "post-1", Size: 100, ContentType: "image/png"}).
+		ExpectStatus(http.StatusCreated).Data(&session)
+
+	app.client.Delete("/v1/api/uploads/"+session.ID).ExpectProblem(http.StatusUnauthorized, "unauthenticated")
+
+	alice.Delete("/v1/api/uploads/" + session.ID).ExpectStatus(http.StatusOK)
+
+	alice.Get("/v1/api/uploads/"+session.ID).ExpectProblem(http.StatusGone, "upload_closed")
+
+	alice.Delete("/v1/api/uploads/"+session.ID).ExpectProblem(http.StatusGone, "upload_closed")
+}
+
+func TestCleanupUploadSessions(t *testing.T) {
+
+	app := newTestApp(t)
+
+	session := data.UploadSession{PostID: "post-1", UserID: "alice", BlobKey: "key", ExpiresAt: time.Now().Add(-time.Minute)}
+
+	if err := app.Models.UploadSession.Create(context.Background(), &session); err != nil {
+		t.Fatal(err)
+	}
+
+	app.cleanupUploadSessions(context.Background())
+
+	stored, err := app.Models.UploadSession.GetByID(context.Background(), session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stored.Status != data.UploadExpired {
+		t.Errorf("status = %q, want %q", stored.Status, data.UploadExpired)
+	}
+}
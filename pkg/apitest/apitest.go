@@ -0,0 +1,298 @@
+// Package apitest drives the http api in tests through fiber's App.Test, so the
+// router and its handlers run without a network listener. It works with any
+// *fiber.App that follows the conventions of the api: callers are identified by
+// the X-User-ID and X-User-Roles headers of the gateway, successful responses
+// wrap their payload in a data field and errors are problem+json documents.
+//
+// It only makes requests, it does not build the app. The App of cmd is wired with
+// fakes by the tests of cmd, which can not be imported, so a team embedding the
+// router builds its app with its own models, blob store and logger.
+package apitest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Client makes requests to a fiber app on behalf of a caller.
+type Client struct {
+	t      testing.TB
+	app    *fiber.App
+	header http.Header
+}
+
+func New(t testing.TB, app *fiber.App) *Client {
+	return &Client{t: t, app: app, header: http.Header{}}
+}
+
+// As returns a copy of the client that makes requests as the user with userID and
+// roles, the way the authenticating gateway asserts them.
+func (c *Client) As(userID string, roles ...string) *Client {
+
+	client := c.With("X-User-ID", userID)
+
+	if len(roles) > 0 {
+		client.header.Set("X-User-Roles", strings.Join(roles, ","))
+	} else {
+		client.header.Del("X-User-Roles")
+	}
+
+	return client
+}
+
+// With returns a copy of the client that sets the header key to value on every request.
+func (c *Client) With(key, value string) *Client {
+
+	client := &Client{t: c.t, app: c.app, header: c.header.Clone()}
+
+	client.header.Set(key, value)
+
+	return client
+}
+
+func (c *Client) Get(path string) *Response {
+	return c.Do(http.MethodGet, path, nil)
+}
+
+func (c *Client) Post(path string, body interface{}) *Response {
+	return c.Do(http.MethodPost, path, body)
+}
+
+func (c *Client) Put(path string, body interface{}) *Response {
+	return c.Do(http.MethodPut, path, body)
+}
+
+func (c *Client) Patch(path string, body interface{}) *Response {
+	return c.Do(http.MethodPatch, path, body)
+}
+
+func (c *Client) Delete(path string) *Response {
+	return c.Do(http.MethodDelete, path, nil)
+}
+
+// Do makes a request with body, a *Form is sent as a multipart form, a []byte or
+// string as it is and any other non nil body is encoded as json.
+func (c *Client) Do(method, path string, body interface{}) *Response {
+
+	c.t.Helper()
+
+	var (
+		r           io.Reader
+		contentType string
+	)
+
+	switch body := body.(type) {
+	case nil:
+	case *Form:
+		b, ct, err := body.encode()
+		if err != nil {
+			c.t.Fatalf("encode form of %v %v: %v", method, path, err)
+		}
+		r, contentType = bytes.NewReader(b), ct
+	case []byte:
+		r, contentType = bytes.NewReader(body), "application/octet-stream"
+	case string:
+		r, contentType = strings.NewReader(body), fiber.MIMEApplicationJSON
+	default:
+		b, err := json.Marshal(body)
+		if err != nil {
+			c.t.Fatalf("encode body of %v %v: %v", method, path, err)
+		}
+		r, contentType = bytes.NewReader(b), fiber.MIMEApplicationJSON
+	}
+
+	req := httptest.NewRequest(method, path, r)
+
+	if contentType != "" {
+		req.Header.Set(fiber.HeaderContentType, contentType)
+	}
+
+	return c.Request(req)
+}
+
+// Request sends req with the headers of the client.
+func (c *Client) Request(req *http.Request) *Response {
+
+	c.t.Helper()
+
+	for key, values := range c.header {
+		req.Header[key] = values
+	}
+
+	res, err := c.app.Test(req, -1)
+	if err != nil {
+		c.t.Fatalf("%v %v: %v", req.Method, req.URL, err)
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		c.t.Fatalf("read response of %v %v: %v", req.Method, req.URL, err)
+	}
+
+	return &Response{
+		t:          c.t,
+		request:    req.Method + " " + req.URL.String(),
+		StatusCode: res.StatusCode,
+		Header:     res.Header,
+		Body:       body,
+	}
+}
+
+// Form is a multipart form of fields and files.
+type Form struct {
+	fields [][2]string
+	files  []formFile
+}
+
+type formFile struct {
+	field, name string
+	content     []byte
+}
+
+func NewForm() *Form {
+	return &Form{}
+}
+
+func (f *Form) Field(name, value string) *Form {
+	f.fields = append(f.fields, [2]string{name, value})
+	return f
+}
+
+func (f *Form) File(field, name string, content []byte) *Form {
+	f.files = append(f.files, formFile{field: field, name: name, content: content})
+	return f
+}
+
+func (f *Form) encode() ([]byte, string, error) {
+
+	var buf bytes.Buffer
+
+	w := multipart.NewWriter(&buf)
+
+	for _, field := range f.fields {
+		if err := w.WriteField(field[0], field[1]); err != nil {
+			return nil, "", err
+		}
+	}
+
+	for _, file := range f.files {
+		part, err := w.CreateFormFile(file.field, file.name)
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err := part.Write(file.content); err != nil {
+			return nil, "", err
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, "", err
+	}
+
+	return buf.Bytes(), w.FormDataContentType(), nil
+}
+
+// Response is the response to a request made by a Client.
+type Response struct {
+	t       testing.TB
+	request string
+
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// ExpectStatus fails the test when the response does not have status code.
+func (r *Response) ExpectStatus(code int) *Response {
+
+	r.t.Helper()
+
+	if r.StatusCode != code {
+		r.t.Fatalf("%v: status = %d, want %d, body: %s", r.request, r.StatusCode, code, r.Body)
+	}
+
+	return r
+}
+
+// Decode decodes the json body of the response into v.
+func (r *Response) Decode(v interface{}) {
+
+	r.t.Helper()
+
+	if err := json.Unmarshal(r.Body, v); err != nil {
+		r.t.Fatalf("%v: decode body %s: %v", r.request, r.Body, err)
+	}
+}
+
+// Data decodes the data field of a successful response into v.
+func (r *Response) Data(v interface{}) {
+
+	r.t.Helper()
+
+	var envelope struct {
+		Status string          `json:"status"`
+		Data   json.RawMessage `json:"data"`
+	}
+
+	r.Decode(&envelope)
+
+	if envelope.Status != "success" {
+		r.t.Fatalf("%v: status = %q, want success, body: %s", r.request, envelope.Status, r.Body)
+	}
+
+	if err := json.Unmarshal(envelope.Data, v); err != nil {
+		r.t.Fatalf("%v: decode data %s: %v", r.request, envelope.Data, err)
+	}
+}
+
+// Problem is a problem+json document the api answers errors with.
+type Problem struct {
+	Type      string            `json:"type"`
+	Title     string            `json:"title"`
+	Status    int               `json:"status"`
+	Detail    string            `json:"detail"`
+	Instance  string            `json:"instance"`
+	Code      string            `json:"code"`
+	RequestID string            `json:"request_id"`
+	Errors    map[string]string `json:"errors"`
+	// Extra holds every member of the document, including the ones above.
+	Extra map[string]interface{} `json:"-"`
+}
+
+// ExpectProblem fails the test unless the response is a problem+json document with
+// status and code, and returns the problem.
+func (r *Response) ExpectProblem(status int, code string) Problem {
+
+	r.t.Helper()
+
+	r.ExpectStatus(status)
+
+	if ct := r.Header.Get(fiber.HeaderContentType); ct != "application/problem+json" {
+		r.t.Fatalf("%v: content type = %q, want application/problem+json", r.request, ct)
+	}
+
+	var p Problem
+
+	r.Decode(&p)
+	r.Decode(&p.Extra)
+
+	if p.Status != status || p.Code != code {
+		r.t.Fatalf("%v: problem %v %q, want %v %q, body: %s", r.request, p.Status, p.Code, status, code, r.Body)
+	}
+
+	return p
+}
+
+func (r *Response) String() string {
+	return fmt.Sprintf("%v: %d %s", r.request, r.StatusCode, r.Body)
+}
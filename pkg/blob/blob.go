@@ -1,7 +1,10 @@
 package blob
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
 
 	"net/url"
@@ -11,14 +14,68 @@ import (
 	"github.com/google/uuid"
 )
 
+// Store stores the blobs of the application's media, Blob stores them in azure blob
+// storage and Local in a directory.
+type Store interface {
+	UploadBytesToBlob(ctx context.Context, data []byte, contentType string, metadata map[string]string) (string, error)
+
+	SignedURL(key string, ttl time.Duration) (string, error)
+
+	StageBlock(ctx context.Context, key string, part int, data []byte) error
+
+	CommitBlocks(ctx context.Context, key string, parts []int, contentType string, metadata map[string]string) error
+
+	AbortBlocks(ctx context.Context, key string) error
+
+	// DownloadBlob fails with ErrNotFound when no blob is stored under key.
+	DownloadBlob(ctx context.Context, key string) ([]byte, error)
+
+	DeleteBlob(ctx context.Context, key string) error
+
+	List(ctx context.Context, fn func(key string, modified time.Time) error) error
+
+	Ping(ctx context.Context) error
+}
+
+// PathVerifier is implemented by stores like Local that serve their blobs through
+// the media route of the api rather than through urls of the store itself.
+type PathVerifier interface {
+	VerifyPath(key, expires, signature string) (string, error)
+}
+
+// Wrap returns wrapper, a store that decorates store, along with the VerifyPath
+// method of store when it is a PathVerifier.
+func Wrap(store, wrapper Store) Store {
+
+	if verifier, ok := store.(PathVerifier); ok {
+		return verifyingStore{Store: wrapper, PathVerifier: verifier}
+	}
+
+	return wrapper
+}
+
+type verifyingStore struct {
+	Store
+	PathVerifier
+}
+
+// ErrNotFound is returned when no blob is stored under a key.
+var ErrNotFound = errors.New("blob not found")
+
 type Blob struct {
 	endPoint    string
 	container   string
-	azrKey      string
+	azrKey      func() (string, error)
 	accountName string
 }
 
 func New(endPoint, container, azrKey, accountName string) *Blob {
+	return NewWithKeyFunc(endPoint, container, func() (string, error) { return azrKey, nil }, accountName)
+}
+
+// NewWithKeyFunc returns a Blob that looks up its account key with azrKey on every
+// request, so a rotated key is used without recreating the Blob.
+func NewWithKeyFunc(endPoint, container string, azrKey func() (string, error), accountName string) *Blob {
 	return &Blob{
 		endPoint:    endPoint,
 		container:   container,
@@ -27,33 +84,235 @@ func New(endPoint, container, azrKey, accountName string) *Blob {
 	}
 }
 
-func getBlobName() string {
+func (b Blob) credential() (*azblob.SharedKeyCredential, error) {
+
+	key, err := b.azrKey()
+	if err != nil {
+		return nil, err
+	}
+
+	return azblob.NewSharedKeyCredential(b.accountName, key)
+}
+
+// extensions maps the content types of supported media to the extension of their keys.
+var extensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"video/mp4":  ".mp4",
+	"video/webm": ".webm",
+
+	"application/zip":      ".zip",
+	"application/x-ndjson": ".jsonl",
+	"text/csv":             ".csv",
+}
+
+// NewKey returns a new unique blob key for a blob of contentType.
+func NewKey(contentType string) string {
 	t := time.Now()
 	uuid := uuid.NewString()
 
-	return fmt.Sprintf("%s-%v.jpg", t.Format("20060102"), uuid)
+	ext, ok := extensions[contentType]
+	if !ok {
+		ext = ".jpg"
+	}
+
+	return fmt.Sprintf("%s-%v%s", t.Format("20060102"), uuid, ext)
 }
 
-func (b Blob) UploadBytesToBlob(data []byte, metadata map[string]string) (string, error) {
+func (b Blob) blockBlobURL(key string) (azblob.BlockBlobURL, error) {
 
-	u, _ := url.Parse(fmt.Sprint(b.endPoint, b.container, "/", getBlobName()))
+	u, _ := url.Parse(fmt.Sprint(b.endPoint, b.container, "/", key))
 
-	credential, err := azblob.NewSharedKeyCredential(b.accountName, b.azrKey)
+	credential, err := b.credential()
 	if err != nil {
-		return "", err
+		return azblob.BlockBlobURL{}, err
 	}
 
-	blockBlobUrl := azblob.NewBlockBlobURL(*u, azblob.NewPipeline(credential, azblob.PipelineOptions{}))
+	return azblob.NewBlockBlobURL(*u, azblob.NewPipeline(credential, azblob.PipelineOptions{})), nil
+}
+
+// UploadBytesToBlob uploads data to a new blob and returns its key, the blob is
+// only readable through the urls returned by SignedURL.
+func (b Blob) UploadBytesToBlob(ctx context.Context, data []byte, contentType string, metadata map[string]string) (string, error) {
+
+	key := NewKey(contentType)
+
+	blockBlobUrl, err := b.blockBlobURL(key)
+	if err != nil {
+		return "", err
+	}
 
-	ctx := context.Background()
 	o := azblob.UploadToBlockBlobOptions{
 		BlobHTTPHeaders: azblob.BlobHTTPHeaders{
-			ContentType: "image/jpg",
+			ContentType: contentType,
 		},
 		Metadata: metadata,
 	}
 
-	_, err = azblob.UploadBufferToBlockBlob(ctx, data, blockBlobUrl, o)
+	if _, err = azblob.UploadBufferToBlockBlob(ctx, data, blockBlobUrl, o); err != nil {
+		return "", err
+	}
+
+	return key, nil
+}
+
+// blockID returns the id of the block holding part, block ids of a blob must all
+// have the same length.
+func blockID(part int) string {
+	return base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%08d", part)))
+}
+
+// StageBlock uploads part of the blob with key as an uncommitted block.
+func (b Blob) StageBlock(ctx context.Context, key string, part int, data []byte) error {
+
+	blockBlobUrl, err := b.blockBlobURL(key)
+	if err != nil {
+		return err
+	}
+
+	_, err = blockBlobUrl.StageBlock(ctx, blockID(part), bytes.NewReader(data), azblob.LeaseAccessConditions{}, nil, azblob.ClientProvidedKeyOptions{})
+
+	return err
+}
+
+// CommitBlocks assembles the blob with key from its staged parts in order.
+func (b Blob) CommitBlocks(ctx context.Context, key string, parts []int, contentType string, metadata map[string]string) error {
+
+	blockBlobUrl, err := b.blockBlobURL(key)
+	if err != nil {
+		return err
+	}
+
+	ids := make([]string, 0, len(parts))
+	for _, part := range parts {
+		ids = append(ids, blockID(part))
+	}
+
+	h := azblob.BlobHTTPHeaders{ContentType: contentType}
+
+	_, err = blockBlobUrl.CommitBlockList(ctx, ids, h, metadata, azblob.BlobAccessConditions{}, azblob.AccessTierNone, nil, azblob.ClientProvidedKeyOptions{}, azblob.ImmutabilityPolicyOptions{})
+
+	return err
+}
+
+// AbortBlocks discards the staged parts of the blob with key. Azure garbage collects
+// uncommitted blocks after a week so there is nothing to delete.
+func (b Blob) AbortBlocks(ctx context.Context, key string) error { return nil }
+
+func (b Blob) DownloadBlob(ctx context.Context, key string) ([]byte, error) {
+
+	blockBlobUrl, err := b.blockBlobURL(key)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := blockBlobUrl.Download(ctx, 0, azblob.CountToEnd, azblob.BlobAccessConditions{}, false, azblob.ClientProvidedKeyOptions{})
+	if err != nil {
+		var serr azblob.StorageError
+		if errors.As(err, &serr) && serr.ServiceCode() == azblob.ServiceCodeBlobNotFound {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	body := resp.Body(azblob.RetryReaderOptions{MaxRetryRequests: 3})
+	defer body.Close()
+
+	var buf bytes.Buffer
+
+	if _, err := buf.ReadFrom(body); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (b Blob) DeleteBlob(ctx context.Context, key string) error {
+
+	blockBlobUrl, err := b.blockBlobURL(key)
+	if err != nil {
+		return err
+	}
+
+	_, err = blockBlobUrl.Delete(ctx, azblob.DeleteSnapshotsOptionInclude, azblob.BlobAccessConditions{})
+
+	return err
+}
+
+// SignedURL returns a read-only SAS url of the blob with key that expires after ttl.
+func (b Blob) SignedURL(key string, ttl time.Duration) (string, error) {
+
+	credential, err := b.credential()
+	if err != nil {
+		return "", err
+	}
+
+	sas, err := azblob.BlobSASSignatureValues{
+		Protocol:      azblob.SASProtocolHTTPS,
+		ExpiryTime:    time.Now().UTC().Add(ttl),
+		ContainerName: b.container,
+		BlobName:      key,
+		Permissions:   azblob.BlobSASPermissions{Read: true}.String(),
+	}.NewSASQueryParameters(credential)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprint(b.endPoint, b.container, "/", key, "?", sas.Encode()), nil
+}
+
+func (b Blob) containerURL() (azblob.ContainerURL, error) {
+
+	u, err := url.Parse(fmt.Sprint(b.endPoint, b.container))
+	if err != nil {
+		return azblob.ContainerURL{}, err
+	}
+
+	credential, err := b.credential()
+	if err != nil {
+		return azblob.ContainerURL{}, err
+	}
+
+	return azblob.NewContainerURL(*u, azblob.NewPipeline(credential, azblob.PipelineOptions{})), nil
+}
+
+// List calls fn with the key and last modification time of every blob in the
+// container, it stops at the first error fn returns.
+func (b Blob) List(ctx context.Context, fn func(key string, modified time.Time) error) error {
+
+	container, err := b.containerURL()
+	if err != nil {
+		return err
+	}
+
+	for marker := (azblob.Marker{}); marker.NotDone(); {
+		resp, err := container.ListBlobsFlatSegment(ctx, marker, azblob.ListBlobsSegmentOptions{})
+		if err != nil {
+			return err
+		}
+
+		for _, item := range resp.Segment.BlobItems {
+			if err := fn(item.Name, item.Properties.LastModified); err != nil {
+				return err
+			}
+		}
+
+		marker = resp.NextMarker
+	}
+
+	return nil
+}
+
+// Ping checks that the container of the blob store is reachable with the account key.
+func (b Blob) Ping(ctx context.Context) error {
+
+	container, err := b.containerURL()
+	if err != nil {
+		return err
+	}
+
+	_, err = container.GetProperties(ctx, azblob.LeaseAccessConditions{})
 
-	return blockBlobUrl.String(), err
+	return err
 }
@@ -0,0 +1,207 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/evansopilo/visuai/pkg/signer"
+)
+
+var ErrInvalidKey = errors.New("error invalid blob key")
+
+// Local stores blobs as files in a directory, it stands in for azure blob storage in
+// development. Blobs are served by the api's media route through HMAC signed urls,
+// signed with a key derived from the key of the signer for media urls only.
+type Local struct {
+	dir     string
+	baseURL string
+	signer  *signer.Signer
+}
+
+func NewLocal(dir, baseURL string, s *signer.Signer) (*Local, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	return &Local{dir: dir, baseURL: strings.TrimSuffix(baseURL, "/"), signer: s.Derive("media")}, nil
+}
+
+func (l Local) UploadBytesToBlob(ctx context.Context, data []byte, contentType string, metadata map[string]string) (string, error) {
+
+	key := NewKey(contentType)
+
+	if err := os.WriteFile(filepath.Join(l.dir, key), data, 0o640); err != nil {
+		return "", err
+	}
+
+	return key, nil
+}
+
+// validKey reports whether key names a file directly inside the store directory.
+func validKey(key string) bool {
+	return key != "" && key == filepath.Base(key) && !strings.HasPrefix(key, ".")
+}
+
+// partsDir returns the directory holding the staged parts of the blob with key.
+func (l Local) partsDir(key string) string { return filepath.Join(l.dir, ".parts", key) }
+
+func (l Local) StageBlock(ctx context.Context, key string, part int, data []byte) error {
+
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+
+	if err := os.MkdirAll(l.partsDir(key), 0o750); err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(l.partsDir(key), strconv.Itoa(part)), data, 0o640)
+}
+
+// CommitBlocks concatenates the staged parts of the blob with key in order and
+// removes them.
+func (l Local) CommitBlocks(ctx context.Context, key string, parts []int, contentType string, metadata map[string]string) error {
+
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+
+	f, err := os.OpenFile(filepath.Join(l.dir, key), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o640)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	for _, part := range parts {
+		data, err := os.ReadFile(filepath.Join(l.partsDir(key), strconv.Itoa(part)))
+		if err != nil {
+			return err
+		}
+
+		if _, err := f.Write(data); err != nil {
+			return err
+		}
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return l.AbortBlocks(ctx, key)
+}
+
+func (l Local) AbortBlocks(ctx context.Context, key string) error {
+
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+
+	return os.RemoveAll(l.partsDir(key))
+}
+
+func (l Local) DownloadBlob(ctx context.Context, key string) ([]byte, error) {
+
+	if !validKey(key) {
+		return nil, ErrInvalidKey
+	}
+
+	b, err := os.ReadFile(filepath.Join(l.dir, key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+
+	return b, err
+}
+
+func (l Local) DeleteBlob(ctx context.Context, key string) error {
+
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+
+	return os.Remove(filepath.Join(l.dir, key))
+}
+
+// List calls fn with the key and modification time of every blob in the store
+// directory, staged parts are not blobs yet and are left out.
+func (l Local) List(ctx context.Context, fn func(key string, modified time.Time) error) error {
+
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !validKey(entry.Name()) {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return err
+		}
+
+		if err := fn(entry.Name(), info.ModTime()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SignedURL returns a url of the media route serving the blob with key that expires after ttl.
+func (l Local) SignedURL(key string, ttl time.Duration) (string, error) {
+
+	expires := time.Now().Add(ttl)
+
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	query.Set("signature", l.signer.Sign(key, expires))
+
+	return fmt.Sprintf("%s/v1/api/media/%s?%s", l.baseURL, url.PathEscape(key), query.Encode()), nil
+}
+
+// VerifyPath checks the expiry and signature of a url returned by SignedURL and
+// returns the path of the file holding the blob with key.
+func (l Local) VerifyPath(key, expires, signature string) (string, error) {
+
+	if !validKey(key) {
+		return "", ErrInvalidKey
+	}
+
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return "", signer.ErrInvalidSignature
+	}
+
+	if err := l.signer.Verify(key, time.Unix(unix, 0), signature); err != nil {
+		return "", err
+	}
+
+	return filepath.Join(l.dir, key), nil
+}
+
+// Ping checks that the store directory still exists.
+func (l Local) Ping(ctx context.Context) error {
+
+	info, err := os.Stat(l.dir)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("blob: %v is not a directory", l.dir)
+	}
+
+	return nil
+}
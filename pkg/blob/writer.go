@@ -0,0 +1,79 @@
+package blob
+
+import "context"
+
+// Writer stages what is written to it as the blocks of a new blob of a store, so a
+// large blob is stored without holding all of it in memory. The blob is committed
+// by Close, a Writer that fails is discarded with Abort.
+type Writer struct {
+	ctx         context.Context
+	store       Store
+	key         string
+	contentType string
+	metadata    map[string]string
+	block       []byte
+	parts       []int
+	size        int64
+}
+
+// NewWriter returns a writer of the blob with key that stages blocks of blockSize
+// bytes, only the last block may be smaller.
+func NewWriter(ctx context.Context, store Store, key string, blockSize int, contentType string, metadata map[string]string) *Writer {
+	return &Writer{ctx: ctx, store: store, key: key, contentType: contentType, metadata: metadata, block: make([]byte, 0, blockSize)}
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+
+	written := 0
+
+	for len(p) > 0 {
+		n := copy(w.block[len(w.block):cap(w.block)], p)
+		w.block, p, written = w.block[:len(w.block)+n], p[n:], written+n
+
+		if len(w.block) == cap(w.block) {
+			if err := w.stage(); err != nil {
+				return written, err
+			}
+		}
+	}
+
+	return written, nil
+}
+
+// stage stages the buffered block as the next part of the blob.
+func (w *Writer) stage() error {
+
+	part := len(w.parts) + 1
+
+	if err := w.store.StageBlock(w.ctx, w.key, part, w.block); err != nil {
+		return err
+	}
+
+	w.parts, w.size, w.block = append(w.parts, part), w.size+int64(len(w.block)), w.block[:0]
+
+	return nil
+}
+
+// Close stages the last block and commits the blob.
+func (w *Writer) Close() error {
+
+	if len(w.block) > 0 || len(w.parts) == 0 {
+		if err := w.stage(); err != nil {
+			return err
+		}
+	}
+
+	return w.store.CommitBlocks(w.ctx, w.key, w.parts, w.contentType, w.metadata)
+}
+
+// Abort discards the blocks staged so far.
+func (w *Writer) Abort() error {
+	return w.store.AbortBlocks(w.ctx, w.key)
+}
+
+// Key returns the key of the blob.
+func (w *Writer) Key() string { return w.key }
+
+// Size returns the number of bytes staged so far, the size of the blob once it is
+// closed.
+func (w *Writer) Size() int64 { return w.size }
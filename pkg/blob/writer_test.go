@@ -0,0 +1,72 @@
+package blob_test
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/evansopilo/visuai/pkg/blob"
+	"github.com/evansopilo/visuai/pkg/signer"
+)
+
+func TestWriter(t *testing.T) {
+
+	ctx := context.Background()
+
+	store, err := blob.NewLocal(t.TempDir(), "", signer.New([]byte("key")))
+	if err != nil {
+		t.Fatalf("NewLocal: %v", err)
+	}
+
+	for _, content := range []string{"", "abc", "abcd", "abcdefghij"} {
+		w := blob.NewWriter(ctx, store, blob.NewKey("application/zip"), 4, "application/zip", nil)
+
+		if _, err := io.Copy(w, strings.NewReader(content)); err != nil {
+			t.Fatalf("write %q: %v", content, err)
+		}
+
+		if err := w.Close(); err != nil {
+			t.Fatalf("close %q: %v", content, err)
+		}
+
+		b, err := store.DownloadBlob(ctx, w.Key())
+		if err != nil {
+			t.Fatalf("download %q: %v", content, err)
+		}
+
+		if string(b) != content || w.Size() != int64(len(content)) {
+			t.Errorf("blob = %q of size %v, want %q", b, w.Size(), content)
+		}
+	}
+}
+
+func TestWriterAbort(t *testing.T) {
+
+	ctx := context.Background()
+
+	store, err := blob.NewLocal(t.TempDir(), "", signer.New([]byte("key")))
+	if err != nil {
+		t.Fatalf("NewLocal: %v", err)
+	}
+
+	w := blob.NewWriter(ctx, store, blob.NewKey("application/zip"), 4, "application/zip", nil)
+
+	if _, err := w.Write([]byte("abcdefghij")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	if err := w.Abort(); err != nil {
+		t.Fatalf("Abort: %v", err)
+	}
+
+	if _, err := store.DownloadBlob(ctx, w.Key()); err == nil {
+		t.Errorf("an aborted blob was stored")
+	}
+
+	count := 0
+	if err := store.List(ctx, func(string, time.Time) error { count++; return nil }); err != nil || count != 0 {
+		t.Errorf("List = %v blobs, %v, want no blobs or staged parts", count, err)
+	}
+}
@@ -0,0 +1,505 @@
+// Package config loads the application configuration from, in increasing order of
+// precedence, defaults, a yaml file, environment variables and command line flags.
+// String settings can hold a reference to a secret of the form secret://name which
+// is resolved through a SecretProvider once, as the configuration is loaded.
+package config
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/evansopilo/visuai/pkg/validator"
+	"gopkg.in/yaml.v3"
+)
+
+// secretScheme prefixes the settings that refer to a secret.
+const secretScheme = "secret://"
+
+var ErrNoSecretProvider = errors.New("secret reference without a secret provider")
+
+// SecretProvider looks up the secrets settings refer to.
+type SecretProvider interface {
+	GetSecret(ctx context.Context, name, version string) (*string, error)
+}
+
+type Config struct {
+	HTTP       HTTP     `yaml:"http"`
+	Mongo      Mongo    `yaml:"mongo"`
+	Blob       Blob     `yaml:"blob"`
+	Secrets    Secrets  `yaml:"secrets"`
+	Tracing    Tracing  `yaml:"tracing"`
+	Log        Log      `yaml:"log"`
+	SigningKey string   `yaml:"signing_key"`
+	Tenants    []Tenant `yaml:"tenants"`
+}
+
+// HTTP configures the http server, in-flight requests are given the shutdown
+// timeout to complete when the server stops.
+type HTTP struct {
+	Port            int           `yaml:"port"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+}
+
+// Mongo configures the database, when migrate is set the server applies the
+// pending migrations and creates the missing indexes as it starts. Collections
+// maps the default names of collections to the names they have in the database.
+type Mongo struct {
+	URI         string            `yaml:"uri"`
+	Database    string            `yaml:"database"`
+	Collections map[string]string `yaml:"collections"`
+	Migrate     bool              `yaml:"migrate"`
+}
+
+// Tenant is served from a database of its own in the same deployment, for the
+// requests to its hosts or that the gateway asserts are made for it. Requests for
+// no tenant are served from the mongo database.
+type Tenant struct {
+	Name     string   `yaml:"name"`
+	Hosts    []string `yaml:"hosts"`
+	Database string   `yaml:"database"`
+}
+
+// Blob configures the blob store, the backend is either azure or local.
+type Blob struct {
+	Backend     string `yaml:"backend"`
+	LocalDir    string `yaml:"local_dir"`
+	BaseURL     string `yaml:"base_url"`
+	Endpoint    string `yaml:"endpoint"`
+	Container   string `yaml:"container"`
+	AccountName string `yaml:"account_name"`
+	AccountKey  string `yaml:"account_key"`
+}
+
+// Secrets configures where secret references are resolved. The provider is azure,
+// env, file or none, when it is not set secrets are read from azure key vault if a
+// vault uri is set. Secrets are cached for the cache ttl and refreshed in the
+// background.
+type Secrets struct {
+	Provider string        `yaml:"provider"`
+	VaultURI string        `yaml:"vault_uri"`
+	Path     string        `yaml:"path"`
+	CacheTTL time.Duration `yaml:"cache_ttl"`
+}
+
+// Tracing configures the exporter of the spans, it is none, stdout or otlp which
+// sends them to the collector at the endpoint, host:port.
+type Tracing struct {
+	Exporter    string  `yaml:"exporter"`
+	Endpoint    string  `yaml:"endpoint"`
+	Insecure    bool    `yaml:"insecure"`
+	SampleRatio float64 `yaml:"sample_ratio"`
+}
+
+// Log configures the application logger, the format is json or text and only one in
+// every sample rate access log entries of successful requests is written.
+type Log struct {
+	Format     string `yaml:"format"`
+	Level      string `yaml:"level"`
+	SampleRate int    `yaml:"sample_rate"`
+}
+
+// SecretProvider returns the name of the configured secret provider.
+func (s Secrets) SecretProvider() string {
+
+	if s.Provider == "" && s.VaultURI != "" {
+		return "azure"
+	}
+
+	if s.Provider == "" {
+		return "none"
+	}
+
+	return s.Provider
+}
+
+// Default returns the configuration used when nothing else is set, it reads its
+// secrets from the names they have in key vault.
+func Default() *Config {
+	return &Config{
+		HTTP:  HTTP{Port: 8080, ShutdownTimeout: 30 * time.Second},
+		Mongo: Mongo{URI: "secret://mongo_uri", Database: "visuai"},
+		Blob: Blob{
+			Backend:     "azure",
+			Endpoint:    "secret://blob_endpoint",
+			Container:   "secret://blob_container",
+			AccountName: "secret://account_name",
+			AccountKey:  "secret://blob_azr_key",
+		},
+		Secrets:    Secrets{CacheTTL: 5 * time.Minute},
+		Log:        Log{Format: "json", Level: "info", SampleRate: 1},
+		Tracing:    Tracing{Exporter: "none", Endpoint: "localhost:4318", SampleRatio: 1},
+		SigningKey: "secret://signing_key",
+	}
+}
+
+// setting binds a configuration value to its environment variable and flag.
+type setting struct {
+	env   string
+	flag  string
+	usage string
+	value flag.Value
+}
+
+func (c *Config) settings() []setting {
+	return []setting{
+		{"port", "port", "port of the http server", (*intValue)(&c.HTTP.Port)},
+		{"shutdown_timeout", "shutdown-timeout", "duration in-flight requests are given to complete on shutdown", (*durationValue)(&c.HTTP.ShutdownTimeout)},
+		{"mongo_uri", "mongo-uri", "uri of the mongodb deployment", (*stringValue)(&c.Mongo.URI)},
+		{"mongo_database", "mongo-database", "name of the mongodb database", (*stringValue)(&c.Mongo.Database)},
+		{"mongo_migrate", "mongo-migrate", "apply migrations and create indexes when the server starts", (*boolValue)(&c.Mongo.Migrate)},
+		{"blob_backend", "blob-backend", "blob store backend, azure or local", (*stringValue)(&c.Blob.Backend)},
+		{"blob_local_dir", "blob-local-dir", "directory of the local blob store", (*stringValue)(&c.Blob.LocalDir)},
+		{"base_url", "base-url", "public base url of the local blob store", (*stringValue)(&c.Blob.BaseURL)},
+		{"blob_endpoint", "blob-endpoint", "endpoint of the azure blob store", (*stringValue)(&c.Blob.Endpoint)},
+		{"blob_container", "blob-container", "container of the azure blob store", (*stringValue)(&c.Blob.Container)},
+		{"account_name", "blob-account-name", "account name of the azure blob store", (*stringValue)(&c.Blob.AccountName)},
+		{"blob_azr_key", "blob-account-key", "account key of the azure blob store", (*stringValue)(&c.Blob.AccountKey)},
+		{"secret_provider", "secret-provider", "provider of secrets, azure, env, file or none", (*stringValue)(&c.Secrets.Provider)},
+		{"azure_vault_uri", "vault-uri", "uri of the azure key vault secrets are read from", (*stringValue)(&c.Secrets.VaultURI)},
+		{"secret_path", "secret-path", "dotenv file or directory secrets are read from", (*stringValue)(&c.Secrets.Path)},
+		{"secret_cache_ttl", "secret-cache-ttl", "duration secrets are cached for", (*durationValue)(&c.Secrets.CacheTTL)},
+		{"trace_exporter", "trace-exporter", "exporter of trace spans, none, stdout or otlp", (*stringValue)(&c.Tracing.Exporter)},
+		{"otlp_endpoint", "otlp-endpoint", "host:port of the otlp http collector", (*stringValue)(&c.Tracing.Endpoint)},
+		{"otlp_insecure", "otlp-insecure", "send spans to the otlp collector without tls", (*boolValue)(&c.Tracing.Insecure)},
+		{"trace_sample_ratio", "trace-sample-ratio", "ratio of the traces started by the application that are sampled", (*floatValue)(&c.Tracing.SampleRatio)},
+		{"log_format", "log-format", "format of the logs, json or text", (*stringValue)(&c.Log.Format)},
+		{"log_level", "log-level", "lowest level logged, from panic to trace", (*stringValue)(&c.Log.Level)},
+		{"log_sample_rate", "log-sample-rate", "one in every how many access log entries of successful requests are written", (*intValue)(&c.Log.SampleRate)},
+		{"signing_key", "signing-key", "key signing share links and media urls", (*stringValue)(&c.SigningKey)},
+	}
+}
+
+// Load builds the configuration from the defaults, the yaml file named by the
+// config flag or environment variable, the environment looked up with lookupEnv and
+// the flags in args.
+func Load(args []string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	return LoadFlags(flag.NewFlagSet("visuai", flag.ContinueOnError), args, lookupEnv)
+}
+
+// LoadFlags is Load with the flags of the configuration added to fs, so a command
+// can parse its own flags along with them.
+func LoadFlags(fs *flag.FlagSet, args []string, lookupEnv func(string) (string, bool)) (*Config, error) {
+
+	cfg := Default()
+
+	path := fs.String("config", "", "path of a yaml configuration file")
+
+	// flags are parsed into a copy so they can be applied last.
+	flags := Default()
+	for _, s := range flags.settings() {
+		fs.Var(s.value, s.flag, s.usage)
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *path == "" {
+		*path, _ = lookupEnv("config_file")
+	}
+
+	if *path != "" {
+		if err := cfg.loadFile(*path); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range cfg.settings() {
+		if value, ok := lookupEnv(s.env); ok {
+			if err := s.value.Set(value); err != nil {
+				return nil, fmt.Errorf("config: invalid value of %v: %w", s.env, err)
+			}
+		}
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	for _, s := range cfg.settings() {
+		if set[s.flag] {
+			if err := s.value.Set(fs.Lookup(s.flag).Value.String()); err != nil {
+				return nil, fmt.Errorf("config: invalid value of -%v: %w", s.flag, err)
+			}
+		}
+	}
+
+	return cfg, nil
+}
+
+func (c *Config) loadFile(path string) error {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("config: %v: %w", path, err)
+	}
+
+	return nil
+}
+
+// ResolveSecrets replaces the secret references of the settings in use with the
+// secrets provider holds, provider can be nil when no setting refers to a secret.
+func (c *Config) ResolveSecrets(ctx context.Context, provider SecretProvider) error {
+
+	for key, value := range c.secretSettings() {
+		name, ok := SecretRef(*value)
+		if !ok {
+			continue
+		}
+
+		if provider == nil {
+			return fmt.Errorf("config: %w: %v", ErrNoSecretProvider, key)
+		}
+
+		secret, err := provider.GetSecret(ctx, name, "")
+		if err != nil {
+			return fmt.Errorf("config: resolve secret %v: %w", name, err)
+		}
+
+		*value = *secret
+	}
+
+	return nil
+}
+
+// SecretRef returns the name of the secret value refers to, ok is false when value
+// does not refer to a secret.
+func SecretRef(value string) (name string, ok bool) {
+
+	if !strings.HasPrefix(value, secretScheme) {
+		return "", false
+	}
+
+	return strings.TrimPrefix(value, secretScheme), true
+}
+
+// SecretRefs returns the names of the secrets the settings in use refer to.
+func (c *Config) SecretRefs() []string {
+
+	var names []string
+
+	for _, value := range c.secretSettings() {
+		if name, ok := SecretRef(*value); ok {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// secretSettings returns the string settings that can refer to a secret and are in
+// use with the configured blob backend.
+func (c *Config) secretSettings() map[string]*string {
+
+	values := map[string]*string{
+		"mongo.uri":   &c.Mongo.URI,
+		"signing_key": &c.SigningKey,
+	}
+
+	if c.Blob.Backend == "azure" {
+		values["blob.endpoint"] = &c.Blob.Endpoint
+		values["blob.container"] = &c.Blob.Container
+		values["blob.account_name"] = &c.Blob.AccountName
+		values["blob.account_key"] = &c.Blob.AccountKey
+	}
+
+	return values
+}
+
+// Redacted returns a copy of the configuration to show, the settings that can hold a
+// secret are replaced unless they refer to one.
+func (c Config) Redacted() Config {
+
+	values := c.secretSettings()
+	values["blob.account_key"] = &c.Blob.AccountKey
+
+	for _, value := range values {
+		if _, ok := SecretRef(*value); !ok && *value != "" {
+			*value = "[redacted]"
+		}
+	}
+
+	return c
+}
+
+// Validate checks the configuration once its secrets are resolved.
+func (c *Config) Validate() error {
+
+	v := validator.New()
+
+	v.Check(c.HTTP.Port > 0 && c.HTTP.Port < 65536, "http.port", "must be between 1 and 65535")
+	v.Check(c.HTTP.ShutdownTimeout > 0, "http.shutdown_timeout", "must be positive")
+	v.Check(c.Mongo.URI != "", "mongo.uri", "must be provided")
+	v.Check(c.Mongo.Database != "", "mongo.database", "must be provided")
+	v.Check(c.SigningKey != "", "signing_key", "must be provided")
+	v.Check(validator.In(c.Blob.Backend, "azure", "local"), "blob.backend", "must be azure or local")
+	v.Check(validator.In(c.Secrets.SecretProvider(), "azure", "env", "file", "none"), "secrets.provider", "must be azure, env, file or none")
+	v.Check(c.Secrets.CacheTTL >= 0, "secrets.cache_ttl", "must not be negative")
+
+	switch c.Secrets.SecretProvider() {
+	case "azure":
+		v.Check(c.Secrets.VaultURI != "", "secrets.vault_uri", "must be provided")
+	case "file":
+		v.Check(c.Secrets.Path != "", "secrets.path", "must be provided")
+	}
+
+	v.Check(validator.In(c.Log.Format, "json", "text"), "log.format", "must be json or text")
+	v.Check(validator.In(c.Log.Level, "panic", "fatal", "error", "warn", "warning", "info", "debug", "trace"), "log.level", "must be a log level from panic to trace")
+	v.Check(c.Log.SampleRate >= 1, "log.sample_rate", "must be at least 1")
+	v.Check(validator.In(c.Tracing.Exporter, "none", "stdout", "otlp"), "tracing.exporter", "must be none, stdout or otlp")
+	v.Check(c.Tracing.Exporter != "otlp" || c.Tracing.Endpoint != "", "tracing.endpoint", "must be provided")
+	v.Check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sample_ratio", "must be between 0 and 1")
+
+	switch c.Blob.Backend {
+	case "azure":
+		v.Check(c.Blob.Endpoint != "", "blob.endpoint", "must be provided")
+		v.Check(c.Blob.Container != "", "blob.container", "must be provided")
+		v.Check(c.Blob.AccountName != "", "blob.account_name", "must be provided")
+		v.Check(c.Blob.AccountKey != "", "blob.account_key", "must be provided")
+	case "local":
+		v.Check(c.Blob.LocalDir != "", "blob.local_dir", "must be provided")
+		v.Check(c.Blob.BaseURL != "", "blob.base_url", "must be provided")
+	}
+
+	names := map[string]bool{}
+	for collection, name := range c.Mongo.Collections {
+		v.Check(name != "" && !names[name], "mongo.collections."+collection, "must be a name no other collection has")
+		names[name] = true
+	}
+
+	databases, hosts := map[string]bool{c.Mongo.Database: true}, map[string]bool{}
+	for i, tenant := range c.Tenants {
+		key := fmt.Sprintf("tenants.%d", i)
+		v.Check(tenant.Name != "" && tenant.Name == strings.TrimSpace(tenant.Name), key+".name", "must be provided")
+		for j, other := range c.Tenants[:i] {
+			v.Check(tenant.Name != other.Name, key+".name", fmt.Sprintf("must differ from the name of tenants.%d", j))
+		}
+		v.Check(tenant.Database != "" && !databases[tenant.Database], key+".database", "must be a database no other tenant uses")
+		databases[tenant.Database] = true
+		for _, host := range tenant.Hosts {
+			v.Check(host != "" && !hosts[strings.ToLower(host)], key+".hosts", "must be hosts no other tenant serves")
+			hosts[strings.ToLower(host)] = true
+		}
+	}
+
+	for key, value := range c.secretSettings() {
+		if _, ok := SecretRef(*value); ok {
+			v.AddError(key, "refers to a secret that is not resolved")
+		}
+	}
+
+	if v.Valid() {
+		return nil
+	}
+
+	return ValidationError(v.Errors)
+}
+
+// ValidationError holds the problems of an invalid configuration by setting.
+type ValidationError map[string]string
+
+func (e ValidationError) Error() string {
+
+	keys := make([]string, 0, len(e))
+	for key := range e {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	problems := make([]string, 0, len(keys))
+	for _, key := range keys {
+		problems = append(problems, fmt.Sprintf("%v %v", key, e[key]))
+	}
+
+	return "config: invalid configuration: " + strings.Join(problems, ", ")
+}
+
+type stringValue string
+
+func (s *stringValue) Set(value string) error { *s = stringValue(value); return nil }
+
+func (s *stringValue) String() string { return string(*s) }
+
+type intValue int
+
+func (i *intValue) Set(value string) error {
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+
+	*i = intValue(n)
+
+	return nil
+}
+
+func (i *intValue) String() string { return strconv.Itoa(int(*i)) }
+
+type durationValue time.Duration
+
+func (d *durationValue) Set(value string) error {
+
+	v, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+
+	*d = durationValue(v)
+
+	return nil
+}
+
+func (d *durationValue) String() string { return time.Duration(*d).String() }
+
+type floatValue float64
+
+func (f *floatValue) Set(value string) error {
+
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return err
+	}
+
+	*f = floatValue(v)
+
+	return nil
+}
+
+func (f *floatValue) String() string { return strconv.FormatFloat(float64(*f), 'g', -1, 64) }
+
+type boolValue bool
+
+func (b *boolValue) Set(value string) error {
+
+	v, err := strconv.ParseBool(value)
+	if err != nil {
+		return err
+	}
+
+	*b = boolValue(v)
+
+	return nil
+}
+
+func (b *boolValue) String() string { return strconv.FormatBool(bool(*b)) }
+
+// IsBoolFlag lets the flag be set without a value.
+func (b *boolValue) IsBoolFlag() bool { return true }
@@ -0,0 +1,233 @@
+package config_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/evansopilo/visuai/pkg/config"
+)
+
+// env returns a lookup of the environment variables in vars.
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		value, ok := vars[key]
+		return value, ok
+	}
+}
+
+// writeFile writes a yaml configuration file and returns its path.
+func writeFile(t *testing.T, content string) string {
+
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+
+	file := writeFile(t, "http:\n  port: 9000\nlog:\n  format: text\n")
+
+	tests := []struct {
+		name   string
+		args   []string
+		env    map[string]string
+		port   int
+		format string
+	}{
+		{"defaults", nil, nil, 8080, "json"},
+		{"file", []string{"-config", file}, nil, 9000, "text"},
+		{"file from the environment", nil, map[string]string{"config_file": file}, 9000, "text"},
+		{"environment over file", []string{"-config", file}, map[string]string{"port": "9001"}, 9001, "text"},
+		{"flag over environment", []string{"-config", file, "-port", "9002"}, map[string]string{"port": "9001", "log_format": "json"}, 9002, "json"},
+		{"flag over default", []string{"-log-format", "text"}, nil, 8080, "text"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := config.Load(tt.args, env(tt.env))
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+
+			if cfg.HTTP.Port != tt.port || cfg.Log.Format != tt.format {
+				t.Errorf("port, format = %v, %v, want %v, %v", cfg.HTTP.Port, cfg.Log.Format, tt.port, tt.format)
+			}
+
+			// settings that are not set keep their defaults.
+			if cfg.HTTP.ShutdownTimeout != 30*time.Second || cfg.Mongo.Database != "visuai" {
+				t.Errorf("defaults = %v, %v, want them kept", cfg.HTTP.ShutdownTimeout, cfg.Mongo.Database)
+			}
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		want string
+	}{
+		{"unknown field", []string{"-config", writeFile(t, "http:\n  prot: 9000\n")}, nil, "field prot not found"},
+		{"field of the wrong type", []string{"-config", writeFile(t, "http:\n  port: high\n")}, nil, "config.yaml"},
+		{"missing file", []string{"-config", "missing.yaml"}, nil, "missing.yaml"},
+		{"invalid environment variable", nil, map[string]string{"shutdown_timeout": "soon"}, "shutdown_timeout"},
+		{"invalid flag", []string{"-port", "high"}, nil, "port"},
+		{"unknown flag", []string{"-verbose"}, nil, "verbose"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := config.Load(tt.args, env(tt.env)); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Load = %v, want an error about %q", err, tt.want)
+			}
+		})
+	}
+
+	if _, err := config.Load([]string{"-config", writeFile(t, "")}, env(nil)); err != nil {
+		t.Errorf("Load of an empty file = %v, want nil", err)
+	}
+}
+
+// valid returns a configuration that passes validation.
+func valid() *config.Config {
+
+	cfg := config.Default()
+	cfg.Mongo.URI = "mongodb://localhost:27017"
+	cfg.SigningKey = "signing key"
+	cfg.Blob = config.Blob{Backend: "local", LocalDir: "/tmp/blobs", BaseURL: "http://localhost:8080"}
+
+	return cfg
+}
+
+func TestValidate(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		modify func(cfg *config.Config)
+		keys   []string
+	}{
+		{"valid", func(cfg *config.Config) {}, nil},
+		{"port", func(cfg *config.Config) { cfg.HTTP.Port = 70000 }, []string{"http.port"}},
+		{"unresolved secret", func(cfg *config.Config) { cfg.SigningKey = "secret://signing_key" }, []string{"signing_key"}},
+		{"azure blob", func(cfg *config.Config) { cfg.Blob = config.Blob{Backend: "azure"} }, []string{"blob.endpoint", "blob.container", "blob.account_name", "blob.account_key"}},
+		{"blob backend", func(cfg *config.Config) { cfg.Blob.Backend = "s3" }, []string{"blob.backend"}},
+		{"file secrets", func(cfg *config.Config) { cfg.Secrets.Provider = "file" }, []string{"secrets.path"}},
+		{"vault secrets", func(cfg *config.Config) { cfg.Secrets.Provider = "azure" }, []string{"secrets.vault_uri"}},
+		{"log", func(cfg *config.Config) { cfg.Log = config.Log{Format: "xml", Level: "loud"} }, []string{"log.format", "log.level", "log.sample_rate"}},
+		{"otlp", func(cfg *config.Config) { cfg.Tracing = config.Tracing{Exporter: "otlp", SampleRatio: 2} }, []string{"tracing.endpoint", "tracing.sample_ratio"}},
+		{"collections", func(cfg *config.Config) {
+			cfg.Mongo.Collections = map[string]string{"posts": "items", "reports": "items"}
+		}, []string{"mongo.collections."}},
+		{"tenants", func(cfg *config.Config) {
+			cfg.Tenants = []config.Tenant{
+				{Name: "staging", Hosts: []string{"staging.example.com"}, Database: "staging"},
+				{Name: "staging", Hosts: []string{"Staging.example.com"}, Database: "visuai"},
+			}
+		}, []string{"tenants.1.name", "tenants.1.database", "tenants.1.hosts"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid()
+			tt.modify(cfg)
+
+			err := cfg.Validate()
+
+			if tt.keys == nil {
+				if err != nil {
+					t.Fatalf("Validate = %v, want nil", err)
+				}
+				return
+			}
+
+			var verr config.ValidationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("Validate = %v, want a ValidationError", err)
+			}
+
+			for _, key := range tt.keys {
+				if !strings.Contains(err.Error(), key) {
+					t.Errorf("Validate = %v, want a problem with %v", err, key)
+				}
+			}
+		})
+	}
+}
+
+type secrets map[string]string
+
+func (s secrets) GetSecret(ctx context.Context, name, version string) (*string, error) {
+
+	value, ok := s[name]
+	if !ok {
+		return nil, errors.New("secret not found")
+	}
+
+	return &value, nil
+}
+
+func TestResolveSecrets(t *testing.T) {
+
+	cfg := valid()
+	cfg.SigningKey = "secret://signing_key"
+	cfg.Blob.AccountKey = "secret://unused_with_local_blobs"
+
+	if err := cfg.ResolveSecrets(context.Background(), nil); !errors.Is(err, config.ErrNoSecretProvider) {
+		t.Errorf("ResolveSecrets without a provider = %v, want ErrNoSecretProvider", err)
+	}
+
+	if err := cfg.ResolveSecrets(context.Background(), secrets{}); err == nil || !strings.Contains(err.Error(), "signing_key") {
+		t.Errorf("ResolveSecrets of a missing secret = %v, want an error naming it", err)
+	}
+
+	if err := cfg.ResolveSecrets(context.Background(), secrets{"signing_key": "resolved"}); err != nil {
+		t.Fatalf("ResolveSecrets: %v", err)
+	}
+
+	if cfg.SigningKey != "resolved" || cfg.Mongo.URI != "mongodb://localhost:27017" {
+		t.Errorf("signing key, mongo uri = %v, %v, want the secret and the literal uri", cfg.SigningKey, cfg.Mongo.URI)
+	}
+
+	if refs := config.Default().SecretRefs(); strings.Join(refs, ",") != "account_name,blob_azr_key,blob_container,blob_endpoint,mongo_uri,signing_key" {
+		t.Errorf("SecretRefs of the defaults = %v", refs)
+	}
+}
+
+func TestRedacted(t *testing.T) {
+
+	cfg := valid()
+	cfg.Blob.AccountKey = "account key"
+	cfg.Mongo.URI = "secret://mongo_uri"
+
+	redacted := cfg.Redacted()
+
+	for name, got := range map[string]string{
+		"signing_key":      redacted.SigningKey,
+		"blob.account_key": redacted.Blob.AccountKey,
+	} {
+		if got != "[redacted]" {
+			t.Errorf("%v = %q, want it redacted", name, got)
+		}
+	}
+
+	if redacted.Mongo.URI != "secret://mongo_uri" || redacted.Blob.LocalDir != "/tmp/blobs" {
+		t.Errorf("mongo uri, local dir = %v, %v, want the reference and the setting shown", redacted.Mongo.URI, redacted.Blob.LocalDir)
+	}
+
+	if cfg.SigningKey != "signing key" || cfg.Blob.AccountKey != "account key" {
+		t.Errorf("Redacted changed the configuration it copies")
+	}
+}
@@ -3,31 +3,79 @@ package data
 import (
 	"context"
 	"errors"
+	"net/url"
 	"time"
 
+	"github.com/evansopilo/visuai/pkg/validator"
+
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 var (
-	ErrNoDocument     = errors.New("error no document")
-	ErrCreateDocument = errors.New("error create document")
-	ErrUpdateDocument = errors.New("error update document")
-	ErrDeleteDocument = errors.New("error delete document")
+	ErrNoDocument     error = &Error{Kind: ErrNotFound, Code: "not_found", Message: "error no document"}
+	ErrCreateDocument       = errors.New("error create document")
+	ErrUpdateDocument       = errors.New("error update document")
+	ErrDeleteDocument       = errors.New("error delete document")
+	ErrEditConflict   error = NewConflict("edit_conflict", "the document changed while it was being edited, please try again")
 )
 
 type Post struct {
-	ID        string    `json:"id,omitempty" bson:"_id,omitempty"`
-	UserID    string    `json:"user_id,omitempty" bson:"user_id,omitempty"`
-	Title     string    `json:"title,omitempty" bson:"title,omitempty"`
-	Desc      string    `json:"desc,omitempty" bson:"desc,omitempty"`
-	PhotoURL  string    `json:"photo_url,omitempty" bson:"photo_url,omitempty"`
-	DestURL   string    `json:"dest_url,omitempty" bson:"dest_url,omitempty"`
-	Category  string    `json:"category,omitempty" bson:"category,omitempty"`
-	GeoTag    GeoTag    `json:"geo_tag,omitempty" bson:"geo_tag,omitempty"`
-	Tags      []string  `json:"tags,omitempty" bson:"tags,omitempty"`
-	CreatedAt time.Time `json:"created_at,omitempty" bson:"created_at,omitempty"`
+	ID              string    `json:"id,omitempty" bson:"_id,omitempty"`
+	ExternalID      string    `json:"-" bson:"external_id,omitempty"`
+	UserID          string    `json:"user_id,omitempty" bson:"user_id,omitempty"`
+	Title           string    `json:"title,omitempty" bson:"title,omitempty"`
+	Desc            string    `json:"desc,omitempty" bson:"desc,omitempty"`
+	PhotoURL        string    `json:"photo_url,omitempty" bson:"photo_url,omitempty"`
+	PhotoKey        string    `json:"-" bson:"photo_key,omitempty"`
+	Media           []Media   `json:"media,omitempty" bson:"media,omitempty"`
+	DestURL         string    `json:"dest_url,omitempty" bson:"dest_url,omitempty"`
+	Category        string    `json:"category,omitempty" bson:"category,omitempty"`
+	GeoTag          GeoTag    `json:"geo_tag,omitempty" bson:"geo_tag,omitempty"`
+	Tags            []string  `json:"tags,omitempty" bson:"tags,omitempty"`
+	PHash           string    `json:"phash,omitempty" bson:"phash,omitempty"`
+	PHashBands      []string  `json:"-" bson:"phash_bands,omitempty"`
+	ModerationState string    `json:"moderation_state,omitempty" bson:"moderation_state,omitempty"`
+	Visibility      string    `json:"visibility,omitempty" bson:"visibility,omitempty"`
+	CreatedAt       time.Time `json:"created_at,omitempty" bson:"created_at,omitempty"`
+}
+
+// Moderation states of a post, posts without a state or in the approved state are
+// listed publicly while all other states are hidden from list queries.
+const (
+	ModerationApproved = "approved"
+	ModerationPending  = "pending"
+	ModerationFlagged  = "flagged"
+	ModerationHidden   = "hidden"
+	ModerationRemoved  = "removed"
+)
+
+// Hidden reports whether the post is hidden from the public by moderation.
+func (p Post) Hidden() bool {
+	return p.ModerationState != "" && p.ModerationState != ModerationApproved
+}
+
+// ValidatePost checks the fields of a post a client can set.
+func ValidatePost(v *validator.Validator, post *Post) {
+	v.Check(len(post.Title) <= 200, "title", "must not be more than 200 bytes long")
+	v.Check(len(post.Desc) <= 5000, "desc", "must not be more than 5000 bytes long")
+	v.Check(len(post.Tags) <= 30, "tags", "must not contain more than 30 tags")
+	v.Check(ValidVisibility(post.Visibility), "visibility", "must be public, followers, unlisted or private")
+
+	if post.DestURL != "" {
+		u, err := url.Parse(post.DestURL)
+		v.Check(err == nil && validator.In(u.Scheme, "http", "https") && u.Host != "", "dest_url", "must be an absolute http or https url")
+	}
+
+	if post.GeoTag.Type != "" || len(post.GeoTag.Coordinates) > 0 {
+		v.Check(post.GeoTag.Type == "Point", "geo_tag", "must be a geojson point")
+		v.Check(len(post.GeoTag.Coordinates) == 2, "geo_tag", "must hold a longitude and a latitude")
+		if len(post.GeoTag.Coordinates) == 2 {
+			lng, lat := post.GeoTag.Coordinates[0], post.GeoTag.Coordinates[1]
+			v.Check(lng >= -180 && lng <= 180 && lat >= -90 && lat <= 90, "geo_tag", "must hold a longitude and a latitude in range")
+		}
+	}
 }
 
 type GeoTag struct {
@@ -36,21 +84,23 @@ type GeoTag struct {
 }
 
 type PostModel struct {
-	client *mongo.Client
+	namespace
 }
 
-func NewPostModel(client *mongo.Client) *PostModel { return &PostModel{client: client} }
+func NewPostModel(client *mongo.Client, database string) *PostModel {
+	return &PostModel{namespace{client: client, database: database}}
+}
 
 func (p PostModel) Create(ctx context.Context, post *Post) error {
 
-	coll := p.client.Database("visuai").Collection("posts")
+	coll := p.collection("posts")
 
 	result, err := coll.InsertOne(ctx, post)
 	if err != nil {
-		return err
+		return translate(err)
 	}
 
-	if result.InsertedID.(string) != post.ID {
+	if id, ok := result.InsertedID.(string); !ok || id != post.ID {
 		return ErrCreateDocument
 	}
 
@@ -59,12 +109,12 @@ func (p PostModel) Create(ctx context.Context, post *Post) error {
 
 func (p PostModel) GetByID(ctx context.Context, id string) (*Post, error) {
 
-	coll := p.client.Database("visuai").Collection("posts")
+	coll := p.collection("posts")
 
 	var post Post
 
-	if err := coll.FindOne(ctx, bson.M{"_id": id}).Decode(&post); err != nil {
-		return nil, err
+	if err := coll.FindOne(ctx, visibilityFilter(ctx, bson.M{"_id": id}, VisibilityUnlisted)).Decode(&post); err != nil {
+		return nil, translate(err)
 	}
 
 	return &post, nil
@@ -74,17 +124,62 @@ func (p PostModel) GetByUserID(ctx context.Context, id string, skip, limit int64
 
 	opts := options.Find().SetSkip(skip).SetLimit(limit)
 
-	coll := p.client.Database("visuai").Collection("posts")
+	coll := p.collection("posts")
+
+	filterCursor, err := coll.Find(ctx, listFilter(ctx, bson.M{"user_id": id}), opts)
+	if err != nil {
+		return nil, translate(err)
+	}
+
+	var posts []Post
+
+	if err := filterCursor.All(ctx, &posts); err != nil {
+		return nil, translate(err)
+	}
+
+	return &posts, nil
+}
+
+// GetAllByUserID returns every post of a user regardless of its visibility and
+// moderation state, ordered by id so it can be paged through, for maintenance such
+// as purging or exporting a user's posts.
+func (p PostModel) GetAllByUserID(ctx context.Context, id string, skip, limit int64) (*[]Post, error) {
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetSkip(skip).SetLimit(limit)
+
+	coll := p.collection("posts")
 
 	filterCursor, err := coll.Find(ctx, bson.M{"user_id": id}, opts)
 	if err != nil {
-		return nil, err
+		return nil, translate(err)
+	}
+
+	var posts []Post
+
+	if err := filterCursor.All(ctx, &posts); err != nil {
+		return nil, translate(err)
+	}
+
+	return &posts, nil
+}
+
+// Scan returns up to limit posts with ids after after in id order, regardless of
+// their visibility and moderation state, so maintenance can go through every post.
+func (p PostModel) Scan(ctx context.Context, after string, limit int64) (*[]Post, error) {
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(limit)
+
+	coll := p.collection("posts")
+
+	filterCursor, err := coll.Find(ctx, bson.M{"_id": bson.M{"$gt": after}}, opts)
+	if err != nil {
+		return nil, translate(err)
 	}
 
 	var posts []Post
 
 	if err := filterCursor.All(ctx, &posts); err != nil {
-		return nil, err
+		return nil, translate(err)
 	}
 
 	return &posts, nil
@@ -94,17 +189,17 @@ func (p PostModel) GetByCategory(ctx context.Context, category string, skip, lim
 
 	opts := options.Find().SetSkip(skip).SetLimit(limit)
 
-	coll := p.client.Database("visuai").Collection("posts")
+	coll := p.collection("posts")
 
-	filterCursor, err := coll.Find(ctx, bson.M{"category": category}, opts)
+	filterCursor, err := coll.Find(ctx, listFilter(ctx, bson.M{"category": category}), opts)
 	if err != nil {
-		return nil, err
+		return nil, translate(err)
 	}
 
 	var posts []Post
 
 	if err := filterCursor.All(ctx, &posts); err != nil {
-		return nil, err
+		return nil, translate(err)
 	}
 
 	return &posts, nil
@@ -114,17 +209,37 @@ func (p PostModel) GetByTags(ctx context.Context, tags []string, skip, limit int
 
 	opts := options.Find().SetSkip(skip).SetLimit(limit)
 
-	coll := p.client.Database("visuai").Collection("posts")
+	coll := p.collection("posts")
+
+	filterCursor, err := coll.Find(ctx, listFilter(ctx, bson.M{"tags": bson.M{"$all": tags}}), opts)
+	if err != nil {
+		return nil, translate(err)
+	}
+
+	var posts []Post
+
+	if err := filterCursor.All(ctx, &posts); err != nil {
+		return nil, translate(err)
+	}
+
+	return &posts, nil
+}
+
+func (p PostModel) GetByPHashBands(ctx context.Context, bands []string, skip, limit int64) (*[]Post, error) {
+
+	opts := options.Find().SetSkip(skip).SetLimit(limit)
+
+	coll := p.collection("posts")
 
-	filterCursor, err := coll.Find(ctx, bson.M{"tags": bson.M{"$all": tags}}, opts)
+	filterCursor, err := coll.Find(ctx, listFilter(ctx, bson.M{"phash_bands": bson.M{"$in": bands}}), opts)
 	if err != nil {
-		return nil, err
+		return nil, translate(err)
 	}
 
 	var posts []Post
 
 	if err := filterCursor.All(ctx, &posts); err != nil {
-		return nil, err
+		return nil, translate(err)
 	}
 
 	return &posts, nil
@@ -134,29 +249,45 @@ func (p PostModel) Get(ctx context.Context, skip, limit int64) (*[]Post, error)
 
 	opts := options.Find().SetSkip(skip).SetLimit(limit)
 
-	coll := p.client.Database("visuai").Collection("posts")
+	coll := p.collection("posts")
 
-	filterCursor, err := coll.Find(ctx, bson.M{}, opts)
+	filterCursor, err := coll.Find(ctx, listFilter(ctx, bson.M{}), opts)
 	if err != nil {
-		return nil, err
+		return nil, translate(err)
 	}
 
 	var posts []Post
 
 	if err := filterCursor.All(ctx, &posts); err != nil {
-		return nil, err
+		return nil, translate(err)
 	}
 
 	return &posts, nil
 }
 
+func (p PostModel) SetModerationState(ctx context.Context, id, state string) error {
+
+	coll := p.collection("posts")
+
+	result, err := coll.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"moderation_state": state}})
+	if err != nil {
+		return translate(err)
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrNoDocument
+	}
+
+	return nil
+}
+
 func (p PostModel) UpdateByID(ctx context.Context, id string, post *Post) error {
 
-	coll := p.client.Database("visuai").Collection("posts")
+	coll := p.collection("posts")
 
-	result, err := coll.UpdateOne(context.TODO(), bson.M{"_id": id}, bson.D{{Key: "$set", Value: post}})
+	result, err := coll.UpdateOne(ctx, bson.M{"_id": id}, bson.D{{Key: "$set", Value: post}})
 	if err != nil {
-		return err
+		return translate(err)
 	}
 
 	if result.MatchedCount == 0 {
@@ -168,11 +299,11 @@ func (p PostModel) UpdateByID(ctx context.Context, id string, post *Post) error
 
 func (p PostModel) DeleteByID(ctx context.Context, id string) error {
 
-	coll := p.client.Database("visuai").Collection("posts")
+	coll := p.collection("posts")
 
 	result, err := coll.DeleteOne(ctx, bson.M{"_id": id})
 	if err != nil {
-		return err
+		return translate(err)
 	}
 
 	if result.DeletedCount == 0 {
@@ -182,18 +313,30 @@ func (p PostModel) DeleteByID(ctx context.Context, id string) error {
 	return nil
 }
 
-func (p PostModel) DeleteByUserID(ctx context.Context, id string) error {
+// DeleteByUserID deletes every post of a user and returns how many were deleted, it
+// fails with ErrNoDocument when the user has no posts.
+func (p PostModel) DeleteByUserID(ctx context.Context, id string) (int64, error) {
 
-	coll := p.client.Database("visuai").Collection("posts")
+	coll := p.collection("posts")
 
 	result, err := coll.DeleteMany(ctx, bson.M{"user_id": id})
 	if err != nil {
-		return err
+		return 0, translate(err)
 	}
 
 	if result.DeletedCount == 0 {
-		return ErrNoDocument
+		return 0, ErrNoDocument
 	}
 
-	return nil
+	return result.DeletedCount, nil
+}
+
+// listFilter restricts filter to the posts the viewer in ctx can see and that are
+// not hidden by moderation, moderator viewers see the posts hidden by moderation.
+func listFilter(ctx context.Context, filter bson.M) bson.M {
+	filter = visibilityFilter(ctx, filter)
+	if !ViewerFromContext(ctx).Moderator {
+		filter["moderation_state"] = bson.M{"$nin": bson.A{ModerationPending, ModerationFlagged, ModerationHidden, ModerationRemoved}}
+	}
+	return filter
 }
@@ -0,0 +1,193 @@
+package data_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/evansopilo/visuai/pkg/data"
+	"github.com/evansopilo/visuai/pkg/data/datatest"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TestPostModel runs the conformance tests against the mongodb of mongo_test_uri,
+// every test uses a database of its own that is dropped when it ends.
+func TestPostModel(t *testing.T) {
+
+	client := testClient(t)
+
+	datatest.TestPostStore(t, func(t *testing.T) data.PostStore {
+		return data.NewPostModel(client, testDatabase(t, client))
+	})
+}
+
+func TestNewModels(t *testing.T) {
+
+	client := testClient(t)
+	database := testDatabase(t, client)
+	ctx := context.Background()
+
+	models := data.NewModels(client, database, data.Collections{"posts": "brand_posts"})
+
+	if err := models.Post.Create(ctx, &data.Post{ID: "p1"}); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	count, err := client.Database(database).Collection("brand_posts").CountDocuments(ctx, bson.M{})
+	if err != nil || count != 1 {
+		t.Errorf("posts in brand_posts = %v, %v, want 1", count, err)
+	}
+}
+
+func TestSchema(t *testing.T) {
+
+	client := testClient(t)
+	database := testDatabase(t, client)
+	ctx := context.Background()
+
+	legacy := "https://example.blob.core.windows.net/photos/20220101-0f8fad5b-d9cb-469f-a165-70867728950e.jpg"
+
+	posts := client.Database(database).Collection("posts")
+
+	if _, err := posts.InsertMany(ctx, []interface{}{
+		bson.M{"_id": "legacy", "photo_url": legacy},
+		bson.M{"_id": "external", "photo_url": "https://example.com/photo.jpg"},
+	}); err != nil {
+		t.Fatalf("insert posts: %v", err)
+	}
+
+	reports := client.Database(database).Collection("reports")
+
+	if _, err := reports.InsertMany(ctx, []interface{}{
+		bson.M{"_id": "r1", "post_id": "legacy", "reporter_id": "bob", "status": data.ReportOpen, "created_at": time.Now()},
+		bson.M{"_id": "r2", "post_id": "legacy", "reporter_id": "bob", "status": data.ReportOpen, "created_at": time.Now().Add(time.Second)},
+		bson.M{"_id": "r3", "post_id": "legacy", "source": data.ReportSourceClassifier, "status": data.ReportOpen},
+		bson.M{"_id": "r4", "post_id": "legacy", "source": data.ReportSourceClassifier, "status": data.ReportOpen},
+	}); err != nil {
+		t.Fatalf("insert reports: %v", err)
+	}
+
+	schema := data.NewSchema(client, database, nil)
+
+	pending, err := schema.Migrate(ctx, true)
+	if err != nil {
+		t.Fatalf("Migrate dry run: %v", err)
+	}
+
+	for _, change := range pending {
+		if change.Status != data.SchemaPending {
+			t.Errorf("dry run change = %+v, want pending", change)
+		}
+	}
+
+	// instances migrating at the same time apply every migration once.
+	var wg sync.WaitGroup
+
+	errs := make([]error, 3)
+
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = schema.Migrate(ctx, false)
+		}(i)
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			t.Fatalf("Migrate: %v", err)
+		}
+	}
+
+	applied, err := client.Database(database).Collection("migrations").CountDocuments(ctx, bson.M{})
+	if err != nil || applied != int64(len(pending)) {
+		t.Errorf("applied migrations = %v, %v, want %v", applied, err, len(pending))
+	}
+
+	post, err := data.NewPostModel(client, database).GetByID(data.ContextWithViewer(ctx, data.Viewer{Moderator: true}), "legacy")
+	if err != nil {
+		t.Fatalf("GetByID: %v", err)
+	}
+
+	if want := "20220101-0f8fad5b-d9cb-469f-a165-70867728950e.jpg"; post.PhotoKey != want {
+		t.Errorf("PhotoKey = %q, want %q", post.PhotoKey, want)
+	}
+
+	if post, _ := data.NewPostModel(client, database).GetByID(ctx, "external"); post == nil || post.PhotoKey != "" {
+		t.Errorf("post with an external photo url = %+v, want no photo key", post)
+	}
+
+	// the duplicate open reports of a user are resolved, the first is kept open.
+	if open, err := reports.CountDocuments(ctx, bson.M{"status": data.ReportOpen}); err != nil || open != 3 {
+		t.Errorf("open reports = %v, %v, want 3", open, err)
+	}
+
+	if reporters, err := data.NewReportModel(client, database).CountOpenReporters(ctx, "legacy"); err != nil || reporters != 1 {
+		t.Errorf("CountOpenReporters = %v, %v, want 1", reporters, err)
+	}
+
+	created, err := schema.CreateIndexes(ctx, false)
+	if err != nil {
+		t.Fatalf("CreateIndexes: %v", err)
+	}
+
+	if err := data.NewReportModel(client, database).Create(ctx, &data.Report{PostID: "legacy", ReporterID: "bob"}); !errors.Is(err, data.ErrConflict) {
+		t.Errorf("Create of a second open report of a user = %v, want a conflict", err)
+	}
+
+	existing, err := schema.CreateIndexes(ctx, true)
+	if err != nil {
+		t.Fatalf("CreateIndexes dry run: %v", err)
+	}
+
+	for i, change := range existing {
+		if created[i].Status != data.SchemaCreated || change.Status != data.SchemaExists {
+			t.Errorf("index %v = %v then %v, want created then exists", change.Name, created[i].Status, change.Status)
+		}
+	}
+}
+
+// testClient connects to the mongodb of mongo_test_uri, it skips the test when it
+// is not set.
+func testClient(t *testing.T) *mongo.Client {
+
+	uri := os.Getenv("mongo_test_uri")
+	if uri == "" {
+		t.Skip("set mongo_test_uri to run the tests against mongodb")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("connect to mongodb: %v", err)
+	}
+
+	t.Cleanup(func() { client.Disconnect(context.Background()) })
+
+	return client
+}
+
+// testDatabase returns the name of a database of the test's own, which is dropped
+// when the test ends.
+func testDatabase(t *testing.T, client *mongo.Client) string {
+
+	database := fmt.Sprintf("visuai_test_%d", time.Now().UnixNano())
+
+	t.Cleanup(func() {
+		if err := client.Database(database).Drop(context.Background()); err != nil {
+			t.Logf("drop database %v: %v", database, err)
+		}
+	})
+
+	return database
+}
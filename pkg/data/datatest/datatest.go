@@ -0,0 +1,747 @@
+// Package datatest holds the conformance tests every implementation of the stores
+// of package data must pass, so they can be used in place of one another.
+package datatest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/evansopilo/visuai/pkg/data"
+)
+
+// TestPostStore runs the conformance tests of data.PostStore against the stores
+// returned by newStore, which is called for every test and must return an empty
+// store.
+func TestPostStore(t *testing.T, newStore func(t *testing.T) data.PostStore) {
+
+	tests := []struct {
+		name string
+		test func(t *testing.T, store data.PostStore)
+	}{
+		{"CreateAndGetByID", testCreateAndGetByID},
+		{"CreateDuplicate", testCreateDuplicate},
+		{"GetByIDNotFound", testGetByIDNotFound},
+		{"GetReturnsCopies", testGetReturnsCopies},
+		{"Pagination", testPagination},
+		{"GetByUserID", testGetByUserID},
+		{"GetAllByUserID", testGetAllByUserID},
+		{"Scan", testScan},
+		{"GetByCategory", testGetByCategory},
+		{"GetByTags", testGetByTags},
+		{"GetByPHashBands", testGetByPHashBands},
+		{"Visibility", testVisibility},
+		{"ModerationState", testModerationState},
+		{"AppendMedia", testAppendMedia},
+		{"ReorderMedia", testReorderMedia},
+		{"SetHashes", testSetHashes},
+		{"RemoveMedia", testRemoveMedia},
+		{"UpdateByID", testUpdateByID},
+		{"DeleteByID", testDeleteByID},
+		{"DeleteByUserID", testDeleteByUserID},
+		{"ConcurrentWrites", testConcurrentWrites},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newStore(t))
+		})
+	}
+}
+
+func testCreateAndGetByID(t *testing.T, store data.PostStore) {
+
+	ctx := context.Background()
+
+	post := data.Post{
+		ID:         "post-1",
+		UserID:     "user-1",
+		Title:      "title",
+		Desc:       "desc",
+		DestURL:    "https://example.com",
+		Category:   "travel",
+		GeoTag:     data.GeoTag{Type: "Point", Coordinates: []float64{36.8, -1.3}},
+		Tags:       []string{"a", "b"},
+		Visibility: data.VisibilityPublic,
+		Media:      []data.Media{{ID: "media-1", Key: "key-1", URL: "https://example.com/key-1"}},
+		CreatedAt:  time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+
+	if err := store.Create(ctx, &post); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	got, err := store.GetByID(ctx, post.ID)
+	if err != nil {
+		t.Fatalf("GetByID: %v", err)
+	}
+
+	if got.ID != post.ID || got.UserID != post.UserID || got.Title != post.Title || got.Desc != post.Desc ||
+		got.DestURL != post.DestURL || got.Category != post.Category || got.Visibility != post.Visibility {
+		t.Errorf("GetByID = %+v, want %+v", got, post)
+	}
+
+	if !got.CreatedAt.Equal(post.CreatedAt) {
+		t.Errorf("CreatedAt = %v, want %v", got.CreatedAt, post.CreatedAt)
+	}
+
+	if fmt.Sprint(got.Tags) != fmt.Sprint(post.Tags) || fmt.Sprint(got.GeoTag) != fmt.Sprint(post.GeoTag) {
+		t.Errorf("Tags, GeoTag = %v, %v, want %v, %v", got.Tags, got.GeoTag, post.Tags, post.GeoTag)
+	}
+
+	if len(got.Media) != 1 || got.Media[0].Key != "key-1" {
+		t.Fatalf("Media = %+v, want the created item", got.Media)
+	}
+
+	if got.Media[0].URL != "" {
+		t.Errorf("Media URL = %q, want urls not to be stored", got.Media[0].URL)
+	}
+}
+
+func testCreateDuplicate(t *testing.T, store data.PostStore) {
+
+	create(t, store, data.Post{ID: "post-1", UserID: "user-1"})
+
+	err := store.Create(context.Background(), &data.Post{ID: "post-1", UserID: "user-2"})
+	if !errors.Is(err, data.ErrConflict) {
+		t.Fatalf("Create duplicate = %v, want %v", err, data.ErrConflict)
+	}
+}
+
+func testGetByIDNotFound(t *testing.T, store data.PostStore) {
+
+	_, err := store.GetByID(context.Background(), "missing")
+	if !errors.Is(err, data.ErrNoDocument) || !errors.Is(err, data.ErrNotFound) {
+		t.Fatalf("GetByID = %v, want %v", err, data.ErrNoDocument)
+	}
+}
+
+func testGetReturnsCopies(t *testing.T, store data.PostStore) {
+
+	ctx := context.Background()
+
+	create(t, store, data.Post{ID: "post-1", Title: "title", Tags: []string{"a"}})
+
+	got, err := store.GetByID(ctx, "post-1")
+	if err != nil {
+		t.Fatalf("GetByID: %v", err)
+	}
+
+	got.Title, got.Tags[0] = "changed", "changed"
+
+	got, err = store.GetByID(ctx, "post-1")
+	if err != nil {
+		t.Fatalf("GetByID: %v", err)
+	}
+
+	if got.Title != "title" || got.Tags[0] != "a" {
+		t.Errorf("GetByID = %+v, want changes to a returned post not to be stored", got)
+	}
+}
+
+func testPagination(t *testing.T, store data.PostStore) {
+
+	for i := 0; i < 5; i++ {
+		create(t, store, data.Post{ID: fmt.Sprintf("post-%d", i)})
+	}
+
+	var ids []string
+
+	for _, want := range []int{2, 2, 1, 0} {
+		posts, err := store.Get(context.Background(), int64(len(ids)), 2)
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		if len(*posts) != want {
+			t.Fatalf("Get(skip %d, limit 2) returned %d posts, want %d", len(ids), len(*posts), want)
+		}
+		ids = append(ids, postIDs(*posts)...)
+	}
+
+	assertIDs(t, "paged Get", ids, "post-0", "post-1", "post-2", "post-3", "post-4")
+
+	posts, err := store.Get(context.Background(), 0, 0)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	if len(*posts) != 5 {
+		t.Errorf("Get(limit 0) returned %d posts, want every post", len(*posts))
+	}
+}
+
+func testGetByUserID(t *testing.T, store data.PostStore) {
+
+	create(t, store,
+		data.Post{ID: "post-1", UserID: "user-1"},
+		data.Post{ID: "post-2", UserID: "user-2"},
+		data.Post{ID: "post-3", UserID: "user-1"},
+	)
+
+	posts, err := store.GetByUserID(context.Background(), "user-1", 0, 10)
+	if err != nil {
+		t.Fatalf("GetByUserID: %v", err)
+	}
+
+	assertIDs(t, "GetByUserID", postIDs(*posts), "post-1", "post-3")
+}
+
+func testGetAllByUserID(t *testing.T, store data.PostStore) {
+
+	create(t, store,
+		data.Post{ID: "post-3", UserID: "user-1", Visibility: data.VisibilityPrivate},
+		data.Post{ID: "post-1", UserID: "user-1", ModerationState: data.ModerationRemoved},
+		data.Post{ID: "post-2", UserID: "user-2"},
+		data.Post{ID: "post-4", UserID: "user-1"},
+	)
+
+	var ids []string
+
+	for _, want := range []string{"[post-1 post-3]", "[post-4]"} {
+		posts, err := store.GetAllByUserID(context.Background(), "user-1", int64(len(ids)), 2)
+		if err != nil {
+			t.Fatalf("GetAllByUserID: %v", err)
+		}
+
+		if got := fmt.Sprint(postIDs(*posts)); got != want {
+			t.Fatalf("GetAllByUserID(skip %d, limit 2) = %v, want %v in id order regardless of visibility and moderation", len(ids), got, want)
+		}
+
+		ids = append(ids, postIDs(*posts)...)
+	}
+}
+
+func testScan(t *testing.T, store data.PostStore) {
+
+	create(t, store,
+		data.Post{ID: "post-3", Visibility: data.VisibilityPrivate},
+		data.Post{ID: "post-1", ModerationState: data.ModerationRemoved},
+		data.Post{ID: "post-2"},
+	)
+
+	var ids []string
+
+	after := ""
+
+	for {
+		posts, err := store.Scan(context.Background(), after, 2)
+		if err != nil {
+			t.Fatalf("Scan: %v", err)
+		}
+
+		if len(*posts) == 0 {
+			break
+		}
+
+		ids = append(ids, postIDs(*posts)...)
+		after = ids[len(ids)-1]
+	}
+
+	assertIDs(t, "Scan", ids, "post-1", "post-2", "post-3")
+}
+
+func testGetByCategory(t *testing.T, store data.PostStore) {
+
+	create(t, store,
+		data.Post{ID: "post-1", Category: "travel"},
+		data.Post{ID: "post-2", Category: "food"},
+	)
+
+	posts, err := store.GetByCategory(context.Background(), "food", 0, 10)
+	if err != nil {
+		t.Fatalf("GetByCategory: %v", err)
+	}
+
+	assertIDs(t, "GetByCategory", postIDs(*posts), "post-2")
+}
+
+func testGetByTags(t *testing.T, store data.PostStore) {
+
+	create(t, store,
+		data.Post{ID: "post-1", Tags: []string{"x", "y"}},
+		data.Post{ID: "post-2", Tags: []string{"x"}},
+		data.Post{ID: "post-3", Tags: []string{"y", "z"}},
+	)
+
+	tests := []struct {
+		tags []string
+		want []string
+	}{
+		{[]string{"x", "y"}, []string{"post-1"}},
+		{[]string{"x"}, []string{"post-1", "post-2"}},
+		{[]string{"y"}, []string{"post-1", "post-3"}},
+		{[]string{"x", "z"}, nil},
+		{[]string{}, nil},
+	}
+
+	for _, tt := range tests {
+		posts, err := store.GetByTags(context.Background(), tt.tags, 0, 10)
+		if err != nil {
+			t.Fatalf("GetByTags(%v): %v", tt.tags, err)
+		}
+
+		assertIDs(t, fmt.Sprintf("GetByTags(%v)", tt.tags), postIDs(*posts), tt.want...)
+	}
+}
+
+func testGetByPHashBands(t *testing.T, store data.PostStore) {
+
+	ctx := context.Background()
+
+	create(t, store, data.Post{ID: "post-1"}, data.Post{ID: "post-2"})
+
+	if err := store.AppendMedia(ctx, "post-1", []string{"0:aa", "1:bb"}, data.Media{ID: "media-1"}); err != nil {
+		t.Fatalf("AppendMedia: %v", err)
+	}
+
+	if err := store.AppendMedia(ctx, "post-2", []string{"0:cc"}, data.Media{ID: "media-2"}); err != nil {
+		t.Fatalf("AppendMedia: %v", err)
+	}
+
+	posts, err := store.GetByPHashBands(ctx, []string{"1:bb", "2:dd"}, 0, 10)
+	if err != nil {
+		t.Fatalf("GetByPHashBands: %v", err)
+	}
+
+	assertIDs(t, "GetByPHashBands", postIDs(*posts), "post-1")
+}
+
+func testVisibility(t *testing.T, store data.PostStore) {
+
+	create(t, store,
+		data.Post{ID: "public", UserID: "owner"},
+		data.Post{ID: "followers", UserID: "owner", Visibility: data.VisibilityFollowers},
+		data.Post{ID: "unlisted", UserID: "owner", Visibility: data.VisibilityUnlisted},
+		data.Post{ID: "private", UserID: "owner", Visibility: data.VisibilityPrivate},
+	)
+
+	tests := []struct {
+		name    string
+		viewer  data.Viewer
+		byID    []string
+		listing []string
+	}{
+		{"anonymous", data.Viewer{}, []string{"public", "unlisted"}, []string{"public"}},
+		{"follower", data.Viewer{UserID: "follower", Following: []string{"owner"}}, []string{"public", "followers", "unlisted"}, []string{"public", "followers"}},
+		{"owner", data.Viewer{UserID: "owner"}, []string{"public", "followers", "unlisted", "private"}, []string{"public", "followers", "unlisted", "private"}},
+		{"moderator", data.Viewer{UserID: "moderator", Moderator: true}, []string{"public", "followers", "unlisted", "private"}, []string{"public", "followers", "unlisted", "private"}},
+	}
+
+	for _, tt := range tests {
+		ctx := data.ContextWithViewer(context.Background(), tt.viewer)
+
+		var byID []string
+		for _, id := range []string{"public", "followers", "unlisted", "private"} {
+			_, err := store.GetByID(ctx, id)
+			switch {
+			case err == nil:
+				byID = append(byID, id)
+			case !errors.Is(err, data.ErrNoDocument):
+				t.Fatalf("%v: GetByID(%v): %v", tt.name, id, err)
+			}
+		}
+
+		assertIDs(t, tt.name+": GetByID", byID, tt.byID...)
+
+		posts, err := store.GetByUserID(ctx, "owner", 0, 10)
+		if err != nil {
+			t.Fatalf("%v: GetByUserID: %v", tt.name, err)
+		}
+
+		assertIDs(t, tt.name+": GetByUserID", postIDs(*posts), tt.listing...)
+	}
+}
+
+func testModerationState(t *testing.T, store data.PostStore) {
+
+	ctx := context.Background()
+
+	create(t, store, data.Post{ID: "post-1"}, data.Post{ID: "post-2"}, data.Post{ID: "post-3"})
+
+	if err := store.SetModerationState(ctx, "post-1", data.ModerationFlagged); err != nil {
+		t.Fatalf("SetModerationState: %v", err)
+	}
+
+	if err := store.SetModerationState(ctx, "post-2", data.ModerationApproved); err != nil {
+		t.Fatalf("SetModerationState: %v", err)
+	}
+
+	posts, err := store.Get(ctx, 0, 10)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	assertIDs(t, "Get", postIDs(*posts), "post-2", "post-3")
+
+	posts, err = store.Get(data.ContextWithViewer(ctx, data.Viewer{Moderator: true}), 0, 10)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	assertIDs(t, "Get as a moderator", postIDs(*posts), "post-1", "post-2", "post-3")
+
+	post, err := store.GetByID(ctx, "post-1")
+	if err != nil {
+		t.Fatalf("GetByID of a flagged post: %v", err)
+	}
+
+	if post.ModerationState != data.ModerationFlagged {
+		t.Errorf("ModerationState = %q, want %q", post.ModerationState, data.ModerationFlagged)
+	}
+
+	if err := store.SetModerationState(ctx, "missing", data.ModerationHidden); !errors.Is(err, data.ErrNoDocument) {
+		t.Errorf("SetModerationState of a missing post = %v, want %v", err, data.ErrNoDocument)
+	}
+}
+
+func testAppendMedia(t *testing.T, store data.PostStore) {
+
+	ctx := context.Background()
+
+	create(t, store, data.Post{ID: "post-1"})
+
+	if err := store.AppendMedia(ctx, "post-1", []string{"0:aa"}, data.Media{ID: "media-1"}); err != nil {
+		t.Fatalf("AppendMedia: %v", err)
+	}
+
+	if err := store.AppendMedia(ctx, "post-1", []string{"0:aa", "1:bb"}, data.Media{ID: "media-2"}, data.Media{ID: "media-3"}); err != nil {
+		t.Fatalf("AppendMedia: %v", err)
+	}
+
+	post, err := store.GetByID(ctx, "post-1")
+	if err != nil {
+		t.Fatalf("GetByID: %v", err)
+	}
+
+	if got := mediaIDs(post.Media); fmt.Sprint(got) != "[media-1 media-2 media-3]" {
+		t.Errorf("Media = %v, want the items in the order they were appended", got)
+	}
+
+	assertIDs(t, "PHashBands", post.PHashBands, "0:aa", "1:bb")
+
+	if err := store.AppendMedia(ctx, "missing", nil, data.Media{ID: "media-4"}); !errors.Is(err, data.ErrNoDocument) {
+		t.Errorf("AppendMedia to a missing post = %v, want %v", err, data.ErrNoDocument)
+	}
+}
+
+func testReorderMedia(t *testing.T, store data.PostStore) {
+
+	ctx := context.Background()
+
+	create(t, store, data.Post{ID: "post-1", Media: []data.Media{{ID: "a"}, {ID: "b"}, {ID: "c"}}})
+
+	if err := store.ReorderMedia(ctx, "post-1", []data.Media{{ID: "c"}, {ID: "a"}, {ID: "b"}}); err != nil {
+		t.Fatalf("ReorderMedia: %v", err)
+	}
+
+	post, err := store.GetByID(ctx, "post-1")
+	if err != nil {
+		t.Fatalf("GetByID: %v", err)
+	}
+
+	if got := mediaIDs(post.Media); fmt.Sprint(got) != "[c a b]" {
+		t.Errorf("Media = %v, want [c a b]", got)
+	}
+
+	for _, media := range [][]data.Media{
+		{{ID: "c"}, {ID: "a"}},
+		{{ID: "c"}, {ID: "a"}, {ID: "d"}},
+	} {
+		if err := store.ReorderMedia(ctx, "post-1", media); !errors.Is(err, data.ErrEditConflict) {
+			t.Errorf("ReorderMedia(%v) = %v, want %v", mediaIDs(media), err, data.ErrEditConflict)
+		}
+	}
+
+	if err := store.ReorderMedia(ctx, "missing", []data.Media{{ID: "a"}}); !errors.Is(err, data.ErrEditConflict) {
+		t.Errorf("ReorderMedia of a missing post = %v, want %v", err, data.ErrEditConflict)
+	}
+}
+
+func testSetHashes(t *testing.T, store data.PostStore) {
+
+	ctx := context.Background()
+
+	create(t, store, data.Post{ID: "post-1", GeoTag: data.GeoTag{Type: "Point", Coordinates: []float64{1, 2}}, Media: []data.Media{{ID: "a"}, {ID: "b"}}})
+
+	media := []data.Media{{ID: "a", PHash: "1"}, {ID: "b", PHash: "2"}}
+
+	if err := store.SetHashes(ctx, "post-1", media, "1", []string{"x", "y"}); err != nil {
+		t.Fatalf("SetHashes: %v", err)
+	}
+
+	post, err := store.GetByID(ctx, "post-1")
+	if err != nil {
+		t.Fatalf("GetByID: %v", err)
+	}
+
+	if post.Media[0].PHash != "1" || post.Media[1].PHash != "2" || post.PHash != "1" || fmt.Sprint(post.PHashBands) != "[x y]" {
+		t.Errorf("post = %+v, want the hashes set", post)
+	}
+
+	if len(post.GeoTag.Coordinates) != 2 {
+		t.Errorf("GeoTag = %+v, want it kept", post.GeoTag)
+	}
+
+	if err := store.SetHashes(ctx, "post-1", media[:1], "1", nil); !errors.Is(err, data.ErrEditConflict) {
+		t.Errorf("SetHashes of fewer items = %v, want %v", err, data.ErrEditConflict)
+	}
+
+	if err := store.SetHashes(ctx, "missing", nil, "", nil); !errors.Is(err, data.ErrEditConflict) {
+		t.Errorf("SetHashes of a missing post = %v, want %v", err, data.ErrEditConflict)
+	}
+}
+
+func testRemoveMedia(t *testing.T, store data.PostStore) {
+
+	ctx := context.Background()
+
+	create(t, store, data.Post{ID: "post-1", Media: []data.Media{{ID: "a"}, {ID: "b"}}, PHashBands: []string{"0:a", "1:b"}})
+
+	post, err := store.GetByID(ctx, "post-1")
+	if err != nil {
+		t.Fatalf("GetByID: %v", err)
+	}
+
+	if err := store.RemoveMedia(ctx, post, "a", []string{"1:b"}); err != nil {
+		t.Fatalf("RemoveMedia: %v", err)
+	}
+
+	if post, err = store.GetByID(ctx, "post-1"); err != nil {
+		t.Fatalf("GetByID: %v", err)
+	}
+
+	if got := mediaIDs(post.Media); fmt.Sprint(got) != "[b]" || fmt.Sprint(post.PHashBands) != "[1:b]" {
+		t.Errorf("Media = %v, PHashBands = %v, want [b] and [1:b]", got, post.PHashBands)
+	}
+
+	if err := store.RemoveMedia(ctx, post, "a", nil); !errors.Is(err, data.ErrNoDocument) {
+		t.Errorf("RemoveMedia of a missing item = %v, want %v", err, data.ErrNoDocument)
+	}
+
+	stale := &data.Post{ID: "post-1", Media: []data.Media{{ID: "a"}, {ID: "b"}}}
+
+	if err := store.RemoveMedia(ctx, stale, "b", nil); !errors.Is(err, data.ErrEditConflict) {
+		t.Errorf("RemoveMedia of a changed post = %v, want %v", err, data.ErrEditConflict)
+	}
+
+	if err := store.RemoveMedia(ctx, &data.Post{ID: "missing", Media: []data.Media{{ID: "b"}}}, "b", nil); !errors.Is(err, data.ErrEditConflict) {
+		t.Errorf("RemoveMedia of a missing post = %v, want %v", err, data.ErrEditConflict)
+	}
+
+	// the cover of a post uploaded before multi-photo posts is its photo as well.
+	create(t, store, data.Post{
+		ID:       "legacy",
+		PhotoURL: "https://example.com/photo.jpg",
+		PhotoKey: "photo.jpg",
+		PHash:    "00000000000000ff",
+		Media:    []data.Media{{ID: "cover", Key: "photo.jpg"}, {ID: "c", Key: "c.jpg"}},
+	})
+
+	legacy, err := store.GetByID(ctx, "legacy")
+	if err != nil {
+		t.Fatalf("GetByID: %v", err)
+	}
+
+	if err := store.RemoveMedia(ctx, legacy, "cover", nil); err != nil {
+		t.Fatalf("RemoveMedia of the cover: %v", err)
+	}
+
+	if legacy, err = store.GetByID(ctx, "legacy"); err != nil {
+		t.Fatalf("GetByID: %v", err)
+	}
+
+	if legacy.PhotoKey != "" || legacy.PhotoURL != "" || legacy.PHash != "" || fmt.Sprint(mediaIDs(legacy.Media)) != "[c]" || len(legacy.PHashBands) != 0 {
+		t.Errorf("post = %+v, want its photo removed along with its cover", legacy)
+	}
+}
+
+func testUpdateByID(t *testing.T, store data.PostStore) {
+
+	ctx := context.Background()
+
+	create(t, store, data.Post{ID: "post-1", UserID: "user-1", Title: "title", Desc: "desc", Tags: []string{"a"}})
+
+	if err := store.UpdateByID(ctx, "post-1", &data.Post{Title: "new title", Tags: []string{"b", "c"}}); err != nil {
+		t.Fatalf("UpdateByID: %v", err)
+	}
+
+	post, err := store.GetByID(ctx, "post-1")
+	if err != nil {
+		t.Fatalf("GetByID: %v", err)
+	}
+
+	if post.Title != "new title" || fmt.Sprint(post.Tags) != "[b c]" {
+		t.Errorf("UpdateByID set Title, Tags = %q, %v, want %q, [b c]", post.Title, post.Tags, "new title")
+	}
+
+	if post.UserID != "user-1" || post.Desc != "desc" {
+		t.Errorf("UpdateByID changed UserID, Desc to %q, %q, want empty fields to be left as they are", post.UserID, post.Desc)
+	}
+
+	if err := store.UpdateByID(ctx, "missing", &data.Post{Title: "title"}); !errors.Is(err, data.ErrNoDocument) {
+		t.Errorf("UpdateByID of a missing post = %v, want %v", err, data.ErrNoDocument)
+	}
+
+	// the update runs with the context of the caller.
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	if err := store.UpdateByID(cancelled, "post-1", &data.Post{Title: "cancelled"}); err == nil {
+		t.Errorf("UpdateByID with a cancelled context = nil, want an error")
+	}
+
+	if post, err := store.GetByID(ctx, "post-1"); err != nil || post.Title != "new title" {
+		t.Errorf("post after a cancelled update = %+v, %v, want it unchanged", post, err)
+	}
+}
+
+func testDeleteByID(t *testing.T, store data.PostStore) {
+
+	ctx := context.Background()
+
+	create(t, store, data.Post{ID: "post-1"}, data.Post{ID: "post-2"})
+
+	if err := store.DeleteByID(ctx, "post-1"); err != nil {
+		t.Fatalf("DeleteByID: %v", err)
+	}
+
+	if _, err := store.GetByID(ctx, "post-1"); !errors.Is(err, data.ErrNoDocument) {
+		t.Errorf("GetByID of a deleted post = %v, want %v", err, data.ErrNoDocument)
+	}
+
+	if err := store.DeleteByID(ctx, "post-1"); !errors.Is(err, data.ErrNoDocument) {
+		t.Errorf("DeleteByID of a deleted post = %v, want %v", err, data.ErrNoDocument)
+	}
+
+	posts, err := store.Get(ctx, 0, 10)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	assertIDs(t, "Get", postIDs(*posts), "post-2")
+}
+
+func testDeleteByUserID(t *testing.T, store data.PostStore) {
+
+	ctx := context.Background()
+
+	create(t, store,
+		data.Post{ID: "post-1", UserID: "user-1"},
+		data.Post{ID: "post-2", UserID: "user-2"},
+		data.Post{ID: "post-3", UserID: "user-1", Visibility: data.VisibilityPrivate},
+	)
+
+	deleted, err := store.DeleteByUserID(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("DeleteByUserID: %v", err)
+	}
+
+	if deleted != 2 {
+		t.Errorf("DeleteByUserID deleted %d posts, want 2", deleted)
+	}
+
+	posts, err := store.Get(data.ContextWithViewer(ctx, data.Viewer{Moderator: true}), 0, 10)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	assertIDs(t, "Get", postIDs(*posts), "post-2")
+
+	if _, err := store.DeleteByUserID(ctx, "user-1"); !errors.Is(err, data.ErrNoDocument) {
+		t.Errorf("DeleteByUserID of a user without posts = %v, want %v", err, data.ErrNoDocument)
+	}
+}
+
+func testConcurrentWrites(t *testing.T, store data.PostStore) {
+
+	ctx := context.Background()
+
+	create(t, store, data.Post{ID: "post"})
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			if err := store.Create(ctx, &data.Post{ID: fmt.Sprintf("post-%d", i)}); err != nil {
+				t.Errorf("Create: %v", err)
+			}
+
+			if err := store.AppendMedia(ctx, "post", nil, data.Media{ID: fmt.Sprintf("media-%d", i)}); err != nil {
+				t.Errorf("AppendMedia: %v", err)
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	posts, err := store.Get(ctx, 0, 0)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	if len(*posts) != 21 {
+		t.Errorf("Get returned %d posts, want 21", len(*posts))
+	}
+
+	post, err := store.GetByID(ctx, "post")
+	if err != nil {
+		t.Fatalf("GetByID: %v", err)
+	}
+
+	if len(post.Media) != 20 {
+		t.Errorf("post holds %d media items, want 20", len(post.Media))
+	}
+}
+
+func create(t *testing.T, store data.PostStore, posts ...data.Post) {
+
+	t.Helper()
+
+	for i := range posts {
+		if err := store.Create(context.Background(), &posts[i]); err != nil {
+			t.Fatalf("Create(%v): %v", posts[i].ID, err)
+		}
+	}
+}
+
+func postIDs(posts []data.Post) []string {
+
+	ids := make([]string, 0, len(posts))
+	for _, post := range posts {
+		ids = append(ids, post.ID)
+	}
+
+	return ids
+}
+
+func mediaIDs(media []data.Media) []string {
+
+	ids := make([]string, 0, len(media))
+	for _, item := range media {
+		ids = append(ids, item.ID)
+	}
+
+	return ids
+}
+
+// assertIDs reports an error when got does not hold the ids of want, in any order.
+func assertIDs(t *testing.T, name string, got []string, want ...string) {
+
+	t.Helper()
+
+	got, want = append([]string(nil), got...), append([]string(nil), want...)
+
+	sort.Strings(got)
+	sort.Strings(want)
+
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("%v = %v, want %v", name, got, want)
+	}
+}
@@ -0,0 +1,98 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Kinds of the errors of the data layer, errors.Is reports whether an *Error is of
+// a kind.
+var (
+	ErrNotFound   = errors.New("not found")
+	ErrConflict   = errors.New("conflict")
+	ErrValidation = errors.New("validation failed")
+	ErrForbidden  = errors.New("forbidden")
+	// ErrTimeout and ErrUnavailable are the kinds of errors of a store that did not
+	// answer in time or could not be reached, the operation can be retried.
+	ErrTimeout     = errors.New("timeout")
+	ErrUnavailable = errors.New("unavailable")
+)
+
+// Error is an error of the data layer of a kind, with a stable code clients can
+// rely on. The Fields of validation errors hold the problem of each invalid field,
+// Err is the error of the store the error was translated from.
+type Error struct {
+	Kind    error
+	Code    string
+	Message string
+	Fields  map[string]string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error { return e.Kind }
+
+// NewNotFound returns the error of a resource with id that does not exist.
+func NewNotFound(resource, id string) *Error {
+	return &Error{
+		Kind:    ErrNotFound,
+		Code:    resource + "_not_found",
+		Message: fmt.Sprintf("%v with id: %v not found", resource, id),
+	}
+}
+
+func NewConflict(code, message string) *Error {
+	return &Error{Kind: ErrConflict, Code: code, Message: message}
+}
+
+// NewValidation returns the error of the fields that failed validation.
+func NewValidation(fields map[string]string) *Error {
+	return &Error{
+		Kind:    ErrValidation,
+		Code:    "validation_failed",
+		Message: "one or more fields are invalid",
+		Fields:  fields,
+	}
+}
+
+func NewForbidden(code, message string) *Error {
+	return &Error{Kind: ErrForbidden, Code: code, Message: message}
+}
+
+// Retryable reports whether err is a timeout or an unavailable store, so the
+// operation that failed with it can be tried again.
+func Retryable(err error) bool {
+	return errors.Is(err, ErrTimeout) || errors.Is(err, ErrUnavailable)
+}
+
+// translate turns the errors of the mongo driver into errors of the data layer, so
+// callers do not depend on the driver. Other errors are returned as they are.
+func translate(err error) error {
+
+	var labeled mongo.ServerError
+
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return ErrNoDocument
+	case mongo.IsDuplicateKeyError(err):
+		return &Error{Kind: ErrConflict, Code: "duplicate", Message: "the document already exists", Err: err}
+	case mongo.IsTimeout(err), errors.Is(err, context.DeadlineExceeded):
+		return &Error{Kind: ErrTimeout, Code: "timeout", Message: "the database did not answer in time", Err: err}
+	case mongo.IsNetworkError(err),
+		errors.As(err, &labeled) && (labeled.HasErrorLabel("RetryableWriteError") || labeled.HasErrorLabel("TransientTransactionError")):
+		return &Error{Kind: ErrUnavailable, Code: "unavailable", Message: "the database is unavailable", Err: err}
+	default:
+		return err
+	}
+}
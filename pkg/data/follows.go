@@ -0,0 +1,89 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type Follow struct {
+	ID         string    `json:"id,omitempty" bson:"_id,omitempty"`
+	FollowerID string    `json:"follower_id,omitempty" bson:"follower_id,omitempty"`
+	FolloweeID string    `json:"followee_id,omitempty" bson:"followee_id,omitempty"`
+	CreatedAt  time.Time `json:"created_at,omitempty" bson:"created_at,omitempty"`
+}
+
+type FollowModel struct {
+	namespace
+}
+
+func NewFollowModel(client *mongo.Client, database string) *FollowModel {
+	return &FollowModel{namespace{client: client, database: database}}
+}
+
+func followID(followerID, followeeID string) string { return followerID + ":" + followeeID }
+
+// Create records that followerID follows followeeID, following a user twice is a no-op.
+func (f FollowModel) Create(ctx context.Context, followerID, followeeID string) error {
+
+	coll := f.collection("follows")
+
+	follow := Follow{
+		ID:         followID(followerID, followeeID),
+		FollowerID: followerID,
+		FolloweeID: followeeID,
+		CreatedAt:  time.Now(),
+	}
+
+	opts := options.Update().SetUpsert(true)
+
+	_, err := coll.UpdateOne(ctx, bson.M{"_id": follow.ID}, bson.M{"$setOnInsert": follow}, opts)
+
+	return translate(err)
+}
+
+func (f FollowModel) Delete(ctx context.Context, followerID, followeeID string) error {
+
+	coll := f.collection("follows")
+
+	result, err := coll.DeleteOne(ctx, bson.M{"_id": followID(followerID, followeeID)})
+	if err != nil {
+		return translate(err)
+	}
+
+	if result.DeletedCount == 0 {
+		return ErrNoDocument
+	}
+
+	return nil
+}
+
+// GetFollowing returns the ids of the users followerID follows.
+func (f FollowModel) GetFollowing(ctx context.Context, followerID string) ([]string, error) {
+
+	coll := f.collection("follows")
+
+	opts := options.Find().SetProjection(bson.M{"followee_id": 1})
+
+	filterCursor, err := coll.Find(ctx, bson.M{"follower_id": followerID}, opts)
+	if err != nil {
+		return nil, translate(err)
+	}
+
+	var follows []Follow
+
+	if err := filterCursor.All(ctx, &follows); err != nil {
+		return nil, translate(err)
+	}
+
+	following := make([]string, 0, len(follows))
+
+	for _, follow := range follows {
+		following = append(following, follow.FolloweeID)
+	}
+
+	return following, nil
+}
@@ -0,0 +1,218 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// Job is a unit of background work, such as purging the posts of a user. Jobs are
+// queued until a worker claims them and record the counts of what they did.
+type Job struct {
+	ID          string `json:"id,omitempty" bson:"_id,omitempty"`
+	Kind        string `json:"kind,omitempty" bson:"kind,omitempty"`
+	UserID      string `json:"user_id,omitempty" bson:"user_id,omitempty"`
+	RequestedBy string `json:"requested_by,omitempty" bson:"requested_by,omitempty"`
+	// Params are the options of a job and InputKey the key of the blob it reads,
+	// such as the file of an import.
+	Params     map[string]string `json:"params,omitempty" bson:"params,omitempty"`
+	InputKey   string            `json:"-" bson:"input_key,omitempty"`
+	Status     string            `json:"status,omitempty" bson:"status,omitempty"`
+	Counts     map[string]int64  `json:"counts,omitempty" bson:"counts,omitempty"`
+	Error      string            `json:"error,omitempty" bson:"error,omitempty"`
+	CreatedAt  time.Time         `json:"created_at,omitempty" bson:"created_at,omitempty"`
+	StartedAt  time.Time         `json:"started_at,omitempty" bson:"started_at,omitempty"`
+	FinishedAt time.Time         `json:"finished_at,omitempty" bson:"finished_at,omitempty"`
+	// ResultKey is the key of the blob a job produced, such as an export archive,
+	// which is deleted once the job expires.
+	ResultKey   string    `json:"-" bson:"result_key,omitempty"`
+	ExpiresAt   time.Time `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+	DownloadURL string    `json:"download_url,omitempty" bson:"-"`
+}
+
+type JobModel struct {
+	namespace
+}
+
+func NewJobModel(client *mongo.Client, database string) *JobModel {
+	return &JobModel{namespace{client: client, database: database}}
+}
+
+// Create queues job.
+func (j JobModel) Create(ctx context.Context, job *Job) error {
+
+	coll := j.collection("jobs")
+
+	if job.ID == "" {
+		job.ID = uuid.NewString()
+	}
+
+	job.Status, job.CreatedAt = JobQueued, time.Now()
+
+	result, err := coll.InsertOne(ctx, job)
+	if err != nil {
+		return translate(err)
+	}
+
+	if id, ok := result.InsertedID.(string); !ok || id != job.ID {
+		return ErrCreateDocument
+	}
+
+	return nil
+}
+
+func (j JobModel) GetByID(ctx context.Context, id string) (*Job, error) {
+
+	coll := j.collection("jobs")
+
+	var job Job
+
+	if err := coll.FindOne(ctx, bson.M{"_id": id}).Decode(&job); err != nil {
+		return nil, translate(err)
+	}
+
+	return &job, nil
+}
+
+// ClaimNext moves the oldest queued job to running and returns it, it fails with
+// ErrNoDocument when no job is queued. A job is only claimed by one worker.
+func (j JobModel) ClaimNext(ctx context.Context) (*Job, error) {
+
+	coll := j.collection("jobs")
+
+	opts := options.FindOneAndUpdate().SetSort(bson.D{{Key: "created_at", Value: 1}}).SetReturnDocument(options.After)
+
+	var job Job
+
+	err := coll.FindOneAndUpdate(ctx, bson.M{"status": JobQueued}, bson.M{"$set": bson.M{
+		"status":     JobRunning,
+		"started_at": time.Now(),
+	}}, opts).Decode(&job)
+	if err != nil {
+		return nil, translate(err)
+	}
+
+	return &job, nil
+}
+
+// Finish records the status, counts, error and result of a job that ran.
+func (j JobModel) Finish(ctx context.Context, job *Job) error {
+
+	coll := j.collection("jobs")
+
+	job.FinishedAt = time.Now()
+
+	result, err := coll.UpdateOne(ctx, bson.M{"_id": job.ID}, bson.M{"$set": bson.M{
+		"status":      job.Status,
+		"counts":      job.Counts,
+		"error":       job.Error,
+		"finished_at": job.FinishedAt,
+		"result_key":  job.ResultKey,
+		"expires_at":  job.ExpiresAt,
+	}})
+	if err != nil {
+		return translate(err)
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrNoDocument
+	}
+
+	return nil
+}
+
+func (j JobModel) CountQueued(ctx context.Context) (int64, error) {
+
+	coll := j.collection("jobs")
+
+	count, err := coll.CountDocuments(ctx, bson.M{"status": JobQueued})
+
+	return count, translate(err)
+}
+
+// RequeueRunning queues the jobs that started running before startedBefore again,
+// they were interrupted by a worker that stopped before finishing them.
+func (j JobModel) RequeueRunning(ctx context.Context, startedBefore time.Time) (int64, error) {
+
+	coll := j.collection("jobs")
+
+	result, err := coll.UpdateMany(ctx, bson.M{"status": JobRunning, "started_at": bson.M{"$lt": startedBefore}}, bson.M{"$set": bson.M{"status": JobQueued}})
+	if err != nil {
+		return 0, translate(err)
+	}
+
+	return result.ModifiedCount, nil
+}
+
+// GetExpired returns up to limit jobs whose result expired before before.
+func (j JobModel) GetExpired(ctx context.Context, before time.Time, limit int64) (*[]Job, error) {
+
+	opts := options.Find().SetLimit(limit)
+
+	coll := j.collection("jobs")
+
+	filterCursor, err := coll.Find(ctx, bson.M{"result_key": bson.M{"$nin": bson.A{nil, ""}}, "expires_at": bson.M{"$lt": before}}, opts)
+	if err != nil {
+		return nil, translate(err)
+	}
+
+	var jobs []Job
+
+	if err := filterCursor.All(ctx, &jobs); err != nil {
+		return nil, translate(err)
+	}
+
+	return &jobs, nil
+}
+
+// GetWithBlobs returns the jobs holding the key of an input or result blob.
+func (j JobModel) GetWithBlobs(ctx context.Context, skip, limit int64) (*[]Job, error) {
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetSkip(skip).SetLimit(limit)
+
+	coll := j.collection("jobs")
+
+	filterCursor, err := coll.Find(ctx, bson.M{"$or": bson.A{
+		bson.M{"input_key": bson.M{"$nin": bson.A{nil, ""}}},
+		bson.M{"result_key": bson.M{"$nin": bson.A{nil, ""}}},
+	}}, opts)
+	if err != nil {
+		return nil, translate(err)
+	}
+
+	var jobs []Job
+
+	if err := filterCursor.All(ctx, &jobs); err != nil {
+		return nil, translate(err)
+	}
+
+	return &jobs, nil
+}
+
+// ClearResult forgets the result of a job once its blob is deleted.
+func (j JobModel) ClearResult(ctx context.Context, id string) error {
+
+	coll := j.collection("jobs")
+
+	result, err := coll.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$unset": bson.M{"result_key": ""}})
+	if err != nil {
+		return translate(err)
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrNoDocument
+	}
+
+	return nil
+}
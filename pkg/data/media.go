@@ -0,0 +1,192 @@
+package data
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// MaxMediaItems is the largest number of media items a post can hold.
+const MaxMediaItems = 10
+
+// Media is a photo, animation or video clip of a post. The first media item of a post
+// is its cover and is returned as the post's photo_url to clients that predate
+// multi-photo posts. The duration of animations and videos is in seconds.
+type Media struct {
+	ID          string    `json:"id,omitempty" bson:"id,omitempty"`
+	Key         string    `json:"-" bson:"key,omitempty"`
+	URL         string    `json:"url,omitempty" bson:"-"`
+	Kind        string    `json:"kind,omitempty" bson:"kind,omitempty"`
+	ContentType string    `json:"content_type,omitempty" bson:"content_type,omitempty"`
+	Width       int       `json:"width,omitempty" bson:"width,omitempty"`
+	Height      int       `json:"height,omitempty" bson:"height,omitempty"`
+	Duration    float64   `json:"duration,omitempty" bson:"duration,omitempty"`
+	Codec       string    `json:"codec,omitempty" bson:"codec,omitempty"`
+	AltText     string    `json:"alt_text,omitempty" bson:"alt_text,omitempty"`
+	PHash       string    `json:"phash,omitempty" bson:"phash,omitempty"`
+	Variants    []Variant `json:"variants,omitempty" bson:"variants,omitempty"`
+}
+
+// Variant is a rendition of a media item, such as a thumbnail, stored as its own blob.
+type Variant struct {
+	Name   string `json:"name,omitempty" bson:"name,omitempty"`
+	Key    string `json:"-" bson:"key,omitempty"`
+	URL    string `json:"url,omitempty" bson:"-"`
+	Width  int    `json:"width,omitempty" bson:"width,omitempty"`
+	Height int    `json:"height,omitempty" bson:"height,omitempty"`
+}
+
+// AppendMedia adds items to the end of a post's media and indexes their hash bands
+// for duplicate lookups.
+func (p PostModel) AppendMedia(ctx context.Context, id string, bands []string, items ...Media) error {
+
+	coll := p.collection("posts")
+
+	update := bson.M{"$push": bson.M{"media": bson.M{"$each": items}}}
+	if len(bands) > 0 {
+		update["$addToSet"] = bson.M{"phash_bands": bson.M{"$each": bands}}
+	}
+
+	result, err := coll.UpdateOne(ctx, bson.M{"_id": id}, update)
+	if err != nil {
+		return translate(err)
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrNoDocument
+	}
+
+	return nil
+}
+
+// ReorderMedia replaces a post's media with media, the same items in a new order. It
+// fails with ErrEditConflict when the post's items changed since they were read.
+func (p PostModel) ReorderMedia(ctx context.Context, id string, media []Media) error {
+
+	coll := p.collection("posts")
+
+	ids := make(bson.A, 0, len(media))
+	for _, item := range media {
+		ids = append(ids, item.ID)
+	}
+
+	filter := bson.M{"_id": id, "media": bson.M{"$size": len(media)}, "media.id": bson.M{"$all": ids}}
+
+	result, err := coll.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"media": media}})
+	if err != nil {
+		return translate(err)
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrEditConflict
+	}
+
+	return nil
+}
+
+// SetHashes replaces a post's media with media, the same items with recomputed
+// perceptual hashes, along with the post's phash and duplicate bands. It fails with
+// ErrEditConflict when the post's items changed since they were read.
+func (p PostModel) SetHashes(ctx context.Context, id string, media []Media, phash string, bands []string) error {
+
+	coll := p.collection("posts")
+
+	ids := make(bson.A, 0, len(media))
+	for _, item := range media {
+		ids = append(ids, item.ID)
+	}
+
+	if bands == nil {
+		bands = []string{}
+	}
+
+	filter := bson.M{"_id": id, "media": bson.M{"$size": len(media)}, "media.id": bson.M{"$all": ids}}
+	set := bson.M{"media": media, "phash": phash, "phash_bands": bands}
+
+	// posts uploaded before multi-photo posts have no media.
+	if len(media) == 0 {
+		filter = bson.M{"_id": id, "media.0": bson.M{"$exists": false}}
+		delete(set, "media")
+	}
+
+	update := bson.M{"$set": set}
+
+	result, err := coll.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return translate(err)
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrEditConflict
+	}
+
+	return nil
+}
+
+// RemoveMedia removes the media item with mediaID from post, as it was read, and
+// replaces the post's duplicate bands with bands. When the item is the cover of a
+// post uploaded before multi-photo posts, whose key is the post's photo key, the
+// photo key, photo url and phash of the post are unset along with it. It fails with
+// ErrNoDocument when post has no such item and with ErrEditConflict when the
+// post's items changed since it was read.
+func (p PostModel) RemoveMedia(ctx context.Context, post *Post, mediaID string, bands []string) error {
+
+	coll := p.collection("posts")
+
+	media, removed, ok := withoutMedia(post, mediaID)
+	if !ok {
+		return ErrNoDocument
+	}
+
+	ids := make(bson.A, 0, len(post.Media))
+	for _, item := range post.Media {
+		ids = append(ids, item.ID)
+	}
+
+	if bands == nil {
+		bands = []string{}
+	}
+
+	filter := bson.M{"_id": post.ID, "media": bson.M{"$size": len(post.Media)}, "media.id": bson.M{"$all": ids}}
+	update := bson.M{"$set": bson.M{"media": media, "phash_bands": bands}}
+
+	if legacyCover(post, removed) {
+		filter["photo_key"] = post.PhotoKey
+		update["$unset"] = bson.M{"photo_key": "", "photo_url": "", "phash": ""}
+	}
+
+	result, err := coll.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return translate(err)
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrEditConflict
+	}
+
+	return nil
+}
+
+// withoutMedia returns the media of post without the item with mediaID, along
+// with the item, or false when post has no such item.
+func withoutMedia(post *Post, mediaID string) ([]Media, Media, bool) {
+
+	var removed Media
+
+	media := make([]Media, 0, len(post.Media))
+	for _, item := range post.Media {
+		if item.ID == mediaID {
+			removed = item
+			continue
+		}
+		media = append(media, item)
+	}
+
+	return media, removed, len(media) < len(post.Media)
+}
+
+// legacyCover reports whether item is the photo of a post uploaded before
+// multi-photo posts, which became its cover when media was added to it.
+func legacyCover(post *Post, item Media) bool {
+	return item.Key != "" && item.Key == post.PhotoKey
+}
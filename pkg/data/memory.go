@@ -0,0 +1,1161 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MemoryPostStore stores posts in memory with the semantics of PostModel, for tests
+// and local demos that run without a database. It is safe for concurrent use.
+type MemoryPostStore struct {
+	mu sync.RWMutex
+	// ids holds the ids of the posts in the order they were created, the order
+	// posts are listed in.
+	ids   []string
+	posts map[string]*Post
+}
+
+func NewMemoryPostStore() *MemoryPostStore {
+	return &MemoryPostStore{posts: make(map[string]*Post)}
+}
+
+var errImmutableID = errors.New("the id of a post can not be changed")
+
+func (m *MemoryPostStore) Create(ctx context.Context, post *Post) error {
+
+	if err := ctx.Err(); err != nil {
+		return translate(err)
+	}
+
+	// mongodb generates an object id for posts without one, which is not a string.
+	if post.ID == "" {
+		return ErrCreateDocument
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.posts[post.ID]; ok {
+		return &Error{Kind: ErrConflict, Code: "duplicate", Message: "the document already exists"}
+	}
+
+	m.ids = append(m.ids, post.ID)
+	m.posts[post.ID] = stored(post)
+
+	return nil
+}
+
+func (m *MemoryPostStore) GetByID(ctx context.Context, id string) (*Post, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, translate(err)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	post, ok := m.posts[id]
+	if !ok || !visible(ViewerFromContext(ctx), post, VisibilityUnlisted) {
+		return nil, ErrNoDocument
+	}
+
+	return clonePost(post), nil
+}
+
+func (m *MemoryPostStore) GetByUserID(ctx context.Context, id string, skip, limit int64) (*[]Post, error) {
+	return m.list(ctx, skip, limit, func(post *Post) bool {
+		return post.UserID == id
+	})
+}
+
+// GetAllByUserID returns every post of a user regardless of its visibility and
+// moderation state, ordered by id.
+func (m *MemoryPostStore) GetAllByUserID(ctx context.Context, id string, skip, limit int64) (*[]Post, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, translate(err)
+	}
+
+	m.mu.RLock()
+
+	var posts []Post
+
+	for _, post := range m.posts {
+		if post.UserID == id {
+			posts = append(posts, *clonePost(post))
+		}
+	}
+
+	m.mu.RUnlock()
+
+	sort.Slice(posts, func(i, j int) bool { return posts[i].ID < posts[j].ID })
+
+	posts = page(posts, skip, limit)
+
+	return &posts, nil
+}
+
+// Scan returns up to limit posts with ids after after in id order, regardless of
+// their visibility and moderation state.
+func (m *MemoryPostStore) Scan(ctx context.Context, after string, limit int64) (*[]Post, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, translate(err)
+	}
+
+	m.mu.RLock()
+
+	var posts []Post
+
+	for _, post := range m.posts {
+		if post.ID > after {
+			posts = append(posts, *clonePost(post))
+		}
+	}
+
+	m.mu.RUnlock()
+
+	sort.Slice(posts, func(i, j int) bool { return posts[i].ID < posts[j].ID })
+
+	posts = page(posts, 0, limit)
+
+	return &posts, nil
+}
+
+func (m *MemoryPostStore) GetByCategory(ctx context.Context, category string, skip, limit int64) (*[]Post, error) {
+	return m.list(ctx, skip, limit, func(post *Post) bool {
+		return post.Category == category
+	})
+}
+
+// GetByTags lists the posts tagged with every one of tags, like $all no post matches
+// an empty list of tags.
+func (m *MemoryPostStore) GetByTags(ctx context.Context, tags []string, skip, limit int64) (*[]Post, error) {
+	return m.list(ctx, skip, limit, func(post *Post) bool {
+		if len(tags) == 0 {
+			return false
+		}
+		for _, tag := range tags {
+			if !contains(post.Tags, tag) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+func (m *MemoryPostStore) GetByPHashBands(ctx context.Context, bands []string, skip, limit int64) (*[]Post, error) {
+	return m.list(ctx, skip, limit, func(post *Post) bool {
+		for _, band := range bands {
+			if contains(post.PHashBands, band) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func (m *MemoryPostStore) Get(ctx context.Context, skip, limit int64) (*[]Post, error) {
+	return m.list(ctx, skip, limit, func(post *Post) bool {
+		return true
+	})
+}
+
+// list returns the page of the posts that match, the viewer in ctx can see and are
+// not hidden by moderation, in the order they were created. Like mongodb a limit of
+// zero returns every post and a negative limit is taken as its absolute value.
+func (m *MemoryPostStore) list(ctx context.Context, skip, limit int64, match func(post *Post) bool) (*[]Post, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, translate(err)
+	}
+
+	if limit < 0 {
+		limit = -limit
+	}
+
+	viewer := ViewerFromContext(ctx)
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var posts []Post
+
+	for _, id := range m.ids {
+		post := m.posts[id]
+
+		if !match(post) || !visible(viewer, post) || listHidden(viewer, post) {
+			continue
+		}
+
+		if skip > 0 {
+			skip--
+			continue
+		}
+
+		posts = append(posts, *clonePost(post))
+
+		if limit > 0 && int64(len(posts)) == limit {
+			break
+		}
+	}
+
+	return &posts, nil
+}
+
+func (m *MemoryPostStore) AppendMedia(ctx context.Context, id string, bands []string, items ...Media) error {
+	return m.update(ctx, id, func(post *Post) error {
+		for _, item := range items {
+			post.Media = append(post.Media, storedMedia(item))
+		}
+		for _, band := range bands {
+			if !contains(post.PHashBands, band) {
+				post.PHashBands = append(post.PHashBands, band)
+			}
+		}
+		return nil
+	})
+}
+
+// ReorderMedia replaces a post's media with media, it fails with ErrEditConflict when
+// the post does not exist or its items changed since they were read.
+func (m *MemoryPostStore) ReorderMedia(ctx context.Context, id string, media []Media) error {
+
+	err := m.update(ctx, id, func(post *Post) error {
+		if len(media) == 0 || len(post.Media) != len(media) {
+			return ErrEditConflict
+		}
+		for _, item := range media {
+			if !hasMedia(post.Media, item.ID) {
+				return ErrEditConflict
+			}
+		}
+
+		post.Media = post.Media[:0]
+		for _, item := range media {
+			post.Media = append(post.Media, storedMedia(item))
+		}
+		return nil
+	})
+
+	if errors.Is(err, ErrNoDocument) {
+		return ErrEditConflict
+	}
+
+	return err
+}
+
+// SetHashes replaces a post's media with media along with its phash and bands, it
+// fails with ErrEditConflict when the post's items changed since they were read.
+func (m *MemoryPostStore) SetHashes(ctx context.Context, id string, media []Media, phash string, bands []string) error {
+
+	err := m.update(ctx, id, func(post *Post) error {
+		if len(post.Media) != len(media) {
+			return ErrEditConflict
+		}
+		for _, item := range media {
+			if !hasMedia(post.Media, item.ID) {
+				return ErrEditConflict
+			}
+		}
+
+		post.Media = post.Media[:0]
+		for _, item := range media {
+			post.Media = append(post.Media, storedMedia(item))
+		}
+		post.PHash, post.PHashBands = phash, append([]string(nil), bands...)
+		return nil
+	})
+
+	if errors.Is(err, ErrNoDocument) {
+		return ErrEditConflict
+	}
+
+	return err
+}
+
+// RemoveMedia removes the media item with mediaID from post along with its legacy
+// photo when the item is its cover and replaces its bands, like RemoveMedia of
+// PostModel.
+func (m *MemoryPostStore) RemoveMedia(ctx context.Context, post *Post, mediaID string, bands []string) error {
+
+	media, removed, ok := withoutMedia(post, mediaID)
+	if !ok {
+		return ErrNoDocument
+	}
+
+	err := m.update(ctx, post.ID, func(current *Post) error {
+		if len(current.Media) != len(post.Media) {
+			return ErrEditConflict
+		}
+		for _, item := range post.Media {
+			if !hasMedia(current.Media, item.ID) {
+				return ErrEditConflict
+			}
+		}
+
+		if legacyCover(post, removed) {
+			if current.PhotoKey != post.PhotoKey {
+				return ErrEditConflict
+			}
+			current.PhotoKey, current.PhotoURL, current.PHash = "", "", ""
+		}
+
+		current.Media = current.Media[:0]
+		for _, item := range media {
+			current.Media = append(current.Media, storedMedia(item))
+		}
+		current.PHashBands = append([]string(nil), bands...)
+		return nil
+	})
+
+	if errors.Is(err, ErrNoDocument) {
+		return ErrEditConflict
+	}
+
+	return err
+}
+
+func (m *MemoryPostStore) SetModerationState(ctx context.Context, id, state string) error {
+	return m.update(ctx, id, func(post *Post) error {
+		post.ModerationState = state
+		return nil
+	})
+}
+
+// UpdateByID sets the fields of post that are not empty on the post with id, like
+// the $set of PostModel. The geo tag is always set.
+func (m *MemoryPostStore) UpdateByID(ctx context.Context, id string, post *Post) error {
+	return m.update(ctx, id, func(current *Post) error {
+		if post.ID != "" && post.ID != id {
+			return errImmutableID
+		}
+
+		update := stored(post)
+
+		setString(&current.ExternalID, update.ExternalID)
+		setString(&current.UserID, update.UserID)
+		setString(&current.Title, update.Title)
+		setString(&current.Desc, update.Desc)
+		setString(&current.PhotoURL, update.PhotoURL)
+		setString(&current.PhotoKey, update.PhotoKey)
+		setString(&current.DestURL, update.DestURL)
+		setString(&current.Category, update.Category)
+		setString(&current.PHash, update.PHash)
+		setString(&current.ModerationState, update.ModerationState)
+		setString(&current.Visibility, update.Visibility)
+
+		if len(update.Media) > 0 {
+			current.Media = update.Media
+		}
+		if len(update.Tags) > 0 {
+			current.Tags = update.Tags
+		}
+		if len(update.PHashBands) > 0 {
+			current.PHashBands = update.PHashBands
+		}
+		if !update.CreatedAt.IsZero() {
+			current.CreatedAt = update.CreatedAt
+		}
+
+		current.GeoTag = update.GeoTag
+
+		return nil
+	})
+}
+
+// update applies fn to the post with id, the post is left as it was when fn fails.
+func (m *MemoryPostStore) update(ctx context.Context, id string, fn func(post *Post) error) error {
+
+	if err := ctx.Err(); err != nil {
+		return translate(err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	post, ok := m.posts[id]
+	if !ok {
+		return ErrNoDocument
+	}
+
+	updated := clonePost(post)
+
+	if err := fn(updated); err != nil {
+		return err
+	}
+
+	// the post is stored under its own id, id can be a string a request reuses.
+	m.posts[post.ID] = updated
+
+	return nil
+}
+
+func (m *MemoryPostStore) DeleteByID(ctx context.Context, id string) error {
+
+	if err := ctx.Err(); err != nil {
+		return translate(err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.posts[id]; !ok {
+		return ErrNoDocument
+	}
+
+	m.remove(func(post *Post) bool { return post.ID == id })
+
+	return nil
+}
+
+func (m *MemoryPostStore) DeleteByUserID(ctx context.Context, id string) (int64, error) {
+
+	if err := ctx.Err(); err != nil {
+		return 0, translate(err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	deleted := m.remove(func(post *Post) bool { return post.UserID == id })
+	if deleted == 0 {
+		return 0, ErrNoDocument
+	}
+
+	return int64(deleted), nil
+}
+
+// remove deletes the posts that match and returns how many were deleted, the caller
+// holds the lock.
+func (m *MemoryPostStore) remove(match func(post *Post) bool) int {
+
+	ids := m.ids[:0]
+	for _, id := range m.ids {
+		if match(m.posts[id]) {
+			delete(m.posts, id)
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	deleted := len(m.ids) - len(ids)
+
+	m.ids = ids
+
+	return deleted
+}
+
+// visible reports whether viewer can see post, following visibilityFilter.
+func visible(viewer Viewer, post *Post, open ...string) bool {
+
+	if viewer.Moderator {
+		return true
+	}
+
+	switch {
+	case post.Visibility == "" || post.Visibility == VisibilityPublic:
+		return true
+	case contains(open, post.Visibility):
+		return true
+	case viewer.UserID != "" && post.UserID == viewer.UserID:
+		return true
+	case post.Visibility == VisibilityFollowers && contains(viewer.Following, post.UserID):
+		return true
+	}
+
+	return false
+}
+
+// listHidden reports whether post is hidden from the list queries of viewer by
+// moderation, following listFilter.
+func listHidden(viewer Viewer, post *Post) bool {
+	if viewer.Moderator {
+		return false
+	}
+	switch post.ModerationState {
+	case ModerationPending, ModerationFlagged, ModerationHidden, ModerationRemoved:
+		return true
+	}
+	return false
+}
+
+// stored returns a copy of post as PostModel stores it, without the fields that are
+// not stored.
+func stored(post *Post) *Post {
+
+	p := clonePost(post)
+
+	for i := range p.Media {
+		p.Media[i] = storedMedia(p.Media[i])
+	}
+
+	return p
+}
+
+func storedMedia(item Media) Media {
+
+	item.URL = ""
+	item.Variants = append([]Variant(nil), item.Variants...)
+
+	for i := range item.Variants {
+		item.Variants[i].URL = ""
+	}
+
+	return item
+}
+
+// clonePost returns a deep copy of post, so callers can not change stored posts.
+func clonePost(post *Post) *Post {
+
+	p := *post
+
+	p.Tags = append([]string(nil), post.Tags...)
+	p.PHashBands = append([]string(nil), post.PHashBands...)
+	p.GeoTag.Coordinates = append([]float64(nil), post.GeoTag.Coordinates...)
+	p.Media = nil
+
+	for _, item := range post.Media {
+		item.Variants = append([]Variant(nil), item.Variants...)
+		p.Media = append(p.Media, item)
+	}
+
+	return &p
+}
+
+// page returns the posts left after skipping skip of them, at most limit of them
+// when limit is not zero.
+func page(posts []Post, skip, limit int64) []Post {
+
+	if limit < 0 {
+		limit = -limit
+	}
+
+	if skip >= int64(len(posts)) {
+		return nil
+	}
+
+	posts = posts[skip:]
+
+	if limit > 0 && limit < int64(len(posts)) {
+		posts = posts[:limit]
+	}
+
+	return posts
+}
+
+func setString(field *string, value string) {
+	if value != "" {
+		*field = value
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func hasMedia(media []Media, id string) bool {
+	for _, item := range media {
+		if item.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// NewMemoryModels returns models that store everything in memory, for tests and
+// local demos that run without a database.
+func NewMemoryModels() Models {
+	return Models{
+		Post:          NewMemoryPostStore(),
+		Report:        NewMemoryReportStore(),
+		Follow:        NewMemoryFollowStore(),
+		UploadSession: NewMemoryUploadSessionStore(),
+		Job:           NewMemoryJobStore(),
+	}
+}
+
+// MemoryReportStore stores reports in memory with the semantics of ReportModel.
+type MemoryReportStore struct {
+	mu      sync.Mutex
+	reports []Report
+}
+
+func NewMemoryReportStore() *MemoryReportStore {
+	return &MemoryReportStore{}
+}
+
+func (m *MemoryReportStore) Create(ctx context.Context, report *Report) error {
+
+	if err := ctx.Err(); err != nil {
+		return translate(err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if report.ID == "" {
+		report.ID = uuid.NewString()
+	}
+
+	for _, r := range m.reports {
+		if r.ID == report.ID || (report.ReporterID != "" && r.ReporterID == report.ReporterID && r.PostID == report.PostID && r.Status == ReportOpen) {
+			return &Error{Kind: ErrConflict, Code: "duplicate", Message: "the document already exists"}
+		}
+	}
+
+	report.Status, report.CreatedAt = ReportOpen, time.Now()
+
+	r := *report
+	r.Labels = append([]string(nil), report.Labels...)
+
+	m.reports = append(m.reports, r)
+
+	return nil
+}
+
+// GetOpen returns the moderation queue, open reports in the order they were filed.
+func (m *MemoryReportStore) GetOpen(ctx context.Context, skip, limit int64) (*[]Report, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, translate(err)
+	}
+
+	if limit < 0 {
+		limit = -limit
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var reports []Report
+
+	for _, r := range m.reports {
+		if r.Status != ReportOpen {
+			continue
+		}
+
+		if skip > 0 {
+			skip--
+			continue
+		}
+
+		r.Labels = append([]string(nil), r.Labels...)
+		reports = append(reports, r)
+
+		if limit > 0 && int64(len(reports)) == limit {
+			break
+		}
+	}
+
+	return &reports, nil
+}
+
+func (m *MemoryReportStore) CountOpenReporters(ctx context.Context, postID string) (int64, error) {
+
+	if err := ctx.Err(); err != nil {
+		return 0, translate(err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	reporters := map[string]bool{}
+
+	for _, r := range m.reports {
+		if r.PostID == postID && r.Status == ReportOpen && r.ReporterID != "" {
+			reporters[r.ReporterID] = true
+		}
+	}
+
+	return int64(len(reporters)), nil
+}
+
+func (m *MemoryReportStore) ResolveByPostID(ctx context.Context, postID, action, moderatorID string) error {
+
+	if err := ctx.Err(); err != nil {
+		return translate(err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, r := range m.reports {
+		if r.PostID == postID && r.Status == ReportOpen {
+			m.reports[i].Status, m.reports[i].Action = ReportResolved, action
+			m.reports[i].ResolvedBy, m.reports[i].ResolvedAt = moderatorID, time.Now()
+		}
+	}
+
+	return nil
+}
+
+func (m *MemoryReportStore) DeleteByPostID(ctx context.Context, postID string) (int64, error) {
+
+	if err := ctx.Err(); err != nil {
+		return 0, translate(err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	reports := m.reports[:0]
+	for _, r := range m.reports {
+		if r.PostID != postID {
+			reports = append(reports, r)
+		}
+	}
+
+	deleted := len(m.reports) - len(reports)
+
+	m.reports = reports
+
+	return int64(deleted), nil
+}
+
+// MemoryFollowStore stores follows in memory with the semantics of FollowModel.
+type MemoryFollowStore struct {
+	mu      sync.Mutex
+	follows []Follow
+}
+
+func NewMemoryFollowStore() *MemoryFollowStore {
+	return &MemoryFollowStore{}
+}
+
+// Create records that followerID follows followeeID, following a user twice is a no-op.
+func (m *MemoryFollowStore) Create(ctx context.Context, followerID, followeeID string) error {
+
+	if err := ctx.Err(); err != nil {
+		return translate(err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := followID(followerID, followeeID)
+
+	for _, f := range m.follows {
+		if f.ID == id {
+			return nil
+		}
+	}
+
+	m.follows = append(m.follows, Follow{ID: id, FollowerID: followerID, FolloweeID: followeeID, CreatedAt: time.Now()})
+
+	return nil
+}
+
+func (m *MemoryFollowStore) Delete(ctx context.Context, followerID, followeeID string) error {
+
+	if err := ctx.Err(); err != nil {
+		return translate(err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := followID(followerID, followeeID)
+
+	for i, f := range m.follows {
+		if f.ID == id {
+			m.follows = append(m.follows[:i], m.follows[i+1:]...)
+			return nil
+		}
+	}
+
+	return ErrNoDocument
+}
+
+func (m *MemoryFollowStore) GetFollowing(ctx context.Context, followerID string) ([]string, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, translate(err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	following := []string{}
+
+	for _, f := range m.follows {
+		if f.FollowerID == followerID {
+			following = append(following, f.FolloweeID)
+		}
+	}
+
+	return following, nil
+}
+
+// MemoryUploadSessionStore stores upload sessions in memory with the semantics of
+// UploadSessionModel.
+type MemoryUploadSessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]*UploadSession
+}
+
+func NewMemoryUploadSessionStore() *MemoryUploadSessionStore {
+	return &MemoryUploadSessionStore{sessions: make(map[string]*UploadSession)}
+}
+
+func (m *MemoryUploadSessionStore) Create(ctx context.Context, session *UploadSession) error {
+
+	if err := ctx.Err(); err != nil {
+		return translate(err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if session.ID == "" {
+		session.ID = uuid.NewString()
+	}
+
+	if _, ok := m.sessions[session.ID]; ok {
+		return &Error{Kind: ErrConflict, Code: "duplicate", Message: "the document already exists"}
+	}
+
+	session.Status, session.Parts, session.CreatedAt = UploadOpen, map[string]int64{}, time.Now()
+
+	m.sessions[session.ID] = cloneSession(session)
+
+	return nil
+}
+
+func (m *MemoryUploadSessionStore) GetByID(ctx context.Context, id string) (*UploadSession, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, translate(err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.sessions[id]
+	if !ok {
+		return nil, ErrNoDocument
+	}
+
+	return cloneSession(session), nil
+}
+
+func (m *MemoryUploadSessionStore) AddPart(ctx context.Context, id string, part int, size int64) error {
+	return m.update(ctx, id, UploadOpen, func(session *UploadSession) {
+		session.Parts[strconv.Itoa(part)] = size
+	})
+}
+
+func (m *MemoryUploadSessionStore) SetStatus(ctx context.Context, id, from, to string) error {
+	return m.update(ctx, id, from, func(session *UploadSession) {
+		session.Status = to
+	})
+}
+
+// update applies fn to the session with id in status.
+func (m *MemoryUploadSessionStore) update(ctx context.Context, id, status string, fn func(session *UploadSession)) error {
+
+	if err := ctx.Err(); err != nil {
+		return translate(err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.sessions[id]
+	if !ok || session.Status != status {
+		return ErrNoDocument
+	}
+
+	fn(session)
+
+	return nil
+}
+
+func (m *MemoryUploadSessionStore) GetExpired(ctx context.Context, t time.Time, limit int64) (*[]UploadSession, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, translate(err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var sessions []UploadSession
+
+	for _, session := range m.sessions {
+		if session.Status != UploadOpen || !session.ExpiresAt.Before(t) {
+			continue
+		}
+
+		sessions = append(sessions, *cloneSession(session))
+
+		if limit > 0 && int64(len(sessions)) == limit {
+			break
+		}
+	}
+
+	return &sessions, nil
+}
+
+func cloneSession(session *UploadSession) *UploadSession {
+
+	s := *session
+
+	s.Parts = make(map[string]int64, len(session.Parts))
+	for part, size := range session.Parts {
+		s.Parts[part] = size
+	}
+
+	return &s
+}
+
+// MemoryJobStore stores jobs in memory with the semantics of JobModel.
+type MemoryJobStore struct {
+	mu   sync.Mutex
+	jobs []*Job
+}
+
+func NewMemoryJobStore() *MemoryJobStore {
+	return &MemoryJobStore{}
+}
+
+func (m *MemoryJobStore) Create(ctx context.Context, job *Job) error {
+
+	if err := ctx.Err(); err != nil {
+		return translate(err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if job.ID == "" {
+		job.ID = uuid.NewString()
+	}
+
+	if m.find(job.ID) != nil {
+		return &Error{Kind: ErrConflict, Code: "duplicate", Message: "the document already exists"}
+	}
+
+	job.Status, job.CreatedAt = JobQueued, time.Now()
+
+	m.jobs = append(m.jobs, cloneJob(job))
+
+	return nil
+}
+
+func (m *MemoryJobStore) GetByID(ctx context.Context, id string) (*Job, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, translate(err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job := m.find(id)
+	if job == nil {
+		return nil, ErrNoDocument
+	}
+
+	return cloneJob(job), nil
+}
+
+// ClaimNext moves the oldest queued job to running and returns it.
+func (m *MemoryJobStore) ClaimNext(ctx context.Context) (*Job, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, translate(err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, job := range m.jobs {
+		if job.Status == JobQueued {
+			job.Status, job.StartedAt = JobRunning, time.Now()
+			return cloneJob(job), nil
+		}
+	}
+
+	return nil, ErrNoDocument
+}
+
+func (m *MemoryJobStore) Finish(ctx context.Context, job *Job) error {
+
+	if err := ctx.Err(); err != nil {
+		return translate(err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored := m.find(job.ID)
+	if stored == nil {
+		return ErrNoDocument
+	}
+
+	job.FinishedAt = time.Now()
+
+	stored.Status, stored.Error, stored.FinishedAt = job.Status, job.Error, job.FinishedAt
+	stored.ResultKey, stored.ExpiresAt = job.ResultKey, job.ExpiresAt
+	stored.Counts = cloneJob(job).Counts
+
+	return nil
+}
+
+func (m *MemoryJobStore) CountQueued(ctx context.Context) (int64, error) {
+
+	if err := ctx.Err(); err != nil {
+		return 0, translate(err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var count int64
+
+	for _, job := range m.jobs {
+		if job.Status == JobQueued {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func (m *MemoryJobStore) RequeueRunning(ctx context.Context, startedBefore time.Time) (int64, error) {
+
+	if err := ctx.Err(); err != nil {
+		return 0, translate(err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var count int64
+
+	for _, job := range m.jobs {
+		if job.Status == JobRunning && job.StartedAt.Before(startedBefore) {
+			job.Status = JobQueued
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func (m *MemoryJobStore) GetExpired(ctx context.Context, before time.Time, limit int64) (*[]Job, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, translate(err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	jobs := []Job{}
+
+	for _, job := range m.jobs {
+		if int64(len(jobs)) >= limit {
+			break
+		}
+		if job.ResultKey != "" && job.ExpiresAt.Before(before) {
+			jobs = append(jobs, *cloneJob(job))
+		}
+	}
+
+	return &jobs, nil
+}
+
+func (m *MemoryJobStore) GetWithBlobs(ctx context.Context, skip, limit int64) (*[]Job, error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, translate(err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	jobs := []Job{}
+
+	for _, job := range m.jobs {
+		if job.InputKey != "" || job.ResultKey != "" {
+			jobs = append(jobs, *cloneJob(job))
+		}
+	}
+
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].ID < jobs[j].ID })
+
+	if skip >= int64(len(jobs)) {
+		jobs = jobs[:0]
+	} else {
+		jobs = jobs[skip:]
+	}
+
+	if limit > 0 && limit < int64(len(jobs)) {
+		jobs = jobs[:limit]
+	}
+
+	return &jobs, nil
+}
+
+func (m *MemoryJobStore) ClearResult(ctx context.Context, id string) error {
+
+	if err := ctx.Err(); err != nil {
+		return translate(err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job := m.find(id)
+	if job == nil {
+		return ErrNoDocument
+	}
+
+	job.ResultKey = ""
+
+	return nil
+}
+
+// find returns the stored job with id, the caller holds the lock.
+func (m *MemoryJobStore) find(id string) *Job {
+	for _, job := range m.jobs {
+		if job.ID == id {
+			return job
+		}
+	}
+	return nil
+}
+
+func cloneJob(job *Job) *Job {
+
+	j := *job
+
+	if job.Counts != nil {
+		j.Counts = make(map[string]int64, len(job.Counts))
+		for name, count := range job.Counts {
+			j.Counts[name] = count
+		}
+	}
+
+	if job.Params != nil {
+		j.Params = make(map[string]string, len(job.Params))
+		for name, value := range job.Params {
+			j.Params[name] = value
+		}
+	}
+
+	return &j
+}
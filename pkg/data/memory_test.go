@@ -0,0 +1,14 @@
+package data_test
+
+import (
+	"testing"
+
+	"github.com/evansopilo/visuai/pkg/data"
+	"github.com/evansopilo/visuai/pkg/data/datatest"
+)
+
+func TestMemoryPostStore(t *testing.T) {
+	datatest.TestPostStore(t, func(t *testing.T) data.PostStore {
+		return data.NewMemoryPostStore()
+	})
+}
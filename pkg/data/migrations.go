@@ -0,0 +1,117 @@
+package data
+
+import (
+	"context"
+	"net/url"
+	"path"
+	"regexp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// migrations are applied in order of their version.
+var migrations = []Migration{
+	{Version: 1, Name: "photo_key_from_photo_url", Up: photoKeyFromPhotoURL},
+	{Version: 2, Name: "resolve_duplicate_reports", Up: resolveDuplicateReports},
+}
+
+// legacyBlobName matches the names of the blobs uploaded before blob keys were
+// stored, the photo url of their posts is the url of the blob.
+var legacyBlobName = regexp.MustCompile(`^\d{8}-[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\.jpg$`)
+
+// photoKeyFromPhotoURL sets the photo key of the posts uploaded before blob keys
+// were stored from the blob url in their photo url, so their photo is served
+// through signed urls and their blob is deleted, exported and kept by the orphan
+// purge along with the post. The photo url is kept.
+func photoKeyFromPhotoURL(ctx context.Context, collection func(name string) *mongo.Collection) error {
+
+	coll := collection("posts")
+
+	filter := bson.M{"photo_key": bson.M{"$exists": false}, "photo_url": bson.M{"$regex": `\.jpg$`}}
+
+	cursor, err := coll.Find(ctx, filter)
+	if err != nil {
+		return translate(err)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var post struct {
+			ID       string `bson:"_id"`
+			PhotoURL string `bson:"photo_url"`
+		}
+
+		if err := cursor.Decode(&post); err != nil {
+			return translate(err)
+		}
+
+		key, ok := LegacyPhotoKey(post.PhotoURL)
+		if !ok {
+			continue
+		}
+
+		if _, err := coll.UpdateOne(ctx, bson.M{"_id": post.ID}, bson.M{"$set": bson.M{"photo_key": key}}); err != nil {
+			return translate(err)
+		}
+	}
+
+	return translate(cursor.Err())
+}
+
+// resolveDuplicateReports resolves the open reports a user filed on a post after
+// their first, so a user has one open report of a post as the unique index of the
+// reports requires.
+func resolveDuplicateReports(ctx context.Context, collection func(name string) *mongo.Collection) error {
+
+	coll := collection("reports")
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"status": ReportOpen, "reporter_id": bson.M{"$exists": true}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{"post_id": "$post_id", "reporter_id": "$reporter_id"},
+			"ids": bson.M{"$push": "$_id"},
+		}}},
+		{{Key: "$match", Value: bson.M{"ids.1": bson.M{"$exists": true}}}},
+	}
+
+	cursor, err := coll.Aggregate(ctx, pipeline)
+	if err != nil {
+		return translate(err)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var group struct {
+			IDs []string `bson:"ids"`
+		}
+
+		if err := cursor.Decode(&group); err != nil {
+			return translate(err)
+		}
+
+		update := bson.M{"$set": bson.M{"status": ReportResolved, "action": "duplicate", "resolved_at": time.Now()}}
+
+		if _, err := coll.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": group.IDs[1:]}}, update); err != nil {
+			return translate(err)
+		}
+	}
+
+	return translate(cursor.Err())
+}
+
+// LegacyPhotoKey returns the key of the blob at rawURL when it names a blob
+// uploaded before blob keys were stored.
+func LegacyPhotoKey(rawURL string) (string, bool) {
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", false
+	}
+
+	key := path.Base(u.Path)
+
+	return key, legacyBlobName.MatchString(key)
+}
@@ -1,25 +1,99 @@
 package data
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 type Models struct {
-	Post interface {
-		Create(ctx context.Context, post *Post) error
+	Post PostStore
 
-		GetByID(ctx context.Context, id string) (*Post, error)
+	Report interface {
+		Create(ctx context.Context, report *Report) error
 
-		GetByUserID(ctx context.Context, id string, skip, limit int64) (*[]Post, error)
+		GetOpen(ctx context.Context, skip, limit int64) (*[]Report, error)
 
-		GetByCategory(ctx context.Context, category string, skip, limit int64) (*[]Post, error)
+		CountOpenReporters(ctx context.Context, postID string) (int64, error)
 
-		GetByTags(ctx context.Context, tags []string, skip, limit int64) (*[]Post, error)
+		ResolveByPostID(ctx context.Context, postID, action, moderatorID string) error
 
-		Get(ctx context.Context, skip, limit int64) (*[]Post, error)
+		DeleteByPostID(ctx context.Context, postID string) (int64, error)
+	}
+
+	Follow interface {
+		Create(ctx context.Context, followerID, followeeID string) error
+
+		Delete(ctx context.Context, followerID, followeeID string) error
+
+		GetFollowing(ctx context.Context, followerID string) ([]string, error)
+	}
+
+	UploadSession interface {
+		Create(ctx context.Context, session *UploadSession) error
+
+		GetByID(ctx context.Context, id string) (*UploadSession, error)
+
+		AddPart(ctx context.Context, id string, part int, size int64) error
+
+		SetStatus(ctx context.Context, id, from, to string) error
 
-		UpdateByID(ctx context.Context, id string, post *Post) error
+		GetExpired(ctx context.Context, t time.Time, limit int64) (*[]UploadSession, error)
+	}
+
+	Job interface {
+		Create(ctx context.Context, job *Job) error
+
+		GetByID(ctx context.Context, id string) (*Job, error)
+
+		ClaimNext(ctx context.Context) (*Job, error)
+
+		Finish(ctx context.Context, job *Job) error
 
-		DeleteByID(ctx context.Context, id string) error
+		CountQueued(ctx context.Context) (int64, error)
 
-		DeleteByUserID(ctx context.Context, id string) error
+		RequeueRunning(ctx context.Context, startedBefore time.Time) (int64, error)
+
+		GetExpired(ctx context.Context, before time.Time, limit int64) (*[]Job, error)
+
+		ClearResult(ctx context.Context, id string) error
+
+		GetWithBlobs(ctx context.Context, skip, limit int64) (*[]Job, error)
 	}
 }
+
+// PostStore stores posts, PostModel stores them in mongodb.
+type PostStore interface {
+	Create(ctx context.Context, post *Post) error
+
+	GetByID(ctx context.Context, id string) (*Post, error)
+
+	GetByUserID(ctx context.Context, id string, skip, limit int64) (*[]Post, error)
+
+	GetAllByUserID(ctx context.Context, id string, skip, limit int64) (*[]Post, error)
+
+	Scan(ctx context.Context, after string, limit int64) (*[]Post, error)
+
+	GetByCategory(ctx context.Context, category string, skip, limit int64) (*[]Post, error)
+
+	GetByTags(ctx context.Context, tags []string, skip, limit int64) (*[]Post, error)
+
+	GetByPHashBands(ctx context.Context, bands []string, skip, limit int64) (*[]Post, error)
+
+	Get(ctx context.Context, skip, limit int64) (*[]Post, error)
+
+	AppendMedia(ctx context.Context, id string, bands []string, items ...Media) error
+
+	ReorderMedia(ctx context.Context, id string, media []Media) error
+
+	SetHashes(ctx context.Context, id string, media []Media, phash string, bands []string) error
+
+	RemoveMedia(ctx context.Context, post *Post, mediaID string, bands []string) error
+
+	SetModerationState(ctx context.Context, id, state string) error
+
+	UpdateByID(ctx context.Context, id string, post *Post) error
+
+	DeleteByID(ctx context.Context, id string) error
+
+	DeleteByUserID(ctx context.Context, id string) (int64, error)
+}
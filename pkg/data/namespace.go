@@ -0,0 +1,47 @@
+package data
+
+import (
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// CollectionNames are the default names of the collections of the models and the
+// schema.
+var CollectionNames = []string{"posts", "reports", "follows", "upload_sessions", "jobs", "migrations", "locks"}
+
+// Collections maps the default names of collections to the names they have in the
+// database, the collections it leaves out keep their default name.
+type Collections map[string]string
+
+func (c Collections) Name(collection string) string {
+	if name, ok := c[collection]; ok && name != "" {
+		return name
+	}
+	return collection
+}
+
+// namespace is the database and the names of the collections a model reads and
+// writes.
+type namespace struct {
+	client      *mongo.Client
+	database    string
+	collections Collections
+}
+
+func (n namespace) collection(name string) *mongo.Collection {
+	return n.client.Database(n.database).Collection(n.collections.Name(name))
+}
+
+// NewModels returns the models of database whose collections are named by
+// collections.
+func NewModels(client *mongo.Client, database string, collections Collections) Models {
+
+	ns := namespace{client: client, database: database, collections: collections}
+
+	return Models{
+		Post:          &PostModel{ns},
+		Report:        &ReportModel{ns},
+		Follow:        &FollowModel{ns},
+		UploadSession: &UploadSessionModel{ns},
+		Job:           &JobModel{ns},
+	}
+}
@@ -0,0 +1,133 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Report sources, reports are either filed by users or raised by the automated
+// safety classifier when a photo is uploaded.
+const (
+	ReportSourceUser       = "user"
+	ReportSourceClassifier = "classifier"
+)
+
+const (
+	ReportOpen     = "open"
+	ReportResolved = "resolved"
+)
+
+type Report struct {
+	ID         string    `json:"id,omitempty" bson:"_id,omitempty"`
+	PostID     string    `json:"post_id,omitempty" bson:"post_id,omitempty"`
+	ReporterID string    `json:"reporter_id,omitempty" bson:"reporter_id,omitempty"`
+	Source     string    `json:"source,omitempty" bson:"source,omitempty"`
+	Reason     string    `json:"reason,omitempty" bson:"reason,omitempty"`
+	Labels     []string  `json:"labels,omitempty" bson:"labels,omitempty"`
+	Status     string    `json:"status,omitempty" bson:"status,omitempty"`
+	Action     string    `json:"action,omitempty" bson:"action,omitempty"`
+	ResolvedBy string    `json:"resolved_by,omitempty" bson:"resolved_by,omitempty"`
+	CreatedAt  time.Time `json:"created_at,omitempty" bson:"created_at,omitempty"`
+	ResolvedAt time.Time `json:"resolved_at,omitempty" bson:"resolved_at,omitempty"`
+}
+
+type ReportModel struct {
+	namespace
+}
+
+func NewReportModel(client *mongo.Client, database string) *ReportModel {
+	return &ReportModel{namespace{client: client, database: database}}
+}
+
+// Create files an open report, a user can have one open report of a post, another
+// fails with a conflict.
+func (r ReportModel) Create(ctx context.Context, report *Report) error {
+
+	coll := r.collection("reports")
+
+	if report.ID == "" {
+		report.ID = uuid.NewString()
+	}
+
+	report.Status, report.CreatedAt = ReportOpen, time.Now()
+
+	result, err := coll.InsertOne(ctx, report)
+	if err != nil {
+		return translate(err)
+	}
+
+	if id, ok := result.InsertedID.(string); !ok || id != report.ID {
+		return ErrCreateDocument
+	}
+
+	return nil
+}
+
+// GetOpen returns the moderation queue, open reports ordered from the oldest.
+func (r ReportModel) GetOpen(ctx context.Context, skip, limit int64) (*[]Report, error) {
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}).SetSkip(skip).SetLimit(limit)
+
+	coll := r.collection("reports")
+
+	filterCursor, err := coll.Find(ctx, bson.M{"status": ReportOpen}, opts)
+	if err != nil {
+		return nil, translate(err)
+	}
+
+	var reports []Report
+
+	if err := filterCursor.All(ctx, &reports); err != nil {
+		return nil, translate(err)
+	}
+
+	return &reports, nil
+}
+
+// CountOpenReporters returns the number of users with an open report of a post, a
+// user counts once however many times they reported it. Reports raised by the
+// classifier have no reporter and are not counted.
+func (r ReportModel) CountOpenReporters(ctx context.Context, postID string) (int64, error) {
+
+	coll := r.collection("reports")
+
+	reporters, err := coll.Distinct(ctx, "reporter_id", bson.M{"post_id": postID, "status": ReportOpen, "reporter_id": bson.M{"$exists": true}})
+	if err != nil {
+		return 0, translate(err)
+	}
+
+	return int64(len(reporters)), nil
+}
+
+// ResolveByPostID closes every open report of a post with the moderator's action.
+func (r ReportModel) ResolveByPostID(ctx context.Context, postID, action, moderatorID string) error {
+
+	coll := r.collection("reports")
+
+	_, err := coll.UpdateMany(ctx, bson.M{"post_id": postID, "status": ReportOpen}, bson.M{"$set": bson.M{
+		"status":      ReportResolved,
+		"action":      action,
+		"resolved_by": moderatorID,
+		"resolved_at": time.Now(),
+	}})
+
+	return translate(err)
+}
+
+// DeleteByPostID deletes every report of a post and returns how many were deleted.
+func (r ReportModel) DeleteByPostID(ctx context.Context, postID string) (int64, error) {
+
+	coll := r.collection("reports")
+
+	result, err := coll.DeleteMany(ctx, bson.M{"post_id": postID})
+	if err != nil {
+		return 0, translate(err)
+	}
+
+	return result.DeletedCount, nil
+}
@@ -0,0 +1,384 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Index is an index of a collection, by its default name, that a query of a model
+// needs. Sparse indexes leave out the documents without the indexed fields, partial
+// indexes the documents that do not match their filter.
+type Index struct {
+	Collection string
+	Name       string
+	Keys       bson.D
+	Unique     bool
+	Sparse     bool
+	Partial    bson.M
+}
+
+func (i Index) String() string {
+
+	keys := make([]string, 0, len(i.Keys))
+	for _, key := range i.Keys {
+		keys = append(keys, fmt.Sprint(key.Key, ":", key.Value))
+	}
+
+	return i.Collection + "." + i.Name + " {" + strings.Join(keys, ", ") + "}"
+}
+
+// indexes are the indexes of the queries of the models.
+var indexes = []Index{
+	// PostModel.GetByUserID, GetAllByUserID and DeleteByUserID
+	{Collection: "posts", Name: "user_id_id", Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "_id", Value: 1}}},
+	// PostModel.GetByCategory
+	{Collection: "posts", Name: "category", Keys: bson.D{{Key: "category", Value: 1}}},
+	// PostModel.GetByTags
+	{Collection: "posts", Name: "tags", Keys: bson.D{{Key: "tags", Value: 1}}},
+	// PostModel.GetByPHashBands
+	{Collection: "posts", Name: "phash_bands", Keys: bson.D{{Key: "phash_bands", Value: 1}}},
+	// posts are imported once per external id.
+	{Collection: "posts", Name: "external_id", Keys: bson.D{{Key: "external_id", Value: 1}}, Unique: true, Sparse: true},
+	// ReportModel.GetOpen
+	{Collection: "reports", Name: "status_created_at", Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: 1}}},
+	// ReportModel.ResolveByPostID and DeleteByPostID
+	{Collection: "reports", Name: "post_id_status", Keys: bson.D{{Key: "post_id", Value: 1}, {Key: "status", Value: 1}}},
+	// ReportModel.CountOpenReporters, a user has one open report of a post.
+	{
+		Collection: "reports",
+		Name:       "post_id_reporter_id_open",
+		Keys:       bson.D{{Key: "post_id", Value: 1}, {Key: "reporter_id", Value: 1}},
+		Unique:     true,
+		Partial:    bson.M{"status": ReportOpen, "reporter_id": bson.M{"$exists": true}},
+	},
+	// FollowModel.GetFollowing
+	{Collection: "follows", Name: "follower_id", Keys: bson.D{{Key: "follower_id", Value: 1}}},
+	// UploadSessionModel.GetExpired
+	{Collection: "upload_sessions", Name: "status_expires_at", Keys: bson.D{{Key: "status", Value: 1}, {Key: "expires_at", Value: 1}}},
+	// JobModel.ClaimNext and CountQueued
+	{Collection: "jobs", Name: "status_created_at", Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: 1}}},
+	// JobModel.RequeueRunning
+	{Collection: "jobs", Name: "status_started_at", Keys: bson.D{{Key: "status", Value: 1}, {Key: "started_at", Value: 1}}},
+	// JobModel.GetExpired
+	{Collection: "jobs", Name: "expires_at", Keys: bson.D{{Key: "expires_at", Value: 1}}},
+}
+
+// Migration evolves the documents of the database from the previous version to
+// its version. A migration is never changed once released, a new one is added.
+// Up reaches collections by their default name through collection.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(ctx context.Context, collection func(name string) *mongo.Collection) error
+}
+
+// migrationLockTTL is how long the lock on the migrations is held when its owner
+// stops without releasing it, it is renewed every migrationLockRenewal while the
+// migrations are applied and before every migration.
+const (
+	migrationLockTTL     = 10 * time.Minute
+	migrationLockRenewal = migrationLockTTL / 3
+)
+
+// ErrMigrationLockLost is returned when the lock on the migrations expired and was
+// taken by another instance while migrations were applied.
+var ErrMigrationLockLost = errors.New("the lock on the migrations was lost")
+
+// Outcomes of an index or a migration in a SchemaChange.
+const (
+	SchemaExists  = "exists"
+	SchemaCreated = "created"
+	SchemaApplied = "applied"
+	SchemaPending = "pending"
+)
+
+// SchemaChange is the outcome of creating an index or applying a migration.
+type SchemaChange struct {
+	Kind      string    `json:"kind"`
+	Name      string    `json:"name"`
+	Version   int       `json:"version,omitempty"`
+	Status    string    `json:"status"`
+	AppliedAt time.Time `json:"applied_at,omitempty"`
+}
+
+// Schema creates the indexes the models need and applies migrations to the
+// documents of the database, the versions of the applied migrations are recorded
+// in the migrations collection.
+type Schema struct {
+	namespace
+}
+
+func NewSchema(client *mongo.Client, database string, collections Collections) *Schema {
+	return &Schema{namespace{client: client, database: database, collections: collections}}
+}
+
+// CreateIndexes creates the indexes that do not exist yet, when dryRun is set they
+// are only reported as pending.
+func (s Schema) CreateIndexes(ctx context.Context, dryRun bool) ([]SchemaChange, error) {
+
+	existing := map[string]bool{}
+
+	var changes []SchemaChange
+
+	for _, index := range indexes {
+		if _, ok := existing[index.Collection]; !ok {
+			names, err := indexNames(ctx, s.collection(index.Collection))
+			if err != nil {
+				return changes, err
+			}
+			for _, name := range names {
+				existing[index.Collection+"."+name] = true
+			}
+			existing[index.Collection] = true
+		}
+
+		change := SchemaChange{Kind: "index", Name: index.String(), Status: SchemaExists}
+
+		if !existing[index.Collection+"."+index.Name] {
+			change.Status = SchemaPending
+
+			if !dryRun {
+				opts := options.Index().SetName(index.Name).SetUnique(index.Unique).SetSparse(index.Sparse)
+				if index.Partial != nil {
+					opts.SetPartialFilterExpression(index.Partial)
+				}
+
+				model := mongo.IndexModel{Keys: index.Keys, Options: opts}
+
+				if _, err := s.collection(index.Collection).Indexes().CreateOne(ctx, model); err != nil {
+					return changes, translate(err)
+				}
+
+				change.Status = SchemaCreated
+			}
+		}
+
+		changes = append(changes, change)
+	}
+
+	return changes, nil
+}
+
+func indexNames(ctx context.Context, coll *mongo.Collection) ([]string, error) {
+
+	cursor, err := coll.Indexes().List(ctx)
+	if err != nil {
+		return nil, translate(err)
+	}
+
+	var specs []struct {
+		Name string `bson:"name"`
+	}
+
+	if err := cursor.All(ctx, &specs); err != nil {
+		return nil, translate(err)
+	}
+
+	names := make([]string, 0, len(specs))
+	for _, spec := range specs {
+		names = append(names, spec.Name)
+	}
+
+	return names, nil
+}
+
+// appliedMigration is the record of an applied migration.
+type appliedMigration struct {
+	Version   int       `bson:"_id"`
+	Name      string    `bson:"name"`
+	AppliedAt time.Time `bson:"applied_at"`
+}
+
+// Migrate applies the migrations that were not applied yet in order of their
+// version, when dryRun is set they are only reported as pending. Instances that
+// migrate at the same time take turns through a lock in the locks collection, so
+// every migration is applied once. The lock is renewed while the migrations are
+// applied, they are cancelled and ErrMigrationLockLost or the error that kept the
+// lock from being renewed is returned when it can not be.
+func (s Schema) Migrate(ctx context.Context, dryRun bool) ([]SchemaChange, error) {
+
+	if !dryRun {
+		owner := uuid.NewString()
+
+		if err := s.lock(ctx, owner); err != nil {
+			return nil, err
+		}
+
+		defer s.unlock(owner)
+
+		// a migration that outlasts the lock is cancelled once the lock can not be
+		// renewed, before another instance takes it.
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		lost := make(chan error, 1)
+		go func() { lost <- s.keepLock(ctx, owner, migrationLockRenewal, cancel) }()
+
+		changes, err := s.migrate(ctx, func() error { return s.renewLock(ctx, owner) })
+
+		cancel()
+		if lockErr := <-lost; lockErr != nil {
+			err = lockErr
+		}
+
+		return changes, err
+	}
+
+	return s.migrate(ctx, nil)
+}
+
+// migrate applies the pending migrations, calling renew before each of them, or
+// reports them as pending when renew is nil.
+func (s Schema) migrate(ctx context.Context, renew func() error) ([]SchemaChange, error) {
+
+	coll := s.collection("migrations")
+
+	cursor, err := coll.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, translate(err)
+	}
+
+	var records []appliedMigration
+
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, translate(err)
+	}
+
+	applied := make(map[int]appliedMigration, len(records))
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+
+	sorted := append([]Migration(nil), migrations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+
+	var changes []SchemaChange
+
+	for _, migration := range sorted {
+		change := SchemaChange{Kind: "migration", Name: migration.Name, Version: migration.Version, Status: SchemaPending}
+
+		if record, ok := applied[migration.Version]; ok {
+			change.Status, change.AppliedAt = SchemaApplied, record.AppliedAt
+			changes = append(changes, change)
+			continue
+		}
+
+		if renew == nil {
+			changes = append(changes, change)
+			continue
+		}
+
+		if err := renew(); err != nil {
+			return changes, err
+		}
+
+		if err := migration.Up(ctx, s.collection); err != nil {
+			return changes, fmt.Errorf("migration %v %v: %w", migration.Version, migration.Name, err)
+		}
+
+		record := appliedMigration{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now().UTC()}
+
+		if _, err := coll.InsertOne(ctx, record); err != nil {
+			return changes, translate(err)
+		}
+
+		change.Status, change.AppliedAt = SchemaApplied, record.AppliedAt
+		changes = append(changes, change)
+	}
+
+	return changes, nil
+}
+
+// lock waits until owner holds the lock on the migrations.
+func (s Schema) lock(ctx context.Context, owner string) error {
+
+	for {
+		locked, err := s.tryLock(ctx, owner)
+		if err != nil || locked {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return translate(ctx.Err())
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// tryLock takes or renews the lock on the migrations for owner, it reports false
+// when another owner holds it.
+func (s Schema) tryLock(ctx context.Context, owner string) (bool, error) {
+
+	coll := s.collection("locks")
+
+	now := time.Now().UTC()
+
+	filter := bson.M{"_id": "migrations", "$or": bson.A{bson.M{"owner": owner}, bson.M{"expires_at": bson.M{"$lt": now}}}}
+
+	update := bson.M{"$set": bson.M{"owner": owner, "expires_at": now.Add(migrationLockTTL)}}
+
+	// the upsert fails on the lock's _id while another owner holds it.
+	_, err := coll.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, translate(err)
+	}
+
+	return true, nil
+}
+
+// renewLock renews the lock of owner, it returns ErrMigrationLockLost when another
+// owner holds it.
+func (s Schema) renewLock(ctx context.Context, owner string) error {
+
+	locked, err := s.tryLock(ctx, owner)
+	if err == nil && !locked {
+		err = ErrMigrationLockLost
+	}
+
+	return err
+}
+
+// keepLock renews the lock of owner every interval until ctx is done. When the lock
+// can not be renewed it calls cancel and returns why.
+func (s Schema) keepLock(ctx context.Context, owner string, interval time.Duration, cancel func()) error {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		if err := s.renewLock(ctx, owner); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			cancel()
+			return err
+		}
+	}
+}
+
+func (s Schema) unlock(owner string) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, _ = s.collection("locks").DeleteOne(ctx, bson.M{"_id": "migrations", "owner": owner})
+}
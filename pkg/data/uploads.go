@@ -0,0 +1,140 @@
+package data
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	UploadOpen = "open"
+	// UploadCompleting is the status of a session claimed by the request completing
+	// it, the claim is released to open when the upload is refused.
+	UploadCompleting = "completing"
+	UploadCompleted  = "completed"
+	UploadAborted    = "aborted"
+	UploadExpired    = "expired"
+)
+
+// UploadSession tracks a resumable upload whose parts are staged as blocks of a
+// blob until the upload is completed. Parts maps the number of each received part
+// to its size.
+type UploadSession struct {
+	ID          string           `json:"id,omitempty" bson:"_id,omitempty"`
+	PostID      string           `json:"post_id,omitempty" bson:"post_id,omitempty"`
+	UserID      string           `json:"user_id,omitempty" bson:"user_id,omitempty"`
+	BlobKey     string           `json:"-" bson:"blob_key,omitempty"`
+	Size        int64            `json:"size,omitempty" bson:"size,omitempty"`
+	ContentType string           `json:"content_type,omitempty" bson:"content_type,omitempty"`
+	AltText     string           `json:"alt_text,omitempty" bson:"alt_text,omitempty"`
+	Parts       map[string]int64 `json:"parts" bson:"parts"`
+	Status      string           `json:"status,omitempty" bson:"status,omitempty"`
+	CreatedAt   time.Time        `json:"created_at,omitempty" bson:"created_at,omitempty"`
+	ExpiresAt   time.Time        `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+}
+
+type UploadSessionModel struct {
+	namespace
+}
+
+func NewUploadSessionModel(client *mongo.Client, database string) *UploadSessionModel {
+	return &UploadSessionModel{namespace{client: client, database: database}}
+}
+
+func (u UploadSessionModel) Create(ctx context.Context, session *UploadSession) error {
+
+	coll := u.collection("upload_sessions")
+
+	if session.ID == "" {
+		session.ID = uuid.NewString()
+	}
+
+	session.Status, session.Parts, session.CreatedAt = UploadOpen, map[string]int64{}, time.Now()
+
+	result, err := coll.InsertOne(ctx, session)
+	if err != nil {
+		return translate(err)
+	}
+
+	if id, ok := result.InsertedID.(string); !ok || id != session.ID {
+		return ErrCreateDocument
+	}
+
+	return nil
+}
+
+func (u UploadSessionModel) GetByID(ctx context.Context, id string) (*UploadSession, error) {
+
+	coll := u.collection("upload_sessions")
+
+	var session UploadSession
+
+	if err := coll.FindOne(ctx, bson.M{"_id": id}).Decode(&session); err != nil {
+		return nil, translate(err)
+	}
+
+	return &session, nil
+}
+
+// AddPart records that part of an open session was received, receiving a part again
+// replaces its size.
+func (u UploadSessionModel) AddPart(ctx context.Context, id string, part int, size int64) error {
+
+	coll := u.collection("upload_sessions")
+
+	result, err := coll.UpdateOne(ctx, bson.M{"_id": id, "status": UploadOpen}, bson.M{"$set": bson.M{"parts." + strconv.Itoa(part): size}})
+	if err != nil {
+		return translate(err)
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrNoDocument
+	}
+
+	return nil
+}
+
+// SetStatus moves a session in status from to status to, it fails with ErrNoDocument
+// when the session is not in status from. A session is only moved once from a
+// status, so moving it from open claims it.
+func (u UploadSessionModel) SetStatus(ctx context.Context, id, from, to string) error {
+
+	coll := u.collection("upload_sessions")
+
+	result, err := coll.UpdateOne(ctx, bson.M{"_id": id, "status": from}, bson.M{"$set": bson.M{"status": to}})
+	if err != nil {
+		return translate(err)
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrNoDocument
+	}
+
+	return nil
+}
+
+// GetExpired returns open sessions that expired before t.
+func (u UploadSessionModel) GetExpired(ctx context.Context, t time.Time, limit int64) (*[]UploadSession, error) {
+
+	opts := options.Find().SetLimit(limit)
+
+	coll := u.collection("upload_sessions")
+
+	filterCursor, err := coll.Find(ctx, bson.M{"status": UploadOpen, "expires_at": bson.M{"$lt": t}}, opts)
+	if err != nil {
+		return nil, translate(err)
+	}
+
+	var sessions []UploadSession
+
+	if err := filterCursor.All(ctx, &sessions); err != nil {
+		return nil, translate(err)
+	}
+
+	return &sessions, nil
+}
@@ -0,0 +1,76 @@
+package data
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Visibility of a post, posts without a visibility are public.
+const (
+	VisibilityPublic    = "public"
+	VisibilityFollowers = "followers"
+	VisibilityUnlisted  = "unlisted"
+	VisibilityPrivate   = "private"
+)
+
+func ValidVisibility(visibility string) bool {
+	switch visibility {
+	case "", VisibilityPublic, VisibilityFollowers, VisibilityUnlisted, VisibilityPrivate:
+		return true
+	}
+	return false
+}
+
+// Viewer is the caller on whose behalf posts are read. The zero Viewer is an
+// anonymous caller that only sees public posts.
+type Viewer struct {
+	UserID string
+	// Following holds the ids of the users the viewer follows.
+	Following []string
+	// Moderator viewers see every post regardless of its visibility.
+	Moderator bool
+}
+
+type viewerKey struct{}
+
+// ContextWithViewer returns a copy of ctx carrying viewer, PostModel read paths
+// only return the posts the viewer in their context is allowed to see.
+func ContextWithViewer(ctx context.Context, viewer Viewer) context.Context {
+	return context.WithValue(ctx, viewerKey{}, viewer)
+}
+
+func ViewerFromContext(ctx context.Context) Viewer {
+	viewer, _ := ctx.Value(viewerKey{}).(Viewer)
+	return viewer
+}
+
+// visibilityFilter restricts filter to the posts the viewer in ctx can see, the
+// visibilities listed in open are visible to everyone.
+func visibilityFilter(ctx context.Context, filter bson.M, open ...string) bson.M {
+
+	viewer := ViewerFromContext(ctx)
+
+	if viewer.Moderator {
+		return filter
+	}
+
+	visible := bson.A{nil, VisibilityPublic}
+	for _, v := range open {
+		visible = append(visible, v)
+	}
+
+	or := bson.A{bson.M{"visibility": bson.M{"$in": visible}}}
+
+	if viewer.UserID != "" {
+		or = append(or, bson.M{"user_id": viewer.UserID})
+	}
+
+	if len(viewer.Following) > 0 {
+		or = append(or, bson.M{"visibility": VisibilityFollowers, "user_id": bson.M{"$in": viewer.Following}})
+	}
+
+	filter["$or"] = or
+
+	return filter
+}
@@ -0,0 +1,203 @@
+// Package lifecycle runs the server and background workers of the application and
+// shuts them down in order when the process is asked to stop.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+type Logger interface {
+	Info(args string, fields map[string]interface{})
+
+	Error(args string, fields map[string]interface{})
+}
+
+type hook struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+// Manager shuts the application down in three steps: it drains the servers
+// registered with OnShutdown, stops the workers started with Go and waits for them
+// to return, then closes the clients registered with OnClose in the reverse order
+// of their registration.
+type Manager struct {
+	logger Logger
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	servers []hook
+	closers []hook
+}
+
+func New(logger Logger) *Manager {
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Manager{logger: logger, ctx: ctx, cancel: cancel}
+}
+
+// Context returns the context of the workers, it is done once shutdown begins
+// stopping them.
+func (m *Manager) Context() context.Context {
+	return m.ctx
+}
+
+// Go runs fn in a new goroutine, its context is done when the workers are stopped
+// and shutdown waits for fn to return.
+func (m *Manager) Go(name string, fn func(ctx context.Context)) {
+
+	m.wg.Add(1)
+
+	go func() {
+		defer m.wg.Done()
+
+		fn(m.ctx)
+
+		m.logger.Info("worker stopped", map[string]interface{}{"worker": name})
+	}()
+}
+
+// OnShutdown registers fn to drain a server before the workers are stopped.
+func (m *Manager) OnShutdown(name string, fn func(ctx context.Context) error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.servers = append(m.servers, hook{name: name, fn: fn})
+}
+
+// OnClose registers fn to close a client once the servers and workers are stopped.
+func (m *Manager) OnClose(name string, fn func(ctx context.Context) error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.closers = append(m.closers, hook{name: name, fn: fn})
+}
+
+// Run calls serve and blocks until it returns or the process receives SIGINT or
+// SIGTERM, then shuts the application down within timeout. It returns the error
+// serve failed with and the errors of the shutdown.
+func (m *Manager) Run(serve func() error, timeout time.Duration) error {
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	served := make(chan error, 1)
+
+	go func() { served <- serve() }()
+
+	var err error
+
+	select {
+	case sig := <-signals:
+		m.logger.Info("received signal, shutting down", map[string]interface{}{"signal": sig.String()})
+	case err = <-served:
+		if err != nil {
+			m.logger.Error("server failed, shutting down", map[string]interface{}{"error": err.Error()})
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if shutdownErr := m.Shutdown(ctx); shutdownErr != nil {
+		return join([]error{err, shutdownErr})
+	}
+
+	return err
+}
+
+// Shutdown drains the servers, stops the workers and closes the clients, it gives
+// up waiting on the workers once ctx is done.
+func (m *Manager) Shutdown(ctx context.Context) error {
+
+	m.mu.Lock()
+	servers, closers := m.servers, m.closers
+	m.mu.Unlock()
+
+	var errs []error
+
+	for _, h := range servers {
+		errs = append(errs, m.run(ctx, "server", h))
+	}
+
+	m.cancel()
+
+	stopped := make(chan struct{})
+
+	go func() {
+		m.wg.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		errs = append(errs, errors.New("lifecycle: timed out waiting for workers to stop"))
+	}
+
+	for i := len(closers) - 1; i >= 0; i-- {
+		errs = append(errs, m.run(ctx, "client", closers[i]))
+	}
+
+	return join(errs)
+}
+
+func (m *Manager) run(ctx context.Context, kind string, h hook) error {
+
+	if err := h.fn(ctx); err != nil {
+		m.logger.Error("failed to stop "+kind, map[string]interface{}{kind: h.name, "error": err.Error()})
+		return err
+	}
+
+	m.logger.Info("stopped "+kind, map[string]interface{}{kind: h.name})
+
+	return nil
+}
+
+// Errors holds the errors of a shutdown, in the order they occurred.
+type Errors []error
+
+func (e Errors) Error() string {
+
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+
+	return strings.Join(msgs, "; ")
+}
+
+// join returns the non-nil errors of errs as Errors, or nil when there are none.
+func join(errs []error) error {
+
+	var joined Errors
+
+	for _, err := range errs {
+		var nested Errors
+		switch {
+		case err == nil:
+		case errors.As(err, &nested):
+			joined = append(joined, nested...)
+		default:
+			joined = append(joined, err)
+		}
+	}
+
+	if len(joined) == 0 {
+		return nil
+	}
+
+	return joined
+}
@@ -0,0 +1,146 @@
+package lifecycle_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/evansopilo/visuai/pkg/lifecycle"
+)
+
+type nopLogger struct{}
+
+func (nopLogger) Info(args string, fields map[string]interface{}) {}
+
+func (nopLogger) Error(args string, fields map[string]interface{}) {}
+
+// recorder records the order in which the hooks of a shutdown ran.
+type recorder struct {
+	mu    sync.Mutex
+	steps []string
+}
+
+func (r *recorder) hook(step string, err error) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		r.add(step)
+		return err
+	}
+}
+
+func (r *recorder) add(step string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.steps = append(r.steps, step)
+}
+
+func (r *recorder) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return strings.Join(r.steps, ",")
+}
+
+func TestShutdownOrder(t *testing.T) {
+
+	var r recorder
+
+	m := lifecycle.New(nopLogger{})
+
+	m.OnClose("mongodb", r.hook("close mongodb", nil))
+	m.OnShutdown("api", r.hook("drain api", nil))
+	m.OnClose("tracing", r.hook("close tracing", nil))
+	m.OnShutdown("metrics", r.hook("drain metrics", nil))
+
+	m.Go("worker", func(ctx context.Context) {
+		<-ctx.Done()
+		r.add("stop worker")
+	})
+
+	if err := m.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	want := "drain api,drain metrics,stop worker,close tracing,close mongodb"
+	if got := r.String(); got != want {
+		t.Errorf("shutdown steps = %v, want %v", got, want)
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+
+	var r recorder
+
+	m := lifecycle.New(nopLogger{})
+
+	errClose := errors.New("close failed")
+
+	m.OnClose("mongodb", r.hook("close mongodb", errClose))
+
+	release := make(chan struct{})
+	defer close(release)
+
+	// the worker ignores its context, so shutdown gives up waiting on it.
+	m.Go("stuck", func(ctx context.Context) { <-release })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	err := m.Shutdown(ctx)
+
+	if got := r.String(); got != "close mongodb" {
+		t.Errorf("shutdown steps = %v, want the clients closed after the timeout", got)
+	}
+
+	var errs lifecycle.Errors
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Fatalf("Shutdown = %v, want the timeout and the close error", err)
+	}
+
+	if !strings.Contains(errs[0].Error(), "timed out waiting for workers") || errs[1] != errClose {
+		t.Errorf("Shutdown = %v, want the timeout followed by the close error", err)
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+
+	m := lifecycle.New(nopLogger{})
+
+	errServe, errDrain, errClose := errors.New("serve failed"), errors.New("drain failed"), errors.New("close failed")
+
+	m.OnShutdown("api", func(ctx context.Context) error { return errDrain })
+	m.OnClose("mongodb", func(ctx context.Context) error { return nil })
+	m.OnClose("tracing", func(ctx context.Context) error { return errClose })
+
+	err := m.Run(func() error { return errServe }, time.Second)
+
+	// the errors of the shutdown are flattened into the errors of the run, without
+	// those of the hooks that succeeded.
+	var errs lifecycle.Errors
+	if !errors.As(err, &errs) {
+		t.Fatalf("Run = %v, want Errors", err)
+	}
+
+	want := lifecycle.Errors{errServe, errDrain, errClose}
+
+	if len(errs) != len(want) {
+		t.Fatalf("Run = %v, want %v", err, want)
+	}
+
+	for i := range want {
+		if errs[i] != want[i] {
+			t.Errorf("Run = %v, want %v", err, want)
+		}
+	}
+
+	if got := err.Error(); got != "serve failed; drain failed; close failed" {
+		t.Errorf("Error = %q, want the errors joined in order", got)
+	}
+
+	if err := lifecycle.New(nopLogger{}).Run(func() error { return nil }, time.Second); err != nil {
+		t.Errorf("Run of a server that stopped cleanly = %v, want nil", err)
+	}
+}
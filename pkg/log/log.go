@@ -1,13 +1,20 @@
 package log
 
 import (
+	"context"
 	"io"
+	"os"
+	"strings"
+	"sync/atomic"
 
 	"github.com/sirupsen/logrus"
 )
 
 type Logger struct {
-	logger *logrus.Logger
+	entry *logrus.Entry
+	// sampler logs one in every rate entries below the warn level, it is shared by
+	// the children of the logger.
+	sampler *sampler
 }
 
 // formatter can be json or text with default as "json", out can be anything that implements io.Writter interface
@@ -16,41 +23,147 @@ func New(formatter string, out io.Writer, logLevel int) *Logger {
 
 	var log = logrus.New()
 
-	if formatter != "json" {
+	if formatter == "text" {
+		log.SetFormatter(&logrus.TextFormatter{})
+	} else {
 		log.SetFormatter(&logrus.JSONFormatter{})
 	}
 
+	if out == nil {
+		out = os.Stderr
+	}
+
+	log.SetOutput(out)
+
 	if logLevel != -1 {
 		log.Level = logrus.Level(logLevel)
 	}
 
-	return &Logger{logger: log}
+	log.AddHook(redactHook{})
+
+	return &Logger{entry: logrus.NewEntry(log), sampler: &sampler{rate: 1}}
+}
+
+// ParseLevel returns the level New takes for the name of a level, from panic to
+// trace.
+func ParseLevel(name string) (int, error) {
+
+	level, err := logrus.ParseLevel(name)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(level), nil
+}
+
+// With returns a child logger adding fields to every entry it logs.
+func (l *Logger) With(fields map[string]interface{}) *Logger {
+	return &Logger{entry: l.entry.WithFields(fields), sampler: l.sampler}
+}
+
+// WithSampling returns a copy of the logger that only logs one in every rate entries
+// below the warn level, warnings and errors are always logged.
+func (l *Logger) WithSampling(rate int) *Logger {
+
+	if rate < 1 {
+		rate = 1
+	}
+
+	return &Logger{entry: l.entry, sampler: &sampler{rate: uint64(rate)}}
+}
+
+func (l *Logger) Trace(args string, fields map[string]interface{}) {
+	if l.sampler.sample() {
+		l.entry.WithFields(fields).Trace(args)
+	}
+}
+
+func (l *Logger) Debug(args string, fields map[string]interface{}) {
+	if l.sampler.sample() {
+		l.entry.WithFields(fields).Debug(args)
+	}
+}
+
+func (l *Logger) Info(args string, fields map[string]interface{}) {
+	if l.sampler.sample() {
+		l.entry.WithFields(fields).Info(args)
+	}
+}
+
+func (l *Logger) Warn(args string, fields map[string]interface{}) {
+	l.entry.WithFields(fields).Warn(args)
+}
+
+func (l *Logger) Error(args string, fields map[string]interface{}) {
+	l.entry.WithFields(fields).Error(args)
 }
 
-func (l Logger) Trace(args string, fields map[string]interface{}) {
-	l.logger.WithFields(fields).Trace(args)
+func (l *Logger) Fatal(msg string, fields map[string]interface{}) {
+	l.entry.WithFields(fields).Fatal(msg)
 }
 
-func (l Logger) Debug(args string, fields map[string]interface{}) {
-	l.logger.WithFields(fields).Debug(args)
+func (l *Logger) Panic(args string, fields map[string]interface{}) {
+	l.entry.WithFields(fields).Panic(args)
 }
 
-func (l Logger) Info(args string, fields map[string]interface{}) {
-	l.logger.WithFields(fields).Info(args)
+type sampler struct {
+	rate  uint64
+	count uint64
 }
 
-func (l Logger) Warn(args string, fields map[string]interface{}) {
-	l.logger.WithFields(fields).Warn(args)
+func (s *sampler) sample() bool {
+	return s.rate <= 1 || atomic.AddUint64(&s.count, 1)%s.rate == 1
 }
 
-func (l Logger) Error(args string, fields map[string]interface{}) {
-	l.logger.WithFields(fields).Error(args)
+type contextKey struct{}
+
+var defaultLogger = New("json", os.Stderr, -1)
+
+// NewContext returns a copy of ctx carrying l.
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
 }
 
-func (l Logger) Fatal(msg string, fields map[string]interface{}) {
-	l.logger.WithFields(fields).Fatal(msg)
+// FromContext returns the logger ctx carries, or a default logger writing json to
+// stderr when it carries none.
+func FromContext(ctx context.Context) *Logger {
+
+	if l, ok := ctx.Value(contextKey{}).(*Logger); ok {
+		return l
+	}
+
+	return defaultLogger
 }
 
-func (l Logger) Panic(args string, fields map[string]interface{}) {
-	l.logger.WithFields(fields).Panic(args)
+// sensitive holds the parts of field names whose values are never logged.
+var sensitive = []string{"password", "secret", "token", "authorization", "cookie", "signature", "api_key", "account_key", "signing_key", "private_key"}
+
+// redactHook replaces the values of sensitive fields before an entry is written.
+type redactHook struct{}
+
+func (redactHook) Levels() []logrus.Level { return logrus.AllLevels }
+
+func (redactHook) Fire(entry *logrus.Entry) error {
+
+	for name := range entry.Data {
+		if Sensitive(name) {
+			entry.Data[name] = "[REDACTED]"
+		}
+	}
+
+	return nil
+}
+
+// Sensitive reports whether the values of the field name are redacted.
+func Sensitive(name string) bool {
+
+	name = strings.ToLower(name)
+
+	for _, s := range sensitive {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+
+	return false
 }
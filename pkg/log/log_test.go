@@ -0,0 +1,195 @@
+package log_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/evansopilo/visuai/pkg/log"
+	"github.com/gofiber/fiber/v2"
+)
+
+// entries decodes the json entries written to buf.
+func entries(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+
+	t.Helper()
+
+	var out []map[string]interface{}
+
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("decode entry %q: %v", line, err)
+		}
+
+		out = append(out, entry)
+	}
+
+	return out
+}
+
+func TestNewFormatter(t *testing.T) {
+
+	tests := []struct {
+		formatter string
+		want      string
+	}{
+		{"json", `"msg":"hello"`},
+		{"", `"msg":"hello"`},
+		{"text", `msg=hello`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.formatter, func(t *testing.T) {
+			var buf bytes.Buffer
+
+			log.New(tt.formatter, &buf, -1).Info("hello", map[string]interface{}{"user_id": "alice"})
+
+			if out := buf.String(); !strings.Contains(out, tt.want) || !strings.Contains(out, "alice") {
+				t.Errorf("entry = %q, want it to contain %q and its fields", out, tt.want)
+			}
+		})
+	}
+}
+
+func TestLevel(t *testing.T) {
+
+	level, err := log.ParseLevel("warn")
+	if err != nil {
+		t.Fatalf("ParseLevel: %v", err)
+	}
+
+	var buf bytes.Buffer
+
+	logger := log.New("json", &buf, level)
+	logger.Info("dropped", nil)
+	logger.Warn("kept", nil)
+
+	if got := entries(t, &buf); len(got) != 1 || got[0]["msg"] != "kept" {
+		t.Errorf("entries = %v, want only the warning", got)
+	}
+
+	if _, err := log.ParseLevel("loud"); err == nil {
+		t.Errorf("ParseLevel of an unknown level = nil, want an error")
+	}
+}
+
+func TestWithSampling(t *testing.T) {
+
+	tests := []struct {
+		rate  int
+		infos int
+		want  int
+	}{
+		{0, 6, 6},
+		{1, 6, 6},
+		{3, 6, 2},
+		{3, 7, 3},
+		{10, 5, 1},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+
+		logger := log.New("json", &buf, -1).WithSampling(tt.rate)
+
+		for i := 0; i < tt.infos; i++ {
+			logger.Info("sampled", nil)
+		}
+
+		// warnings and errors are always written.
+		logger.Warn("warning", nil)
+		logger.Error("error", nil)
+
+		if got := len(entries(t, &buf)); got != tt.want+2 {
+			t.Errorf("rate %v: %v of %v entries written, want %v", tt.rate, got, tt.infos+2, tt.want+2)
+		}
+	}
+}
+
+func TestRedaction(t *testing.T) {
+
+	var buf bytes.Buffer
+
+	log.New("json", &buf, -1).With(map[string]interface{}{"Authorization": "Bearer abc"}).Info("request", map[string]interface{}{
+		"share_token": "tok",
+		"signature":   "sig",
+		"user_id":     "alice",
+	})
+
+	got := entries(t, &buf)[0]
+
+	for _, name := range []string{"Authorization", "share_token", "signature"} {
+		if got[name] != "[REDACTED]" {
+			t.Errorf("%v = %v, want it redacted", name, got[name])
+		}
+	}
+
+	if got["user_id"] != "alice" {
+		t.Errorf("user_id = %v, want alice", got["user_id"])
+	}
+}
+
+func TestSensitive(t *testing.T) {
+
+	for name, want := range map[string]bool{
+		"password":      true,
+		"DB_PASSWORD":   true,
+		"token":         true,
+		"signing_key":   true,
+		"Cookie":        true,
+		"user_id":       false,
+		"path":          false,
+		"post_id":       false,
+		"content_type":  false,
+		"expires_at":    false,
+		"account_name":  false,
+		"authorization": true,
+	} {
+		if got := log.Sensitive(name); got != want {
+			t.Errorf("Sensitive(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+
+	var buf bytes.Buffer
+
+	app := fiber.New()
+	app.Use(log.Middleware(log.New("json", &buf, -1), 1))
+	app.Get("/v1/api/shared/:token", func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) })
+	app.Get("/v1/api/posts/:post_id", func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) })
+
+	tests := []struct {
+		target string
+		path   string
+		route  string
+	}{
+		{"/v1/api/shared/secret-token", "/v1/api/shared/[REDACTED]", "/v1/api/shared/:token"},
+		{"/v1/api/posts/post-1?include=media", "/v1/api/posts/post-1", "/v1/api/posts/:post_id"},
+	}
+
+	for _, tt := range tests {
+		buf.Reset()
+
+		if _, err := app.Test(httptest.NewRequest("GET", tt.target, nil)); err != nil {
+			t.Fatalf("request %v: %v", tt.target, err)
+		}
+
+		got := entries(t, &buf)
+		if len(got) != 1 {
+			t.Fatalf("entries of %v = %v, want 1", tt.target, got)
+		}
+
+		if got[0]["path"] != tt.path || got[0]["route"] != tt.route || got[0]["status"] != float64(fiber.StatusOK) {
+			t.Errorf("entry of %v = %v, want path %v and route %v", tt.target, got[0], tt.path, tt.route)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package log
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Middleware sets a child of l carrying the request and trace ids of each request in
+// its user context, for handlers to log with FromContext, and writes an access log
+// entry once the request is handled. Only one in every sampleRate access log entries
+// of successful requests is written.
+func Middleware(l *Logger, sampleRate int) fiber.Handler {
+
+	if l == nil {
+		l = defaultLogger
+	}
+
+	access := l.WithSampling(sampleRate)
+
+	return func(c *fiber.Ctx) error {
+
+		start := time.Now()
+
+		fields := map[string]interface{}{"requestid": c.Locals("requestid")}
+
+		if sc := trace.SpanContextFromContext(c.UserContext()); sc.HasTraceID() {
+			fields["traceid"] = sc.TraceID().String()
+		}
+
+		c.SetUserContext(NewContext(c.UserContext(), l.With(fields)))
+
+		err := c.Next()
+
+		status := c.Response().StatusCode()
+
+		var e *fiber.Error
+		if errors.As(err, &e) {
+			status = e.Code
+		} else if err != nil {
+			status = fiber.StatusInternalServerError
+		}
+
+		entry := map[string]interface{}{
+			"method":     c.Method(),
+			"path":       RedactedPath(c),
+			"route":      c.Route().Path,
+			"status":     status,
+			"latency_ms": float64(time.Since(start).Microseconds()) / 1000,
+			"bytes":      len(c.Response().Body()),
+			"ip":         c.IP(),
+		}
+
+		if status >= fiber.StatusInternalServerError {
+			l.With(fields).Error("request handled", entry)
+		} else {
+			access.With(fields).Info("request handled", entry)
+		}
+
+		return err
+	}
+}
+
+// RedactedPath returns the path of the request with the values of its sensitive
+// route parameters, such as the token of a share link, redacted. It is the path of
+// the request once its route is matched.
+func RedactedPath(c *fiber.Ctx) string {
+
+	path := c.Path()
+
+	for _, name := range c.Route().Params {
+		if value := c.Params(name); value != "" && Sensitive(name) {
+			path = strings.Replace(path, value, "[REDACTED]", 1)
+		}
+	}
+
+	return path
+}
@@ -0,0 +1,126 @@
+package media
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+
+	_ "image/gif"
+	_ "image/png"
+)
+
+// DecodeImage decodes a gif, jpeg or png encoded image.
+func DecodeImage(data []byte) (image.Image, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	return img, err
+}
+
+// Fit returns the dimensions of a width by height frame scaled down so that neither
+// side exceeds max. Frames without an area are returned as they are.
+func Fit(width, height, max int) (int, int) {
+
+	if width <= 0 || height <= 0 || width <= max && height <= max {
+		return width, height
+	}
+
+	w, h := max, height*max/width
+	if height > width {
+		w, h = width*max/height, max
+	}
+	if w == 0 {
+		w = 1
+	}
+	if h == 0 {
+		h = 1
+	}
+
+	return w, h
+}
+
+// Thumbnail scales img down with a box filter so that neither side exceeds max,
+// images that already fit are returned unchanged.
+func Thumbnail(img image.Image, max int) image.Image {
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	if w <= max && h <= max {
+		return img
+	}
+
+	tw, th := Fit(w, h, max)
+
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+
+	for y := 0; y < th; y++ {
+		y0, y1 := b.Min.Y+y*h/th, b.Min.Y+(y+1)*h/th
+		for x := 0; x < tw; x++ {
+			x0, x1 := b.Min.X+x*w/tw, b.Min.X+(x+1)*w/tw
+
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, bl, a, n = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca), n+1
+				}
+			}
+
+			dst.Set(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(bl / n), A: uint16(a / n)})
+		}
+	}
+
+	return dst
+}
+
+func EncodeJPEG(img image.Image) ([]byte, error) {
+
+	var buf bytes.Buffer
+
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85}); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Placeholder returns a poster image of width by height for media whose frames can
+// not be decoded, a dark frame with a play symbol in its centre.
+func Placeholder(width, height int) image.Image {
+
+	if width <= 0 || height <= 0 {
+		width, height = 16, 9
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	background, symbol := color.RGBA{R: 0x20, G: 0x20, B: 0x20, A: 0xFF}, color.RGBA{R: 0xE0, G: 0xE0, B: 0xE0, A: 0xFF}
+
+	size := height / 4
+	if width < height {
+		size = width / 4
+	}
+
+	cx, cy := width/2, height/2
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, background)
+
+			// a right pointing triangle narrowing from its left edge to its tip.
+			dx, dy := x-(cx-size/2), y-cy
+			if dx >= 0 && dx <= size && 2*abs(dy) <= size-dx {
+				img.Set(x, y, symbol)
+			}
+		}
+	}
+
+	return img
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,321 @@
+package media_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/evansopilo/visuai/pkg/media"
+)
+
+func encodePNG(t testing.TB, width, height int) []byte {
+
+	var buf bytes.Buffer
+
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+
+	return buf.Bytes()
+}
+
+func encodeJPEG(t testing.TB, width, height int) []byte {
+
+	var buf bytes.Buffer
+
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height)), nil); err != nil {
+		t.Fatalf("encode jpeg: %v", err)
+	}
+
+	return buf.Bytes()
+}
+
+// encodeGIF encodes frames frames of width by height, each shown for 100ms.
+func encodeGIF(t testing.TB, width, height, frames int) []byte {
+
+	palette := color.Palette{color.Black, color.White}
+
+	g := &gif.GIF{}
+	for i := 0; i < frames; i++ {
+		g.Image = append(g.Image, image.NewPaletted(image.Rect(0, 0, width, height), palette))
+		g.Delay = append(g.Delay, 10)
+	}
+
+	var buf bytes.Buffer
+
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		t.Fatalf("encode gif: %v", err)
+	}
+
+	return buf.Bytes()
+}
+
+// mp4Box returns an iso base media file format box of typ holding payload.
+func mp4Box(typ string, payload ...[]byte) []byte {
+
+	body := bytes.Join(payload, nil)
+
+	b := make([]byte, 8, 8+len(body))
+	binary.BigEndian.PutUint32(b, uint32(8+len(body)))
+	copy(b[4:], typ)
+
+	return append(b, body...)
+}
+
+// encodeMP4 returns the boxes of an mp4 file lasting seconds with a track handled
+// by handler, whose frames are width by height and encoded with codec.
+func encodeMP4(seconds uint32, handler, codec string, width, height uint32) []byte {
+
+	mvhd := make([]byte, 20)
+	binary.BigEndian.PutUint32(mvhd[12:], 1000)
+	binary.BigEndian.PutUint32(mvhd[16:], seconds*1000)
+
+	tkhd := make([]byte, 84)
+	binary.BigEndian.PutUint32(tkhd[76:], width<<16)
+	binary.BigEndian.PutUint32(tkhd[80:], height<<16)
+
+	hdlr := make([]byte, 24)
+	copy(hdlr[8:], handler)
+
+	stsd := make([]byte, 16)
+	copy(stsd[12:], codec)
+
+	return append(
+		mp4Box("ftyp", []byte("isom\x00\x00\x02\x00isom")),
+		mp4Box("moov",
+			mp4Box("mvhd", mvhd),
+			mp4Box("trak",
+				mp4Box("tkhd", tkhd),
+				mp4Box("mdia",
+					mp4Box("hdlr", hdlr),
+					mp4Box("minf", mp4Box("stbl", mp4Box("stsd", stsd)))),
+			),
+		)...,
+	)
+}
+
+// ebml returns an ebml element of id holding payload.
+func ebml(id uint32, payload ...[]byte) []byte {
+
+	body := bytes.Join(payload, nil)
+
+	var b []byte
+	for shift := 24; shift >= 0; shift -= 8 {
+		if octet := byte(id >> shift); octet != 0 || len(b) > 0 {
+			b = append(b, octet)
+		}
+	}
+
+	// a two byte size.
+	b = append(b, 0x40|byte(len(body)>>8), byte(len(body)))
+
+	return append(b, body...)
+}
+
+func ebmlUint(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+// encodeWebM returns the header, info and tracks of a webm file of docType lasting
+// seconds with a video track whose frames are width by height.
+func encodeWebM(docType string, seconds float64, width, height uint64) []byte {
+
+	duration := make([]byte, 8)
+	binary.BigEndian.PutUint64(duration, math.Float64bits(seconds*1000))
+
+	return append(
+		ebml(0x1A45DFA3, ebml(0x4282, []byte(docType))),
+		ebml(0x18538067,
+			ebml(0x1549A966, ebml(0x2AD7B1, ebmlUint(1000000)), ebml(0x4489, duration)),
+			ebml(0x1654AE6B,
+				ebml(0xAE, ebml(0x83, ebmlUint(2)), ebml(0x86, []byte("A_OPUS"))),
+				ebml(0xAE,
+					ebml(0x83, ebmlUint(1)),
+					ebml(0x86, []byte("V_VP9")),
+					ebml(0xE0, ebml(0xB0, ebmlUint(width)), ebml(0xBA, ebmlUint(height))),
+				),
+			),
+		)...,
+	)
+}
+
+func TestProbe(t *testing.T) {
+
+	tests := []struct {
+		name string
+		data []byte
+		want media.Info
+		err  bool
+	}{
+		{
+			name: "png",
+			data: encodePNG(t, 40, 30),
+			want: media.Info{Kind: media.KindImage, ContentType: "image/png", Width: 40, Height: 30},
+		},
+		{
+			name: "jpeg",
+			data: encodeJPEG(t, 30, 40),
+			want: media.Info{Kind: media.KindImage, ContentType: "image/jpeg", Width: 30, Height: 40},
+		},
+		{
+			name: "gif",
+			data: encodeGIF(t, 20, 10, 1),
+			want: media.Info{Kind: media.KindImage, ContentType: "image/gif", Width: 20, Height: 10, Codec: "gif"},
+		},
+		{
+			name: "animated gif",
+			data: encodeGIF(t, 20, 10, 3),
+			want: media.Info{Kind: media.KindAnimation, ContentType: "image/gif", Width: 20, Height: 10, Duration: 300 * time.Millisecond, Codec: "gif"},
+		},
+		{
+			name: "mp4",
+			data: encodeMP4(5, "vide", "avc1", 640, 360),
+			want: media.Info{Kind: media.KindVideo, ContentType: "video/mp4", Width: 640, Height: 360, Duration: 5 * time.Second, Codec: "avc1"},
+		},
+		{
+			name: "mp4 without a video track",
+			data: encodeMP4(5, "soun", "mp4a", 0, 0),
+			want: media.Info{Kind: media.KindVideo, ContentType: "video/mp4", Duration: 5 * time.Second},
+		},
+		{
+			name: "mp4 without a movie box",
+			data: mp4Box("ftyp", []byte("isom\x00\x00\x02\x00isom")),
+			err:  true,
+		},
+		{
+			name: "truncated mp4",
+			data: encodeMP4(5, "vide", "avc1", 640, 360)[:60],
+			err:  true,
+		},
+		{
+			name: "webm",
+			data: encodeWebM("webm", 2.5, 320, 240),
+			want: media.Info{Kind: media.KindVideo, ContentType: "video/webm", Width: 320, Height: 240, Duration: 2500 * time.Millisecond, Codec: "V_VP9"},
+		},
+		{
+			name: "matroska",
+			data: encodeWebM("matroska", 1, 320, 240),
+			want: media.Info{Kind: media.KindVideo, ContentType: "video/webm", Width: 320, Height: 240, Duration: time.Second, Codec: "V_VP9"},
+		},
+		{
+			name: "text",
+			data: []byte("hello, world"),
+			err:  true,
+		},
+		{
+			name: "empty",
+			err:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := media.Probe(tt.data)
+
+			if tt.err {
+				if err == nil {
+					t.Fatalf("Probe = %+v, want an error", info)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Probe: %v", err)
+			}
+
+			if info != tt.want {
+				t.Errorf("Probe = %+v, want %+v", info, tt.want)
+			}
+		})
+	}
+}
+
+func TestProbeUnsupported(t *testing.T) {
+
+	if _, err := media.Probe([]byte("hello, world")); !errors.Is(err, media.ErrUnsupported) {
+		t.Errorf("Probe of text = %v, want ErrUnsupported", err)
+	}
+}
+
+func TestFit(t *testing.T) {
+
+	tests := []struct {
+		name          string
+		width, height int
+		max           int
+		w, h          int
+	}{
+		{"within", 100, 50, 200, 100, 50},
+		{"exact", 200, 200, 200, 200, 200},
+		{"wide", 400, 200, 200, 200, 100},
+		{"tall", 200, 400, 200, 100, 200},
+		{"sliver", 10000, 1, 100, 100, 1},
+		{"zero width", 0, 400, 200, 0, 400},
+		{"zero height", 400, 0, 200, 400, 0},
+		{"negative", -400, 300, 200, -400, 300},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w, h := media.Fit(tt.width, tt.height, tt.max); w != tt.w || h != tt.h {
+				t.Errorf("Fit(%v, %v, %v) = %v, %v, want %v, %v", tt.width, tt.height, tt.max, w, h, tt.w, tt.h)
+			}
+		})
+	}
+}
+
+func FuzzProbe(f *testing.F) {
+
+	f.Add(encodePNG(f, 4, 3))
+	f.Add(encodeGIF(f, 4, 3, 2))
+	f.Add(encodeMP4(1, "vide", "avc1", 4, 3))
+	f.Add(encodeMP4(1, "vide", "avc1", 0, 3))
+	f.Add(encodeWebM("webm", 1, 4, 3))
+	f.Add(encodeWebM("webm", 1, 0, 0))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		info, err := media.Probe(data)
+		if err != nil {
+			return
+		}
+
+		// the dimensions of probed media are scaled for their previews.
+		media.Fit(info.Width, info.Height, 64)
+	})
+}
+
+func FuzzFit(f *testing.F) {
+
+	f.Add(400, 200, 200)
+	f.Add(0, 400, 200)
+	f.Add(10000, 1, 100)
+
+	f.Fuzz(func(t *testing.T, width, height, max int) {
+		if width > 1<<20 || height > 1<<20 || max <= 0 || max > 1<<20 {
+			t.Skip()
+		}
+
+		w, h := media.Fit(width, height, max)
+
+		if width <= 0 || height <= 0 {
+			if w != width || h != height {
+				t.Errorf("Fit(%v, %v, %v) = %v, %v, want the frame unchanged", width, height, max, w, h)
+			}
+			return
+		}
+
+		if w < 1 || h < 1 || w > max || h > max {
+			t.Errorf("Fit(%v, %v, %v) = %v, %v, want sides from 1 to %v", width, height, max, w, h, max)
+		}
+	})
+}
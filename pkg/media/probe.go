@@ -0,0 +1,373 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/gif"
+	"math"
+	"net/http"
+	"time"
+)
+
+var ErrUnsupported = errors.New("error unsupported media type")
+
+// Kinds of media.
+const (
+	KindImage     = "image"
+	KindAnimation = "animation"
+	KindVideo     = "video"
+)
+
+// Info describes an uploaded file as read from its container, without decoding any
+// video frames.
+type Info struct {
+	Kind        string
+	ContentType string
+	Width       int
+	Height      int
+	Duration    time.Duration
+	Codec       string
+}
+
+// Probe sniffs the type of data and reads its dimensions and, for animations and
+// videos, its duration. Jpeg, png, gif, mp4 and webm files are supported.
+func Probe(data []byte) (Info, error) {
+
+	// mp4 files whose brands do not start with mp4, such as isom or M4V, are not
+	// recognised by http.DetectContentType.
+	if len(data) >= 12 && string(data[4:8]) == "ftyp" {
+		return probeMP4(data)
+	}
+
+	switch contentType := http.DetectContentType(data); contentType {
+	case "image/jpeg", "image/png":
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return Info{}, err
+		}
+		return Info{Kind: KindImage, ContentType: contentType, Width: cfg.Width, Height: cfg.Height}, nil
+	case "image/gif":
+		return probeGIF(data)
+	case "video/mp4":
+		return probeMP4(data)
+	case "video/webm":
+		return probeWebM(data)
+	}
+
+	return Info{}, ErrUnsupported
+}
+
+func probeGIF(data []byte) (Info, error) {
+
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return Info{}, err
+	}
+
+	info := Info{Kind: KindImage, ContentType: "image/gif", Width: g.Config.Width, Height: g.Config.Height, Codec: "gif"}
+
+	if len(g.Image) > 1 {
+		info.Kind = KindAnimation
+		for _, delay := range g.Delay {
+			info.Duration += time.Duration(delay) * 10 * time.Millisecond
+		}
+	}
+
+	return info, nil
+}
+
+// box is an iso base media file format box.
+type box struct {
+	typ     string
+	payload []byte
+}
+
+// boxes splits data into its top level boxes.
+func boxes(data []byte) ([]box, error) {
+
+	var out []box
+
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil, ErrUnsupported
+		}
+
+		size, header := uint64(binary.BigEndian.Uint32(data)), uint64(8)
+		typ := string(data[4:8])
+
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return nil, ErrUnsupported
+			}
+			size, header = binary.BigEndian.Uint64(data[8:]), 16
+		}
+
+		if size < header || size > uint64(len(data)) {
+			return nil, ErrUnsupported
+		}
+
+		out = append(out, box{typ: typ, payload: data[header:size]})
+		data = data[size:]
+	}
+
+	return out, nil
+}
+
+// child returns the payload of the first box of type typ in data.
+func child(data []byte, typ string) []byte {
+
+	children, err := boxes(data)
+	if err != nil {
+		return nil
+	}
+
+	for _, b := range children {
+		if b.typ == typ {
+			return b.payload
+		}
+	}
+
+	return nil
+}
+
+func probeMP4(data []byte) (Info, error) {
+
+	info := Info{Kind: KindVideo, ContentType: "video/mp4"}
+
+	moov := child(data, "moov")
+	if moov == nil {
+		return Info{}, ErrUnsupported
+	}
+
+	if mvhd := child(moov, "mvhd"); len(mvhd) >= 4 {
+		var timescale, duration uint64
+		switch {
+		case mvhd[0] == 1 && len(mvhd) >= 32:
+			timescale, duration = uint64(binary.BigEndian.Uint32(mvhd[20:])), binary.BigEndian.Uint64(mvhd[24:])
+		case mvhd[0] == 0 && len(mvhd) >= 20:
+			timescale, duration = uint64(binary.BigEndian.Uint32(mvhd[12:])), uint64(binary.BigEndian.Uint32(mvhd[16:]))
+		}
+		if timescale > 0 {
+			info.Duration = time.Duration(float64(duration) / float64(timescale) * float64(time.Second))
+		}
+	}
+
+	traks, err := boxes(moov)
+	if err != nil {
+		return Info{}, err
+	}
+
+	for _, trak := range traks {
+		if trak.typ != "trak" {
+			continue
+		}
+
+		mdia := child(trak.payload, "mdia")
+		if hdlr := child(mdia, "hdlr"); len(hdlr) < 12 || string(hdlr[8:12]) != "vide" {
+			continue
+		}
+
+		if tkhd := child(trak.payload, "tkhd"); len(tkhd) >= 8 {
+			info.Width = int(binary.BigEndian.Uint32(tkhd[len(tkhd)-8:]) >> 16)
+			info.Height = int(binary.BigEndian.Uint32(tkhd[len(tkhd)-4:]) >> 16)
+		}
+
+		if stsd := child(child(child(mdia, "minf"), "stbl"), "stsd"); len(stsd) >= 16 {
+			info.Codec = string(stsd[12:16])
+		}
+
+		break
+	}
+
+	return info, nil
+}
+
+// ebml element ids read by probeWebM.
+const (
+	ebmlHeader      = 0x1A45DFA3
+	ebmlDocType     = 0x4282
+	ebmlSegment     = 0x18538067
+	ebmlInfo        = 0x1549A966
+	ebmlTimecode    = 0x2AD7B1
+	ebmlDuration    = 0x4489
+	ebmlTracks      = 0x1654AE6B
+	ebmlTrackEntry  = 0xAE
+	ebmlTrackType   = 0x83
+	ebmlCodecID     = 0x86
+	ebmlVideo       = 0xE0
+	ebmlPixelWidth  = 0xB0
+	ebmlPixelHeight = 0xBA
+	ebmlCluster     = 0x1F43B675
+)
+
+// vint reads an ebml variable length integer, keeping the length marker for element
+// ids. It returns the number of bytes read and whether all value bits are set.
+func vint(data []byte, keepMarker bool) (uint64, int, bool) {
+
+	if len(data) == 0 || data[0] == 0 {
+		return 0, 0, false
+	}
+
+	n := 1
+	for mask := byte(0x80); data[0]&mask == 0; mask >>= 1 {
+		n++
+	}
+
+	if n > 8 || len(data) < n {
+		return 0, 0, false
+	}
+
+	value := uint64(data[0])
+	if !keepMarker {
+		value &= uint64(0xFF >> n)
+	}
+
+	for _, b := range data[1:n] {
+		value = value<<8 | uint64(b)
+	}
+
+	unknown := !keepMarker && value == 1<<(7*n)-1
+
+	return value, n, unknown
+}
+
+// walkEBML calls fn with the id and payload of every element of data, elements of
+// unknown size extend to the end of data.
+func walkEBML(data []byte, fn func(id uint64, payload []byte) bool) {
+
+	for len(data) > 0 {
+		id, n, _ := vint(data, true)
+		if n == 0 {
+			return
+		}
+		data = data[n:]
+
+		size, m, unknown := vint(data, false)
+		if m == 0 {
+			return
+		}
+		data = data[m:]
+
+		if unknown || size > uint64(len(data)) {
+			size = uint64(len(data))
+		}
+
+		if !fn(id, data[:size]) {
+			return
+		}
+
+		data = data[size:]
+	}
+}
+
+func ebmlUint(data []byte) uint64 {
+	var v uint64
+	for _, b := range data {
+		v = v<<8 | uint64(b)
+	}
+	return v
+}
+
+func ebmlFloat(data []byte) float64 {
+	switch len(data) {
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(data)))
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(data))
+	}
+	return 0
+}
+
+func probeWebM(data []byte) (Info, error) {
+
+	info := Info{Kind: KindVideo, ContentType: "video/webm"}
+
+	var docType string
+	var timecodeScale uint64 = 1000000
+	var duration float64
+
+	walkEBML(data, func(id uint64, payload []byte) bool {
+		switch id {
+		case ebmlHeader:
+			walkEBML(payload, func(id uint64, payload []byte) bool {
+				if id == ebmlDocType {
+					docType = string(payload)
+				}
+				return true
+			})
+		case ebmlSegment:
+			walkEBML(payload, func(id uint64, payload []byte) bool {
+				switch id {
+				case ebmlInfo:
+					walkEBML(payload, func(id uint64, payload []byte) bool {
+						switch id {
+						case ebmlTimecode:
+							timecodeScale = ebmlUint(payload)
+						case ebmlDuration:
+							duration = ebmlFloat(payload)
+						}
+						return true
+					})
+				case ebmlTracks:
+					walkEBML(payload, func(id uint64, payload []byte) bool {
+						if id == ebmlTrackEntry && info.Codec == "" {
+							probeWebMTrack(payload, &info)
+						}
+						return true
+					})
+				case ebmlCluster:
+					// track and segment info precede the clusters holding the frames.
+					return false
+				}
+				return true
+			})
+			return false
+		}
+		return true
+	})
+
+	if docType != "webm" && docType != "matroska" {
+		return Info{}, ErrUnsupported
+	}
+
+	info.Duration = time.Duration(duration * float64(timecodeScale))
+
+	return info, nil
+}
+
+// probeWebMTrack reads the codec and dimensions of a video track entry into info.
+func probeWebMTrack(entry []byte, info *Info) {
+
+	var video bool
+	var codec string
+	var width, height int
+
+	walkEBML(entry, func(id uint64, payload []byte) bool {
+		switch id {
+		case ebmlTrackType:
+			video = ebmlUint(payload) == 1
+		case ebmlCodecID:
+			codec = string(payload)
+		case ebmlVideo:
+			walkEBML(payload, func(id uint64, payload []byte) bool {
+				switch id {
+				case ebmlPixelWidth:
+					width = int(ebmlUint(payload))
+				case ebmlPixelHeight:
+					height = int(ebmlUint(payload))
+				}
+				return true
+			})
+		}
+		return true
+	})
+
+	if video {
+		info.Codec, info.Width, info.Height = codec, width, height
+	}
+}
@@ -0,0 +1,80 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/evansopilo/visuai/pkg/blob"
+)
+
+// Blob records the duration and outcome of every operation of a blob store along
+// with the bytes uploaded to it.
+type Blob struct {
+	store   blob.Store
+	metrics *Metrics
+}
+
+// Blob wraps store with a Blob, as blob.Wrap does.
+func (m *Metrics) Blob(store blob.Store) blob.Store {
+	return blob.Wrap(store, &Blob{store: store, metrics: m})
+}
+
+func (b Blob) observe(operation string, start time.Time, err error) {
+	b.metrics.blobDuration.WithLabelValues(operation, outcome(err)).Observe(time.Since(start).Seconds())
+}
+
+func (b Blob) uploaded(operation string, n int, err error) {
+	if err == nil {
+		b.metrics.blobBytes.WithLabelValues(operation).Add(float64(n))
+	}
+}
+
+func (b Blob) UploadBytesToBlob(ctx context.Context, data []byte, contentType string, metadata map[string]string) (key string, err error) {
+	defer func(start time.Time) {
+		b.observe("upload", start, err)
+		b.uploaded("upload", len(data), err)
+	}(time.Now())
+	return b.store.UploadBytesToBlob(ctx, data, contentType, metadata)
+}
+
+func (b Blob) SignedURL(key string, ttl time.Duration) (url string, err error) {
+	defer func(start time.Time) { b.observe("signed_url", start, err) }(time.Now())
+	return b.store.SignedURL(key, ttl)
+}
+
+func (b Blob) StageBlock(ctx context.Context, key string, part int, data []byte) (err error) {
+	defer func(start time.Time) {
+		b.observe("stage_block", start, err)
+		b.uploaded("stage_block", len(data), err)
+	}(time.Now())
+	return b.store.StageBlock(ctx, key, part, data)
+}
+
+func (b Blob) CommitBlocks(ctx context.Context, key string, parts []int, contentType string, metadata map[string]string) (err error) {
+	defer func(start time.Time) { b.observe("commit_blocks", start, err) }(time.Now())
+	return b.store.CommitBlocks(ctx, key, parts, contentType, metadata)
+}
+
+func (b Blob) AbortBlocks(ctx context.Context, key string) (err error) {
+	defer func(start time.Time) { b.observe("abort_blocks", start, err) }(time.Now())
+	return b.store.AbortBlocks(ctx, key)
+}
+
+func (b Blob) DownloadBlob(ctx context.Context, key string) (data []byte, err error) {
+	defer func(start time.Time) { b.observe("download", start, err) }(time.Now())
+	return b.store.DownloadBlob(ctx, key)
+}
+
+func (b Blob) DeleteBlob(ctx context.Context, key string) (err error) {
+	defer func(start time.Time) { b.observe("delete", start, err) }(time.Now())
+	return b.store.DeleteBlob(ctx, key)
+}
+
+func (b Blob) List(ctx context.Context, fn func(key string, modified time.Time) error) (err error) {
+	defer func(start time.Time) { b.observe("list", start, err) }(time.Now())
+	return b.store.List(ctx, fn)
+}
+
+func (b Blob) Ping(ctx context.Context) error {
+	return b.store.Ping(ctx)
+}
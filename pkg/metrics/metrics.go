@@ -0,0 +1,150 @@
+// Package metrics exposes prometheus metrics of the http server, the mongodb
+// commands and the blob store.
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+const namespace = "visuai"
+
+// Metrics holds the collectors of the application in their own registry.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	mongoDuration   *prometheus.HistogramVec
+	storeDuration   *prometheus.HistogramVec
+	blobDuration    *prometheus.HistogramVec
+	blobBytes       *prometheus.CounterVec
+
+	// JobQueueDepth is the number of jobs waiting to run by queue.
+	JobQueueDepth *prometheus.GaugeVec
+}
+
+func New() *Metrics {
+
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "Number of http requests handled by method, route and status.",
+		}, []string{"method", "route", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Latency of http requests by method, route and status.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		mongoDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "mongo_command_duration_seconds",
+			Help:      "Duration of mongodb commands by command and outcome.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"command", "outcome"}),
+		storeDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "store_operation_duration_seconds",
+			Help:      "Duration of data store operations by store, operation and outcome.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"store", "operation", "outcome"}),
+		blobDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "blob_operation_duration_seconds",
+			Help:      "Duration of blob store operations by operation and outcome.",
+			Buckets:   []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+		}, []string{"operation", "outcome"}),
+		blobBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "blob_uploaded_bytes_total",
+			Help:      "Number of bytes uploaded to the blob store by operation.",
+		}, []string{"operation"}),
+		JobQueueDepth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "job_queue_depth",
+			Help:      "Number of jobs waiting to run by queue.",
+		}, []string{"queue"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests,
+		m.requestDuration,
+		m.mongoDuration,
+		m.storeDuration,
+		m.blobDuration,
+		m.blobBytes,
+		m.JobQueueDepth,
+	)
+
+	return m
+}
+
+// Handler serves the metrics in the prometheus exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// Middleware records the count and latency of every request by the route it
+// matched, so paths with ids do not each get their own series.
+func (m *Metrics) Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+
+		start := time.Now()
+
+		err := c.Next()
+
+		status := c.Response().StatusCode()
+
+		var e *fiber.Error
+		if errors.As(err, &e) {
+			status = e.Code
+		} else if err != nil {
+			status = fiber.StatusInternalServerError
+		}
+
+		labels := prometheus.Labels{
+			"method": c.Method(),
+			"route":  c.Route().Path,
+			"status": strconv.Itoa(status),
+		}
+
+		m.requests.With(labels).Inc()
+		m.requestDuration.With(labels).Observe(time.Since(start).Seconds())
+
+		return err
+	}
+}
+
+// CommandMonitor returns a mongodb command monitor recording the duration of every
+// command the client sends.
+func (m *Metrics) CommandMonitor() *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Succeeded: func(_ context.Context, e *event.CommandSucceededEvent) {
+			m.mongoDuration.WithLabelValues(e.CommandName, "success").Observe(time.Duration(e.DurationNanos).Seconds())
+		},
+		Failed: func(_ context.Context, e *event.CommandFailedEvent) {
+			m.mongoDuration.WithLabelValues(e.CommandName, "failure").Observe(time.Duration(e.DurationNanos).Seconds())
+		},
+	}
+}
+
+func outcome(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
@@ -0,0 +1,135 @@
+package metrics_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/evansopilo/visuai/pkg/blob"
+	"github.com/evansopilo/visuai/pkg/metrics"
+	"github.com/evansopilo/visuai/pkg/signer"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+// scrape returns the metrics of m in the prometheus exposition format.
+func scrape(t *testing.T, m *metrics.Metrics) string {
+
+	t.Helper()
+
+	recorder := httptest.NewRecorder()
+
+	m.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	b, err := io.ReadAll(recorder.Body)
+	if err != nil {
+		t.Fatalf("read metrics: %v", err)
+	}
+
+	return string(b)
+}
+
+// expectSeries fails the test when a line of the scraped metrics does not start with
+// each series.
+func expectSeries(t *testing.T, scraped string, series ...string) {
+
+	t.Helper()
+
+	for _, s := range series {
+		if !strings.Contains(scraped, "\n"+s) {
+			t.Errorf("metrics lack %v", s)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+
+	m := metrics.New()
+
+	app := fiber.New()
+	app.Use(m.Middleware())
+	app.Get("/v1/api/posts/:post_id", func(c *fiber.Ctx) error {
+		if c.Params("post_id") == "missing" {
+			return fiber.ErrNotFound
+		}
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	for _, path := range []string{"/v1/api/posts/post-1", "/v1/api/posts/post-2", "/v1/api/posts/missing"} {
+		if _, err := app.Test(httptest.NewRequest("GET", path, nil)); err != nil {
+			t.Fatalf("request %v: %v", path, err)
+		}
+	}
+
+	scraped := scrape(t, m)
+
+	expectSeries(t, scraped,
+		`visuai_http_requests_total{method="GET",route="/v1/api/posts/:post_id",status="200"} 2`,
+		`visuai_http_requests_total{method="GET",route="/v1/api/posts/:post_id",status="404"} 1`,
+		`visuai_http_request_duration_seconds_count{method="GET",route="/v1/api/posts/:post_id",status="200"} 2`,
+	)
+
+	if strings.Contains(scraped, "post-1") {
+		t.Errorf("metrics label requests by their path, want their route")
+	}
+}
+
+func TestCommandMonitor(t *testing.T) {
+
+	m := metrics.New()
+
+	monitor := m.CommandMonitor()
+
+	finished := event.CommandFinishedEvent{CommandName: "find", DurationNanos: int64(time.Millisecond)}
+
+	monitor.Succeeded(context.Background(), &event.CommandSucceededEvent{CommandFinishedEvent: finished})
+	monitor.Succeeded(context.Background(), &event.CommandSucceededEvent{CommandFinishedEvent: finished})
+	monitor.Failed(context.Background(), &event.CommandFailedEvent{CommandFinishedEvent: finished, Failure: "timeout"})
+
+	expectSeries(t, scrape(t, m),
+		`visuai_mongo_command_duration_seconds_count{command="find",outcome="success"} 2`,
+		`visuai_mongo_command_duration_seconds_count{command="find",outcome="failure"} 1`,
+	)
+}
+
+func TestBlob(t *testing.T) {
+
+	m := metrics.New()
+
+	local, err := blob.NewLocal(t.TempDir(), "http://localhost", signer.New([]byte("key")))
+	if err != nil {
+		t.Fatalf("NewLocal: %v", err)
+	}
+
+	store := m.Blob(local)
+
+	if _, ok := store.(blob.PathVerifier); !ok {
+		t.Errorf("Blob of a local store lost its VerifyPath method")
+	}
+
+	ctx := context.Background()
+
+	key, err := store.UploadBytesToBlob(ctx, []byte("photo"), "image/jpeg", nil)
+	if err != nil {
+		t.Fatalf("UploadBytesToBlob: %v", err)
+	}
+
+	if _, err := store.DownloadBlob(ctx, key); err != nil {
+		t.Fatalf("DownloadBlob: %v", err)
+	}
+
+	if _, err := store.DownloadBlob(ctx, "missing"); !errors.Is(err, blob.ErrNotFound) {
+		t.Fatalf("DownloadBlob of a missing blob = %v, want ErrNotFound", err)
+	}
+
+	expectSeries(t, scrape(t, m),
+		`visuai_blob_uploaded_bytes_total{operation="upload"} 5`,
+		`visuai_blob_operation_duration_seconds_count{operation="upload",outcome="success"} 1`,
+		`visuai_blob_operation_duration_seconds_count{operation="download",outcome="success"} 1`,
+		`visuai_blob_operation_duration_seconds_count{operation="download",outcome="failure"} 1`,
+	)
+}
@@ -0,0 +1,107 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/evansopilo/visuai/pkg/data"
+)
+
+// Post records the duration and outcome of every operation of a post store.
+type Post struct {
+	store   data.PostStore
+	metrics *Metrics
+}
+
+func (m *Metrics) Post(store data.PostStore) *Post {
+	return &Post{store: store, metrics: m}
+}
+
+func (p Post) observe(operation string, start time.Time, err error) {
+	p.metrics.storeDuration.WithLabelValues("post", operation, outcome(err)).Observe(time.Since(start).Seconds())
+}
+
+func (p Post) Create(ctx context.Context, post *data.Post) (err error) {
+	defer func(start time.Time) { p.observe("create", start, err) }(time.Now())
+	return p.store.Create(ctx, post)
+}
+
+func (p Post) GetByID(ctx context.Context, id string) (post *data.Post, err error) {
+	defer func(start time.Time) { p.observe("get_by_id", start, err) }(time.Now())
+	return p.store.GetByID(ctx, id)
+}
+
+func (p Post) GetByUserID(ctx context.Context, id string, skip, limit int64) (posts *[]data.Post, err error) {
+	defer func(start time.Time) { p.observe("get_by_user_id", start, err) }(time.Now())
+	return p.store.GetByUserID(ctx, id, skip, limit)
+}
+
+func (p Post) GetAllByUserID(ctx context.Context, id string, skip, limit int64) (posts *[]data.Post, err error) {
+	defer func(start time.Time) { p.observe("get_all_by_user_id", start, err) }(time.Now())
+	return p.store.GetAllByUserID(ctx, id, skip, limit)
+}
+
+func (p Post) Scan(ctx context.Context, after string, limit int64) (posts *[]data.Post, err error) {
+	defer func(start time.Time) { p.observe("scan", start, err) }(time.Now())
+	return p.store.Scan(ctx, after, limit)
+}
+
+func (p Post) GetByCategory(ctx context.Context, category string, skip, limit int64) (posts *[]data.Post, err error) {
+	defer func(start time.Time) { p.observe("get_by_category", start, err) }(time.Now())
+	return p.store.GetByCategory(ctx, category, skip, limit)
+}
+
+func (p Post) GetByTags(ctx context.Context, tags []string, skip, limit int64) (posts *[]data.Post, err error) {
+	defer func(start time.Time) { p.observe("get_by_tags", start, err) }(time.Now())
+	return p.store.GetByTags(ctx, tags, skip, limit)
+}
+
+func (p Post) GetByPHashBands(ctx context.Context, bands []string, skip, limit int64) (posts *[]data.Post, err error) {
+	defer func(start time.Time) { p.observe("get_by_phash_bands", start, err) }(time.Now())
+	return p.store.GetByPHashBands(ctx, bands, skip, limit)
+}
+
+func (p Post) Get(ctx context.Context, skip, limit int64) (posts *[]data.Post, err error) {
+	defer func(start time.Time) { p.observe("get", start, err) }(time.Now())
+	return p.store.Get(ctx, skip, limit)
+}
+
+func (p Post) AppendMedia(ctx context.Context, id string, bands []string, items ...data.Media) (err error) {
+	defer func(start time.Time) { p.observe("append_media", start, err) }(time.Now())
+	return p.store.AppendMedia(ctx, id, bands, items...)
+}
+
+func (p Post) ReorderMedia(ctx context.Context, id string, media []data.Media) (err error) {
+	defer func(start time.Time) { p.observe("reorder_media", start, err) }(time.Now())
+	return p.store.ReorderMedia(ctx, id, media)
+}
+
+func (p Post) SetHashes(ctx context.Context, id string, media []data.Media, phash string, bands []string) (err error) {
+	defer func(start time.Time) { p.observe("set_hashes", start, err) }(time.Now())
+	return p.store.SetHashes(ctx, id, media, phash, bands)
+}
+
+func (p Post) RemoveMedia(ctx context.Context, post *data.Post, mediaID string, bands []string) (err error) {
+	defer func(start time.Time) { p.observe("remove_media", start, err) }(time.Now())
+	return p.store.RemoveMedia(ctx, post, mediaID, bands)
+}
+
+func (p Post) SetModerationState(ctx context.Context, id, state string) (err error) {
+	defer func(start time.Time) { p.observe("set_moderation_state", start, err) }(time.Now())
+	return p.store.SetModerationState(ctx, id, state)
+}
+
+func (p Post) UpdateByID(ctx context.Context, id string, post *data.Post) (err error) {
+	defer func(start time.Time) { p.observe("update_by_id", start, err) }(time.Now())
+	return p.store.UpdateByID(ctx, id, post)
+}
+
+func (p Post) DeleteByID(ctx context.Context, id string) (err error) {
+	defer func(start time.Time) { p.observe("delete_by_id", start, err) }(time.Now())
+	return p.store.DeleteByID(ctx, id)
+}
+
+func (p Post) DeleteByUserID(ctx context.Context, id string) (deleted int64, err error) {
+	defer func(start time.Time) { p.observe("delete_by_user_id", start, err) }(time.Now())
+	return p.store.DeleteByUserID(ctx, id)
+}
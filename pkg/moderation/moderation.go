@@ -0,0 +1,21 @@
+package moderation
+
+import "context"
+
+// Verdict is the outcome of screening an uploaded file.
+type Verdict struct {
+	Flagged bool     `json:"flagged"`
+	Labels  []string `json:"labels,omitempty"`
+	Score   float64  `json:"score"`
+}
+
+// Classifier screens uploaded files for unsafe content before they are published,
+// implementations can wrap a hosted service such as Azure Content Moderator.
+type Classifier interface {
+	Classify(ctx context.Context, data []byte) (Verdict, error)
+}
+
+// Noop is a Classifier that never flags content.
+type Noop struct{}
+
+func (Noop) Classify(ctx context.Context, data []byte) (Verdict, error) { return Verdict{}, nil }
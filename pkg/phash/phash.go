@@ -0,0 +1,117 @@
+package phash
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"math/bits"
+	"strconv"
+
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// Hash is a 64 bit difference hash (dHash) of an image. Visually similar images
+// produce hashes with a small hamming distance between them.
+type Hash uint64
+
+// bandCount is the number of 16 bit bands a hash is split into for indexed lookups,
+// two hashes within MaxDistance of each other are guaranteed to share at least one band.
+const bandCount = 4
+
+// MaxDistance is the largest hamming distance at which two hashes are considered
+// near-duplicates.
+const MaxDistance = bandCount - 1
+
+// FromBytes decodes a gif, jpeg or png encoded image and returns its hash.
+func FromBytes(data []byte) (Hash, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	return FromImage(img), nil
+}
+
+// FromImage shrinks img to a 9x8 grayscale grid and sets one bit per cell
+// depending on whether it is brighter than its right neighbour.
+func FromImage(img image.Image) Hash {
+
+	var grid [8][9]float64
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w == 0 || h == 0 {
+		return 0
+	}
+
+	for row := 0; row < 8; row++ {
+		y0, y1 := b.Min.Y+row*h/8, b.Min.Y+(row+1)*h/8
+		if y1 == y0 {
+			y1 = y0 + 1
+		}
+		for col := 0; col < 9; col++ {
+			x0, x1 := b.Min.X+col*w/9, b.Min.X+(col+1)*w/9
+			if x1 == x0 {
+				x1 = x0 + 1
+			}
+			grid[row][col] = meanLuma(img, x0, y0, x1, y1)
+		}
+	}
+
+	var hash Hash
+	for row := 0; row < 8; row++ {
+		for col := 0; col < 8; col++ {
+			hash <<= 1
+			if grid[row][col] > grid[row][col+1] {
+				hash |= 1
+			}
+		}
+	}
+
+	return hash
+}
+
+func meanLuma(img image.Image, x0, y0, x1, y1 int) float64 {
+
+	var sum float64
+
+	for y := y0; y < y1; y++ {
+		for x := x0; x < x1; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+		}
+	}
+
+	return sum / float64((x1-x0)*(y1-y0))
+}
+
+// Parse parses a hash previously formatted with String.
+func Parse(s string) (Hash, error) {
+	v, err := strconv.ParseUint(s, 16, 64)
+	if err != nil {
+		return 0, err
+	}
+	return Hash(v), nil
+}
+
+func (h Hash) String() string { return fmt.Sprintf("%016x", uint64(h)) }
+
+// Distance returns the number of bits that differ between h and o.
+func (h Hash) Distance(o Hash) int { return bits.OnesCount64(uint64(h ^ o)) }
+
+// NearDuplicate reports whether h and o are within MaxDistance of each other.
+func (h Hash) NearDuplicate(o Hash) bool { return h.Distance(o) <= MaxDistance }
+
+// Bands splits the hash into position-prefixed 16 bit bands suitable for storing
+// in an indexed array field and querying with $in.
+func (h Hash) Bands() []string {
+
+	bands := make([]string, bandCount)
+
+	for i := 0; i < bandCount; i++ {
+		bands[i] = fmt.Sprintf("%d:%04x", i, uint16(uint64(h)>>(16*(bandCount-1-i))))
+	}
+
+	return bands
+}
@@ -0,0 +1,184 @@
+package phash_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/evansopilo/visuai/pkg/phash"
+)
+
+// gradient returns a width by height image that is darker to the right, or to the
+// left when reversed.
+func gradient(width, height int, reversed bool) image.Image {
+
+	img := image.NewGray(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := 255 * x / width
+			if !reversed {
+				v = 255 - v
+			}
+			img.SetGray(x, y, color.Gray{Y: uint8(v)})
+		}
+	}
+
+	return img
+}
+
+func TestBands(t *testing.T) {
+
+	tests := []struct {
+		hash phash.Hash
+		want []string
+	}{
+		{0, []string{"0:0000", "1:0000", "2:0000", "3:0000"}},
+		{0x0123456789abcdef, []string{"0:0123", "1:4567", "2:89ab", "3:cdef"}},
+		{0xffffffffffffffff, []string{"0:ffff", "1:ffff", "2:ffff", "3:ffff"}},
+		{0x000000000000ffff, []string{"0:0000", "1:0000", "2:0000", "3:ffff"}},
+	}
+
+	for _, tt := range tests {
+		if got := tt.hash.Bands(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v.Bands() = %v, want %v", tt.hash, got, tt.want)
+		}
+	}
+}
+
+func TestBandsOfNearDuplicates(t *testing.T) {
+
+	r := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 1000; i++ {
+		h := phash.Hash(r.Uint64())
+
+		// flip up to MaxDistance bits of h.
+		o := h
+		for j := 0; j < phash.MaxDistance; j++ {
+			o ^= 1 << r.Intn(64)
+		}
+
+		if !shareBand(h.Bands(), o.Bands()) {
+			t.Fatalf("%v and %v are %v apart and share no band", h, o, h.Distance(o))
+		}
+	}
+}
+
+func shareBand(a, b []string) bool {
+
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func TestParse(t *testing.T) {
+
+	tests := []struct {
+		s    string
+		want phash.Hash
+		err  bool
+	}{
+		{s: "0000000000000000", want: 0},
+		{s: "0123456789abcdef", want: 0x0123456789abcdef},
+		{s: "FFFFFFFFFFFFFFFF", want: 0xffffffffffffffff},
+		{s: "ff", want: 0xff},
+		{s: "", err: true},
+		{s: "not a hash", err: true},
+		{s: "10000000000000000", err: true},
+		{s: "-1", err: true},
+	}
+
+	for _, tt := range tests {
+		got, err := phash.Parse(tt.s)
+
+		if tt.err {
+			if err == nil {
+				t.Errorf("Parse(%q) = %v, want an error", tt.s, got)
+			}
+			continue
+		}
+
+		if err != nil || got != tt.want {
+			t.Errorf("Parse(%q) = %v, %v, want %v", tt.s, got, err, tt.want)
+		}
+
+		if parsed, err := phash.Parse(got.String()); err != nil || parsed != got {
+			t.Errorf("Parse(%q) = %v, %v, want %v", got.String(), parsed, err, got)
+		}
+	}
+}
+
+func TestDistance(t *testing.T) {
+
+	tests := []struct {
+		h, o phash.Hash
+		want int
+		near bool
+	}{
+		{0, 0, 0, true},
+		{0, 1, 1, true},
+		{0, 0b111, 3, true},
+		{0, 0b1111, 4, false},
+		{0xf0f0f0f0f0f0f0f0, 0x0f0f0f0f0f0f0f0f, 64, false},
+		{0x8000000000000001, 0, 2, true},
+	}
+
+	for _, tt := range tests {
+		if got := tt.h.Distance(tt.o); got != tt.want || tt.o.Distance(tt.h) != tt.want {
+			t.Errorf("%v.Distance(%v) = %v, want %v", tt.h, tt.o, got, tt.want)
+		}
+
+		if got := tt.h.NearDuplicate(tt.o); got != tt.near {
+			t.Errorf("%v.NearDuplicate(%v) = %v, want %v", tt.h, tt.o, got, tt.near)
+		}
+	}
+}
+
+func TestFromBytes(t *testing.T) {
+
+	encode := func(img image.Image) []byte {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			t.Fatalf("encode png: %v", err)
+		}
+		return buf.Bytes()
+	}
+
+	small, err := phash.FromBytes(encode(gradient(90, 80, false)))
+	if err != nil {
+		t.Fatalf("FromBytes: %v", err)
+	}
+
+	large, err := phash.FromBytes(encode(gradient(900, 800, false)))
+	if err != nil {
+		t.Fatalf("FromBytes: %v", err)
+	}
+
+	reversed, err := phash.FromBytes(encode(gradient(90, 80, true)))
+	if err != nil {
+		t.Fatalf("FromBytes: %v", err)
+	}
+
+	if !small.NearDuplicate(large) {
+		t.Errorf("hashes of a resized image %v and %v are %v apart", small, large, small.Distance(large))
+	}
+
+	if small.NearDuplicate(reversed) {
+		t.Errorf("hashes of a mirrored image %v and %v are near-duplicates", small, reversed)
+	}
+
+	if _, err := phash.FromBytes([]byte("not an image")); err == nil {
+		t.Errorf("FromBytes of text = nil, want an error")
+	}
+}
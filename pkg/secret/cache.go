@@ -0,0 +1,121 @@
+package secret
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Cache caches the secrets of a provider for a ttl. Secrets that could not be
+// refreshed keep being served from the cache until they can be.
+type Cache struct {
+	provider Provider
+	ttl      time.Duration
+
+	mu      sync.RWMutex
+	entries map[cacheKey]cacheEntry
+	failed  map[cacheKey]error
+}
+
+type cacheKey struct {
+	name    string
+	version string
+}
+
+type cacheEntry struct {
+	value     string
+	fetchedAt time.Time
+}
+
+func NewCache(provider Provider, ttl time.Duration) *Cache {
+	return &Cache{
+		provider: provider,
+		ttl:      ttl,
+		entries:  make(map[cacheKey]cacheEntry),
+		failed:   make(map[cacheKey]error),
+	}
+}
+
+func (c *Cache) GetSecret(ctx context.Context, name, version string) (*string, error) {
+
+	key := cacheKey{name: name, version: version}
+
+	c.mu.RLock()
+	entry, ok := c.entries[key]
+	c.mu.RUnlock()
+
+	if ok && time.Since(entry.fetchedAt) < c.ttl {
+		return &entry.value, nil
+	}
+
+	value, err := c.fetch(ctx, key)
+	if err != nil {
+		if ok {
+			return &entry.value, nil
+		}
+		return nil, err
+	}
+
+	return &value, nil
+}
+
+func (c *Cache) fetch(ctx context.Context, key cacheKey) (string, error) {
+
+	value, err := c.provider.GetSecret(ctx, key.name, key.version)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err != nil {
+		c.failed[key] = err
+		return "", err
+	}
+
+	c.entries[key] = cacheEntry{value: *value, fetchedAt: time.Now()}
+	delete(c.failed, key)
+
+	return *value, nil
+}
+
+// Err returns an error of a secret that failed to be fetched the last time it was
+// fetched, it is nil when every secret is up to date.
+func (c *Cache) Err() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for key, err := range c.failed {
+		return fmt.Errorf("secret %v: %w", key.name, err)
+	}
+
+	return nil
+}
+
+// Refresh refetches every cached secret each interval until ctx is done, so callers
+// get rotated secrets without waiting on the provider. onError is called with the
+// name of each secret that fails to refresh, it can be nil.
+func (c *Cache) Refresh(ctx context.Context, interval time.Duration, onError func(name string, err error)) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.mu.RLock()
+			keys := make([]cacheKey, 0, len(c.entries))
+			for key := range c.entries {
+				keys = append(keys, key)
+			}
+			c.mu.RUnlock()
+
+			for _, key := range keys {
+				if _, err := c.fetch(ctx, key); err != nil && onError != nil {
+					onError(key.name, err)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package secret_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/evansopilo/visuai/pkg/secret"
+)
+
+// counting counts the lookups of the secrets of a memory provider, and fails them
+// while it is down.
+type counting struct {
+	*secret.Memory
+
+	mu      sync.Mutex
+	lookups int
+	down    bool
+}
+
+var errDown = errors.New("provider down")
+
+func (p *counting) GetSecret(ctx context.Context, name, version string) (*string, error) {
+
+	p.mu.Lock()
+	p.lookups++
+	down := p.down
+	p.mu.Unlock()
+
+	if down {
+		return nil, errDown
+	}
+
+	return p.Memory.GetSecret(ctx, name, version)
+}
+
+func (p *counting) setDown(down bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.down = down
+}
+
+func (p *counting) count() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.lookups
+}
+
+func get(t *testing.T, c *secret.Cache, name string) string {
+
+	t.Helper()
+
+	value, err := c.GetSecret(context.Background(), name, "")
+	if err != nil {
+		t.Fatalf("GetSecret(%v): %v", name, err)
+	}
+
+	return *value
+}
+
+func TestCacheTTL(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		ttl     time.Duration
+		wait    time.Duration
+		lookups int
+		value   string
+	}{
+		{"within the ttl", time.Hour, 0, 1, "v1"},
+		{"expired", 20 * time.Millisecond, 40 * time.Millisecond, 2, "v2"},
+		{"no ttl", 0, 0, 2, "v2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &counting{Memory: secret.NewMemory(map[string]string{"signing_key": "v1"})}
+			c := secret.NewCache(provider, tt.ttl)
+
+			if got := get(t, c, "signing_key"); got != "v1" {
+				t.Fatalf("GetSecret = %v, want v1", got)
+			}
+
+			provider.Set("signing_key", "v2")
+			time.Sleep(tt.wait)
+
+			if got := get(t, c, "signing_key"); got != tt.value || provider.count() != tt.lookups {
+				t.Errorf("GetSecret = %v after %v lookups, want %v after %v", got, provider.count(), tt.value, tt.lookups)
+			}
+		})
+	}
+}
+
+func TestCacheServesStale(t *testing.T) {
+
+	provider := &counting{Memory: secret.NewMemory(map[string]string{"signing_key": "v1"})}
+	c := secret.NewCache(provider, 0)
+
+	get(t, c, "signing_key")
+
+	provider.setDown(true)
+
+	if got := get(t, c, "signing_key"); got != "v1" {
+		t.Errorf("GetSecret while the provider is down = %v, want the cached v1", got)
+	}
+
+	if err := c.Err(); !errors.Is(err, errDown) {
+		t.Errorf("Err = %v, want the failure of the provider", err)
+	}
+
+	if _, err := c.GetSecret(context.Background(), "uncached", ""); !errors.Is(err, errDown) {
+		t.Errorf("GetSecret of an uncached secret = %v, want the failure of the provider", err)
+	}
+
+	provider.setDown(false)
+	provider.Set("signing_key", "v2")
+
+	if got := get(t, c, "signing_key"); got != "v2" {
+		t.Errorf("GetSecret once the provider is back = %v, want v2", got)
+	}
+
+	if _, err := c.GetSecret(context.Background(), "uncached", ""); !errors.Is(err, secret.ErrNotFound) {
+		t.Errorf("GetSecret of a missing secret = %v, want ErrNotFound", err)
+	}
+
+	get(t, c, "signing_key")
+	provider.Set("uncached", "value")
+	get(t, c, "uncached")
+
+	if err := c.Err(); err != nil {
+		t.Errorf("Err once every secret is fetched = %v, want nil", err)
+	}
+}
+
+func TestCacheRefresh(t *testing.T) {
+
+	provider := &counting{Memory: secret.NewMemory(map[string]string{"signing_key": "v1"})}
+	c := secret.NewCache(provider, time.Hour)
+
+	get(t, c, "signing_key")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	failures := make(chan string, 16)
+	go c.Refresh(ctx, 5*time.Millisecond, func(name string, err error) {
+		select {
+		case failures <- name:
+		default:
+		}
+	})
+
+	provider.Set("signing_key", "v2")
+
+	// the refreshed secret is served without waiting for the ttl.
+	deadline := time.Now().Add(time.Second)
+	for get(t, c, "signing_key") != "v2" {
+		if time.Now().After(deadline) {
+			t.Fatalf("GetSecret = v1 a second after it was rotated, want v2")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	provider.setDown(true)
+
+	select {
+	case name := <-failures:
+		if name != "signing_key" {
+			t.Errorf("onError called for %v, want signing_key", name)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("onError not called a second after the provider went down")
+	}
+
+	if got := get(t, c, "signing_key"); got != "v2" {
+		t.Errorf("GetSecret after a failed refresh = %v, want the cached v2", got)
+	}
+}
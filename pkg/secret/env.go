@@ -0,0 +1,27 @@
+package secret
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// Env reads secrets from environment variables named by the secret's name after a
+// prefix.
+type Env struct {
+	prefix string
+}
+
+func NewEnv(prefix string) *Env {
+	return &Env{prefix: prefix}
+}
+
+func (e Env) GetSecret(ctx context.Context, name, version string) (*string, error) {
+
+	value, ok := os.LookupEnv(e.prefix + name)
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", ErrNotFound, name)
+	}
+
+	return &value, nil
+}
@@ -0,0 +1,99 @@
+package secret
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// File reads secrets from a dotenv file of NAME=value lines, or from the files in a
+// directory named by the secret's name as secrets are mounted in containers. The
+// file is read on every lookup so rotated secrets are picked up, wrap it in a Cache
+// to read it less often.
+type File struct {
+	path string
+}
+
+func NewFile(path string) *File {
+	return &File{path: path}
+}
+
+func (f File) GetSecret(ctx context.Context, name, version string) (*string, error) {
+
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		if name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+			return nil, fmt.Errorf("%w: %v", ErrNotFound, name)
+		}
+
+		b, err := os.ReadFile(filepath.Join(f.path, name))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil, fmt.Errorf("%w: %v", ErrNotFound, name)
+			}
+			return nil, err
+		}
+
+		value := strings.TrimRight(string(b), "\r\n")
+
+		return &value, nil
+	}
+
+	values, err := readDotenv(f.path)
+	if err != nil {
+		return nil, err
+	}
+
+	value, ok := values[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", ErrNotFound, name)
+	}
+
+	return &value, nil
+}
+
+// readDotenv parses the NAME=value lines of a dotenv file, blank lines and lines
+// starting with # are skipped and values can be quoted.
+func readDotenv(path string) (map[string]string, error) {
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	values := make(map[string]string)
+
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		line = strings.TrimPrefix(line, "export ")
+
+		i := strings.Index(line, "=")
+		if i < 1 {
+			return nil, fmt.Errorf("secret: %v:%d: expected NAME=value", path, n)
+		}
+
+		name, value := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+
+		values[name] = value
+	}
+
+	return values, scanner.Err()
+}
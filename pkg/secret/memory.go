@@ -0,0 +1,44 @@
+package secret
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Memory holds secrets in memory, it is meant for tests and local development.
+type Memory struct {
+	mu      sync.RWMutex
+	secrets map[string]string
+}
+
+func NewMemory(secrets map[string]string) *Memory {
+
+	m := &Memory{secrets: make(map[string]string, len(secrets))}
+
+	for name, value := range secrets {
+		m.secrets[name] = value
+	}
+
+	return m
+}
+
+// Set sets the secret name to value, replacing its previous value.
+func (m *Memory) Set(name, value string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.secrets[name] = value
+}
+
+func (m *Memory) GetSecret(ctx context.Context, name, version string) (*string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	value, ok := m.secrets[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", ErrNotFound, name)
+	}
+
+	return &value, nil
+}
@@ -2,11 +2,21 @@ package secret
 
 import (
 	"context"
+	"errors"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/Azure/azure-sdk-for-go/sdk/keyvault/azsecrets"
 )
 
+var ErrNotFound = errors.New("secret not found")
+
+// Provider looks up secrets by name, an empty version is the latest version of the
+// secret. Providers that do not version their secrets ignore version.
+type Provider interface {
+	GetSecret(ctx context.Context, name, version string) (*string, error)
+}
+
+// Secret reads secrets from azure key vault.
 type Secret struct {
 	client *azsecrets.Client
 }
@@ -0,0 +1,147 @@
+package signer
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidSignature = errors.New("error invalid signature")
+	ErrExpired          = errors.New("error signature expired")
+)
+
+// Signer creates and verifies HMAC-SHA256 signatures of values that expire.
+type Signer struct {
+	// keys returns the key signatures are made with followed by the keys they are
+	// still verified with.
+	keys func() [][]byte
+}
+
+func New(key []byte) *Signer {
+	return &Signer{keys: func() [][]byte { return [][]byte{key} }}
+}
+
+// NewWithKeyFunc returns a signer that looks up its key with key on every use, so a
+// rotated key is used without a restart. Signatures made with the key it replaced
+// keep verifying until the key is rotated again, and the last key found is used
+// while key fails. It fails when the first lookup of the key does.
+func NewWithKeyFunc(key func() ([]byte, error)) (*Signer, error) {
+
+	current, err := key()
+	if err != nil {
+		return nil, err
+	}
+
+	var mu sync.Mutex
+
+	keys := [][]byte{current}
+
+	return &Signer{keys: func() [][]byte {
+
+		k, err := key()
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if err == nil && !bytes.Equal(k, keys[0]) {
+			keys = [][]byte{k, keys[0]}
+		}
+
+		return keys
+	}}, nil
+}
+
+// Derive returns a signer whose keys are derived from the keys of s for purpose, so
+// the signatures made for one purpose are not valid for another.
+func (s Signer) Derive(purpose string) *Signer {
+	return &Signer{keys: func() [][]byte {
+
+		keys := s.keys()
+
+		derived := make([][]byte, len(keys))
+		for i, key := range keys {
+			mac := hmac.New(sha256.New, key)
+			mac.Write([]byte(purpose))
+			derived[i] = mac.Sum(nil)
+		}
+
+		return derived
+	}}
+}
+
+// Sign returns the url safe signature of value valid until expires.
+func (s Signer) Sign(value string, expires time.Time) string {
+	return sign(s.keys()[0], value, expires)
+}
+
+func sign(key []byte, value string, expires time.Time) string {
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(strconv.FormatInt(expires.Unix(), 10) + "\n" + value))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks that signature was created by Sign for value and expires, and that
+// it has not yet expired.
+func (s Signer) Verify(value string, expires time.Time, signature string) error {
+
+	valid := false
+	for _, key := range s.keys() {
+		if hmac.Equal([]byte(sign(key, value, expires)), []byte(signature)) {
+			valid = true
+			break
+		}
+	}
+
+	if !valid {
+		return ErrInvalidSignature
+	}
+
+	if time.Now().After(expires) {
+		return ErrExpired
+	}
+
+	return nil
+}
+
+// Token packs value, its expiry and signature into a single url safe token.
+func (s Signer) Token(value string, expires time.Time) string {
+	return strings.Join([]string{
+		base64.RawURLEncoding.EncodeToString([]byte(value)),
+		strconv.FormatInt(expires.Unix(), 10),
+		s.Sign(value, expires),
+	}, ".")
+}
+
+// ParseToken verifies a token created by Token and returns the value it carries.
+func (s Signer) ParseToken(token string) (string, error) {
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", ErrInvalidSignature
+	}
+
+	value, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", ErrInvalidSignature
+	}
+
+	unix, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", ErrInvalidSignature
+	}
+
+	if err := s.Verify(string(value), time.Unix(unix, 0), parts[2]); err != nil {
+		return "", err
+	}
+
+	return string(value), nil
+}
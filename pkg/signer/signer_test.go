@@ -0,0 +1,149 @@
+package signer_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/evansopilo/visuai/pkg/signer"
+)
+
+func TestDerive(t *testing.T) {
+
+	s := signer.New([]byte("signing key"))
+	expires := time.Now().Add(time.Hour)
+
+	media, share := s.Derive("media"), s.Derive("share")
+
+	if err := media.Verify("post-1", expires, s.Derive("media").Sign("post-1", expires)); err != nil {
+		t.Errorf("Verify of a signature of the same purpose = %v, want nil", err)
+	}
+
+	for name, signature := range map[string]string{
+		"another purpose": share.Sign("post-1", expires),
+		"the parent key":  s.Sign("post-1", expires),
+	} {
+		if err := media.Verify("post-1", expires, signature); !errors.Is(err, signer.ErrInvalidSignature) {
+			t.Errorf("Verify of a signature of %v = %v, want ErrInvalidSignature", name, err)
+		}
+	}
+
+	if _, err := share.ParseToken(media.Token("post-1", expires)); !errors.Is(err, signer.ErrInvalidSignature) {
+		t.Errorf("ParseToken of a token of another purpose = %v, want ErrInvalidSignature", err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+
+	s := signer.New([]byte("signing key"))
+	expires := time.Now().Add(time.Hour)
+	signature := s.Sign("post-1", expires)
+
+	tests := []struct {
+		name      string
+		value     string
+		expires   time.Time
+		signature string
+		err       error
+	}{
+		{"valid", "post-1", expires, signature, nil},
+		{"another value", "post-2", expires, signature, signer.ErrInvalidSignature},
+		{"another expiry", "post-1", expires.Add(time.Hour), signature, signer.ErrInvalidSignature},
+		{"another key", "post-1", expires, signer.New([]byte("other key")).Sign("post-1", expires), signer.ErrInvalidSignature},
+		{"empty signature", "post-1", expires, "", signer.ErrInvalidSignature},
+		{"expired", "post-1", time.Now().Add(-time.Second), s.Sign("post-1", time.Now().Add(-time.Second)), signer.ErrExpired},
+	}
+
+	for _, tt := range tests {
+		if err := s.Verify(tt.value, tt.expires, tt.signature); !errors.Is(err, tt.err) {
+			t.Errorf("%v: Verify = %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestParseToken(t *testing.T) {
+
+	s := signer.New([]byte("signing key"))
+	token := s.Token("post-1", time.Now().Add(time.Hour))
+
+	tests := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"valid", token, nil},
+		{"expired", s.Token("post-1", time.Now().Add(-time.Minute)), signer.ErrExpired},
+		{"another key", signer.New([]byte("other key")).Token("post-1", time.Now().Add(time.Hour)), signer.ErrInvalidSignature},
+		{"truncated", token[:len(token)-2], signer.ErrInvalidSignature},
+		{"missing part", "cG9zdC0x.123", signer.ErrInvalidSignature},
+		{"bad value", "!!!." + token[len("cG9zdC0x."):], signer.ErrInvalidSignature},
+		{"bad expiry", "cG9zdC0x.soon.c2ln", signer.ErrInvalidSignature},
+		{"empty", "", signer.ErrInvalidSignature},
+	}
+
+	for _, tt := range tests {
+		value, err := s.ParseToken(tt.token)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%v: ParseToken = %v, want %v", tt.name, err, tt.err)
+			continue
+		}
+
+		if err == nil && value != "post-1" {
+			t.Errorf("%v: ParseToken = %q, want post-1", tt.name, value)
+		}
+	}
+}
+
+func TestNewWithKeyFunc(t *testing.T) {
+
+	key, err := "key-1", error(nil)
+
+	s, _ := signer.NewWithKeyFunc(func() ([]byte, error) { return []byte(key), err })
+	share := s.Derive("share")
+
+	expires := time.Now().Add(time.Hour)
+	first, firstShare := s.Sign("post-1", expires), share.Sign("post-1", expires)
+
+	if first != signer.New([]byte("key-1")).Sign("post-1", expires) {
+		t.Fatalf("Sign did not use the key of the key func")
+	}
+
+	key = "key-2"
+
+	second := s.Sign("post-1", expires)
+	if second == first || second != signer.New([]byte("key-2")).Sign("post-1", expires) {
+		t.Fatalf("Sign after the key was rotated did not use the rotated key")
+	}
+
+	// signatures of the previous key verify until the key is rotated again.
+	for name, verify := range map[string]error{
+		"previous key":         s.Verify("post-1", expires, first),
+		"previous derived key": share.Verify("post-1", expires, firstShare),
+		"rotated key":          s.Verify("post-1", expires, second),
+	} {
+		if verify != nil {
+			t.Errorf("Verify of a signature of the %v = %v, want nil", name, verify)
+		}
+	}
+
+	// a failed lookup keeps the last keys.
+	err = errors.New("vault unavailable")
+
+	if s.Sign("post-1", expires) != second || s.Verify("post-1", expires, first) != nil {
+		t.Errorf("Sign while the key func fails did not use the last keys")
+	}
+
+	key, err = "key-3", nil
+
+	if got := s.Verify("post-1", expires, first); !errors.Is(got, signer.ErrInvalidSignature) {
+		t.Errorf("Verify of a signature of a key rotated twice = %v, want ErrInvalidSignature", got)
+	}
+
+	if s.Verify("post-1", expires, second) != nil {
+		t.Errorf("Verify of a signature of the previous key failed")
+	}
+
+	if _, err := signer.NewWithKeyFunc(func() ([]byte, error) { return nil, errors.New("vault unavailable") }); err == nil {
+		t.Errorf("NewWithKeyFunc with a failing key func = nil, want an error")
+	}
+}
@@ -0,0 +1,83 @@
+package tracing
+
+import (
+	"context"
+	"time"
+
+	"github.com/evansopilo/visuai/pkg/blob"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Blob starts a client span for every operation of a blob store.
+type Blob struct {
+	store blob.Store
+}
+
+// NewBlob wraps store with a Blob, as blob.Wrap does.
+func NewBlob(store blob.Store) blob.Store {
+	return blob.Wrap(store, &Blob{store: store})
+}
+
+func (b Blob) start(ctx context.Context, operation string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer().Start(ctx, "blob."+operation, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
+
+func end(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (b Blob) UploadBytesToBlob(ctx context.Context, data []byte, contentType string, metadata map[string]string) (key string, err error) {
+	ctx, span := b.start(ctx, "upload", attribute.Int("blob.size", len(data)), attribute.String("blob.content_type", contentType))
+	defer func() { span.SetAttributes(attribute.String("blob.key", key)); end(span, err) }()
+	return b.store.UploadBytesToBlob(ctx, data, contentType, metadata)
+}
+
+func (b Blob) SignedURL(key string, ttl time.Duration) (string, error) {
+	return b.store.SignedURL(key, ttl)
+}
+
+func (b Blob) StageBlock(ctx context.Context, key string, part int, data []byte) (err error) {
+	ctx, span := b.start(ctx, "stage_block", attribute.String("blob.key", key), attribute.Int("blob.part", part), attribute.Int("blob.size", len(data)))
+	defer func() { end(span, err) }()
+	return b.store.StageBlock(ctx, key, part, data)
+}
+
+func (b Blob) CommitBlocks(ctx context.Context, key string, parts []int, contentType string, metadata map[string]string) (err error) {
+	ctx, span := b.start(ctx, "commit_blocks", attribute.String("blob.key", key), attribute.Int("blob.parts", len(parts)))
+	defer func() { end(span, err) }()
+	return b.store.CommitBlocks(ctx, key, parts, contentType, metadata)
+}
+
+func (b Blob) AbortBlocks(ctx context.Context, key string) (err error) {
+	ctx, span := b.start(ctx, "abort_blocks", attribute.String("blob.key", key))
+	defer func() { end(span, err) }()
+	return b.store.AbortBlocks(ctx, key)
+}
+
+func (b Blob) DownloadBlob(ctx context.Context, key string) (data []byte, err error) {
+	ctx, span := b.start(ctx, "download", attribute.String("blob.key", key))
+	defer func() { end(span, err) }()
+	return b.store.DownloadBlob(ctx, key)
+}
+
+func (b Blob) DeleteBlob(ctx context.Context, key string) (err error) {
+	ctx, span := b.start(ctx, "delete", attribute.String("blob.key", key))
+	defer func() { end(span, err) }()
+	return b.store.DeleteBlob(ctx, key)
+}
+
+func (b Blob) List(ctx context.Context, fn func(key string, modified time.Time) error) (err error) {
+	ctx, span := b.start(ctx, "list")
+	defer func() { end(span, err) }()
+	return b.store.List(ctx, fn)
+}
+
+func (b Blob) Ping(ctx context.Context) error {
+	return b.store.Ping(ctx)
+}
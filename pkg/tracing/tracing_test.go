@@ -0,0 +1,43 @@
+package tracing_test
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/evansopilo/visuai/pkg/tracing"
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestMiddleware(t *testing.T) {
+
+	recorder := tracetest.NewSpanRecorder()
+
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	app := fiber.New()
+	app.Use(tracing.Middleware())
+	app.Get("/v1/api/media/:key", func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) })
+
+	if _, err := app.Test(httptest.NewRequest("GET", "/v1/api/media/photo.jpg?expires=1&signature=secret", nil)); err != nil {
+		t.Fatalf("request: %v", err)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("spans = %v, want 1", len(spans))
+	}
+
+	attributes := map[string]string{}
+	for _, attribute := range spans[0].Attributes() {
+		attributes[string(attribute.Key)] = attribute.Value.Emit()
+	}
+
+	if spans[0].Name() != "GET /v1/api/media/:key" || attributes["http.route"] != "/v1/api/media/:key" || attributes["http.target"] != "/v1/api/media/photo.jpg" {
+		t.Errorf("span %v with attributes %v, want its route and its path without the query", spans[0].Name(), attributes)
+	}
+}